@@ -12,14 +12,20 @@ import (
 type DbCollectionName string
 
 const (
-	InstanceCollectionKey    DbCollectionName = "servers"
-	ApiKeysCollectionKey     DbCollectionName = "apiKeys"
-	UsersCollectionKey       DbCollectionName = "users"
-	AlbumsCollectionKey      DbCollectionName = "albums"
-	SharesCollectionKey      DbCollectionName = "shares"
-	FileHistoryCollectionKey DbCollectionName = "fileHistory"
-	FolderMediaCollectionKey DbCollectionName = "folderMedia"
-	MediaCollectionKey       DbCollectionName = "media"
+	InstanceCollectionKey     DbCollectionName = "servers"
+	ApiKeysCollectionKey      DbCollectionName = "apiKeys"
+	UsersCollectionKey        DbCollectionName = "users"
+	AlbumsCollectionKey       DbCollectionName = "albums"
+	SharesCollectionKey       DbCollectionName = "shares"
+	FileHistoryCollectionKey  DbCollectionName = "fileHistory"
+	FolderMediaCollectionKey  DbCollectionName = "folderMedia"
+	MediaCollectionKey        DbCollectionName = "media"
+	LibrariesCollectionKey    DbCollectionName = "libraries"
+	ScrubReportsCollectionKey DbCollectionName = "scrubReports"
+	ContentIndexCollectionKey DbCollectionName = "contentIndex"
+	FileMetadataCollectionKey DbCollectionName = "fileMetadata"
+	FileActivityCollectionKey DbCollectionName = "fileActivity"
+	TusUploadsCollectionKey   DbCollectionName = "tusUploads"
 )
 
 const maxRetries = 5
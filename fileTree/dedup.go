@@ -0,0 +1,367 @@
+package fileTree
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+)
+
+// linkTempSuffix marks the files that are staged next to a file while its content is being swapped out.
+// The file watcher ignores these, as they only exist until they are renamed over the real file.
+const linkTempSuffix = ".weblens-link"
+
+type LinkMethod string
+
+const (
+	LinkReflink  LinkMethod = "reflink"
+	LinkHardlink LinkMethod = "hardlink"
+
+	// LinkCopy means the content could not be linked, and was copied instead
+	LinkCopy LinkMethod = "copy"
+
+	// linkNone means the files did not match, linkExisting means they were already hardlinked or
+	// reflinked, and linkFailed means they could not be compared or linked
+	linkNone     LinkMethod = ""
+	linkExisting LinkMethod = "existing"
+	linkFailed   LinkMethod = "failed"
+)
+
+type DedupResult struct {
+	FilesLinked int
+	Reflinked   int
+	Hardlinked  int
+
+	// FilesFailed is the number of duplicates that could not be linked, and were left as they were
+	FilesFailed int
+
+	// BytesReclaimed is the space freed on disk. A duplicate that was already a hardlink of some other
+	// file, like one in the restore tree, does not free any space when it is replaced. Files that were
+	// reflinked on an earlier run are found by their shared extents, and are not cloned or counted again.
+	BytesReclaimed int64
+}
+
+func isLinkTempFile(name string) bool {
+	return strings.HasSuffix(name, linkTempSuffix)
+}
+
+// Dedup finds the regular files under root that have the same content, and replaces every copy but the
+// first with a link to the first. Files are linked with a reflink if the filesystem supports it, and a
+// hardlink otherwise. Hardlinked files are split apart again before they are next written to.
+func Dedup(root *WeblensFileImpl) (DedupResult, error) {
+	var result DedupResult
+	if root == nil {
+		return result, werror.WithStack(werror.ErrNilFile)
+	}
+
+	var contentIds []string
+	byContentId := map[string][]*WeblensFileImpl{}
+	err := root.RecursiveMap(
+		func(f *WeblensFileImpl) error {
+			if f.IsDir() || f.memOnly || f.Size() == 0 {
+				return nil
+			}
+
+			contentId := f.GetContentId()
+			if contentId == "" {
+				return nil
+			}
+
+			if _, ok := byContentId[contentId]; !ok {
+				contentIds = append(contentIds, contentId)
+			}
+			byContentId[contentId] = append(byContentId[contentId], f)
+			return nil
+		},
+	)
+	if err != nil {
+		return result, err
+	}
+
+	for _, contentId := range contentIds {
+		group := byContentId[contentId]
+		if len(group) < 2 {
+			continue
+		}
+
+		// Keep the same copy each run, so files that were linked last time are still linked to it
+		slices.SortFunc(
+			group, func(a, b *WeblensFileImpl) int {
+				return strings.Compare(a.AbsPath(), b.AbsPath())
+			},
+		)
+
+		// A stale content id can put files that no longer match in the same group, so each
+		// file is linked to the first kept file that it actually matches, or is kept itself.
+		keeps := []*WeblensFileImpl{group[0]}
+		for _, dup := range group[1:] {
+			method := linkNone
+			for _, keep := range keeps {
+				var reclaimed int64
+				var err error
+				method, reclaimed, err = linkDuplicate(keep, dup)
+				if err != nil {
+					log.ErrTrace(err)
+				}
+
+				switch method {
+				case LinkReflink:
+					result.Reflinked++
+				case LinkHardlink:
+					result.Hardlinked++
+				case linkFailed:
+					result.FilesFailed++
+				}
+				result.BytesReclaimed += reclaimed
+
+				if method != linkNone {
+					break
+				}
+			}
+
+			if method == linkNone {
+				keeps = append(keeps, dup)
+			}
+		}
+	}
+
+	result.FilesLinked = result.Reflinked + result.Hardlinked
+
+	return result, nil
+}
+
+// linkDuplicate replaces the content of dup with a link to the content of keep. If the two files
+// do not actually match, or are already linked, dup is left alone.
+func linkDuplicate(keep, dup *WeblensFileImpl) (LinkMethod, int64, error) {
+	// Writes to either file would be lost if they landed between comparing and linking the files
+	keep.linkLock.Lock()
+	defer keep.linkLock.Unlock()
+	dup.linkLock.Lock()
+	defer dup.linkLock.Unlock()
+
+	keepStat, err := os.Stat(keep.AbsPath())
+	if err != nil {
+		return linkFailed, 0, werror.WithStack(err)
+	}
+
+	dupStat, err := os.Stat(dup.AbsPath())
+	if err != nil {
+		return linkFailed, 0, werror.WithStack(err)
+	}
+
+	if os.SameFile(keepStat, dupStat) {
+		return linkExisting, 0, nil
+	} else if keepStat.Size() != dupStat.Size() {
+		return linkNone, 0, nil
+	} else if sharesExtents(keep.AbsPath(), dup.AbsPath()) {
+		// A reflink is a file of its own, but its content is the same blocks on disk as the file it was cloned from
+		return linkExisting, 0, nil
+	}
+
+	// Content ids are only as fresh as the last time the file was hashed, so we
+	// do not trust them to say the files are the same before throwing one away.
+	same, err := sameContent(keep.AbsPath(), dup.AbsPath())
+	if err != nil {
+		return linkFailed, 0, err
+	} else if !same {
+		return linkNone, 0, nil
+	}
+
+	method, err := linkContent(keep.AbsPath(), dup.AbsPath())
+	if err != nil {
+		return linkFailed, 0, err
+	}
+
+	var reclaimed int64
+	if linkCount(dupStat) == 1 {
+		reclaimed = dupStat.Size()
+	}
+
+	return method, reclaimed, nil
+}
+
+// linkContent replaces the file at dst with a link to the file at src
+func linkContent(src, dst string) (LinkMethod, error) {
+	tmpPath := dst + linkTempSuffix
+	_ = os.Remove(tmpPath)
+
+	method := LinkReflink
+	err := reflink(src, tmpPath)
+	if err != nil {
+		method = LinkHardlink
+		err = os.Link(src, tmpPath)
+		if err != nil {
+			return "", werror.WithStack(err)
+		}
+	}
+
+	err = os.Rename(tmpPath, dst)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", werror.WithStack(err)
+	}
+
+	return method, nil
+}
+
+// LinkFrom gives f the content of src, by linking to it if the filesystem allows, and copying it if it does
+// not. Read-only files, like those in libraries, can be changed outside of weblens, so they are never hardlinked.
+func (f *WeblensFileImpl) LinkFrom(src *WeblensFileImpl) (LinkMethod, error) {
+	if f.IsDir() || src.IsDir() {
+		return "", werror.WithStack(werror.ErrDirNotAllowed)
+	} else if f.readOnly {
+		return "", werror.WithStack(werror.ErrReadOnly.WithArg(f.GetPortablePath().ToPortable()))
+	}
+
+	src.linkLock.RLock()
+	defer src.linkLock.RUnlock()
+	f.linkLock.Lock()
+	defer f.linkLock.Unlock()
+
+	tmpPath := f.AbsPath() + linkTempSuffix
+	_ = os.Remove(tmpPath)
+
+	method := LinkReflink
+	err := reflink(src.AbsPath(), tmpPath)
+	if err != nil && !src.readOnly {
+		_ = os.Remove(tmpPath)
+		method = LinkHardlink
+		err = werror.WithStack(os.Link(src.AbsPath(), tmpPath))
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		method = LinkCopy
+		err = copyFileContent(src.AbsPath(), tmpPath)
+	}
+	if err == nil {
+		err = werror.WithStack(os.Rename(tmpPath, f.AbsPath()))
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+
+	stat, err := os.Stat(f.AbsPath())
+	if err != nil {
+		return "", werror.WithStack(err)
+	}
+	f.SetSize(stat.Size())
+
+	return method, nil
+}
+
+// lockForWrite makes sure the file has content of its own before it is written to, and keeps dedup
+// from linking the file until the returned unlock function is called.
+func (f *WeblensFileImpl) lockForWrite() (func(), error) {
+	if f.readOnly {
+		return nil, werror.WithStack(werror.ErrReadOnly.WithArg(f.GetPortablePath().ToPortable()))
+	}
+
+	f.linkLock.RLock()
+	linked, err := f.isLinked()
+	if err != nil {
+		f.linkLock.RUnlock()
+		return nil, err
+	} else if !linked {
+		return f.linkLock.RUnlock, nil
+	}
+	f.linkLock.RUnlock()
+
+	f.linkLock.Lock()
+	err = f.breakLink()
+	f.linkLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	f.linkLock.RLock()
+	return f.linkLock.RUnlock, nil
+}
+
+func (f *WeblensFileImpl) isLinked() (bool, error) {
+	stat, err := os.Stat(f.AbsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, werror.WithStack(err)
+	}
+
+	return linkCount(stat) > 1, nil
+}
+
+// breakLink gives a hardlinked file a copy of the content to itself, so writing to it does not change
+// the other links. Reflinks are copy-on-write already, so they do not need to be broken. Callers must
+// hold the link lock for writing.
+func (f *WeblensFileImpl) breakLink() error {
+	if f.memOnly {
+		return nil
+	}
+
+	linked, err := f.isLinked()
+	if err != nil || !linked {
+		return err
+	}
+
+	tmpPath := f.AbsPath() + linkTempSuffix
+	_ = os.Remove(tmpPath)
+
+	err = reflink(f.AbsPath(), tmpPath)
+	if err != nil {
+		err = copyFileContent(f.AbsPath(), tmpPath)
+		if err != nil {
+			_ = os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	err = os.Rename(tmpPath, f.AbsPath())
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+// sameContent compares the files at the two paths byte by byte
+func sameContent(aPath, bPath string) (bool, error) {
+	a, err := os.Open(aPath)
+	if err != nil {
+		return false, werror.WithStack(err)
+	}
+	defer a.Close()
+
+	b, err := os.Open(bPath)
+	if err != nil {
+		return false, werror.WithStack(err)
+	}
+	defer b.Close()
+
+	const chunkSize = 64 * 1024
+	aBuf := make([]byte, chunkSize)
+	bBuf := make([]byte, chunkSize)
+	for {
+		aN, aErr := io.ReadFull(a, aBuf)
+		bN, bErr := io.ReadFull(b, bBuf)
+		if aN != bN || !bytes.Equal(aBuf[:aN], bBuf[:bN]) {
+			return false, nil
+		}
+
+		aDone := aErr == io.EOF || errors.Is(aErr, io.ErrUnexpectedEOF)
+		bDone := bErr == io.EOF || errors.Is(bErr, io.ErrUnexpectedEOF)
+		if aErr != nil && !aDone {
+			return false, werror.WithStack(aErr)
+		} else if bErr != nil && !bDone {
+			return false, werror.WithStack(bErr)
+		}
+
+		if aDone || bDone {
+			return aDone == bDone, nil
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package fileTree_test
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedup(t *testing.T) {
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+
+	root := tree.GetRoot()
+	dir, err := tree.MkDir(root, "dir", nil)
+	require.NoError(t, err)
+
+	content := []byte("the same content in two places")
+	makeFile := func(parent *WeblensFileImpl, name string, data []byte, contentId string) *WeblensFileImpl {
+		f, err := tree.Touch(parent, name, nil)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		f.SetContentId(contentId)
+		return f
+	}
+
+	original := makeFile(root, "original.txt", content, "content-1")
+	duplicate := makeFile(dir, "duplicate.txt", content, "content-1")
+	unique := makeFile(dir, "unique.txt", []byte("something else"), "content-2")
+
+	// A stale content id should not be enough to throw away a file that no longer matches
+	stale := makeFile(dir, "stale.txt", []byte("the same content in 2 places!!"), "content-1")
+
+	result, err := Dedup(root)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.FilesLinked)
+	assert.Equal(t, 0, result.FilesFailed)
+	assert.Equal(t, int64(len(content)), result.BytesReclaimed)
+
+	originalStat, err := os.Stat(original.AbsPath())
+	require.NoError(t, err)
+	duplicateStat, err := os.Stat(duplicate.AbsPath())
+	require.NoError(t, err)
+	staleStat, err := os.Stat(stale.AbsPath())
+	require.NoError(t, err)
+	uniqueStat, err := os.Stat(unique.AbsPath())
+	require.NoError(t, err)
+
+	if result.Hardlinked == 1 {
+		assert.True(t, os.SameFile(originalStat, duplicateStat))
+	}
+	assert.False(t, os.SameFile(originalStat, staleStat))
+	assert.False(t, os.SameFile(originalStat, uniqueStat))
+
+	data, err := os.ReadFile(duplicate.AbsPath())
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// Running again should find nothing left to do, and no more space to free, whether the files were
+	// hardlinked or reflinked the first time
+	result, err = Dedup(root)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.FilesLinked)
+	assert.Equal(t, int64(0), result.BytesReclaimed)
+
+	// Writing to the duplicate must not change the original
+	_, err = duplicate.Write([]byte("new content"))
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(original.AbsPath())
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	data, err = os.ReadFile(duplicate.AbsPath())
+	require.NoError(t, err)
+	assert.Equal(t, "new content", string(data))
+}
+
+func TestLinkFrom(t *testing.T) {
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+
+	root := tree.GetRoot()
+	content := []byte("content that is already on the server")
+
+	src, err := tree.Touch(root, "src.txt", nil)
+	require.NoError(t, err)
+	_, err = src.Write(content)
+	require.NoError(t, err)
+
+	dst, err := tree.Touch(root, "dst.txt", nil)
+	require.NoError(t, err)
+
+	method, err := dst.LinkFrom(src)
+	require.NoError(t, err)
+	assert.Contains(t, []LinkMethod{LinkReflink, LinkHardlink, LinkCopy}, method)
+	assert.Equal(t, int64(len(content)), dst.Size())
+
+	data, err := os.ReadFile(dst.AbsPath())
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// The new file is its own file, even if it started as a link
+	_, err = dst.Write([]byte("changed"))
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(src.AbsPath())
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	_, err = root.LinkFrom(src)
+	assert.ErrorIs(t, err, werror.ErrDirNotAllowed)
+}
@@ -19,6 +19,11 @@ type FileAction struct {
 	ServerId        string           `json:"serverId" bson:"serverId"`
 
 	Size int64 `json:"size" bson:"size"`
+
+	// ContentId and OldContentId are only set on FileModify actions, and
+	// record the content the file had after and before the modification
+	ContentId    string `json:"contentId,omitempty" bson:"contentId,omitempty"`
+	OldContentId string `json:"oldContentId,omitempty" bson:"oldContentId,omitempty"`
 }
 
 func (fa *FileAction) GetTimestamp() time.Time {
@@ -109,4 +114,6 @@ const (
 	Backup         FileActionType = "backup"
 	FileDelete     FileActionType = "fileDelete"
 	FileRestore    FileActionType = "fileRestore"
+	FileModify     FileActionType = "fileModify"
+	FileCopy       FileActionType = "fileCopy"
 )
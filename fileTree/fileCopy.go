@@ -0,0 +1,155 @@
+package fileTree
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethanrous/weblens/internal/werror"
+)
+
+// Copy duplicates f as newFilename inside of newParent. If f is a directory, all of its children are copied as well.
+// The copies take on the contentId and size of the file they were copied from, so nothing needs to be re-hashed.
+// onCopy, if not nil, is called after each file has been copied.
+func (ft *FileTreeImpl) Copy(
+	f, newParent *WeblensFileImpl, newFilename string, event *FileEvent, onCopy func(source, copied *WeblensFileImpl),
+) (*WeblensFileImpl, error) {
+	if f == nil || newParent == nil {
+		return nil, werror.WithStack(werror.ErrNilFile)
+	} else if !newParent.IsDir() {
+		return nil, werror.WithStack(werror.ErrDirectoryRequired)
+	} else if newFilename == "" {
+		return nil, werror.WithStack(werror.ErrFilenameRequired)
+	} else if newParent.readOnly {
+		return nil, werror.WithStack(werror.ErrReadOnly.WithArg(newParent.GetPortablePath().ToPortable()))
+	}
+
+	if f.IsDir() && (newParent == f || strings.HasPrefix(newParent.AbsPath(), f.AbsPath())) {
+		return nil, werror.WithStack(werror.ErrCopyIntoSelf)
+	}
+
+	if existing, _ := newParent.GetChild(newFilename); existing != nil {
+		return nil, werror.WithStack(werror.ErrFileAlreadyExists.WithArg(existing.GetPortablePath().ToPortable()))
+	}
+
+	// Gather the children before the copy is added, in case it is being copied into the same directory
+	children := f.GetChildren()
+
+	copied, err := ft.copyOne(f, newParent, newFilename, event)
+	if err != nil {
+		return nil, err
+	}
+
+	if onCopy != nil {
+		onCopy(f, copied)
+	}
+
+	for _, child := range children {
+		_, err = ft.Copy(child, copied, child.Filename(), event, onCopy)
+		if err != nil {
+			return copied, err
+		}
+	}
+
+	return copied, nil
+}
+
+func (ft *FileTreeImpl) copyOne(f, newParent *WeblensFileImpl, newFilename string, event *FileEvent) (
+	*WeblensFileImpl, error,
+) {
+	absPath := filepath.Join(newParent.AbsPath(), newFilename)
+	if f.IsDir() {
+		absPath += "/"
+	}
+
+	copied := &WeblensFileImpl{
+		id:           ft.GenerateFileId(),
+		absolutePath: absPath,
+		filename:     newFilename,
+		isDir:        boolPointer(f.IsDir()),
+		modifyDate:   time.Now(),
+		parentId:     newParent.ID(),
+		parent:       newParent,
+		childrenMap:  map[string]*WeblensFileImpl{},
+		childIds:     []FileId{},
+	}
+
+	copied.size.Store(f.Size())
+
+	if f.IsDir() {
+		err := copied.CreateSelf()
+		if err != nil {
+			return nil, err
+		}
+
+		err = ft.Add(copied)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		dstFile, err := os.OpenFile(copied.AbsPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+		if err != nil {
+			if os.IsExist(err) {
+				return nil, werror.WithStack(werror.ErrFileAlreadyExists)
+			}
+			return nil, werror.WithStack(err)
+		}
+		defer dstFile.Close()
+
+		// The copy is added to the tree as soon as it exists, before the content is written, so the file watcher
+		// finds it already in the tree and does not import it as a new file while a long copy is still running
+		copied.SetContentId(f.GetContentId())
+		err = ft.Add(copied)
+		if err != nil {
+			_ = os.Remove(copied.AbsPath())
+			return nil, err
+		}
+
+		err = copyFileInto(f.AbsPath(), dstFile)
+		if err != nil {
+			if _, rmErr := ft.Remove(copied.ID()); rmErr != nil {
+				return nil, rmErr
+			}
+			_ = os.Remove(copied.AbsPath())
+			return nil, err
+		}
+	}
+
+	if event != nil {
+		event.NewCopyAction(copied, f)
+	}
+
+	return copied, nil
+}
+
+// copyFileContent copies the regular file at src to a new file at dst
+func copyFileContent(src, dst string) error {
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	if err != nil {
+		if os.IsExist(err) {
+			return werror.WithStack(werror.ErrFileAlreadyExists)
+		}
+		return werror.WithStack(err)
+	}
+	defer dstFile.Close()
+
+	return copyFileInto(src, dstFile)
+}
+
+// copyFileInto copies the content of the regular file at src into dst
+func copyFileInto(src string, dst io.Writer) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+	defer srcFile.Close()
+
+	_, err = io.Copy(dst, srcFile)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
@@ -186,6 +186,39 @@ func (fe *FileEvent) NewRestoreAction(file *WeblensFileImpl) *FileAction {
 	return newAction
 }
 
+// NewCopyAction starts the lifetime of a file that was copied from source. The copy
+// takes the contentId of the source, so it does not need to be hashed again.
+func (fe *FileEvent) NewCopyAction(file, source *WeblensFileImpl) *FileAction {
+	if fe.journal == nil {
+		return nil
+	}
+
+	log.Trace.Func(func(l log.Logger) {
+		l.Printf("Building copy action for [%s] -> [%s]", source.GetPortablePath(), file.GetPortablePath())
+	})
+
+	if !file.IsDir() && file.GetContentId() == "" {
+		file.SetContentId(source.GetContentId())
+	}
+
+	newAction := &FileAction{
+		LifeId:          file.ID(),
+		Timestamp:       time.Now(),
+		ActionType:      FileCopy,
+		OriginPath:      source.GetPortablePath().ToPortable(),
+		DestinationPath: file.GetPortablePath().ToPortable(),
+		EventId:         fe.EventId,
+		ParentId:        file.GetParentId(),
+		ServerId:        fe.ServerId,
+
+		file: file,
+	}
+
+	fe.addAction(newAction)
+
+	return newAction
+}
+
 func (fe *FileEvent) NewSizeChangeAction(file *WeblensFileImpl) *FileAction {
 	if fe.journal == nil {
 		log.Trace.Println("Journal not set on size change action")
@@ -226,3 +259,40 @@ func (fe *FileEvent) NewSizeChangeAction(file *WeblensFileImpl) *FileAction {
 
 	return newAction
 }
+
+// NewModifyAction records that the content of file was replaced. The file must already have its new
+// contentId set, and oldContentId should be the id of the content that was replaced.
+func (fe *FileEvent) NewModifyAction(file *WeblensFileImpl, oldContentId string) *FileAction {
+	if fe.journal == nil {
+		return nil
+	}
+
+	fe.journal.Flush()
+
+	log.Trace.Func(func(l log.Logger) { l.Printf("Building modify action for [%s]", file.GetPortablePath()) })
+	lt := fe.journal.Get(file.ID())
+	if lt == nil {
+		err := werror.Errorf("Cannot find existing lifetime for %s", file.ID())
+		log.ErrTrace(err)
+		return nil
+	}
+
+	newAction := &FileAction{
+		LifeId:          file.ID(),
+		Timestamp:       time.Now(),
+		ActionType:      FileModify,
+		DestinationPath: file.GetPortablePath().ToPortable(),
+		EventId:         fe.EventId,
+		ParentId:        file.GetParentId(),
+		ServerId:        fe.ServerId,
+		Size:            file.Size(),
+		ContentId:       file.GetContentId(),
+		OldContentId:    oldContentId,
+
+		file: file,
+	}
+
+	fe.addAction(newAction)
+
+	return newAction
+}
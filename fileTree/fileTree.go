@@ -24,6 +24,9 @@ type FileTreeImpl struct {
 
 	root *WeblensFileImpl
 
+	// nameIndex is kept up to date with the name of every file in the tree, so they can be searched quickly
+	nameIndex *FilenameIndex
+
 	rootPath  string
 	rootAlias string
 
@@ -40,6 +43,23 @@ func boolPointer(b bool) *bool {
 }
 
 func NewFileTree(rootPath, rootAlias string, journal Journal, doFileDiscovery bool) (FileTree, error) {
+	return newFileTree(rootPath, rootAlias, journal, doFileDiscovery, false)
+}
+
+// NewReadOnlyFileTree loads the existing directory at rootPath as a tree that weblens will never write to.
+// Unlike NewFileTree, the root must already exist, as it is expected to be managed outside of weblens.
+func NewReadOnlyFileTree(rootPath, rootAlias string, journal Journal) (FileTree, error) {
+	stat, err := os.Stat(rootPath)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	} else if !stat.IsDir() {
+		return nil, werror.WithStack(werror.ErrDirectoryRequired)
+	}
+
+	return newFileTree(rootPath, rootAlias, journal, true, true)
+}
+
+func newFileTree(rootPath, rootAlias string, journal Journal, doFileDiscovery, readOnly bool) (FileTree, error) {
 	if journal == nil {
 		return nil, werror.Errorf("Got nil journal trying to create new FileTree")
 	}
@@ -73,6 +93,7 @@ func NewFileTree(rootPath, rootAlias string, journal Journal, doFileDiscovery bo
 			rootAlias: rootAlias,
 			relPath:   "",
 		},
+		readOnly: readOnly,
 	}
 
 	root.size.Store(-1)
@@ -83,6 +104,7 @@ func NewFileTree(rootPath, rootAlias string, journal Journal, doFileDiscovery bo
 		root:      root,
 		journal:   journal,
 		rootAlias: rootAlias,
+		nameIndex: NewFilenameIndex(),
 	}
 
 	event := tree.GetJournal().NewEvent()
@@ -95,6 +117,7 @@ func NewFileTree(rootPath, rootAlias string, journal Journal, doFileDiscovery bo
 	}
 
 	journal.LogEvent(event)
+	journal.SetFileTree(tree)
 
 	return tree, nil
 }
@@ -105,6 +128,7 @@ func (ft *FileTreeImpl) GetJournal() Journal {
 
 func (ft *FileTreeImpl) SetJournal(j Journal) {
 	ft.journal = j
+	j.SetFileTree(ft)
 }
 
 func (ft *FileTreeImpl) addInternal(id FileId, f *WeblensFileImpl) {
@@ -159,6 +183,10 @@ func (ft *FileTreeImpl) Add(f *WeblensFileImpl) error {
 		f.setParentInternal(parent)
 	}
 
+	if f.GetParent().readOnly {
+		f.readOnly = true
+	}
+
 	err := f.GetParent().AddChild(f)
 	if err != nil {
 		return err
@@ -187,6 +215,8 @@ func (ft *FileTreeImpl) Add(f *WeblensFileImpl) error {
 		f.setAbsPath(abs)
 	}
 
+	ft.nameIndex.Set(f.ID(), f.Filename(), searchScope(f))
+
 	return nil
 }
 
@@ -219,6 +249,7 @@ func (ft *FileTreeImpl) Remove(id FileId) ([]*WeblensFileImpl, error) {
 		func(file *WeblensFileImpl) error {
 			deleted = append(deleted, file)
 			ft.deleteInternal(file.ID())
+			ft.nameIndex.Remove(file.ID())
 
 			return nil
 		},
@@ -235,6 +266,8 @@ func (ft *FileTreeImpl) Delete(id FileId, event *FileEvent) error {
 
 	if f == ft.root {
 		return werror.Errorf("cannot delete root directory")
+	} else if f.readOnly {
+		return werror.WithStack(werror.ErrReadOnly.WithArg(f.GetPortablePath().ToPortable()))
 	}
 
 	if f.IsDir() && len(f.GetChildren()) != 0 {
@@ -277,6 +310,14 @@ func (ft *FileTreeImpl) Move(
 		return nil, werror.WithStack(werror.ErrEmptyMove)
 	}
 
+	if newParent == f || isInside(newParent, f) {
+		return nil, werror.WithStack(werror.ErrMoveIntoSelf)
+	}
+
+	if f.readOnly || newParent.readOnly {
+		return nil, werror.WithStack(werror.ErrReadOnly)
+	}
+
 	newAbsPath := filepath.Join(newParent.AbsPath(), newFilename)
 
 	if !overwrite {
@@ -291,6 +332,8 @@ func (ft *FileTreeImpl) Move(
 	}
 
 	oldAbsPath := f.AbsPath()
+	oldParent := f.GetParent()
+	oldFilename := f.Filename()
 
 	// Point of no return //
 
@@ -301,6 +344,39 @@ func (ft *FileTreeImpl) Move(
 		hasExternalEvent = true
 	}
 
+	moved, err := ft.moveInTree(f, newParent, newFilename, event)
+	if err != nil {
+		return nil, err
+	}
+
+	if overwrite {
+		err = os.Remove(newAbsPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, werror.WithStack(err)
+		}
+	}
+
+	err = os.Rename(oldAbsPath, newAbsPath)
+	if err != nil {
+		// The file never left, so put the tree back to match
+		if _, revertErr := ft.moveInTree(f, oldParent, oldFilename, event); revertErr != nil {
+			log.ErrTrace(revertErr)
+		}
+		return nil, werror.WithStack(err)
+	}
+
+	if !hasExternalEvent {
+		ft.journal.LogEvent(event)
+	}
+
+	return moved, nil
+}
+
+// moveInTree syncs the file tree with a move of f, and all of its children, to newParent.
+// The caller is responsible for making the same change on the filesystem.
+func (ft *FileTreeImpl) moveInTree(
+	f, newParent *WeblensFileImpl, newFilename string, event *FileEvent,
+) ([]MoveInfo, error) {
 	// Sync file tree with new move, including f and all of its children.
 	var moved []MoveInfo
 	err := f.RecursiveMap(
@@ -332,6 +408,7 @@ func (ft *FileTreeImpl) Move(
 				return err
 			}
 			w.setPortable(portable)
+			ft.nameIndex.Set(w.ID(), w.Filename(), searchScope(w))
 
 			event.NewMoveAction(preFile.ID(), w)
 
@@ -352,22 +429,6 @@ func (ft *FileTreeImpl) Move(
 		return nil, err
 	}
 
-	if overwrite {
-		err = os.Remove(newAbsPath)
-		if err != nil && !os.IsNotExist(err) {
-			return nil, werror.WithStack(err)
-		}
-	}
-
-	err = os.Rename(oldAbsPath, newAbsPath)
-	if err != nil {
-		return nil, err
-	}
-
-	if !hasExternalEvent {
-		ft.journal.LogEvent(event)
-	}
-
 	return moved, nil
 }
 
@@ -382,6 +443,10 @@ func (ft *FileTreeImpl) Size() int {
 func (ft *FileTreeImpl) Touch(parentFolder *WeblensFileImpl, newFileName string, event *FileEvent) (
 	*WeblensFileImpl, error,
 ) {
+	if parentFolder.readOnly {
+		return nil, werror.WithStack(werror.ErrReadOnly.WithArg(parentFolder.GetPortablePath().ToPortable()))
+	}
+
 	childPath := parentFolder.GetPortablePath().Child(newFileName, false)
 	absPath, err := ft.PortableToAbs(childPath)
 	if err != nil {
@@ -423,6 +488,10 @@ func (ft *FileTreeImpl) Touch(parentFolder *WeblensFileImpl, newFileName string,
 func (ft *FileTreeImpl) MkDir(
 	parentFolder *WeblensFileImpl, newDirName string, event *FileEvent,
 ) (*WeblensFileImpl, error) {
+	if parentFolder.readOnly {
+		return nil, werror.WithStack(werror.ErrReadOnly.WithArg(parentFolder.GetPortablePath().ToPortable()))
+	}
+
 	if existingFile, _ := parentFolder.GetChild(newDirName); existingFile != nil {
 		return existingFile, werror.WithStack(werror.ErrDirAlreadyExists.WithArg(parentFolder.AbsPath() + newDirName))
 	}
@@ -458,14 +527,18 @@ func (ft *FileTreeImpl) MkDir(
 
 	d.size.Store(0)
 
-	err := ft.Add(d)
+	// The directory must exist before it is added, so the file watcher has something to watch
+	err := d.CreateSelf()
 	if err != nil {
 		return nil, err
 	}
 
-	err = d.CreateSelf()
+	err = ft.Add(d)
 	if err != nil {
-		return d, err
+		if rmErr := os.Remove(d.AbsPath()); rmErr != nil {
+			log.ErrTrace(werror.WithStack(rmErr))
+		}
+		return nil, err
 	}
 
 	if event != nil {
@@ -499,10 +572,36 @@ func (ft *FileTreeImpl) ReplaceId(existingId, newId FileId) error {
 	ft.deleteInternal(existingId)
 	f.setIdInternal(newId)
 	ft.addInternal(newId, f)
+	ft.nameIndex.ReplaceId(existingId, newId)
 
 	return nil
 }
 
+// SearchFilenames finds the files inside of base with names that contain every word in the query, ignoring case.
+// This uses the name index, so it only looks at files that could match, not every file under base.
+func (ft *FileTreeImpl) SearchFilenames(query string, base *WeblensFileImpl) []*WeblensFileImpl {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	scope := ""
+	if base != ft.root {
+		scope = searchScope(base)
+	}
+
+	var files []*WeblensFileImpl
+	for _, id := range ft.nameIndex.Search(words, scope) {
+		f := ft.Get(id)
+		if f == nil || f == base || !isInside(f, base) {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	return files
+}
+
 // ReadDir reads the filesystem for files it does not yet have, adds them to the tree,
 // and returns the newly added files
 func (ft *FileTreeImpl) ReadDir(dir *WeblensFileImpl) ([]*WeblensFileImpl, error) {
@@ -795,6 +894,89 @@ func (ft *FileTreeImpl) importFromDirEntry(entry os.DirEntry, parent *WeblensFil
 	return f, nil
 }
 
+// importNew adds a file that has appeared on disk, and any children it has, to the tree.
+// A create action is added to the event for each new file.
+func (ft *FileTreeImpl) importNew(entry os.DirEntry, parent *WeblensFileImpl, event *FileEvent) (
+	[]*WeblensFileImpl, error,
+) {
+	newFile, err := ft.importFromDirEntry(entry, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	var created []*WeblensFileImpl
+	toLoad := []*WeblensFileImpl{newFile}
+	for len(toLoad) != 0 {
+		var fileToLoad *WeblensFileImpl
+		fileToLoad, toLoad = toLoad[0], toLoad[1:]
+		if slices.Contains(IgnoreFilenames, fileToLoad.Filename()) {
+			continue
+		}
+
+		fileToLoad.setIdInternal(ft.GenerateFileId())
+		err = ft.Add(fileToLoad)
+		if err != nil {
+			return created, err
+		}
+
+		event.NewCreateAction(fileToLoad)
+		created = append(created, fileToLoad)
+
+		if fileToLoad.IsDir() {
+			children, err := ft.ReadDir(fileToLoad)
+			if err != nil {
+				return created, err
+			}
+			toLoad = append(toLoad, children...)
+		}
+	}
+
+	return created, nil
+}
+
+// getByAbsPath finds the file in the tree at absPath by walking down from the root,
+// or returns nil if the tree has no file at that path.
+func (ft *FileTreeImpl) getByAbsPath(absPath string) *WeblensFileImpl {
+	rootPath := strings.TrimSuffix(ft.GetRoot().AbsPath(), "/")
+	absPath = strings.TrimSuffix(absPath, "/")
+	if absPath == rootPath {
+		return ft.GetRoot()
+	}
+
+	relPath, ok := strings.CutPrefix(absPath, rootPath+"/")
+	if !ok {
+		return nil
+	}
+
+	f := ft.GetRoot()
+	for _, name := range strings.Split(relPath, "/") {
+		child, err := f.GetChild(name)
+		if err != nil {
+			return nil
+		}
+		f = child
+	}
+
+	return f
+}
+
+// searchScope is the part of the tree the file is indexed under, which is the folder at the top of the tree
+// that it is in. In the users tree, this is the home folder of the user that owns the file.
+func searchScope(f *WeblensFileImpl) string {
+	scope, _, _ := strings.Cut(f.GetPortablePath().RelativePath(), "/")
+	return scope
+}
+
+// isInside reports if f is somewhere below dir
+func isInside(f, dir *WeblensFileImpl) bool {
+	for parent := f.GetParent(); parent != nil; parent = parent.GetParent() {
+		if parent == dir {
+			return true
+		}
+	}
+	return false
+}
+
 func MoveFileBetweenTrees(
 	file, newParent *WeblensFileImpl, newName string, oldTree, newTree FileTree, event *FileEvent,
 ) error {
@@ -837,9 +1019,13 @@ type FileTree interface {
 	Move(f, newParent *WeblensFileImpl, newFilename string, overwrite bool, event *FileEvent) ([]MoveInfo, error)
 	Touch(parentFolder *WeblensFileImpl, newFileName string, event *FileEvent) (*WeblensFileImpl, error)
 	MkDir(parentFolder *WeblensFileImpl, newDirName string, event *FileEvent) (*WeblensFileImpl, error)
+	Copy(
+		f, newParent *WeblensFileImpl, newFilename string, event *FileEvent, onCopy func(source, copied *WeblensFileImpl),
+	) (*WeblensFileImpl, error)
 
 	SetRootAlias(alias string) error
 	ReplaceId(oldId, newId FileId) error
+	SearchFilenames(query string, base *WeblensFileImpl) []*WeblensFileImpl
 
 	PortableToAbs(portable WeblensFilepath) (string, error)
 	AbsToPortable(absPath string) (WeblensFilepath, error)
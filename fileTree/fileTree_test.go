@@ -142,6 +142,63 @@ func TestFileTreeImpl_Move(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestFileTreeImpl_Copy(t *testing.T) {
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+
+	root := tree.GetRoot()
+	srcDir, err := tree.MkDir(root, "src", nil)
+	require.NoError(t, err)
+
+	subDir, err := tree.MkDir(srcDir, "sub", nil)
+	require.NoError(t, err)
+
+	srcFile, err := tree.Touch(subDir, "file.txt", nil)
+	require.NoError(t, err)
+	_, err = srcFile.Write([]byte("hello weblens"))
+	require.NoError(t, err)
+	srcFile.SetContentId("test-content-id")
+
+	destDir, err := tree.MkDir(root, "dest", nil)
+	require.NoError(t, err)
+
+	var copiedCount int
+	copied, err := tree.Copy(
+		srcDir, destDir, "src-copy", nil, func(_, _ *WeblensFileImpl) {
+			copiedCount++
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 3, copiedCount)
+	assert.NotEqual(t, srcDir.ID(), copied.ID())
+	assert.Equal(t, destDir, copied.GetParent())
+
+	copiedSub, err := copied.GetChild("sub")
+	require.NoError(t, err)
+	copiedFile, err := copiedSub.GetChild("file.txt")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, srcFile.ID(), copiedFile.ID())
+	assert.Equal(t, srcFile.GetContentId(), copiedFile.GetContentId())
+	assert.Equal(t, srcFile.Size(), copiedFile.Size())
+
+	data, err := os.ReadFile(copiedFile.AbsPath())
+	require.NoError(t, err)
+	assert.Equal(t, "hello weblens", string(data))
+
+	// The source should be left where it was
+	_, err = os.Stat(srcFile.AbsPath())
+	assert.NoError(t, err)
+
+	// Copying over an existing file is not allowed
+	_, err = tree.Copy(srcDir, destDir, "src-copy", nil, nil)
+	assert.ErrorIs(t, err, werror.ErrFileAlreadyExists)
+
+	// A folder cannot be copied inside of itself
+	_, err = tree.Copy(srcDir, subDir, "src", nil, nil)
+	assert.ErrorIs(t, err, werror.ErrCopyIntoSelf)
+}
+
 func TestFileTreeImpl_Delete(t *testing.T) {
 	tree, err := NewTestFileTree()
 	require.NoError(t, err)
@@ -178,3 +235,55 @@ func TestFileTreeImpl_Delete(t *testing.T) {
 	err = tree.Delete(newDir2.ID(), &FileEvent{})
 	assert.NoError(t, err)
 }
+
+func TestReadOnlyFileTree(t *testing.T) {
+	rootPath, err := os.MkdirTemp("", "weblens-test-readonly-*")
+	require.NoError(t, err)
+
+	require.NoError(t, os.Mkdir(filepath.Join(rootPath, "photos"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(rootPath, "photos", "a.txt"), []byte("external"), 0644))
+
+	_, err = NewReadOnlyFileTree(filepath.Join(rootPath, "does-not-exist"), "LIBRARY", mock.NewHollowJournalService())
+	assert.Error(t, err)
+
+	tree, err := NewReadOnlyFileTree(rootPath, "LIBRARY", mock.NewHollowJournalService())
+	require.NoError(t, err)
+
+	root := tree.GetRoot()
+	assert.True(t, root.IsReadOnly())
+
+	photos, err := root.GetChild("photos")
+	require.NoError(t, err)
+	file, err := photos.GetChild("a.txt")
+	require.NoError(t, err)
+
+	// Files found on disk inherit the read-only flag from the root
+	assert.True(t, photos.IsReadOnly())
+	assert.True(t, file.IsReadOnly())
+
+	_, err = tree.Touch(photos, "b.txt", nil)
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	_, err = tree.MkDir(root, "new folder", nil)
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	_, err = tree.Move(file, root, "a.txt", false, nil)
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	err = tree.Delete(file.ID(), &FileEvent{})
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	_, err = file.Write([]byte("overwritten"))
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	data, err := os.ReadFile(file.AbsPath())
+	require.NoError(t, err)
+	assert.Equal(t, "external", string(data))
+
+	// Copying out of a read-only tree is allowed
+	writable, err := NewTestFileTree()
+	require.NoError(t, err)
+	copied, err := writable.Copy(file, writable.GetRoot(), "a.txt", nil, nil)
+	require.NoError(t, err)
+	assert.False(t, copied.IsReadOnly())
+}
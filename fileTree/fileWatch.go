@@ -1,121 +1,421 @@
 package fileTree
 
 import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
 	"github.com/fsnotify/fsnotify"
 )
 
-// TODO
+const (
+	// Move events show up as a distinct "Rename" at the source and "Create" at the destination,
+	// so we hold on to either half for a moment to see if its pair comes through.
+	watcherHoldDuration = time.Millisecond * 100
 
-type watcherPathMod struct {
-	path string
-	add  bool
+	// Writes come in bursts while a file is being copied in, so we wait for them to settle
+	// before re-sizing the file.
+	watcherResizeDelay = time.Millisecond * 500
+)
+
+// FileWatchHandler is notified after the file watcher has applied an out-of-band
+// change to the tree, and the event for that change has been logged to the journal.
+type FileWatchHandler interface {
+	FilesCreated(files []*WeblensFileImpl)
+	FileMoved(preMoveFile, postMoveFile *WeblensFileImpl)
+	FilesDeleted(files []*WeblensFileImpl)
+	FilesResized(files []*WeblensFileImpl)
 }
 
-var pathModChan = make(chan (watcherPathMod), 5)
+func (j *JournalImpl) SetWatchHandler(handler FileWatchHandler) {
+	j.watchHandler = handler
+}
 
+// FileWatcher watches every directory in the journals file tree, and keeps the tree and journal
+// in sync with changes that are made to the filesystem outside of weblens. FileWatcher blocks
+// until the journal is closed.
 func (j *JournalImpl) FileWatcher() {
-	_, err := fsnotify.NewWatcher()
+	if j.fileTree == nil {
+		j.log.Error.Println("File watcher started on journal with no file tree")
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		j.log.ErrTrace(werror.WithStack(err))
+		return
+	}
+	j.watcher.Store(watcher)
+
+	// Directories that were added to the tree before the watcher existed have not been registered yet
+	err = j.fileTree.GetRoot().RecursiveMap(
+		func(f *WeblensFileImpl) error {
+			if !f.IsDir() {
+				return nil
+			}
+			return j.WatchFolder(f)
+		},
+	)
 	if err != nil {
-		panic(err)
+		j.log.ErrTrace(err)
 	}
 
-	// err = watcher.Add(j.fileTree.GetRoot().GetAbsPath())
-	// if err != nil {
-	// 	panic(err)
-	// }
+	j.log.Debug.Func(func(l log.Logger) { l.Printf("File watcher started on %s", j.fileTree.GetRoot().AbsPath()) })
 
-	// var holder *fsnotify.Event
-	holdTimer := time.NewTimer(time.Second)
+	var held *fsnotify.Event
+	holdTimer := time.NewTimer(watcherHoldDuration)
 	holdTimer.Stop()
 
-WatcherLoop:
+	pendingResize := map[string]struct{}{}
+	resizeTimer := time.NewTimer(watcherResizeDelay)
+	resizeTimer.Stop()
+
 	for {
 		select {
-		// case <-holdTimer.C:
-		// 	jeStream <- FileEvent{action: FileCreate, postFilePath: holder.Name}
-		// 	holder = nil
-		// case event, ok := <-watcher.Events:
-		// 	if !ok {
-		// 		break WatcherLoop
-		// 	}
-		//
-		// 	// util.Debug.Println("Got file event", event.Name)
-		// 	if event.Has(fsnotify.Create) {
-		// 		// Move events show up as a distinct "Create" in the destination
-		// 		// followed by a "Rename" in the old location, so we hold on to
-		// 		// create Actions for 100 ms to wait for the following rename.
-		//
-		// 		if holder == nil {
-		// 			holder = &event
-		// 			holdTimer = time.NewTimer(time.Millisecond * 100)
-		// 			continue
-		// 		}
-		//
-		// 		// If we are already holding onto a create event, then
-		// 		// it must have been a real create event, as it was not followed
-		// 		// by a rename. So we rinse and repeat
-		// 		holdTimer.Stop()
-		// 		jeStream <- FileEvent{action: FileCreate, postFilePath: holder.Name}
-		// 		holder = &event
-		// 		holdTimer = time.NewTimer(time.Millisecond * 100)
-		// 		continue
-		// 	}
-		//
-		// 	if event.Has(fsnotify.Remove) {
-		// 		jeStream <- FileEvent{action: FileDelete, preFilePath: event.Name}
-		// 		continue
-		// 	}
-		//
-		// 	if event.Has(fsnotify.Rename) {
-		// 		if holder == nil {
-		// 			jeStream <- FileEvent{action: FileDelete, preFilePath: event.Name}
-		// 		} else {
-		// 			holdTimer.Stop()
-		// 			jeStream <- FileEvent{action: FileMove, preFilePath: event.Name, postFilePath: holder.Name}
-		// 			holder = nil
-		// 		}
-		// 	}
-		//
-		// case err, ok := <-watcher.Errors:
-		// 	if !ok {
-		// 		break WatcherLoop
-		// 	}
-		// 	util.ShowErr(err, "File watcher error")
-		case _, ok := <-pathModChan:
+		case <-holdTimer.C:
+			j.handleHeldWatchEvent(*held)
+			held = nil
+
+		case <-resizeTimer.C:
+			j.handleWatchResize(pendingResize)
+			pendingResize = map[string]struct{}{}
+
+		case event, ok := <-watcher.Events:
 			if !ok {
-				break WatcherLoop
+				j.log.Debug.Println("File watcher exiting...")
+				return
+			}
+
+			if slices.Contains(IgnoreFilenames, filepath.Base(event.Name)) || isLinkTempFile(event.Name) {
+				continue
+			}
+
+			j.log.Trace.Func(func(l log.Logger) { l.Printf("File watcher got %s", event) })
+
+			switch {
+			case event.Has(fsnotify.Create):
+				if held != nil && held.Has(fsnotify.Rename) {
+					holdTimer.Stop()
+					j.handleWatchMove(held.Name, event.Name)
+					held = nil
+					continue
+				} else if held != nil {
+					// The held event was not followed by its pair, so it must have been a real create or delete
+					holdTimer.Stop()
+					j.handleHeldWatchEvent(*held)
+				}
+
+				held = &event
+				holdTimer.Reset(watcherHoldDuration)
+
+			case event.Has(fsnotify.Rename):
+				if held != nil && held.Has(fsnotify.Create) {
+					holdTimer.Stop()
+					j.handleWatchMove(event.Name, held.Name)
+					held = nil
+					continue
+				} else if held != nil {
+					holdTimer.Stop()
+					j.handleHeldWatchEvent(*held)
+				}
+
+				held = &event
+				holdTimer.Reset(watcherHoldDuration)
+
+			case event.Has(fsnotify.Remove):
+				j.handleWatchDelete(event.Name)
+
+			case event.Has(fsnotify.Write):
+				pendingResize[event.Name] = struct{}{}
+				if len(pendingResize) == 1 {
+					resizeTimer.Reset(watcherResizeDelay)
+				}
 			}
 
-			// if mod.add {
-			// 	watcher.Add(mod.path)
-			// } else {
-			// 	watcher.Remove(mod.path)
-			// }
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				j.log.Debug.Println("File watcher exiting...")
+				return
+			}
+			j.log.ErrTrace(werror.WithStack(err))
 		}
 	}
-
-	// Not reached
-	log.Error.Panicln("File watcher exiting...")
 }
 
 func (j *JournalImpl) WatchFolder(f *WeblensFileImpl) error {
-	// if !f.IsDir() {
-	// 	return dataStore.ErrDirectoryRequired
-	// }
-	// if f.Owner() == types.SERV.UserService.Get("WEBLENS") {
-	// 	return nil
-	// }
+	watcher := j.watcher.Load()
+	if watcher == nil {
+		return nil
+	}
+
+	if !f.IsDir() {
+		return werror.WithStack(werror.ErrDirectoryRequired)
+	}
 
-	// err := f.SetWatching()
-	// if err != nil {
-	// 	return err
-	// }
+	if f.IsWatching() {
+		return nil
+	}
 
-	// newMod := watcherPathMod{path: f.GetAbsPath(), add: true}
-	// pathModChan <- newMod
+	err := watcher.Add(f.AbsPath())
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	// Only mark the folder once the watch is in place, so a folder that failed to be watched can be tried again
+	err = f.SetWatching()
+	if err != nil && !errors.Is(err, werror.ErrAlreadyWatching) {
+		return err
+	}
 
 	return nil
 }
+
+// rewatch updates the watcher after a directory has been moved. inotify drops the watch on
+// a directory that moves, and any watches on its children are left pointing at the old paths.
+func (j *JournalImpl) rewatch(oldPath string, dir *WeblensFileImpl) {
+	watcher := j.watcher.Load()
+	if watcher == nil || !dir.IsDir() {
+		return
+	}
+
+	oldPath = strings.TrimSuffix(oldPath, "/")
+	for _, watched := range watcher.WatchList() {
+		if watched == oldPath || strings.HasPrefix(watched, oldPath+"/") {
+			_ = watcher.Remove(watched)
+		}
+	}
+
+	_ = dir.RecursiveMap(
+		func(f *WeblensFileImpl) error {
+			if !f.IsDir() {
+				return nil
+			}
+			f.watching = true
+			if err := watcher.Add(f.AbsPath()); err != nil {
+				j.log.ErrTrace(werror.WithStack(err))
+			}
+			return nil
+		},
+	)
+}
+
+func (j *JournalImpl) handleHeldWatchEvent(event fsnotify.Event) {
+	if event.Has(fsnotify.Create) {
+		j.handleWatchCreate(event.Name)
+	} else {
+		// A rename with no matching create means the file was moved somewhere we are not watching
+		j.handleWatchDelete(event.Name)
+	}
+}
+
+func (j *JournalImpl) handleWatchCreate(absPath string) {
+	// Files created by weblens itself will already be in the tree
+	if j.fileTree.getByAbsPath(absPath) != nil {
+		return
+	}
+
+	parent := j.fileTree.getByAbsPath(filepath.Dir(absPath))
+	if parent == nil {
+		// If the parent is not in the tree yet, it will pick up this file when it is imported
+		return
+	}
+
+	stat, err := os.Stat(absPath)
+	if err != nil {
+		// The file is already gone again
+		return
+	}
+
+	event := j.NewEvent()
+	created, err := j.fileTree.importNew(fs.FileInfoToDirEntry(stat), parent, event)
+	if err != nil {
+		j.log.ErrTrace(err)
+	}
+
+	if len(created) == 0 {
+		return
+	}
+
+	// Size the new files before their parents, so the parents do not count the unsized children
+	err = j.fileTree.ResizeDown(created[0], event, func(*WeblensFileImpl) {})
+	if err != nil {
+		j.log.ErrTrace(err)
+	}
+
+	err = j.fileTree.ResizeUp(parent, event, func(*WeblensFileImpl) {})
+	if err != nil {
+		j.log.ErrTrace(err)
+	}
+
+	j.LogEvent(event)
+	event.Wait()
+
+	j.log.Debug.Func(func(l log.Logger) {
+		l.Printf("File watcher found %d new file(s) at %s", len(created), created[0].GetPortablePath())
+	})
+
+	if j.watchHandler != nil {
+		j.watchHandler.FilesCreated(created)
+	}
+}
+
+func (j *JournalImpl) handleWatchMove(oldPath, newPath string) {
+	f := j.fileTree.getByAbsPath(oldPath)
+	if f == nil {
+		if existing := j.fileTree.getByAbsPath(newPath); existing != nil {
+			// Moves made through weblens update the tree before touching the filesystem,
+			// so all that is left to do is fix up the watches.
+			j.rewatch(oldPath, existing)
+			return
+		}
+
+		// Moved in from somewhere we are not watching
+		j.handleWatchCreate(newPath)
+		return
+	}
+
+	newParent := j.fileTree.getByAbsPath(filepath.Dir(newPath))
+	stat, err := os.Stat(newPath)
+	if newParent == nil || err != nil || stat.IsDir() != f.IsDir() {
+		// Either the destination is outside of the tree, or the create we paired this
+		// rename with was unrelated. Either way, the file at the old path is gone.
+		j.handleWatchDelete(oldPath)
+		if err == nil {
+			j.handleWatchCreate(newPath)
+		}
+		return
+	}
+
+	event := j.NewEvent()
+	oldParent := f.GetParent()
+	preMoveFile := f.Freeze()
+
+	// Moving onto an existing file replaces it
+	if existing := j.fileTree.getByAbsPath(newPath); existing != nil && existing != f {
+		j.removeWatched(existing, event)
+	}
+
+	_, err = j.fileTree.moveInTree(f, newParent, filepath.Base(newPath), event)
+	if err != nil {
+		j.log.ErrTrace(err)
+		return
+	}
+
+	for _, resizeAnchor := range []*WeblensFileImpl{oldParent, newParent} {
+		err = j.fileTree.ResizeUp(resizeAnchor, event, func(*WeblensFileImpl) {})
+		if err != nil {
+			j.log.ErrTrace(err)
+		}
+	}
+
+	j.LogEvent(event)
+	event.Wait()
+
+	j.rewatch(oldPath, f)
+
+	j.log.Debug.Func(func(l log.Logger) {
+		l.Printf("File watcher moved %s to %s", preMoveFile.GetPortablePath(), f.GetPortablePath())
+	})
+
+	if j.watchHandler != nil {
+		j.watchHandler.FileMoved(preMoveFile, f)
+	}
+}
+
+func (j *JournalImpl) handleWatchDelete(absPath string) {
+	f := j.fileTree.getByAbsPath(absPath)
+	if f == nil {
+		return
+	}
+
+	// The file might have been replaced before we got to the event
+	if _, err := os.Stat(absPath); err == nil {
+		return
+	}
+
+	parent := f.GetParent()
+	event := j.NewEvent()
+
+	deleted := j.removeWatched(f, event)
+	if len(deleted) == 0 {
+		return
+	}
+
+	err := j.fileTree.ResizeUp(parent, event, func(*WeblensFileImpl) {})
+	if err != nil {
+		j.log.ErrTrace(err)
+	}
+
+	j.LogEvent(event)
+	event.Wait()
+
+	j.log.Debug.Func(func(l log.Logger) {
+		l.Printf("File watcher removed %d file(s) at %s", len(deleted), f.GetPortablePath())
+	})
+
+	if j.watchHandler != nil {
+		j.watchHandler.FilesDeleted(deleted)
+	}
+}
+
+// removeWatched removes f and all of its children from the tree, adding a delete action for each
+// to the event. The content is already gone from disk, so there is nothing to send to the restore tree.
+func (j *JournalImpl) removeWatched(f *WeblensFileImpl, event *FileEvent) []*WeblensFileImpl {
+	deleted, err := j.fileTree.Remove(f.ID())
+	if err != nil {
+		j.log.ErrTrace(err)
+		return nil
+	}
+
+	for _, d := range deleted {
+		event.NewDeleteAction(d.ID())
+	}
+
+	return deleted
+}
+
+func (j *JournalImpl) handleWatchResize(paths map[string]struct{}) {
+	event := j.NewEvent()
+
+	resizedMap := map[FileId]*WeblensFileImpl{}
+	for path := range paths {
+		f := j.fileTree.getByAbsPath(path)
+		if f == nil || f.IsDir() {
+			continue
+		}
+
+		// If the file has no lifetime yet, it is still being created by weblens, which will size it when it is done
+		if j.Get(f.ID()) == nil {
+			continue
+		}
+
+		// LoadStat will not re-stat a file that already has a size
+		f.size.Store(-1)
+
+		err := j.fileTree.ResizeUp(
+			f, event, func(resized *WeblensFileImpl) {
+				resizedMap[resized.ID()] = resized
+			},
+		)
+		if err != nil {
+			j.log.ErrTrace(err)
+		}
+	}
+
+	j.LogEvent(event)
+	event.Wait()
+
+	if j.watchHandler != nil && len(resizedMap) != 0 {
+		resized := make([]*WeblensFileImpl, 0, len(resizedMap))
+		for _, f := range resizedMap {
+			resized = append(resized, f)
+		}
+		j.watchHandler.FilesResized(resized)
+	}
+}
@@ -0,0 +1,271 @@
+package fileTree_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/database"
+	. "github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/env"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/service/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testWatchHandler struct {
+	created chan *WeblensFileImpl
+	moved   chan *WeblensFileImpl
+	deleted chan *WeblensFileImpl
+}
+
+func (h *testWatchHandler) FilesCreated(files []*WeblensFileImpl) {
+	for _, f := range files {
+		h.created <- f
+	}
+}
+
+func (h *testWatchHandler) FileMoved(_, postMoveFile *WeblensFileImpl) {
+	h.moved <- postMoveFile
+}
+
+func (h *testWatchHandler) FilesDeleted(files []*WeblensFileImpl) {
+	for _, f := range files {
+		h.deleted <- f
+	}
+}
+
+func (h *testWatchHandler) FilesResized([]*WeblensFileImpl) {}
+
+func waitForWatchedFile(t *testing.T, c chan *WeblensFileImpl) *WeblensFileImpl {
+	select {
+	case f := <-c:
+		return f
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timed out waiting for file watcher")
+		return nil
+	}
+}
+
+func TestJournalImpl_FileWatcher(t *testing.T) {
+	mondb, err := database.ConnectToMongo(env.GetMongoURI(), env.GetMongoDBName(env.Config{}))
+	if err != nil {
+		panic(err)
+	}
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	hasherFactory := func() Hasher {
+		hasher := mock.NewMockHasher()
+		hasher.SetShouldCount(true)
+		return hasher
+	}
+
+	col := mondb.Collection(t.Name())
+	err = col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	journal, err := NewJournal(col, "weblens_test_server", false, hasherFactory, logger)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+	tree.SetJournal(journal)
+
+	event := journal.NewEvent()
+	event.NewCreateAction(tree.GetRoot())
+	journal.LogEvent(event)
+	event.Wait()
+
+	handler := &testWatchHandler{
+		created: make(chan *WeblensFileImpl, 10),
+		moved:   make(chan *WeblensFileImpl, 10),
+		deleted: make(chan *WeblensFileImpl, 10),
+	}
+	journal.SetWatchHandler(handler)
+	go journal.FileWatcher()
+
+	// Give the watcher a moment to register the root
+	time.Sleep(time.Millisecond * 100)
+
+	// Create a file out from under the tree
+	outsidePath := filepath.Join(tree.GetRoot().AbsPath(), "outside_file")
+	err = os.WriteFile(outsidePath, []byte("hello"), 0660)
+	require.NoError(t, err)
+
+	created := waitForWatchedFile(t, handler.created)
+	assert.Equal(t, "outside_file", created.Filename())
+	assert.Equal(t, created, tree.GetRoot().GetChildren()[0])
+	require.NotNil(t, journal.Get(created.ID()))
+
+	// Moving the file should keep the same lifetime
+	movedPath := filepath.Join(tree.GetRoot().AbsPath(), "moved_file")
+	err = os.Rename(outsidePath, movedPath)
+	require.NoError(t, err)
+
+	moved := waitForWatchedFile(t, handler.moved)
+	assert.Equal(t, created.ID(), moved.ID())
+	assert.Equal(t, "moved_file", moved.Filename())
+
+	lt := journal.Get(moved.ID())
+	require.NotNil(t, lt)
+	assert.Equal(t, FileMove, lt.GetLatestAction().GetActionType())
+
+	err = os.Remove(movedPath)
+	require.NoError(t, err)
+
+	deleted := waitForWatchedFile(t, handler.deleted)
+	assert.Equal(t, created.ID(), deleted.ID())
+	assert.Nil(t, tree.Get(created.ID()))
+	assert.False(t, journal.Get(created.ID()).IsLive())
+}
+
+func TestJournalImpl_FileWatcherCopy(t *testing.T) {
+	mondb, err := database.ConnectToMongo(env.GetMongoURI(), env.GetMongoDBName(env.Config{}))
+	if err != nil {
+		panic(err)
+	}
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	hasherFactory := func() Hasher {
+		hasher := mock.NewMockHasher()
+		hasher.SetShouldCount(true)
+		return hasher
+	}
+
+	col := mondb.Collection(t.Name())
+	err = col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	journal, err := NewJournal(col, "weblens_test_server", false, hasherFactory, logger)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+	tree.SetJournal(journal)
+
+	// Large enough that copying it takes longer than the watcher holds on to a create event
+	const copySize = 512 * 1024 * 1024
+
+	event := journal.NewEvent()
+	event.NewCreateAction(tree.GetRoot())
+	srcFile, err := tree.Touch(tree.GetRoot(), "large_file", event)
+	require.NoError(t, err)
+	err = os.Truncate(srcFile.AbsPath(), copySize)
+	require.NoError(t, err)
+	srcFile.SetSize(copySize)
+	srcFile.SetContentId("test-content-id")
+	journal.LogEvent(event)
+	event.Wait()
+
+	handler := &testWatchHandler{
+		created: make(chan *WeblensFileImpl, 10),
+		moved:   make(chan *WeblensFileImpl, 10),
+		deleted: make(chan *WeblensFileImpl, 10),
+	}
+	journal.SetWatchHandler(handler)
+	go journal.FileWatcher()
+
+	// Give the watcher a moment to register the root
+	time.Sleep(time.Millisecond * 100)
+
+	event = journal.NewEvent()
+	start := time.Now()
+	copied, err := tree.Copy(srcFile, tree.GetRoot(), "large_file_copy", event, nil)
+	require.NoError(t, err)
+	t.Logf("Copied %d bytes in %s", copySize, time.Since(start))
+	journal.LogEvent(event)
+	event.Wait()
+
+	// Wait out the watcher, which must not import the copy as a file of its own
+	select {
+	case f := <-handler.created:
+		t.Fatalf("File watcher imported copy as a new file [%s]", f.ID())
+	case <-time.After(time.Second):
+	}
+
+	assert.Len(t, tree.GetRoot().GetChildren(), 2)
+	assert.Equal(t, copied, tree.Get(copied.ID()))
+	assert.Equal(t, int64(copySize), copied.Size())
+
+	lt := journal.Get(copied.ID())
+	require.NotNil(t, lt)
+	assert.Equal(t, FileCopy, lt.GetLatestAction().GetActionType())
+}
+
+func TestJournalImpl_FileWatcherMkDir(t *testing.T) {
+	mondb, err := database.ConnectToMongo(env.GetMongoURI(), env.GetMongoDBName(env.Config{}))
+	if err != nil {
+		panic(err)
+	}
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	hasherFactory := func() Hasher {
+		hasher := mock.NewMockHasher()
+		hasher.SetShouldCount(true)
+		return hasher
+	}
+
+	col := mondb.Collection(t.Name())
+	err = col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	journal, err := NewJournal(col, "weblens_test_server", false, hasherFactory, logger)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+	tree.SetJournal(journal)
+
+	event := journal.NewEvent()
+	event.NewCreateAction(tree.GetRoot())
+	journal.LogEvent(event)
+	event.Wait()
+
+	handler := &testWatchHandler{
+		created: make(chan *WeblensFileImpl, 10),
+		moved:   make(chan *WeblensFileImpl, 10),
+		deleted: make(chan *WeblensFileImpl, 10),
+	}
+	journal.SetWatchHandler(handler)
+	go journal.FileWatcher()
+
+	// Give the watcher a moment to register the root
+	time.Sleep(time.Millisecond * 100)
+
+	// Folders made through weblens are watched as soon as they are added to the tree
+	event = journal.NewEvent()
+	dir, err := tree.MkDir(tree.GetRoot(), "new_dir", event)
+	require.NoError(t, err)
+	journal.LogEvent(event)
+	event.Wait()
+
+	assert.True(t, dir.IsWatching())
+	assert.DirExists(t, dir.AbsPath())
+
+	// The watcher must not import the new folder as a file of its own
+	select {
+	case f := <-handler.created:
+		t.Fatalf("File watcher imported folder made by weblens as a new file [%s]", f.ID())
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	// A file created inside the new folder shows up, so the folder is being watched
+	err = os.WriteFile(filepath.Join(dir.AbsPath(), "inside_file"), []byte("hello"), 0660)
+	require.NoError(t, err)
+
+	created := waitForWatchedFile(t, handler.created)
+	assert.Equal(t, "inside_file", created.Filename())
+	assert.Equal(t, dir.ID(), created.GetParentId())
+}
@@ -0,0 +1,253 @@
+package fileTree
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// compactAfterRemoved is how many removed names a scope will hold on to before it considers rebuilding
+const compactAfterRemoved = 1024
+
+// FilenameIndex finds files by the text in their names without looking at every file. Each name is broken
+// into the trigrams (runs of 3 characters) it contains, and a search only reads the files that have every
+// trigram in the query.
+//
+// Names are kept in scopes, which in the users tree are the home folder of each user, so a search
+// in one users files is not slowed down by how many files the other users have.
+type FilenameIndex struct {
+	scopes map[string]*filenameScope
+	docs   map[FileId]filenameDoc
+
+	mu sync.RWMutex
+}
+
+type filenameDoc struct {
+	scope string
+	num   uint32
+}
+
+// filenameScope is the index of the names in one scope. Names are numbered in the order they are added,
+// so the list of names with a trigram is always sorted, and adding a name only appends to it.
+type filenameScope struct {
+	names    []indexedName
+	postings map[trigram][]uint32
+	removed  int
+}
+
+type indexedName struct {
+	id   FileId
+	name string
+	gone bool
+}
+
+type trigram [3]rune
+
+func NewFilenameIndex() *FilenameIndex {
+	return &FilenameIndex{
+		scopes: map[string]*filenameScope{},
+		docs:   map[FileId]filenameDoc{},
+	}
+}
+
+// Set adds the file to the index, or updates it if its name or scope have changed
+func (idx *FilenameIndex) Set(id FileId, filename, scope string) {
+	name := strings.ToLower(filename)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if doc, ok := idx.docs[id]; ok {
+		if doc.scope == scope && idx.scopes[scope].names[doc.num].name == name {
+			return
+		}
+		idx.removeInternal(id)
+	}
+
+	s := idx.scopes[scope]
+	if s == nil {
+		s = &filenameScope{postings: map[trigram][]uint32{}}
+		idx.scopes[scope] = s
+	}
+
+	num := s.add(id, name)
+	idx.docs[id] = filenameDoc{scope: scope, num: num}
+}
+
+func (idx *FilenameIndex) Remove(id FileId) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeInternal(id)
+}
+
+// ReplaceId moves the name indexed for oldId over to newId
+func (idx *FilenameIndex) ReplaceId(oldId, newId FileId) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	doc, ok := idx.docs[oldId]
+	if !ok {
+		return
+	}
+
+	delete(idx.docs, oldId)
+	idx.docs[newId] = doc
+	idx.scopes[doc.scope].names[doc.num].id = newId
+}
+
+func (idx *FilenameIndex) Size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Search finds the ids of the files in the scope whose names contain every one of the words, ignoring case.
+// An empty scope searches all of them.
+func (idx *FilenameIndex) Search(words []string, scope string) []FileId {
+	var grams []trigram
+	lowerWords := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ToLower(word)
+		lowerWords = append(lowerWords, word)
+		grams = append(grams, trigramsOf(word)...)
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var ids []FileId
+	for scopeName, s := range idx.scopes {
+		if scope != "" && scopeName != scope {
+			continue
+		}
+
+		for _, num := range s.candidates(grams) {
+			name := s.names[num]
+			if name.gone {
+				continue
+			}
+
+			if !slices.ContainsFunc(lowerWords, func(word string) bool { return !strings.Contains(name.name, word) }) {
+				ids = append(ids, name.id)
+			}
+		}
+	}
+
+	return ids
+}
+
+func (idx *FilenameIndex) removeInternal(id FileId) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	delete(idx.docs, id)
+
+	s := idx.scopes[doc.scope]
+	s.names[doc.num].gone = true
+	s.removed++
+
+	// Removed names are only skipped over, so once they make up most of the scope, it is rebuilt without them
+	if s.removed < compactAfterRemoved || s.removed < len(s.names)/2 {
+		return
+	}
+
+	if s.removed == len(s.names) {
+		delete(idx.scopes, doc.scope)
+		return
+	}
+
+	compacted := &filenameScope{postings: map[trigram][]uint32{}}
+	for _, name := range s.names {
+		if name.gone {
+			continue
+		}
+		num := compacted.add(name.id, name.name)
+		idx.docs[name.id] = filenameDoc{scope: doc.scope, num: num}
+	}
+	idx.scopes[doc.scope] = compacted
+}
+
+func (s *filenameScope) add(id FileId, name string) uint32 {
+	num := uint32(len(s.names))
+	s.names = append(s.names, indexedName{id: id, name: name})
+
+	grams := trigramsOf(name)
+	slices.SortFunc(grams, compareTrigrams)
+	for _, gram := range slices.Compact(grams) {
+		s.postings[gram] = append(s.postings[gram], num)
+	}
+
+	return num
+}
+
+// candidates returns the numbers of the names that have every one of the trigrams. With no trigrams,
+// which happens when the words are all shorter than 3 characters, every name is a candidate.
+func (s *filenameScope) candidates(grams []trigram) []uint32 {
+	if len(grams) == 0 {
+		all := make([]uint32, len(s.names))
+		for i := range all {
+			all[i] = uint32(i)
+		}
+		return all
+	}
+
+	lists := make([][]uint32, 0, len(grams))
+	for _, gram := range grams {
+		list, ok := s.postings[gram]
+		if !ok {
+			return nil
+		}
+		lists = append(lists, list)
+	}
+
+	// Starting from the rarest trigram keeps the work down to the size of the smallest list
+	slices.SortFunc(lists, func(a, b []uint32) int { return len(a) - len(b) })
+
+	found := slices.Clone(lists[0])
+	for _, list := range lists[1:] {
+		found = intersectSorted(found, list)
+		if len(found) == 0 {
+			return nil
+		}
+	}
+
+	return found
+}
+
+// intersectSorted keeps the numbers in found that are also in list. Both must be sorted.
+func intersectSorted(found, list []uint32) []uint32 {
+	kept := found[:0]
+	for _, num := range found {
+		i, ok := slices.BinarySearch(list, num)
+		if ok {
+			kept = append(kept, num)
+		}
+		list = list[i:]
+	}
+
+	return kept
+}
+
+func trigramsOf(text string) []trigram {
+	runes := []rune(text)
+	if len(runes) < 3 {
+		return nil
+	}
+
+	grams := make([]trigram, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		grams = append(grams, trigram{runes[i], runes[i+1], runes[i+2]})
+	}
+
+	return grams
+}
+
+func compareTrigrams(a, b trigram) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return int(a[i] - b[i])
+		}
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package fileTree_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	. "github.com/ethanrous/weblens/fileTree"
+	"github.com/lithammer/fuzzysearch/fuzzy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilenameIndex(t *testing.T) {
+	t.Parallel()
+
+	idx := NewFilenameIndex()
+	idx.Set("a", "Quarterly Report.pdf", "alice")
+	idx.Set("b", "report notes.txt", "alice")
+	idx.Set("c", "Report.pdf", "bob")
+	idx.Set("d", "IMG_1234.jpg", "alice")
+
+	search := func(scope string, words ...string) []FileId {
+		found := idx.Search(words, scope)
+		slices.Sort(found)
+		return found
+	}
+
+	assert.Equal(t, []FileId{"a", "b", "c"}, search("", "REPORT"))
+	assert.Equal(t, []FileId{"a", "b"}, search("alice", "report"))
+	assert.Equal(t, []FileId{"a"}, search("alice", "report", "pdf"))
+	assert.Equal(t, []FileId{"d"}, search("alice", "g_1"))
+	assert.Empty(t, search("alice", "report", "jpg"))
+	assert.Empty(t, search("carol", "report"))
+
+	// Words that are too short to have any trigrams are still checked against every name
+	assert.Equal(t, []FileId{"a", "c"}, search("", "report", "df"))
+
+	// Renaming, or moving to another scope, replaces what was indexed for the file before
+	idx.Set("b", "meeting notes.txt", "alice")
+	assert.Equal(t, []FileId{"a"}, search("alice", "report"))
+	idx.Set("c", "Report.pdf", "alice")
+	assert.Equal(t, []FileId{"a", "c"}, search("alice", "report"))
+	assert.Empty(t, search("bob", "report"))
+
+	idx.ReplaceId("c", "e")
+	assert.Equal(t, []FileId{"a", "e"}, search("alice", "report"))
+
+	idx.Remove("a")
+	assert.Equal(t, []FileId{"e"}, search("", "report"))
+	assert.Equal(t, 3, idx.Size())
+
+	// Removing enough names rebuilds the scope without them, which should not lose any that are left
+	for i := range 5000 {
+		idx.Set(FileId(fmt.Sprint("bulk", i)), fmt.Sprintf("bulk file %d.txt", i), "alice")
+	}
+	for i := range 4000 {
+		idx.Remove(FileId(fmt.Sprint("bulk", i)))
+	}
+	assert.Equal(t, []FileId{"e"}, search("", "report"))
+	assert.Equal(t, []FileId{"bulk4999"}, search("alice", "file 4999"))
+	assert.Len(t, search("alice", "bulk"), 1000)
+}
+
+func TestFileTreeSearchFilenames(t *testing.T) {
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+
+	root := tree.GetRoot()
+	aliceHome, err := tree.MkDir(root, "alice", nil)
+	require.NoError(t, err)
+	bobHome, err := tree.MkDir(root, "bob", nil)
+	require.NoError(t, err)
+
+	projects, err := tree.MkDir(aliceHome, "Projects", nil)
+	require.NoError(t, err)
+	plan, err := tree.Touch(projects, "project plan.md", nil)
+	require.NoError(t, err)
+	bobPlan, err := tree.Touch(bobHome, "plan.md", nil)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []*WeblensFileImpl{plan}, tree.SearchFilenames("plan", aliceHome))
+	assert.ElementsMatch(t, []*WeblensFileImpl{plan, bobPlan}, tree.SearchFilenames("plan", root))
+	assert.ElementsMatch(t, []*WeblensFileImpl{projects, plan}, tree.SearchFilenames("project", aliceHome))
+	assert.ElementsMatch(t, []*WeblensFileImpl{plan}, tree.SearchFilenames("project", projects))
+
+	_, err = tree.Move(plan, bobHome, "the plan.md", false, nil)
+	require.NoError(t, err)
+	assert.Empty(t, tree.SearchFilenames("plan", aliceHome))
+	assert.ElementsMatch(t, []*WeblensFileImpl{plan, bobPlan}, tree.SearchFilenames("plan", bobHome))
+
+	err = tree.Delete(bobPlan.ID(), tree.GetJournal().NewEvent())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*WeblensFileImpl{plan}, tree.SearchFilenames("plan", root))
+}
+
+// The time it takes to search for a file should only depend on how many files could match it, not on how
+// many files are in the index. The names here never share a trigram with the one being searched for.
+func BenchmarkFilenameIndexSearch(b *testing.B) {
+	for _, size := range []int{10_000, 100_000, 1_000_000} {
+		idx := NewFilenameIndex()
+		for i, name := range benchFilenames(size) {
+			idx.Set(FileId(fmt.Sprint(i)), name, fmt.Sprint("user", i%10))
+		}
+		for i := range 10 {
+			idx.Set(FileId(fmt.Sprint("target", i)), fmt.Sprintf("weekend trip %d.mov", i), "user0")
+		}
+
+		b.Run(fmt.Sprintf("files=%d", size), func(b *testing.B) {
+			for range b.N {
+				if len(idx.Search([]string{"weekend", "trip"}, "user0")) != 10 {
+					b.Fatal("did not find the files")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFilenameScan is how searches worked before there was an index, for comparison
+func BenchmarkFilenameScan(b *testing.B) {
+	for _, size := range []int{10_000, 100_000, 1_000_000} {
+		names := benchFilenames(size)
+
+		b.Run(fmt.Sprintf("files=%d", size), func(b *testing.B) {
+			for range b.N {
+				_ = fuzzy.RankFindFold("weekend trip", names)
+			}
+		})
+	}
+}
+
+func benchFilenames(count int) []string {
+	r := rand.New(rand.NewPCG(1, 2))
+	names := make([]string, count)
+	for i := range names {
+		word := make([]byte, 8+r.IntN(8))
+		for j := range word {
+			word[j] = byte('a' + r.IntN(13))
+		}
+		names[i] = fmt.Sprintf("%s_%d.jpg", word, i)
+	}
+	return names
+}
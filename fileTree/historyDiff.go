@@ -0,0 +1,203 @@
+package fileTree
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethanrous/weblens/internal/werror"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type FileDiffType string
+
+const (
+	FileDiffAdded    FileDiffType = "added"
+	FileDiffRemoved  FileDiffType = "removed"
+	FileDiffMoved    FileDiffType = "moved"
+	FileDiffResized  FileDiffType = "resized"
+	FileDiffModified FileDiffType = "modified"
+)
+
+// FileDiff describes how a single file changed between two points in time. Paths are portable paths,
+// and are empty if the file was not in the folder at that time.
+type FileDiff struct {
+	FileId   FileId
+	DiffType FileDiffType
+	FromPath string
+	ToPath   string
+	FromSize int64
+	ToSize   int64
+	IsDir    bool
+}
+
+// lifetimeState is what the journal knows about a file at a single point in time
+type lifetimeState struct {
+	path      string
+	parentId  FileId
+	size      int64
+	contentId string
+}
+
+func (s lifetimeState) exists() bool {
+	return s.path != ""
+}
+
+// stateAt replays the lifetime up to and including t
+func (l *Lifetime) stateAt(t time.Time) lifetimeState {
+	var state lifetimeState
+	for _, action := range l.getSortedActions() {
+		if action.GetTimestamp().After(t) {
+			break
+		}
+
+		if action.ActionType == FileDelete {
+			state = lifetimeState{}
+			continue
+		}
+
+		state.path = action.DestinationPath
+		state.parentId = action.ParentId
+		state.size = action.Size
+	}
+
+	if state.exists() {
+		state.contentId = l.ContentIdAt(t)
+	}
+
+	return state
+}
+
+// GetFolderDiff finds each file under the folder that was added, removed, moved or resized between from and to.
+// Paths are compared relative to the folder, so moving the folder itself does not show every child as moved.
+// If recursive is false, only the direct children of the folder are considered.
+func (j *JournalImpl) GetFolderDiff(folderId FileId, from, to time.Time, recursive bool) ([]FileDiff, error) {
+	if !from.Before(to) {
+		return nil, werror.WithStack(werror.ErrBadTimeRange)
+	}
+
+	folderLt := j.Get(folderId)
+	if folderLt == nil {
+		return nil, werror.WithStack(werror.ErrNoLifetime.WithArg(folderId))
+	}
+
+	fromFolder := folderLt.stateAt(from)
+	toFolder := folderLt.stateAt(to)
+
+	var prefixes []string
+	for _, folderState := range []lifetimeState{fromFolder, toFolder} {
+		if folderState.exists() && !slices.Contains(prefixes, folderState.path) {
+			prefixes = append(prefixes, folderState.path)
+		}
+	}
+	if len(prefixes) == 0 {
+		return []FileDiff{}, nil
+	}
+
+	lifetimes, err := j.getLifetimesChangedUnder(prefixes, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	inFolder := func(folderState, state lifetimeState) (string, bool) {
+		if !folderState.exists() || !state.exists() || state.path == folderState.path {
+			return "", false
+		}
+		if !recursive && state.parentId != folderId {
+			return "", false
+		}
+		if !strings.HasPrefix(state.path, folderState.path) {
+			return "", false
+		}
+		return strings.TrimPrefix(state.path, folderState.path), true
+	}
+
+	diffs := []FileDiff{}
+	for _, lt := range lifetimes {
+		if lt.ID() == folderId {
+			continue
+		}
+
+		fromState := lt.stateAt(from)
+		toState := lt.stateAt(to)
+
+		fromRel, wasIn := inFolder(fromFolder, fromState)
+		toRel, isIn := inFolder(toFolder, toState)
+
+		diff := FileDiff{FileId: lt.ID(), IsDir: lt.GetIsDir()}
+		if wasIn {
+			diff.FromPath = fromState.path
+			diff.FromSize = fromState.size
+		}
+		if isIn {
+			diff.ToPath = toState.path
+			diff.ToSize = toState.size
+		}
+
+		switch {
+		case !wasIn && !isIn:
+			continue
+		case !wasIn:
+			diff.DiffType = FileDiffAdded
+		case !isIn:
+			diff.DiffType = FileDiffRemoved
+		case fromRel != toRel:
+			diff.DiffType = FileDiffMoved
+		case !diff.IsDir && fromState.contentId != toState.contentId:
+			diff.DiffType = FileDiffModified
+		case !diff.IsDir && fromState.size != toState.size:
+			diff.DiffType = FileDiffResized
+		default:
+			continue
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	slices.SortFunc(
+		diffs, func(a, b FileDiff) int {
+			return strings.Compare(a.ToPath+a.FromPath, b.ToPath+b.FromPath)
+		},
+	)
+
+	return diffs, nil
+}
+
+// getLifetimesChangedUnder finds the lifetimes that have an action between from and to
+// with a path under any of the given prefixes.
+func (j *JournalImpl) getLifetimesChangedUnder(prefixes []string, from, to time.Time) ([]*Lifetime, error) {
+	var pathMatch bson.A
+	for _, prefix := range prefixes {
+		pathRegex := "^" + regexp.QuoteMeta(prefix)
+		pathMatch = append(
+			pathMatch,
+			bson.M{"originPath": bson.M{"$regex": pathRegex}},
+			bson.M{"destinationPath": bson.M{"$regex": pathRegex}},
+		)
+	}
+
+	filter := bson.M{
+		"serverId": j.serverId,
+		"actions": bson.M{
+			"$elemMatch": bson.M{
+				"timestamp": bson.M{"$gt": from, "$lte": to},
+				"$or":       pathMatch,
+			},
+		},
+	}
+
+	ret, err := j.col.Find(context.Background(), filter)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	var target []*Lifetime
+	err = ret.All(context.Background(), &target)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return target, nil
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethanrous/weblens/internal/log"
 	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/fsnotify/fsnotify"
 	"github.com/viccon/sturdyc"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -38,8 +41,19 @@ type JournalImpl struct {
 	ignoreLocal bool
 
 	cache *sturdyc.Client[*Lifetime]
+
+	watcher      atomic.Pointer[fsnotify.Watcher]
+	watchHandler FileWatchHandler
+
+	eventHandlers   []FileEventHandler
+	eventHandlersMu sync.RWMutex
 }
 
+// FileEventHandler is called from the event worker with each event once it has been logged to the
+// journal. It must not block, or it will hold up every event after it, so slow work should be handed
+// off to a task.
+type FileEventHandler func(event *FileEvent)
+
 func NewJournal(col *mongo.Collection, serverId string, ignoreLocal bool, hasherFactory func() Hasher, logger log.Bundle) (
 	*JournalImpl, error,
 ) {
@@ -113,6 +127,13 @@ func (j *JournalImpl) NewEvent() *FileEvent {
 	}
 }
 
+// AddEventHandler registers a handler that is called with every event that is logged to the journal
+func (j *JournalImpl) AddEventHandler(handler FileEventHandler) {
+	j.eventHandlersMu.Lock()
+	defer j.eventHandlersMu.Unlock()
+	j.eventHandlers = append(j.eventHandlers, handler)
+}
+
 func (j *JournalImpl) SetFileTree(ft *FileTreeImpl) {
 	j.fileTree = ft
 }
@@ -217,6 +238,62 @@ func (j *JournalImpl) GetActionsByPath(path WeblensFilepath) ([]*FileAction, err
 	return j.getActionsByPath(path, false)
 }
 
+// GetRecentActions returns the newest action of each of the given types with a destination inside of folder,
+// at most one for each lifetime, newest first. Lifetimes whose newest action has a destination inside of exclude,
+// such as a file that was moved to the trash, are left out entirely, instead of falling back to an older action.
+// An empty exclude leaves nothing out.
+func (j *JournalImpl) GetRecentActions(
+	folder, exclude WeblensFilepath, actionTypes []FileActionType, limit int,
+) ([]*FileAction, error) {
+	pathMatch := bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(folder.ToPortable())}}
+
+	latestMatch := bson.D{{Key: "destinationPath", Value: pathMatch}}
+	if excludePath := exclude.ToPortable(); excludePath != "" {
+		latestMatch = bson.D{
+			{
+				Key: "$and", Value: bson.A{
+					latestMatch,
+					bson.D{{Key: "destinationPath", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(excludePath)}}}}},
+				},
+			},
+		}
+	}
+
+	pipe := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "serverId", Value: j.serverId}, {Key: "actions.destinationPath", Value: pathMatch}}}},
+		bson.D{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$actions"}}}},
+		bson.D{
+			{
+				Key: "$match", Value: bson.D{
+					{Key: "actions.actionType", Value: bson.D{{Key: "$in", Value: actionTypes}}},
+				},
+			},
+		},
+		bson.D{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$actions"}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: -1}}}},
+		bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$lifeId"}, {Key: "action", Value: bson.D{{Key: "$first", Value: "$$ROOT"}}}}}},
+		bson.D{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$action"}}}},
+		// The destination is checked after finding the newest action, so a file that has since left the folder, or
+		// gone into exclude, does not show up with an action from before it did
+		bson.D{{Key: "$match", Value: latestMatch}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: -1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	}
+
+	ret, err := j.col.Aggregate(context.Background(), pipe)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	var target []*FileAction
+	err = ret.All(context.Background(), &target)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return target, nil
+}
+
 func (j *JournalImpl) GetLatestAction() (*FileAction, error) {
 	opts := options.FindOne().SetSort(bson.M{"actions.timestamp": -1})
 
@@ -289,7 +366,7 @@ func (j *JournalImpl) GetPastFile(id FileId, time time.Time) (*WeblensFileImpl,
 	f.portablePath = path
 	f.pastFile = true
 	f.pastId = relevantAction.LifeId
-	f.SetContentId(lt.ContentId)
+	f.SetContentId(lt.ContentIdAt(time))
 	f.setModTime(relevantAction.GetTimestamp())
 
 	children, err := j.GetPastFolderChildren(f, time)
@@ -349,7 +426,7 @@ func (j *JournalImpl) GetPastFolderChildren(folder *WeblensFileImpl, time time.T
 		newChild.setModTime(time)
 		newChild.setPastFile(true)
 		newChild.size.Store(action.Size)
-		newChild.contentId = j.Get(action.LifeId).ContentId
+		newChild.contentId = j.Get(action.LifeId).ContentIdAt(time)
 		children = append(
 			children, newChild,
 		)
@@ -417,6 +494,12 @@ func (j *JournalImpl) GetLifetimesSince(date time.Time) ([]*Lifetime, error) {
 
 func (j *JournalImpl) Close() {
 	close(j.eventStream)
+
+	if watcher := j.watcher.Swap(nil); watcher != nil {
+		if err := watcher.Close(); err != nil {
+			j.log.ErrTrace(werror.WithStack(err))
+		}
+	}
 }
 
 func (j *JournalImpl) EventWorker() {
@@ -437,6 +520,14 @@ func (j *JournalImpl) EventWorker() {
 				j.log.ErrTrace(err)
 			}
 			close(e.LoggedChan)
+
+			if e.Logged.Load() {
+				j.eventHandlersMu.RLock()
+				for _, handler := range j.eventHandlers {
+					handler(e)
+				}
+				j.eventHandlersMu.RUnlock()
+			}
 		}
 
 		if len(j.eventStream) == 0 {
@@ -496,7 +587,7 @@ func (j *JournalImpl) handleFileEvent(event *FileEvent) error {
 		})
 
 		actionType := action.GetActionType()
-		if actionType == FileCreate || actionType == FileRestore {
+		if actionType == FileCreate || actionType == FileRestore || actionType == FileCopy {
 			if action.Size == -1 {
 				_, err := action.file.LoadStat()
 				if err != nil {
@@ -520,7 +611,7 @@ func (j *JournalImpl) handleFileEvent(event *FileEvent) error {
 				return werror.Errorf("trying to add create action to already existing lifetime: %s", newL.ID())
 			}
 			updated = append(updated, newL)
-		} else if actionType == FileDelete || actionType == FileMove || actionType == FileSizeChange {
+		} else if actionType == FileDelete || actionType == FileMove || actionType == FileSizeChange || actionType == FileModify {
 			existing := j.Get(action.LifeId)
 			if existing == nil {
 				j.log.ErrTrace(werror.WithStack(werror.ErrNoLifetime.WithArg(action.LifeId)))
@@ -528,6 +619,10 @@ func (j *JournalImpl) handleFileEvent(event *FileEvent) error {
 			}
 			existing.Add(action)
 
+			if actionType == FileModify {
+				existing.SetContentId(action.ContentId)
+			}
+
 			updated = append(updated, existing)
 		} else {
 			return werror.Errorf("unknown file action type %s", actionType)
@@ -691,10 +786,13 @@ type Journal interface {
 
 	GetPastFile(id FileId, time time.Time) (*WeblensFileImpl, error)
 	GetActionsByPath(WeblensFilepath) ([]*FileAction, error)
+	GetRecentActions(folder, exclude WeblensFilepath, actionTypes []FileActionType, limit int) ([]*FileAction, error)
 	GetPastFolderChildren(folder *WeblensFileImpl, time time.Time) ([]*WeblensFileImpl, error)
+	GetFolderDiff(folderId FileId, from, to time.Time, recursive bool) ([]FileDiff, error)
 	GetLatestAction() (*FileAction, error)
 	GetLifetimesSince(date time.Time) ([]*Lifetime, error)
 	UpdateLifetime(lifetime *Lifetime) error
+	Compact(policy RetentionPolicy) (CompactResult, error)
 
 	EventWorker()
 	FileWatcher()
@@ -10,6 +10,7 @@ import (
 	"github.com/ethanrous/weblens/internal"
 	"github.com/ethanrous/weblens/internal/env"
 	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
 	"github.com/ethanrous/weblens/service/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -198,3 +199,269 @@ func TestJournalImpl_GetPastFile(t *testing.T) {
 	require.Equal(t, 1, len(pastDirChildren))
 	assert.Equal(t, testFile.ID(), pastDirChildren[0].ID())
 }
+
+func TestJournalImpl_Compact(t *testing.T) {
+	mondb, err := database.ConnectToMongo(env.GetMongoURI(), env.GetMongoDBName(env.Config{}))
+	if err != nil {
+		panic(err)
+	}
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	hasherFactory := func() Hasher {
+		return mock.NewMockHasher()
+	}
+
+	col := mondb.Collection(t.Name())
+	err = col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	journal, err := NewJournal(col, "weblens_test_server", false, hasherFactory, logger)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	// Mongo only stores milliseconds, so keep the timestamps comparable after a round trip
+	now := time.Now().Truncate(time.Millisecond)
+	day := time.Hour * 24
+
+	newLifetime := func(id FileId, contentId string, actions ...*FileAction) *Lifetime {
+		for _, a := range actions {
+			a.LifeId = id
+		}
+		return &Lifetime{Id: id, ContentId: contentId, ServerId: "weblens_test_server", Actions: actions}
+	}
+
+	oldDeleted := newLifetime(
+		"oldDeleted", "deletedContent",
+		&FileAction{ActionType: FileCreate, Timestamp: now.Add(-day * 30), DestinationPath: "USERS:old"},
+		&FileAction{ActionType: FileDelete, Timestamp: now.Add(-day * 20), OriginPath: "USERS:old"},
+	)
+	recentDeleted := newLifetime(
+		"recentDeleted", "recentContent",
+		&FileAction{ActionType: FileCreate, Timestamp: now.Add(-day * 30), DestinationPath: "USERS:recent"},
+		&FileAction{ActionType: FileDelete, Timestamp: now.Add(-day), OriginPath: "USERS:recent"},
+	)
+
+	path := "USERS:live"
+	live := newLifetime(
+		"live", "content3",
+		&FileAction{ActionType: FileCreate, Timestamp: now.Add(-time.Hour * 7), DestinationPath: path},
+		&FileAction{ActionType: FileSizeChange, Timestamp: now.Add(-time.Hour * 6), DestinationPath: path},
+		&FileAction{ActionType: FileSizeChange, Timestamp: now.Add(-time.Hour * 5), DestinationPath: path},
+		&FileAction{ActionType: FileSizeChange, Timestamp: now.Add(-time.Hour * 4), DestinationPath: path},
+		&FileAction{
+			ActionType: FileModify, Timestamp: now.Add(-time.Hour * 3), DestinationPath: path,
+			ContentId: "content2", OldContentId: "content1",
+		},
+		&FileAction{ActionType: FileSizeChange, Timestamp: now.Add(-time.Hour * 2), DestinationPath: path},
+		&FileAction{
+			ActionType: FileModify, Timestamp: now.Add(-time.Hour), DestinationPath: path,
+			ContentId: "content3", OldContentId: "content2",
+		},
+	)
+
+	err = journal.Add(oldDeleted, recentDeleted, live)
+	require.NoError(t, err)
+
+	result, err := journal.Compact(
+		RetentionPolicy{DeletedLifetimeTTL: day * 7, MaxActionsPerLifetime: 3, CollapseSizeChanges: true},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, result.LifetimesRemoved)
+	assert.Equal(t, 4, result.ActionsRemoved)
+	assert.ElementsMatch(t, []string{"deletedContent", "content1"}, result.OrphanedContentIds)
+
+	assert.Nil(t, journal.Get(oldDeleted.ID()))
+	assert.NotNil(t, journal.Get(recentDeleted.ID()))
+
+	compactedLive := journal.Get(live.ID())
+	require.NotNil(t, compactedLive)
+
+	actions := compactedLive.GetActions()
+	require.Len(t, actions, 3)
+	assert.Equal(t, FileCreate, actions[0].GetActionType())
+	assert.Equal(t, FileSizeChange, actions[1].GetActionType())
+	assert.Equal(t, FileModify, actions[2].GetActionType())
+
+	// The compacted lifetimes should be what is loaded the next time the journal starts
+	reloaded, err := NewJournal(col, "weblens_test_server", false, hasherFactory, logger)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	assert.Nil(t, reloaded.Get(oldDeleted.ID()))
+	require.NotNil(t, reloaded.Get(live.ID()))
+	assert.Len(t, reloaded.Get(live.ID()).GetActions(), 3)
+	assert.Equal(t, "content2", reloaded.Get(live.ID()).ContentIdAt(now.Add(-time.Hour*2)))
+}
+
+func TestJournalImpl_GetFolderDiff(t *testing.T) {
+	mondb, err := database.ConnectToMongo(env.GetMongoURI(), env.GetMongoDBName(env.Config{}))
+	if err != nil {
+		panic(err)
+	}
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	hasherFactory := func() Hasher {
+		hasher := mock.NewMockHasher()
+		hasher.SetShouldCount(true)
+		return hasher
+	}
+
+	col := mondb.Collection(t.Name())
+	err = col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	journal, err := NewJournal(col, "weblens_test_server", false, hasherFactory, logger)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+	tree.SetJournal(journal)
+
+	event := journal.NewEvent()
+	event.NewCreateAction(tree.GetRoot())
+
+	dir, err := tree.MkDir(tree.GetRoot(), "dir", event)
+	require.NoError(t, err)
+	subDir, err := tree.MkDir(dir, "subDir", event)
+	require.NoError(t, err)
+	toMove, err := tree.Touch(dir, "toMove", event)
+	require.NoError(t, err)
+	toDelete, err := tree.Touch(dir, "toDelete", event)
+	require.NoError(t, err)
+
+	journal.LogEvent(event)
+	event.Wait()
+
+	// Mongo only has millisecond precision, keep the events clear of the diff boundaries
+	time.Sleep(time.Millisecond * 10)
+	from := time.Now()
+	time.Sleep(time.Millisecond * 10)
+
+	changeEvent := journal.NewEvent()
+	added, err := tree.Touch(subDir, "added", changeEvent)
+	require.NoError(t, err)
+	_, err = tree.Move(toMove, dir, "moved", false, changeEvent)
+	require.NoError(t, err)
+	err = tree.Delete(toDelete.ID(), changeEvent)
+	require.NoError(t, err)
+
+	journal.LogEvent(changeEvent)
+	changeEvent.Wait()
+
+	time.Sleep(time.Millisecond * 10)
+	to := time.Now()
+
+	diffs, err := journal.GetFolderDiff(dir.ID(), from, to, true)
+	require.NoError(t, err)
+
+	diffTypes := map[FileId]FileDiffType{}
+	for _, d := range diffs {
+		diffTypes[d.FileId] = d.DiffType
+	}
+
+	assert.Equal(
+		t, map[FileId]FileDiffType{
+			added.ID():    FileDiffAdded,
+			toMove.ID():   FileDiffMoved,
+			toDelete.ID(): FileDiffRemoved,
+		}, diffTypes,
+	)
+
+	// The new file is not a direct child of the folder
+	diffs, err = journal.GetFolderDiff(dir.ID(), from, to, false)
+	require.NoError(t, err)
+	assert.Len(t, diffs, 2)
+
+	// Nothing changed before the range
+	diffs, err = journal.GetFolderDiff(dir.ID(), from.Add(-time.Millisecond*5), from, true)
+	require.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	_, err = journal.GetFolderDiff(dir.ID(), to, from, true)
+	assert.ErrorIs(t, err, werror.ErrBadTimeRange)
+}
+
+func TestJournalImpl_GetRecentActions(t *testing.T) {
+	mondb, err := database.ConnectToMongo(env.GetMongoURI(), env.GetMongoDBName(env.Config{}))
+	if err != nil {
+		panic(err)
+	}
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	hasherFactory := func() Hasher {
+		hasher := mock.NewMockHasher()
+		hasher.SetShouldCount(true)
+		return hasher
+	}
+
+	col := mondb.Collection(t.Name())
+	err = col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	journal, err := NewJournal(col, "weblens_test_server", false, hasherFactory, logger)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+	tree.SetJournal(journal)
+
+	event := journal.NewEvent()
+	event.NewCreateAction(tree.GetRoot())
+
+	home, err := tree.MkDir(tree.GetRoot(), "home", event)
+	require.NoError(t, err)
+	trash, err := tree.MkDir(home, ".user_trash", event)
+	require.NoError(t, err)
+	kept, err := tree.Touch(home, "kept", event)
+	require.NoError(t, err)
+
+	var trashed []*WeblensFileImpl
+	for _, name := range []string{"a", "b", "c"} {
+		f, err := tree.Touch(home, name, event)
+		require.NoError(t, err)
+		trashed = append(trashed, f)
+	}
+
+	journal.LogEvent(event)
+	event.Wait()
+
+	// Keep the moves clearly newer than the creates
+	time.Sleep(time.Millisecond * 10)
+
+	trashEvent := journal.NewEvent()
+	for _, f := range trashed {
+		_, err = tree.Move(f, trash, f.Filename(), false, trashEvent)
+		require.NoError(t, err)
+	}
+	journal.LogEvent(trashEvent)
+	trashEvent.Wait()
+
+	types := []FileActionType{FileCreate, FileMove}
+
+	// Without leaving out the trash, the trashed files take up the limit
+	actions, err := journal.GetRecentActions(home.GetPortablePath(), WeblensFilepath{}, types, 3)
+	require.NoError(t, err)
+	require.Len(t, actions, 3)
+	for _, a := range actions {
+		assert.Equal(t, FileMove, a.GetActionType())
+	}
+
+	// Files that were moved into the trash do not show up with their older create actions either
+	actions, err = journal.GetRecentActions(home.GetPortablePath(), trash.GetPortablePath(), types, 3)
+	require.NoError(t, err)
+
+	ids := internal.Map(actions, func(a *FileAction) FileId { return a.GetLifetimeId() })
+	assert.Contains(t, ids, kept.ID())
+	for _, f := range trashed {
+		assert.NotContains(t, ids, f.ID())
+	}
+}
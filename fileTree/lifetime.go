@@ -3,6 +3,7 @@ package fileTree
 import (
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/ethanrous/weblens/internal"
 	"github.com/ethanrous/weblens/internal/log"
@@ -21,8 +22,8 @@ type Lifetime struct {
 
 func NewLifetime(createAction *FileAction) (*Lifetime, error) {
 	actionType := createAction.GetActionType()
-	if actionType != FileCreate && actionType != FileRestore {
-		return nil, werror.Errorf("First Lifetime action must be of type FileCreate or FileRestore")
+	if actionType != FileCreate && actionType != FileRestore && actionType != FileCopy {
+		return nil, werror.Errorf("First Lifetime action must be of type FileCreate, FileRestore or FileCopy")
 	}
 
 	if createAction.file == nil {
@@ -95,7 +96,7 @@ func (l *Lifetime) GetLatestMove() *FileAction {
 
 	i := len(l.Actions) - 1
 	for i >= 0 {
-		if l.Actions[i].ActionType != FileSizeChange {
+		if l.Actions[i].ActionType != FileSizeChange && l.Actions[i].ActionType != FileModify {
 			return l.Actions[i]
 		}
 		i--
@@ -120,6 +121,71 @@ func (l *Lifetime) SetContentId(cId string) {
 	l.ContentId = cId
 }
 
+// ContentIdAt returns the id of the content the file held at the given time. Each modify
+// action records the content it replaced, so the first modification after the given time
+// tells us what the content was.
+func (l *Lifetime) ContentIdAt(t time.Time) string {
+	for _, action := range l.getSortedActions() {
+		if action.ActionType == FileModify && action.GetTimestamp().After(t) {
+			return action.OldContentId
+		}
+	}
+
+	return l.ContentId
+}
+
+// ContentVersion is a span of time over which a file held the same content
+type ContentVersion struct {
+	Begin     time.Time
+	End       time.Time
+	ContentId string
+	Size      int64
+}
+
+// GetContentVersions returns each version of the content this lifetime has held, oldest first.
+// The End of the current version is the zero time.
+func (l *Lifetime) GetContentVersions() []ContentVersion {
+	actions := l.getSortedActions()
+	if len(actions) == 0 {
+		return nil
+	}
+
+	var versions []ContentVersion
+	current := ContentVersion{Begin: actions[0].GetTimestamp()}
+	var lastSize int64
+	for _, action := range actions {
+		if action.ActionType == FileModify {
+			current.ContentId = action.OldContentId
+			current.End = action.GetTimestamp()
+			current.Size = lastSize
+			versions = append(versions, current)
+
+			current = ContentVersion{Begin: action.GetTimestamp()}
+		}
+
+		if action.ActionType != FileDelete {
+			lastSize = action.GetSize()
+		}
+	}
+
+	current.ContentId = l.ContentId
+	current.Size = lastSize
+	versions = append(versions, current)
+
+	return versions
+}
+
+func (l *Lifetime) getSortedActions() []*FileAction {
+	actions := l.GetActions()
+	slices.SortFunc(
+		actions, func(a, b *FileAction) int {
+			return a.GetTimestamp().Compare(b.GetTimestamp())
+		},
+	)
+
+	return actions
+}
+
 // IsLive returns a boolean representing if this Lifetime shows a file
 // currently on the real filesystem, and has not been deleted.
 func (l *Lifetime) IsLive() bool {
@@ -0,0 +1,54 @@
+package fileTree_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/ethanrous/weblens/fileTree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifetime_ContentVersions(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	firstModify := created.Add(time.Minute * 10)
+	secondModify := created.Add(time.Minute * 20)
+
+	lt := &Lifetime{
+		Id:        "file1",
+		ContentId: "content3",
+		Actions: []*FileAction{
+			{ActionType: FileCreate, Timestamp: created, Size: 10, DestinationPath: "USERS:test/file"},
+			{
+				ActionType: FileModify, Timestamp: firstModify, Size: 20, DestinationPath: "USERS:test/file",
+				ContentId: "content2", OldContentId: "content1",
+			},
+			{
+				ActionType: FileModify, Timestamp: secondModify, Size: 30, DestinationPath: "USERS:test/file",
+				ContentId: "content3", OldContentId: "content2",
+			},
+		},
+	}
+
+	assert.Equal(t, "content1", lt.ContentIdAt(created.Add(time.Minute)))
+	assert.Equal(t, "content2", lt.ContentIdAt(firstModify.Add(time.Minute)))
+	assert.Equal(t, "content3", lt.ContentIdAt(time.Now()))
+
+	// Modifications should not be mistaken for moves
+	assert.Equal(t, FileCreate, lt.GetLatestMove().GetActionType())
+
+	versions := lt.GetContentVersions()
+	require.Len(t, versions, 3)
+
+	assert.Equal(t, "content1", versions[0].ContentId)
+	assert.Equal(t, int64(10), versions[0].Size)
+	assert.Equal(t, created, versions[0].Begin)
+	assert.Equal(t, firstModify, versions[0].End)
+
+	assert.Equal(t, "content2", versions[1].ContentId)
+	assert.Equal(t, int64(20), versions[1].Size)
+
+	assert.Equal(t, "content3", versions[2].ContentId)
+	assert.Equal(t, int64(30), versions[2].Size)
+	assert.True(t, versions[2].End.IsZero())
+}
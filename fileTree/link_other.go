@@ -0,0 +1,13 @@
+//go:build !unix
+
+package fileTree
+
+import (
+	"os"
+)
+
+// linkCount is the number of hardlinks to the file described by info. Link counts are
+// not exposed on this platform, so every file is treated as having only one link
+func linkCount(_ os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package fileTree
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkCount is the number of hardlinks to the file described by info
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
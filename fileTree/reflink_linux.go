@@ -0,0 +1,125 @@
+package fileTree
+
+import (
+	"math"
+	"os"
+	"unsafe"
+
+	"github.com/ethanrous/weblens/internal/werror"
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates dst as a copy-on-write clone of src. This only works if the filesystem
+// supports FICLONE (btrfs, xfs, ...) and both paths are on the same filesystem.
+func reflink(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	err = unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd()))
+	closeErr := dstFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+const (
+	fsIocFiemap = 0xC020660B
+
+	fiemapFlagSync     = 0x1
+	fiemapExtentLast   = 0x1
+	fiemapExtentShared = 0x2000
+
+	// fiemapExtentBatch is how many extents are asked for with each FIEMAP call
+	fiemapExtentBatch = 32
+)
+
+type fiemapExtent struct {
+	Logical  uint64
+	Physical uint64
+	Length   uint64
+	_        [2]uint64
+	Flags    uint32
+	_        [3]uint32
+}
+
+// fiemapRequest is laid out the same as struct fiemap, with room for a batch of extents after it
+type fiemapRequest struct {
+	Start         uint64
+	Length        uint64
+	Flags         uint32
+	MappedExtents uint32
+	ExtentCount   uint32
+	_             uint32
+	Extents       [fiemapExtentBatch]fiemapExtent
+}
+
+// fileExtents lists where on disk the content of the file at path is kept
+func fileExtents(path string) ([]fiemapExtent, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+	defer fp.Close()
+
+	var extents []fiemapExtent
+	var start uint64
+	for {
+		req := fiemapRequest{Start: start, Length: math.MaxUint64, Flags: fiemapFlagSync, ExtentCount: fiemapExtentBatch}
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, fp.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&req)))
+		if errno != 0 {
+			return nil, werror.WithStack(errno)
+		}
+
+		if req.MappedExtents == 0 {
+			return extents, nil
+		}
+
+		for _, extent := range req.Extents[:req.MappedExtents] {
+			extents = append(extents, extent)
+			if extent.Flags&fiemapExtentLast != 0 {
+				return extents, nil
+			}
+		}
+
+		last := req.Extents[req.MappedExtents-1]
+		start = last.Logical + last.Length
+	}
+}
+
+// sharesExtents reports if the files at a and b are reflinks of each other, where every extent of their content
+// is the same shared extent on disk. If the filesystem cannot say where the content is, they are not.
+func sharesExtents(a, b string) bool {
+	aExtents, err := fileExtents(a)
+	if err != nil || len(aExtents) == 0 {
+		return false
+	}
+
+	bExtents, err := fileExtents(b)
+	if err != nil || len(aExtents) != len(bExtents) {
+		return false
+	}
+
+	for i, extent := range aExtents {
+		other := bExtents[i]
+		if extent.Flags&fiemapExtentShared == 0 || other.Flags&fiemapExtentShared == 0 ||
+			extent.Logical != other.Logical || extent.Physical != other.Physical || extent.Length != other.Length {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package fileTree
+
+import (
+	"errors"
+
+	"github.com/ethanrous/weblens/internal/werror"
+)
+
+func reflink(_, _ string) error {
+	return werror.WithStack(errors.ErrUnsupported)
+}
+
+// sharesExtents reports if the files at a and b are reflinks of each other. Only linux can reflink files.
+func sharesExtents(_, _ string) bool {
+	return false
+}
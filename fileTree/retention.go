@@ -0,0 +1,251 @@
+package fileTree
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// compactWriteBatch is how many lifetime writes are sent to mongo at once while compacting
+const compactWriteBatch = 1000
+
+// RetentionPolicy describes how much file history the journal should hold on to.
+// The zero value of each field disables that part of the policy.
+type RetentionPolicy struct {
+	// DeletedLifetimeTTL is how long to keep the history of a file after it has been deleted
+	DeletedLifetimeTTL time.Duration
+
+	// MaxActionsPerLifetime is the most actions to keep for any one file. The first and latest
+	// actions are always kept, so values below 2 are treated as 2.
+	MaxActionsPerLifetime int
+
+	// CollapseSizeChanges keeps only the last of each run of consecutive size change actions
+	CollapseSizeChanges bool
+}
+
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.DeletedLifetimeTTL <= 0 && p.MaxActionsPerLifetime <= 0 && !p.CollapseSizeChanges
+}
+
+type CompactResult struct {
+	LifetimesRemoved int
+	ActionsRemoved   int
+
+	// OrphanedContentIds are the ids of content that the journal can no longer restore,
+	// and can be removed from the restore tree.
+	OrphanedContentIds []string
+}
+
+// compact drops the actions from the lifetime that fall outside of the policy, and returns
+// the actions that were dropped. The lifetime keeps its first and latest action no matter the policy.
+func (l *Lifetime) compact(policy RetentionPolicy) []*FileAction {
+	l.actionsLock.Lock()
+	defer l.actionsLock.Unlock()
+
+	if len(l.Actions) <= 2 {
+		return nil
+	}
+
+	slices.SortFunc(
+		l.Actions, func(a, b *FileAction) int {
+			return a.GetTimestamp().Compare(b.GetTimestamp())
+		},
+	)
+
+	var dropped []*FileAction
+	kept := make([]*FileAction, 0, len(l.Actions))
+
+	for i, action := range l.Actions {
+		if policy.CollapseSizeChanges && action.ActionType == FileSizeChange && i != 0 && i != len(l.Actions)-1 &&
+			l.Actions[i+1].ActionType == FileSizeChange {
+			dropped = append(dropped, action)
+			continue
+		}
+		kept = append(kept, action)
+	}
+
+	maxActions := policy.MaxActionsPerLifetime
+	if maxActions > 0 && maxActions < 2 {
+		maxActions = 2
+	}
+
+	if maxActions > 0 && len(kept) > maxActions {
+		// Drop the oldest actions, other than the first
+		trimCount := len(kept) - maxActions
+		dropped = append(dropped, kept[1:1+trimCount]...)
+		kept = slices.Delete(kept, 1, 1+trimCount)
+	}
+
+	l.Actions = kept
+
+	return dropped
+}
+
+// referencedContentIds returns each contentId the lifetime could still restore
+func (l *Lifetime) referencedContentIds() []string {
+	var ids []string
+	if l.ContentId != "" {
+		ids = append(ids, l.ContentId)
+	}
+
+	for _, action := range l.GetActions() {
+		if action.ActionType == FileModify && action.OldContentId != "" {
+			ids = append(ids, action.OldContentId)
+		}
+	}
+
+	return ids
+}
+
+// Compact applies the retention policy to each lifetime in the journal. Lifetimes of files that have been
+// deleted for longer than the policy allows are removed entirely, and the rest have their actions trimmed.
+func (j *JournalImpl) Compact(policy RetentionPolicy) (CompactResult, error) {
+	var result CompactResult
+	if policy.IsEmpty() {
+		return result, nil
+	}
+
+	start := time.Now()
+
+	cur, err := j.col.Find(context.Background(), bson.M{"serverId": j.serverId})
+	if err != nil {
+		return result, werror.WithStack(err)
+	}
+	defer cur.Close(context.Background())
+
+	c := &compaction{
+		policy:            policy,
+		deleteBefore:      start.Add(-policy.DeletedLifetimeTTL),
+		result:            &result,
+		droppedContent:    map[string]struct{}{},
+		referencedContent: map[string]struct{}{},
+	}
+
+	for {
+		more, err := j.compactBatch(cur, c)
+		if err != nil {
+			return result, err
+		}
+		if !more {
+			break
+		}
+	}
+
+	// Content that is dropped from one lifetime may still be restorable through another,
+	// so only report content that is no longer referenced anywhere.
+	for cId := range c.droppedContent {
+		if _, ok := c.referencedContent[cId]; !ok {
+			result.OrphanedContentIds = append(result.OrphanedContentIds, cId)
+		}
+	}
+
+	j.log.Debug.Func(func(l log.Logger) {
+		l.Printf(
+			"Journal compaction removed %d lifetimes and %d actions, orphaning %d contents, in %s",
+			result.LifetimesRemoved, result.ActionsRemoved, len(result.OrphanedContentIds), time.Since(start),
+		)
+	})
+
+	return result, nil
+}
+
+type compaction struct {
+	policy            RetentionPolicy
+	deleteBefore      time.Time
+	result            *CompactResult
+	droppedContent    map[string]struct{}
+	referencedContent map[string]struct{}
+}
+
+// compactBatch compacts the next batch of lifetimes from the cursor, and reports if there are more to go.
+func (j *JournalImpl) compactBatch(cur *mongo.Cursor, c *compaction) (bool, error) {
+	// Hold the lock the event worker takes for each event, so no actions are added to the lifetimes
+	// while we are rewriting them. Locking per batch lets file events through between batches, so
+	// every lifetime read counts toward the batch, whether or not it changed.
+	j.flushCond.L.Lock()
+	defer j.flushCond.L.Unlock()
+
+	var removeIds []FileId
+	var writes []mongo.WriteModel
+	var compacted []*Lifetime
+
+	more := true
+	for scanned := 0; scanned < compactWriteBatch; scanned++ {
+		if !cur.Next(context.Background()) {
+			more = false
+			break
+		}
+
+		lt := &Lifetime{}
+		err := cur.Decode(lt)
+		if err != nil {
+			return false, werror.WithStack(err)
+		}
+
+		// The cursor may have read the lifetime before the event worker last updated it,
+		// the cached lifetime is the one the worker keeps up to date.
+		if cached, ok := j.cache.Get(lt.ID()); ok && cached != nil {
+			lt = cached
+		}
+
+		if c.policy.DeletedLifetimeTTL > 0 && !lt.IsLive() && lt.GetLatestAction().GetTimestamp().Before(c.deleteBefore) {
+			for _, cId := range lt.referencedContentIds() {
+				c.droppedContent[cId] = struct{}{}
+			}
+			removeIds = append(removeIds, lt.ID())
+			continue
+		}
+
+		dropped := lt.compact(c.policy)
+		for _, action := range dropped {
+			if action.ActionType == FileModify && action.OldContentId != "" {
+				c.droppedContent[action.OldContentId] = struct{}{}
+			}
+		}
+
+		for _, cId := range lt.referencedContentIds() {
+			c.referencedContent[cId] = struct{}{}
+		}
+
+		if len(dropped) == 0 {
+			continue
+		}
+
+		c.result.ActionsRemoved += len(dropped)
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": lt.ID()}).SetReplacement(lt))
+		compacted = append(compacted, lt)
+	}
+	if err := cur.Err(); err != nil {
+		return false, werror.WithStack(err)
+	}
+
+	if len(writes) != 0 {
+		_, err := j.col.BulkWrite(context.Background(), writes)
+		if err != nil {
+			return false, werror.WithStack(err)
+		}
+
+		for _, lt := range compacted {
+			j.cache.Set(lt.ID(), lt)
+		}
+	}
+
+	if len(removeIds) != 0 {
+		_, err := j.col.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": removeIds}})
+		if err != nil {
+			return false, werror.WithStack(err)
+		}
+
+		for _, id := range removeIds {
+			j.cache.Delete(id)
+		}
+		c.result.LifetimesRemoved += len(removeIds)
+	}
+
+	return more, nil
+}
@@ -90,13 +90,17 @@ type WeblensFileImpl struct {
 	// Lock to atomize long file events
 	fileLock sync.Mutex
 
+	// Held for reading while the file is written to, and for writing while the content
+	// of the file is swapped out for a link. See dedup.go
+	linkLock sync.RWMutex
+
 	// If we already have added the file to the watcher
 	// See fileWatch.go
 	watching bool
 
 	// Mark file as read-only internally.
 	// This should be checked before any write action is to be performed.
-	// This should not be changed during run-time, only set in InitMediaRoot.
+	// This should not be changed during run-time, only set on the root by NewReadOnlyFileTree.
 	// If a directory is `readOnly`, all children are as well
 	readOnly bool
 
@@ -144,6 +148,7 @@ func (f *WeblensFileImpl) Freeze() *WeblensFileImpl {
 
 	c.childLock = sync.RWMutex{}
 	c.updateLock = sync.RWMutex{}
+	c.linkLock = sync.RWMutex{}
 
 	return &c
 }
@@ -326,6 +331,16 @@ func (f *WeblensFileImpl) Readable() (io.Reader, error) {
 func (f *WeblensFileImpl) Writeable() (*os.File, error) {
 	if f.IsDir() {
 		return nil, fmt.Errorf("attempt to read from directory")
+	} else if f.readOnly {
+		return nil, werror.WithStack(werror.ErrReadOnly.WithArg(f.GetPortablePath().ToPortable()))
+	}
+
+	// The file is written to after we return, so we can only break the link up front
+	f.linkLock.Lock()
+	err := f.breakLink()
+	f.linkLock.Unlock()
+	if err != nil {
+		return nil, err
 	}
 
 	path := f.AbsPath()
@@ -368,7 +383,13 @@ func (f *WeblensFileImpl) Write(data []byte) (int, error) {
 		return len(data), nil
 	}
 
-	err := os.WriteFile(f.AbsPath(), data, 0600)
+	unlock, err := f.lockForWrite()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	err = os.WriteFile(f.AbsPath(), data, 0600)
 	if err == nil {
 		f.size.Store(int64(len(data)))
 		f.modifyDate = time.Now()
@@ -385,6 +406,12 @@ func (f *WeblensFileImpl) WriteAt(data []byte, seekLoc int64) error {
 		panic(werror.NotImplemented("memOnly file write at"))
 	}
 
+	unlock, err := f.lockForWrite()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	path := f.AbsPath()
 	realFile, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0664)
 	if err != nil {
@@ -416,6 +443,12 @@ func (f *WeblensFileImpl) Append(data []byte) error {
 		return nil
 	}
 
+	unlock, err := f.lockForWrite()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	realFile, err := os.OpenFile(f.AbsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
 	if err != nil {
 		return err
@@ -455,6 +488,25 @@ func (f *WeblensFileImpl) GetChildren() []*WeblensFileImpl {
 	return slices.Collect(maps.Values(f.childrenMap))
 }
 
+// UniqueChildName returns childName if f has no child with that name, or otherwise childName with
+// the first number that makes it unique added to the end, like "photo.jpg (1)"
+func (f *WeblensFileImpl) UniqueChildName(childName string) string {
+	dupeCount := 0
+	_, e := f.GetChild(childName)
+	for e == nil {
+		dupeCount++
+		tmp := fmt.Sprintf("%s (%d)", childName, dupeCount)
+		_, e = f.GetChild(tmp)
+	}
+
+	newFilename := childName
+	if dupeCount != 0 {
+		newFilename = fmt.Sprintf("%s (%d)", newFilename, dupeCount)
+	}
+
+	return newFilename
+}
+
 func (f *WeblensFileImpl) AddChild(child *WeblensFileImpl) error {
 	if !f.IsDir() {
 		return werror.WithStack(werror.ErrDirectoryRequired)
@@ -661,6 +713,10 @@ func (f *WeblensFileImpl) IsParentOf(child *WeblensFileImpl) bool {
 	return strings.HasPrefix(child.AbsPath(), f.AbsPath())
 }
 
+func (f *WeblensFileImpl) IsWatching() bool {
+	return f.watching
+}
+
 func (f *WeblensFileImpl) SetWatching() error {
 	if f.watching {
 		return werror.ErrAlreadyWatching
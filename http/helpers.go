@@ -3,10 +3,13 @@ package http
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal"
 	"github.com/ethanrous/weblens/internal/log"
 	"github.com/ethanrous/weblens/internal/werror"
@@ -154,6 +157,32 @@ func getShareFromCtx[T models.Share](w http.ResponseWriter, r *http.Request) (T,
 	return empty, err
 }
 
+// serveFile writes the content of f to w. Range requests, with one range or many, are supported so clients
+// can resume downloads. ETag and Last-Modified are set so conditional requests get a 304 or 412 when they should.
+func serveFile(w http.ResponseWriter, r *http.Request, f *fileTree.WeblensFileImpl) {
+	fp, err := os.Open(f.AbsPath())
+	if SafeErrorAndExit(werror.WithStack(err), w) {
+		return
+	}
+	defer fp.Close()
+
+	// The mod time is taken from the file on disk, not the tree, so it is never behind what we are about to send
+	stat, err := fp.Stat()
+	if SafeErrorAndExit(werror.WithStack(err), w) {
+		return
+	}
+
+	w.Header().Set("ETag", fileETag(f, stat))
+	http.ServeContent(w, r, f.Filename(), stat.ModTime(), fp)
+}
+
+// fileETag is a strong ETag for the content of f, made from its id, size and mod time on disk. The content id is
+// not used, as it is not hashed again when the file is changed outside of weblens, and an edit that keeps the
+// size the same would then keep its old ETag, letting a client resume with bytes that do not go with the ones it has.
+func fileETag(f *fileTree.WeblensFileImpl, stat os.FileInfo) string {
+	return fmt.Sprintf(`"%s-%x-%x"`, f.ID(), stat.Size(), stat.ModTime().UnixNano())
+}
+
 type FileStat struct {
 	ModTime time.Time `json:"modifyTimestamp"`
 	Name    string    `json:"name"`
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/service/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeFile(t *testing.T) {
+	t.Parallel()
+
+	tree, err := fileTree.NewFileTree(t.TempDir()+"/", "USERS", mock.NewHollowJournalService(), false)
+	require.NoError(t, err)
+
+	f, err := tree.Touch(tree.GetRoot(), "notes.txt", nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(f.AbsPath(), []byte("hello world"), 0666))
+
+	serve := func(headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		serveFile(w, r, f)
+		return w
+	}
+
+	w := serve(nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello world", w.Body.String())
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	w = serve(map[string]string{"Range": "bytes=6-"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "world", w.Body.String())
+	assert.Equal(t, "bytes 6-10/11", w.Header().Get("Content-Range"))
+
+	// Asking for more than one range gets each of them in its own part
+	w = serve(map[string]string{"Range": "bytes=0-4,6-10"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	var parts []string
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts = append(parts, string(data))
+	}
+	assert.Equal(t, []string{"hello", "world"}, parts)
+
+	w = serve(map[string]string{"Range": "bytes=100-200"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	w = serve(map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = serve(map[string]string{"If-Match": `"something-else"`})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serve(map[string]string{"If-Match": etag, "Range": "bytes=6-", "If-Range": etag})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "world", w.Body.String())
+
+	// An edit from outside of weblens that keeps the size the same still changes the ETag, so a download of
+	// the old content is not resumed with bytes from the new content
+	require.NoError(t, os.WriteFile(f.AbsPath(), []byte("HELLO WORLD"), 0666))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(f.AbsPath(), later, later))
+
+	w = serve(map[string]string{"Range": "bytes=6-", "If-Range": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HELLO WORLD", w.Body.String())
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
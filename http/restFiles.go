@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"mime"
 	"net/http"
+	"path"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal"
+	"github.com/ethanrous/weblens/internal/archive"
 	"github.com/ethanrous/weblens/internal/log"
 	"github.com/ethanrous/weblens/internal/werror"
 	"github.com/ethanrous/weblens/models"
@@ -112,7 +116,7 @@ func getFileText(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.ServeFile(w, r, file.Filename())
+	serveFile(w, r, file)
 }
 
 // GetFileStats godoc
@@ -169,8 +173,13 @@ func getFileStats(w http.ResponseWriter, r *http.Request) {
 //	@Param		fileId		path		string					true	"File Id"
 //	@Param		shareId		query		string					false	"Share Id"
 //	@Param		isTakeout	query		bool					false	"Is this a takeout file"	Enums(true, false)	default(false)
+//	@Param		Range		header		string					false	"Byte ranges of the file to download"
 //	@Success	200			{string}	binary					"File content"
+//	@Success	206			{string}	binary					"Requested ranges of the file content"
+//	@Success	304
 //	@Success	404			{object}	rest.WeblensErrorInfo	"Error Info"
+//	@Failure	412
+//	@Failure	416
 //	@Router		/files/{fileId}/download [get]
 func downloadFile(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
@@ -206,10 +215,22 @@ func downloadFile(w http.ResponseWriter, r *http.Request) {
 		if SafeErrorAndExit(err, w) {
 			return
 		}
+
+		if pack.FileActivityService != nil && !u.IsPublic() {
+			err = pack.FileActivityService.RecordDownload(u.GetUsername(), file.ID())
+			if err != nil {
+				log.ErrTrace(err)
+			}
+		}
+	}
+
+	// Go does not know the mime types of all of the takeout formats, so it is not left to guess them
+	if format, ok := archive.DetectFormat(file.Filename()); ok && isTakeout == "true" {
+		w.Header().Set("Content-Type", format.ContentType())
 	}
 
 	pack.Log.Debug.Println("Downloading file", file.GetPortablePath())
-	http.ServeFile(w, r, file.AbsPath())
+	serveFile(w, r, file)
 }
 
 // GetFolderHistory godoc
@@ -266,22 +287,207 @@ func getFolderHistory(w http.ResponseWriter, r *http.Request) {
 	writeJson(w, http.StatusOK, actionInfos)
 }
 
-// SearchByFilename godoc
+// GetFolderHistoryDiff godoc
 //
-//	@ID			SearchByFilename
+//	@ID			GetFolderHistoryDiff
+//
+//	@Security	SessionAuth
+//
+//	@Summary	Get the files in a folder that changed between two points in time
+//	@Tags		Folder
+//	@Param		fileId		path	string				true	"Folder Id"
+//	@Param		from		query	int					true	"Start of the time range, in ms since epoch"
+//	@Param		to			query	int					false	"End of the time range, in ms since epoch. Defaults to now"
+//	@Param		recursive	query	bool				false	"Include changes to files in sub-folders"
+//	@Param		shareId		query	string				false	"Share Id"
+//	@Success	200			{array}	rest.FileDiffInfo	"Changed files"
+//	@Failure	400
+//	@Failure	404
+//	@Failure	500
+//	@Router		/files/{fileId}/history/diff [get]
+func getFolderHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	share, err := getShareFromCtx[*models.FileShare](w, r)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	folder, err := pack.FileService.GetFileSafe(chi.URLParam(r, "fileId"), u, share)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if !folder.IsDir() {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "history diff requires a folder"})
+		return
+	}
+
+	fromMillis, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil || fromMillis < 0 {
+		SafeErrorAndExit(werror.ErrBadTimestamp, w)
+		return
+	}
+	from := time.UnixMilli(fromMillis)
+
+	to := time.Now()
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		toMillis, err := strconv.ParseInt(toStr, 10, 64)
+		if err != nil || toMillis < 0 {
+			SafeErrorAndExit(werror.ErrBadTimestamp, w)
+			return
+		}
+		to = time.UnixMilli(toMillis)
+	}
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+
+	diffs, err := pack.FileService.GetJournalByTree("USERS").GetFolderDiff(folder.ID(), from, to, recursive)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	diffInfos := make([]rest.FileDiffInfo, 0, len(diffs))
+	for _, d := range diffs {
+		diffInfos = append(diffInfos, rest.FileDiffToFileDiffInfo(d))
+	}
+
+	writeJson(w, http.StatusOK, diffInfos)
+}
+
+// GetFileVersions godoc
+//
+//	@ID			GetFileVersions
 //
 //	@Security	SessionAuth
 //
-//	@Summary	Search for files by filename
+//	@Summary	Get the versions of content a file has held, oldest first
 //	@Tags		Files
+//	@Param		fileId	path	string					true	"File Id"
+//	@Param		shareId	query	string					false	"Share Id"
+//	@Success	200		{array}	rest.FileVersionInfo	"File versions"
+//	@Failure	400
+//	@Failure	404
+//	@Failure	500
+//	@Router		/files/{fileId}/versions [get]
+func getFileVersions(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	share, err := getShareFromCtx[*models.FileShare](w, r)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	file, err := pack.FileService.GetFileSafe(chi.URLParam(r, "fileId"), u, share)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if file.IsDir() {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "directories do not have versions"})
+		return
+	}
+
+	versions, err := pack.FileService.GetFileVersions(file)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	versionInfos := make([]rest.FileVersionInfo, 0, len(versions))
+	for _, v := range versions {
+		available := v.ContentId != ""
+		if available {
+			_, err = pack.FileService.GetFileByContentId(v.ContentId)
+			available = err == nil
+		}
+		versionInfos = append(versionInfos, rest.ContentVersionToFileVersionInfo(v, available))
+	}
+
+	writeJson(w, http.StatusOK, versionInfos)
+}
+
+// RestoreFileVersion godoc
+//
+//	@ID			RestoreFileVersion
+//
+//	@Security	SessionAuth
 //
-//	@Param		search			query	string			true	"Filename to search for"
-//	@Param		baseFolderId	query	string			false	"The folder to search in, defaults to the user's home folder"
-//	@Success	200				{array}	rest.FileInfo	"File Info"
+//	@Summary	Roll the content of a file back to an earlier version
+//	@Tags		Files
+//	@Param		fileId		path	string	true	"File Id"
+//	@Param		contentId	path	string	true	"Content Id of the version to restore"
+//	@Param		shareId		query	string	false	"Share Id"
+//	@Success	200
 //	@Failure	400
-//	@Failure	401
+//	@Failure	403
+//	@Failure	404
 //	@Failure	500
-//	@Router		/files/search [get]
+//	@Router		/files/{fileId}/versions/{contentId}/restore [post]
+func restoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	share, err := getShareFromCtx[*models.FileShare](w, r)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	file, err := pack.FileService.GetFileSafe(chi.URLParam(r, "fileId"), u, share)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if pack.FileService.IsFileInTrash(file) {
+		writeJson(w, http.StatusForbidden, rest.WeblensErrorInfo{Error: "cannot restore version of file in trash"})
+		return
+	}
+
+	err = pack.FileService.RestoreFileVersion(file, chi.URLParam(r, "contentId"), pack.Caster)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// SearchByFilename godoc
+//
+//	@ID			SearchByFilename
+//
+//	@Security	SessionAuth
+//
+//	@Summary		Search for files by filename, or by their content
+//	@Description	If content is given, files are searched for by the words in them instead of by filename,
+//	@Description	and the response is a list of rest.ContentSearchResult, with snippets of the matching text.
+//	@Description	Filename searches can also filter by other things about the files, see models.FileQuery for the filters.
+//	@Description	Names that contain the words of the search are found first, and if there are none, the words are matched
+//	@Description	loosely, so "hldy" finds "holiday.mov".
+//	@Tags			Files
+//
+//	@Param			search			query	string			false	"Filename to search for, along with filters like ext:pdf, size:>2G, modified:2024, in:/Projects, owner:alice or is:dir"
+//	@Param			content			query	string			false	"Words to search for in the text of files"
+//	@Param			baseFolderId	query	string			false	"The folder to search in, defaults to the user's home folder for filename searches"
+//	@Param			sort			query	string			false	"How to sort filename searches, by relevance if not given"	Enums(name, size, modified, created)
+//	@Param			order			query	string			false	"Sort order"	Enums(asc, desc)
+//	@Param			page			query	int				false	"Page of results to get, starting at 0"
+//	@Param			limit			query	int				false	"Number of results in a page, defaults to 100"
+//	@Success		200				{array}	rest.FileInfo	"File Info"
+//	@Failure		400
+//	@Failure		401
+//	@Failure		500
+//	@Failure		501
+//	@Router			/files/search [get]
 func searchByFilename(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
 	u, err := getUserFromCtx(r, true)
@@ -289,18 +495,59 @@ func searchByFilename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filenameSearch := r.URL.Query().Get("search")
-	if filenameSearch == "" {
+	if contentSearch := r.URL.Query().Get("content"); contentSearch != "" {
+		searchByContent(w, r, contentSearch, u)
+		return
+	}
+
+	if r.URL.Query().Get("search") == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	baseFolderId := r.URL.Query().Get("baseFolderId")
-	if baseFolderId == "" {
-		baseFolderId = u.HomeId
+	query, err := models.ParseFileQuery(r.URL.Query().Get("search"), time.Now())
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	sortBy := models.FileSortBy(r.URL.Query().Get("sort"))
+	descending := r.URL.Query().Get("order") == "desc"
+
+	page := 0
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		page, err = strconv.Atoi(pageStr)
+		if err != nil || page < 0 {
+			SafeErrorAndExit(werror.ErrBadSearchTerm("page="+pageStr), w)
+			return
+		}
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			SafeErrorAndExit(werror.ErrBadSearchTerm("limit="+limitStr), w)
+			return
+		}
 	}
 
-	baseFolder, err := pack.FileService.GetFileSafe(baseFolderId, u, nil)
+	var baseFolder *fileTree.WeblensFileImpl
+	if query.In != "" {
+		// Paths in the query are relative to the users home, even if they start with a slash
+		inPath := strings.TrimPrefix(strings.TrimPrefix(query.In, "~"), "/")
+		baseFolder, err = pack.FileService.UserPathToFile("~/"+inPath, u)
+		if err != nil {
+			SafeErrorAndExit(werror.ErrBadSearchTerm("in:"+query.In), w)
+			return
+		}
+		baseFolder, err = pack.FileService.GetFileSafe(baseFolder.ID(), u, nil)
+	} else {
+		baseFolderId := r.URL.Query().Get("baseFolderId")
+		if baseFolderId == "" {
+			baseFolderId = u.HomeId
+		}
+		baseFolder, err = pack.FileService.GetFileSafe(baseFolderId, u, nil)
+	}
 	if SafeErrorAndExit(err, w) {
 		return
 	}
@@ -310,37 +557,29 @@ func searchByFilename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var fileIds []fileTree.FileId
-	var filenames []string
-	_ = baseFolder.RecursiveMap(
-		func(f *fileTree.WeblensFileImpl) error {
-			fileIds = append(fileIds, f.ID())
-			filenames = append(filenames, f.Filename())
-			return nil
-		},
-	)
-
-	matches := fuzzy.RankFindFold(filenameSearch, filenames)
-	slices.SortFunc(
-		matches, func(a, b fuzzy.Rank) int {
-			return a.Distance - b.Distance
-		},
+	candidates := models.SearchCandidates(
+		pack.FileService.GetFileTreeByName(baseFolder.GetPortablePath().RootName()), baseFolder, query.Words,
 	)
 
-	files := internal.FilterMap(
-		matches, func(match fuzzy.Rank) (*fileTree.WeblensFileImpl, bool) {
-			f, err := pack.FileService.GetFileSafe(fileIds[match.OriginalIndex], u, nil)
-			if err != nil {
-				return nil, false
-			}
+	candidates = slices.DeleteFunc(
+		candidates, func(f *fileTree.WeblensFileImpl) bool {
 			if f.ID() == u.HomeId || f.ID() == u.TrashId {
-				return nil, false
+				return true
 			}
-			return f, true
+			_, err := pack.FileService.GetFileSafe(f.ID(), u, nil)
+			return err != nil
 		},
 	)
 
-	var fileInfos []rest.FileInfo
+	files, err := models.SearchFiles(candidates, query, newFileQueryContext(pack, baseFolder), sortBy, descending)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	start := min(page*limit, len(files))
+	files = files[start:min(start+limit, len(files))]
+
+	fileInfos := []rest.FileInfo{}
 	for _, file := range files {
 		f, err := rest.WeblensFileToFileInfo(file, pack, false)
 		if SafeErrorAndExit(err, w) {
@@ -352,6 +591,96 @@ func searchByFilename(w http.ResponseWriter, r *http.Request) {
 	writeJson(w, http.StatusOK, fileInfos)
 }
 
+// newFileQueryContext tells a file query how to find the owner, media type, and creation time of files
+// under the base folder
+func newFileQueryContext(pack *models.ServicePack, baseFolder *fileTree.WeblensFileImpl) models.FileQueryContext {
+	journal := pack.FileService.GetJournalByTree(baseFolder.GetPortablePath().RootName())
+
+	return models.FileQueryContext{
+		Owner: func(f *fileTree.WeblensFileImpl) models.Username {
+			owner, err := pack.FileService.GetFileOwner(f)
+			if err != nil {
+				return ""
+			}
+			return owner.GetUsername()
+		},
+		MediaType: func(f *fileTree.WeblensFileImpl) models.MediaType {
+			return pack.MediaService.GetMediaTypes().ParseExtension(filepath.Ext(f.Filename()))
+		},
+		Metadata: func(f *fileTree.WeblensFileImpl) models.FileMetadata {
+			if pack.FileMetadataService == nil {
+				return models.FileMetadata{}
+			}
+			return pack.FileMetadataService.Get(f.ID())
+		},
+		Created: func(f *fileTree.WeblensFileImpl) time.Time {
+			if journal == nil {
+				return f.ModTime()
+			}
+			lt := journal.Get(f.ID())
+			if lt == nil || len(lt.GetActions()) == 0 {
+				return f.ModTime()
+			}
+			return lt.GetActions()[0].GetTimestamp()
+		},
+	}
+}
+
+// searchByContent finds the files the user can access with text containing every word in the search. Unlike
+// filename searches, this looks everywhere the user has access to unless a base folder is given.
+func searchByContent(w http.ResponseWriter, r *http.Request, contentSearch string, u *models.User) {
+	pack := getServices(r)
+	if pack.ContentIndexService == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	var basePath string
+	if baseFolderId := r.URL.Query().Get("baseFolderId"); baseFolderId != "" {
+		baseFolder, err := pack.FileService.GetFileSafe(baseFolderId, u, nil)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+		if !baseFolder.IsDir() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		basePath = baseFolder.GetPortablePath().ToPortable()
+	}
+
+	files := map[fileTree.FileId]*fileTree.WeblensFileImpl{}
+	canSee := func(fileId fileTree.FileId) bool {
+		// GetFileSafe checks CanUserAccessFile, so the index never shows a user anything they could not open
+		f, err := pack.FileService.GetFileSafe(fileId, u, nil)
+		if err != nil || pack.FileService.IsFileInTrash(f) {
+			return false
+		}
+		if basePath != "" && !strings.HasPrefix(f.GetPortablePath().ToPortable(), basePath) {
+			return false
+		}
+
+		files[fileId] = f
+		return true
+	}
+
+	const maxContentResults = 50
+	matches, err := pack.ContentIndexService.Search(contentSearch, canSee, maxContentResults)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	results := make([]rest.ContentSearchResult, 0, len(matches))
+	for _, match := range matches {
+		fileInfo, err := rest.WeblensFileToFileInfo(files[match.FileId], pack, false)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+		results = append(results, rest.ContentSearchResult{File: fileInfo, Snippets: match.Snippets})
+	}
+
+	writeJson(w, http.StatusOK, results)
+}
+
 // CreateFolder godoc
 //
 //	@ID	CreateFolder
@@ -408,7 +737,7 @@ func createFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = pack.FileService.MoveFiles(children, newDir, "USERS", pack.Caster)
+	_, err = pack.FileService.MoveFiles(children, newDir, "USERS", models.ConflictKeepBoth, pack.Caster)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
@@ -548,65 +877,272 @@ func setFolderCover(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// GetExternalDirs godoc
+//
+//	@ID			GetExternalDirs
+//
+//	@Security	SessionAuth
+//
+//	@Summary	Get the external libraries the logged in user can browse
+//	@Tags		Files
+//	@Produce	json
+//	@Success	200	{array}	rest.LibraryInfo	"Libraries"
+//	@Failure	401
+//	@Router		/files/external [get]
 func getExternalDirs(w http.ResponseWriter, r *http.Request) {
-	panic(werror.NotImplemented("getExternalDirs"))
-}
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
 
-func getExternalFolderInfo(w http.ResponseWriter, r *http.Request) {
-	panic(werror.NotImplemented("getExternalFolderInfo"))
+	libraries := pack.LibraryService.GetLibrariesForUser(u)
+	slices.SortFunc(
+		libraries, func(a, b *models.Library) int {
+			return strings.Compare(a.Alias, b.Alias)
+		},
+	)
+
+	infos := make([]rest.LibraryInfo, 0, len(libraries))
+	for _, lib := range libraries {
+		attached := pack.FileService.GetFileTreeByName(lib.Alias) != nil
+		infos = append(infos, rest.LibraryToLibraryInfo(lib, attached))
+	}
+
+	writeJson(w, http.StatusOK, infos)
 }
 
-// ScanFolder godoc
+// GetExternalFolderInfo godoc
 //
-//	@ID	ScanFolder
+//	@ID			GetExternalFolderInfo
 //
-//	@Security
 //	@Security	SessionAuth
 //
-//	@Summary	Dispatch a folder scan
-//	@Tags		Folder
-//	@Param		shareId	query	string			false	"Share Id"
-//	@Param		request	body	rest.ScanBody	true	"Scan parameters"
-//	@Success	200
+//	@Summary	Get the top folder of an external library
+//	@Tags		Files
+//	@Produce	json
+//	@Param		alias	path		string					true	"Library alias"
+//	@Success	200		{object}	rest.FolderInfoResponse	"Folder Info"
+//	@Failure	401
 //	@Failure	404
-//	@Failure	500
-//	@Router		/folder/scan [post]
-func scanDir(w http.ResponseWriter, r *http.Request) {
+//	@Router		/files/external/{alias} [get]
+func getExternalFolderInfo(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
 	u, err := getUserFromCtx(r, true)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
-	sh, err := getShareFromCtx[*models.FileShare](w, r)
-	if err != nil {
-		return
-	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	var scanInfo rest.ScanBody
-	err = json.Unmarshal(body, &scanInfo)
-	if err != nil {
-		log.ErrTrace(err)
-		w.WriteHeader(http.StatusInternalServerError)
+	alias := chi.URLParam(r, "alias")
+	lib := pack.LibraryService.Get(alias)
+	if lib == nil || !lib.CanUserAccess(u) {
+		SafeErrorAndExit(werror.ErrNoLibrary.WithArg(alias), w)
 		return
 	}
 
-	dir, err := pack.FileService.GetFileSafe(scanInfo.FolderId, u, sh)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	tree := pack.FileService.GetFileTreeByName(alias)
+	if tree == nil {
+		writeJson(w, http.StatusNotFound, rest.WeblensErrorInfo{Error: "Library directory is not available"})
 		return
 	}
 
-	meta := models.ScanMeta{
+	formatRespondFolderInfo(tree.GetRoot(), w, r)
+}
+
+// AddExternalDir godoc
+//
+//	@ID	AddExternalDir
+//
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
+//
+//	@Summary		Attach a directory on the host as a read-only library
+//	@Description	The directory is loaded as its own file tree, and a scan for media is started.
+//	@Description	Weblens will never write to, move, or delete anything in the library.
+//	@Tags			Files
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		rest.NewLibraryParams	true	"New library"
+//	@Success		201		{object}	rest.LibraryInfo		"Library Info"
+//	@Failure		400		{object}	rest.WeblensErrorInfo
+//	@Failure		401
+//	@Failure		409		{object}	rest.WeblensErrorInfo
+//	@Failure		500
+//	@Router			/files/external [post]
+func addExternalDir(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	params, err := readCtxBody[rest.NewLibraryParams](w, r)
+	if err != nil {
+		return
+	}
+
+	lib := models.NewLibrary(params.Alias, params.Path, u)
+	err = pack.LibraryService.Add(lib)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	tree := pack.FileService.GetFileTreeByName(lib.Alias)
+
+	// Files are hashed as the tree is loaded, and media can only be scanned once they have a content id
+	tree.GetJournal().Flush()
+
+	meta := models.ScanMeta{
+		File:         tree.GetRoot(),
+		FileService:  pack.FileService,
+		MediaService: pack.MediaService,
+		TaskSubber:   pack.ClientService,
+		TaskService:  pack.TaskService,
+		ContentIndex: pack.ContentIndexService,
+	}
+	_, err = pack.TaskService.DispatchJob(models.ScanDirectoryTask, meta, nil)
+	if err != nil {
+		// The library is usable without media, and can be scanned again later
+		log.ErrTrace(err)
+	}
+
+	writeJson(w, http.StatusCreated, rest.LibraryToLibraryInfo(lib, true))
+}
+
+// RemoveExternalDir godoc
+//
+//	@ID	RemoveExternalDir
+//
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
+//
+//	@Summary		Detach an external library
+//	@Description	Nothing is removed from the directory on the host, weblens simply stops showing it.
+//	@Tags			Files
+//	@Param			alias	path	string	true	"Library alias"
+//	@Success		200
+//	@Failure		401
+//	@Failure		404
+//	@Failure		500
+//	@Router			/files/external/{alias} [delete]
+func removeExternalDir(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+
+	err := pack.LibraryService.Del(chi.URLParam(r, "alias"))
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// SetExternalDirAccessors godoc
+//
+//	@ID	SetExternalDirAccessors
+//
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
+//
+//	@Summary	Update which users can browse an external library
+//	@Tags		Files
+//	@Accept		json
+//	@Produce	json
+//	@Param		alias	path		string				true	"Library alias"
+//	@Param		request	body		rest.UserListBody	true	"Library Accessors"
+//	@Success	200		{object}	rest.LibraryInfo	"Library Info"
+//	@Failure	401
+//	@Failure	404
+//	@Failure	500
+//	@Router		/files/external/{alias}/accessors [patch]
+func setExternalDirAccessors(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+
+	alias := chi.URLParam(r, "alias")
+	lib := pack.LibraryService.Get(alias)
+	if lib == nil {
+		SafeErrorAndExit(werror.ErrNoLibrary.WithArg(alias), w)
+		return
+	}
+
+	ub, err := readCtxBody[rest.UserListBody](w, r)
+	if err != nil {
+		return
+	}
+
+	for _, un := range slices.Concat(ub.AddUsers, ub.RemoveUsers) {
+		if pack.UserService.Get(un) == nil {
+			writeJson(w, http.StatusNotFound, rest.WeblensErrorInfo{Error: "Could not find user with name " + un})
+			return
+		}
+	}
+
+	accessors := internal.AddToSet(slices.Clone(lib.GetAccessors()), ub.AddUsers...)
+	accessors = internal.Filter(
+		accessors, func(un models.Username) bool {
+			return !slices.Contains(ub.RemoveUsers, un)
+		},
+	)
+
+	err = pack.LibraryService.SetAccessors(lib, accessors)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	writeJson(w, http.StatusOK, rest.LibraryToLibraryInfo(lib, pack.FileService.GetFileTreeByName(alias) != nil))
+}
+
+// ScanFolder godoc
+//
+//	@ID	ScanFolder
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary	Dispatch a folder scan
+//	@Tags		Folder
+//	@Param		shareId	query	string			false	"Share Id"
+//	@Param		request	body	rest.ScanBody	true	"Scan parameters"
+//	@Success	200
+//	@Failure	404
+//	@Failure	500
+//	@Router		/folder/scan [post]
+func scanDir(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	sh, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var scanInfo rest.ScanBody
+	err = json.Unmarshal(body, &scanInfo)
+	if err != nil {
+		log.ErrTrace(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	dir, err := pack.FileService.GetFileSafe(scanInfo.FolderId, u, sh)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	meta := models.ScanMeta{
 		File:         dir,
 		FileService:  pack.FileService,
 		MediaService: pack.MediaService,
 		TaskSubber:   pack.ClientService,
 		TaskService:  pack.TaskService,
+		ContentIndex: pack.ContentIndexService,
 	}
 	_, err = pack.TaskService.DispatchJob(models.ScanDirectoryTask, meta, nil)
 	if SafeErrorAndExit(err, w) {
@@ -686,132 +1222,428 @@ func getSharedFiles(w http.ResponseWriter, r *http.Request) {
 	writeJson(w, http.StatusOK, res)
 }
 
-// CreateTakeout godoc
+// GetStarredFiles godoc
 //
-//	@ID	CreateTakeout
+//	@ID			GetStarredFiles
 //
-//	@Security
-//	@Security		SessionAuth
+//	@Security	SessionAuth
 //
-//	@Summary		Create a zip file
-//	@Description	Dispatch a task to create a zip file of the given files, or get the id of a previously created zip file if it already exists
-//	@Tags			Files
-//	@Param			shareId	query		string					false	"Share Id"
-//	@Param			request	body		rest.FilesListParams	true	"File Ids"
-//	@Success		200		{object}	rest.TakeoutInfo		"Zip Takeout Info"
-//	@Success		202		{object}	rest.TakeoutInfo		"Task Dispatch Info"
-//	@Failure		400
-//	@Failure		404
-//	@Failure		500
-//	@Router			/takeout [post]
-func createTakeout(w http.ResponseWriter, r *http.Request) {
+//	@Summary	Get the files the logged in user has starred
+//	@Tags		Files
+//	@Success	200	{array}	rest.FileInfo	"Starred files, most recently starred first"
+//	@Failure	401
+//	@Failure	500
+//	@Router		/files/starred [get]
+func getStarredFiles(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
-	u, err := getUserFromCtx(r, true)
-	if err != nil && !errors.Is(err, werror.ErrNoPublicUser) {
-		SafeErrorAndExit(err, w)
+	if pack.FileActivityService == nil {
+		w.WriteHeader(http.StatusNotImplemented)
 		return
 	}
 
-	if u == nil {
-		u = pack.UserService.GetPublicUser()
-	}
-
-	takeoutRequest, err := readCtxBody[rest.FilesListParams](w, r)
-	if err != nil {
+	u, err := getUserFromCtx(r, false)
+	if SafeErrorAndExit(err, w) {
 		return
 	}
-	if len(takeoutRequest.FileIds) == 0 {
-		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Cannot takeout 0 files"})
+
+	stars, err := pack.FileActivityService.GetActivity(u.GetUsername(), models.FileStarred)
+	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	share, err := getShareFromCtx[*models.FileShare](w, r)
-	if err != nil {
+	getFile, err := newUserFileGetter(pack, u)
+	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	// Make sure user has access to all requested files
-	var files []*fileTree.WeblensFileImpl
-	for _, fileId := range takeoutRequest.FileIds {
-		file, err := pack.FileService.GetFileSafe(fileId, u, share)
+	infos := make([]rest.FileInfo, 0, len(stars))
+	for _, star := range stars {
+		f := getFile(star.FileId)
+		if f == nil {
+			continue
+		}
+
+		info, err := rest.WeblensFileToFileInfo(f, pack, false)
 		if SafeErrorAndExit(err, w) {
 			return
 		}
-
-		files = append(files, file)
+		infos = append(infos, info)
 	}
 
-	// If we only have 1 file, and it is not a directory, we should have requested to just
-	// simply download that file on it's own, not zip it.
-	if len(files) == 1 && !files[0].IsDir() {
-		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Single non-directory file should not be zipped"})
+	writeJson(w, http.StatusOK, infos)
+}
+
+// StarFile godoc
+//
+//	@ID	StarFile
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary	Star a file or folder for the logged in user
+//	@Tags		Files
+//	@Param		fileId	path	string	true	"File Id"
+//	@Param		shareId	query	string	false	"Share Id"
+//	@Success	200
+//	@Failure	401
+//	@Failure	404
+//	@Failure	500
+//	@Router		/files/{fileId}/star [put]
+func starFile(w http.ResponseWriter, r *http.Request) {
+	setFileStarred(w, r, true)
+}
+
+// UnstarFile godoc
+//
+//	@ID	UnstarFile
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary	Remove the star from a file or folder for the logged in user
+//	@Tags		Files
+//	@Param		fileId	path	string	true	"File Id"
+//	@Param		shareId	query	string	false	"Share Id"
+//	@Success	200
+//	@Failure	401
+//	@Failure	404
+//	@Failure	500
+//	@Router		/files/{fileId}/star [delete]
+func unstarFile(w http.ResponseWriter, r *http.Request) {
+	setFileStarred(w, r, false)
+}
+
+func setFileStarred(w http.ResponseWriter, r *http.Request, starred bool) {
+	pack := getServices(r)
+	if pack.FileActivityService == nil {
+		w.WriteHeader(http.StatusNotImplemented)
 		return
 	}
 
-	cstr := caster.NewSimpleCaster(pack.ClientService)
-	meta := models.ZipMeta{
-		Files:       files,
-		Requester:   u,
-		Share:       share,
-		Caster:      cstr,
-		FileService: pack.FileService,
+	u, err := getUserFromCtx(r, false)
+	if SafeErrorAndExit(err, w) {
+		return
 	}
-	t, err := pack.TaskService.DispatchJob(models.CreateZipTask, meta, nil)
-
+	share, err := getShareFromCtx[*models.FileShare](w, r)
 	if err != nil {
-		log.ShowErr(err)
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	completed, status := t.Status()
-	if completed && status == task.TaskSuccess {
-		res := rest.TakeoutInfo{TakeoutId: t.GetResult("takeoutId").(string), Single: false, Filename: t.GetResult("filename").(string)}
-		writeJson(w, http.StatusOK, res)
-	} else {
-		res := rest.TakeoutInfo{TaskId: t.TaskId()}
-		writeJson(w, http.StatusAccepted, res)
-	}
-}
-
-func getFileStat(w http.ResponseWriter, r *http.Request) {
-	pack := getServices(r)
-	u, err := getUserFromCtx(r, true)
+	file, err := pack.FileService.GetFileSafe(chi.URLParam(r, "fileId"), u, share)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
-	fileId := chi.URLParam(r, "fileId")
 
-	f, err := pack.FileService.GetFileSafe(fileId, u, nil)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+	if starred {
+		err = pack.FileActivityService.Star(u.GetUsername(), file.ID())
+	} else {
+		err = pack.FileActivityService.Unstar(u.GetUsername(), file.ID())
+	}
+	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	size := f.Size()
-
-	writeJson(w,
-		http.StatusOK,
-		FileStat{Name: f.Filename(), Size: size, IsDir: f.IsDir(), ModTime: f.ModTime(), Exists: true},
-	)
+	w.WriteHeader(http.StatusOK)
 }
 
-// AutocompletePath godoc
+// GetRecentFiles godoc
 //
-//	@ID			AutocompletePath
+//	@ID			GetRecentFiles
 //
 //	@Security	SessionAuth
 //
-//	@Summary	Get path completion suggestions
-//	@Tags		Files
-//	@Param		searchPath	query		string	true	"Search path"
-//	@Success	200			{object}	rest.FolderInfoResponse"Path info"
-//	@Failure	500
-//	@Router		/files/autocomplete [get]
-func autocompletePath(w http.ResponseWriter, r *http.Request) {
+//	@Summary		Get the files the logged in user has worked with recently
+//	@Description	Files created, uploaded, moved, changed or restored in the users home, and files the user has
+//	@Description	downloaded from anywhere they can access. Each file is listed once, with the latest thing done to it.
+//	@Tags			Files
+//	@Param			limit	query	int						false	"Most files to return"	default(50)
+//	@Success		200		{array}	rest.RecentFileInfo	"Recent files, newest first"
+//	@Failure		400
+//	@Failure		401
+//	@Failure		500
+//	@Router			/files/recent [get]
+func getRecentFiles(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
-	searchPath := r.URL.Query().Get("searchPath")
-	if len(searchPath) == 0 {
+	if pack.FileActivityService == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	u, err := getUserFromCtx(r, false)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 500 {
+			writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "limit must be between 1 and 500"})
+			return
+		}
+	}
+
+	home, err := pack.FileService.GetFileSafe(u.HomeId, u, nil)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	trash, err := pack.FileService.GetFileSafe(u.TrashId, u, nil)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	// Moving a file to the trash is a move inside of home, so files whose last move was into the trash are left out
+	actions, err := pack.FileService.GetJournalByTree("USERS").GetRecentActions(
+		home.GetPortablePath(), trash.GetPortablePath(), models.RecentFileActions, limit,
+	)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	downloads, err := pack.FileActivityService.GetActivity(u.GetUsername(), models.FileDownloaded)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	getFile, err := newUserFileGetter(pack, u)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	recent := models.RecentFiles(actions, downloads, getFile, limit)
+
+	infos := make([]rest.RecentFileInfo, 0, len(recent))
+	for _, rf := range recent {
+		info, err := rest.WeblensFileToFileInfo(rf.File, pack, false)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+		infos = append(infos, rest.RecentFileInfo{File: info, Action: rf.Action, Timestamp: rf.Timestamp.UnixMilli()})
+	}
+
+	writeJson(w, http.StatusOK, infos)
+}
+
+// newUserFileGetter returns a function that finds a file that the user can get to, either in their own files,
+// a library, or through a share. It gives nil for files that are gone, are in the trash, or are the home or trash folder.
+func newUserFileGetter(pack *models.ServicePack, u *models.User) (func(id fileTree.FileId) *fileTree.WeblensFileImpl, error) {
+	usersTree := pack.FileService.GetFileTreeByName("USERS")
+	if usersTree == nil {
+		return nil, werror.WithStack(werror.ErrNoFileTree)
+	}
+	shares, err := pack.ShareService.GetFileSharesWithUser(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(id fileTree.FileId) *fileTree.WeblensFileImpl {
+		if id == u.HomeId || id == u.TrashId {
+			return nil
+		}
+
+		f := usersTree.Get(id)
+		if f == nil {
+			// Not in the users tree, so it could only be in a library
+			var err error
+			f, err = pack.FileService.GetFileSafe(id, u, nil)
+			if err != nil {
+				return nil
+			}
+			return f
+		}
+
+		canAccess := func(share *models.FileShare) bool { return pack.AccessService.CanUserAccessFile(u, f, share) }
+		if pack.FileService.IsFileInTrash(f) || (!canAccess(nil) && !slices.ContainsFunc(shares, canAccess)) {
+			return nil
+		}
+
+		return f
+	}, nil
+}
+
+// CreateTakeout godoc
+//
+//	@ID	CreateTakeout
+//
+//	@Security
+//	@Security		SessionAuth
+//
+//	@Summary		Create a zip file
+//	@Description	Dispatch a task to create a zip file of the given files, or get the id of a previously created zip file if it already exists.
+//	@Description	If a timestamp is given, the zip is built from the files as they were at that time.
+//	@Description	If stream is set, no zip is built, and a url that writes the zip as it is downloaded is given instead.
+//	@Description	A tar, tar.gz or tar.zst can be made instead of a zip by setting the format.
+//	@Tags			Files
+//	@Param			shareId	query		string				false	"Share Id"
+//	@Param			request	body		rest.TakeoutParams	true	"File Ids"
+//	@Success		200		{object}	rest.TakeoutInfo		"Zip Takeout Info"
+//	@Success		202		{object}	rest.TakeoutInfo		"Task Dispatch Info"
+//	@Failure		400
+//	@Failure		404
+//	@Failure		500
+//	@Router			/takeout [post]
+func createTakeout(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if err != nil && !errors.Is(err, werror.ErrNoPublicUser) {
+		SafeErrorAndExit(err, w)
+		return
+	}
+
+	if u == nil {
+		u = pack.UserService.GetPublicUser()
+	}
+
+	takeoutRequest, err := readCtxBody[rest.TakeoutParams](w, r)
+	if err != nil {
+		return
+	}
+	if len(takeoutRequest.FileIds) == 0 {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Cannot takeout 0 files"})
+		return
+	}
+	if takeoutRequest.Timestamp < 0 {
+		SafeErrorAndExit(werror.ErrBadTimestamp, w)
+		return
+	}
+
+	format := archive.Zip
+	if takeoutRequest.Format != "" {
+		var ok bool
+		format, ok = archive.ParseFormat(string(takeoutRequest.Format))
+		if !ok {
+			SafeErrorAndExit(werror.ErrBadArchiveFormat, w)
+			return
+		}
+	}
+
+	share, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	var pastTime time.Time
+	if takeoutRequest.Timestamp != 0 {
+		pastTime = time.UnixMilli(takeoutRequest.Timestamp)
+	}
+
+	// Make sure user has access to all requested files
+	var files []*fileTree.WeblensFileImpl
+	for _, fileId := range takeoutRequest.FileIds {
+		var file *fileTree.WeblensFileImpl
+		if pastTime.IsZero() {
+			file, err = pack.FileService.GetFileSafe(fileId, u, share)
+		} else {
+			file, err = getPastFileSafe(pack, fileId, pastTime, u, share)
+		}
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+
+		files = append(files, file)
+	}
+
+	// If we only have 1 file, and it is not a directory, we should have requested to just
+	// simply download that file on it's own, not zip it. Past files cannot be downloaded directly,
+	// so they are allowed through.
+	if len(files) == 1 && !files[0].IsDir() && pastTime.IsZero() {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Single non-directory file should not be zipped"})
+		return
+	}
+
+	if takeoutRequest.Stream {
+		takeoutId, filename := newStreamTakeout(pack, files, u, share, pastTime, format)
+		streamUrl := strings.TrimSuffix(r.URL.Path, "/") + "/" + takeoutId + "/stream"
+		writeJson(w, http.StatusOK, rest.TakeoutInfo{TakeoutId: takeoutId, Filename: filename, StreamUrl: streamUrl})
+		return
+	}
+
+	cstr := caster.NewSimpleCaster(pack.ClientService)
+	meta := models.ZipMeta{
+		Files:       files,
+		Requester:   u,
+		Share:       share,
+		Caster:      cstr,
+		FileService: pack.FileService,
+		Timestamp:   pastTime,
+		Format:      format,
+	}
+	t, err := pack.TaskService.DispatchJob(models.CreateZipTask, meta, nil)
+
+	if err != nil {
+		log.ShowErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	completed, status := t.Status()
+	if completed && status == task.TaskSuccess {
+		res := rest.TakeoutInfo{TakeoutId: t.GetResult("takeoutId").(string), Single: false, Filename: t.GetResult("filename").(string)}
+		writeJson(w, http.StatusOK, res)
+	} else {
+		res := rest.TakeoutInfo{TaskId: t.TaskId()}
+		writeJson(w, http.StatusAccepted, res)
+	}
+}
+
+// getPastFileSafe gets the file as it was at pastTime, if the user has access to it
+func getPastFileSafe(
+	pack *models.ServicePack, fileId fileTree.FileId, pastTime time.Time, u *models.User, share *models.FileShare,
+) (*fileTree.WeblensFileImpl, error) {
+	pastFile, err := pack.FileService.GetJournalByTree("USERS").GetPastFile(fileId, pastTime)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pack.AccessService.CanUserAccessFile(u, pastFile, share) {
+		return nil, werror.WithStack(werror.ErrNoFileAccess)
+	}
+
+	return pastFile, nil
+}
+
+func getFileStat(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	fileId := chi.URLParam(r, "fileId")
+
+	f, err := pack.FileService.GetFileSafe(fileId, u, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	size := f.Size()
+
+	writeJson(w,
+		http.StatusOK,
+		FileStat{Name: f.Filename(), Size: size, IsDir: f.IsDir(), ModTime: f.ModTime(), Exists: true},
+	)
+}
+
+// autocompleteIndexThreshold is how many children a folder has before autocomplete uses the name index
+const autocompleteIndexThreshold = 1000
+
+// AutocompletePath godoc
+//
+//	@ID			AutocompletePath
+//
+//	@Security	SessionAuth
+//
+//	@Summary	Get path completion suggestions
+//	@Tags		Files
+//	@Param		searchPath	query		string	true	"Search path"
+//	@Success	200			{object}	rest.FolderInfoResponse"Path info"
+//	@Failure	500
+//	@Router		/files/autocomplete [get]
+func autocompletePath(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	searchPath := r.URL.Query().Get("searchPath")
+	if len(searchPath) == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -833,6 +1665,22 @@ func autocompletePath(w http.ResponseWriter, r *http.Request) {
 	}
 
 	children := folder.GetChildren()
+
+	// Ranking every name in very large folders is slow, so those are narrowed down with the name index first.
+	// The index looks at the whole subtree, which is only worth it when there are this many children to skip.
+	// If the index finds nothing, the name may still be a fuzzy match for one of the children, so they are all ranked.
+	if tree := pack.FileService.GetFileTreeByName(folder.GetPortablePath().RootName()); len(childName) >= 3 &&
+		len(children) > autocompleteIndexThreshold && tree != nil {
+		indexed := slices.DeleteFunc(
+			tree.SearchFilenames(childName, folder), func(f *fileTree.WeblensFileImpl) bool {
+				return f.GetParent() != folder
+			},
+		)
+		if len(indexed) != 0 {
+			children = indexed
+		}
+	}
+
 	if folder.GetParentId() == "ROOT" {
 		trashIndex := slices.IndexFunc(children, func(f *fileTree.WeblensFileImpl) bool {
 			return f.ID() == u.TrashId
@@ -868,204 +1716,917 @@ func autocompletePath(w http.ResponseWriter, r *http.Request) {
 		childInfos = append(childInfos, childInfo)
 	}
 
-	selfInfo, err := rest.WeblensFileToFileInfo(folder, pack, false)
+	selfInfo, err := rest.WeblensFileToFileInfo(folder, pack, false)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	ret := rest.FolderInfoResponse{Children: childInfos, Self: selfInfo}
+	writeJson(w, http.StatusOK, ret)
+}
+
+// RestoreFiles godoc
+//
+//	@ID			RestoreFiles
+//
+//	@Security	SessionAuth
+//
+//	@Summary	Restore files from some time in the past
+//	@Tags		Files
+//	@Accept		json
+//	@Produce	json
+//	@Param		request	body		rest.RestoreFilesBody		true	"Restore files request body"
+//	@Success	200		{object}	rest.RestoreFilesInfo		"Restore files info"
+//	@Failure	400
+//	@Failure	404
+//	@Failure	409		{object}	rest.FileConflictsInfo	"Conflicting files, when the conflict policy is fail"
+//	@Failure	500
+//	@Router		/files/restore [post]
+func restoreFiles(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	body, err := readCtxBody[rest.RestoreFilesBody](w, r)
+	if err != nil {
+		return
+	}
+
+	if body.Timestamp == 0 {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Missing body parameter 'timestamp'"})
+		return
+	}
+	restoreTime := time.UnixMilli(body.Timestamp)
+
+	policy, err := models.ParseConflictPolicy(string(body.ConflictPolicy), models.ConflictKeepBoth)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	lt := pack.FileService.GetJournalByTree("USERS").Get(body.NewParentId)
+	if lt == nil {
+		writeJson(w, http.StatusNotFound, rest.WeblensErrorInfo{Error: "Could not find new parent"})
+		return
+	}
+
+	var newParent *fileTree.WeblensFileImpl
+	if lt.GetLatestAction().GetActionType() == fileTree.FileDelete {
+		newParent, err = pack.FileService.GetFileSafe(u.HomeId, u, nil)
+
+		// this should never error, but you never know
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+	} else {
+		newParent, err = pack.FileService.GetFileSafe(body.NewParentId, u, nil)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+	}
+
+	conflicts, err := pack.FileService.RestoreFiles(body.FileIds, newParent, restoreTime, policy, pack.Caster)
+	if writeConflictsAndExit(conflicts, err, w) {
+		return
+	}
+
+	res := rest.RestoreFilesInfo{NewParentId: newParent.ID(), Conflicts: conflicts}
+
+	writeJson(w, http.StatusOK, res)
+}
+
+// UpdateFile godoc
+//
+//	@ID	UpdateFile
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary	Update a File
+//	@Tags		Files
+//	@Accept		json
+//	@Param		fileId	path	string					true	"File Id"
+//	@Param		shareId	query	string					false	"Share Id"
+//	@Param		request	body	rest.UpdateFileParams	true	"Update file request body"
+//	@Success	200
+//	@Failure	403
+//	@Failure	404
+//	@Failure	500
+//	@Router		/files/{fileId} [patch]
+func updateFile(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	fileId := chi.URLParam(r, "fileId")
+	updateInfo, err := readCtxBody[rest.UpdateFileParams](w, r)
+	if err != nil {
+		return
+	}
+
+	share, err := getShareFromCtx[*models.FileShare](w, r)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	file, err := pack.FileService.GetFileSafe(fileId, u, share)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if pack.FileService.IsFileInTrash(file) {
+		writeJson(w, http.StatusForbidden, rest.WeblensErrorInfo{Error: "cannot rename file in trash"})
+		return
+	}
+
+	err = pack.FileService.RenameFile(file, updateInfo.NewName, pack.Caster)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpdateFileMetadata godoc
+//
+//	@ID	UpdateFileMetadata
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Change the tags and properties on a file
+//	@Description	Tags are matched without case. Removals are done before additions, and the response has the
+//	@Description	tags and properties of the file after the change.
+//	@Tags			Files
+//	@Accept			json
+//	@Param			fileId	path		string							true	"File Id"
+//	@Param			shareId	query		string							false	"Share Id"
+//	@Param			request	body		rest.UpdateFileMetadataParams	true	"Metadata changes"
+//	@Success		200		{object}	rest.FileMetadataInfo			"Metadata of the file"
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Router			/files/{fileId}/metadata [patch]
+func updateFileMetadata(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	if pack.FileMetadataService == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	share, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	params, err := readCtxBody[rest.UpdateFileMetadataParams](w, r)
+	if err != nil {
+		return
+	}
+
+	file, err := pack.FileService.GetFileSafe(chi.URLParam(r, "fileId"), u, share)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	metadata, err := pack.FileMetadataService.Update(
+		file.ID(), models.FileMetadataUpdate{
+			AddTags:          params.AddTags,
+			RemoveTags:       params.RemoveTags,
+			SetProperties:    params.SetProperties,
+			RemoveProperties: params.RemoveProperties,
+		},
+	)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	pack.Caster.PushFileUpdate(file, nil)
+
+	writeJson(w, http.StatusOK, rest.FileMetadataInfo{Tags: metadata.Tags, Properties: metadata.Properties})
+}
+
+// MoveFiles godoc
+//
+//	@ID	MoveFiles
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Move a list of files to a new parent folder
+//	@Description	Files with the same name as one already in the new parent are handled by the conflict policy,
+//	@Description	and the response lists each of them and what was done.
+//	@Tags			Files
+//	@Param			request	body		rest.MoveFilesParams	true	"Move files request body"
+//	@Param			shareId	query		string					false	"Share Id"
+//	@Success		200		{object}	rest.FileConflictsInfo	"Conflicting files"
+//	@Failure		400
+//	@Failure		404
+//	@Failure		409		{object}	rest.FileConflictsInfo	"Conflicting files, when the conflict policy is fail"
+//	@Failure		500
+//	@Router			/files [patch]
+func moveFiles(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	sh, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	filesData, err := readCtxBody[rest.MoveFilesParams](w, r)
+	if err != nil {
+		return
+	}
+
+	newParent, err := pack.FileService.GetFileSafe(filesData.NewParentId, u, sh)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if len(filesData.Files) == 0 {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "No file ids provided"})
+		return
+	}
+
+	if filesData.NewParentId == "" {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "No parent id provided"})
+		return
+	}
+
+	policy, err := models.ParseConflictPolicy(string(filesData.ConflictPolicy), models.ConflictKeepBoth)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	var files []*fileTree.WeblensFileImpl
+	parentId := ""
+	for _, fileId := range filesData.Files {
+		f, err := pack.FileService.GetFileSafe(fileId, u, sh)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+		if parentId == "" {
+			parentId = f.GetParentId()
+		} else if parentId != f.GetParentId() {
+			writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "All files must have the same parent"})
+			return
+		}
+
+		files = append(files, f)
+	}
+
+	conflicts, err := pack.FileService.MoveFiles(files, newParent, "USERS", policy, pack.Caster)
+	if writeConflictsAndExit(conflicts, err, w) {
+		return
+	}
+
+	writeJson(w, http.StatusOK, rest.FileConflictsInfo{Conflicts: conflicts})
+}
+
+// writeConflictsAndExit is SafeErrorAndExit, but when files were not changed because of conflicts, it also
+// tells the client which files they were
+func writeConflictsAndExit(conflicts []models.FileConflict, err error, w http.ResponseWriter) (shouldExit bool) {
+	if len(conflicts) != 0 && errors.Is(err, werror.ErrFileAlreadyExists) {
+		safe, _ := log.TrySafeErr(err)
+		writeJson(w, http.StatusConflict, rest.FileConflictsInfo{Error: safe.Error(), Conflicts: conflicts})
+		return true
+	}
+
+	return SafeErrorAndExit(err, w)
+}
+
+// RunFileBatch godoc
+//
+//	@ID	RunFileBatch
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Apply a list of file operations together
+//	@Description	The operations (move, rename, trash and mkdir) are applied in order. If any of them fails,
+//	@Description	the ones before it are undone, and the response says which operation it was.
+//	@Description	A parentId of "$n" refers to the folder made by the mkdir operation at index n.
+//	@Tags			Files
+//	@Param			request	body		rest.FileBatchParams	true	"Batch of file operations"
+//	@Param			shareId	query		string					false	"Share Id"
+//	@Success		200		{object}	rest.FileBatchInfo		"Ids of the new folders"
+//	@Failure		400		{object}	rest.FileBatchFailedInfo	"The operation that failed"
+//	@Failure		403		{object}	rest.FileBatchFailedInfo	"The operation that failed"
+//	@Failure		404		{object}	rest.FileBatchFailedInfo	"The operation that failed"
+//	@Failure		409		{object}	rest.FileBatchFailedInfo	"The operation that failed"
+//	@Failure		500
+//	@Router			/files/batch [post]
+func runFileBatch(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	sh, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	params, err := readCtxBody[rest.FileBatchParams](w, r)
+	if err != nil {
+		return
+	}
+
+	if len(params.Operations) == 0 {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "No operations provided"})
+		return
+	}
+
+	newFolderIds, err := pack.FileService.RunBatch(params.Operations, u, sh, pack.Caster)
+	var batchErr *models.FileBatchError
+	if errors.As(err, &batchErr) {
+		safe, code := log.TrySafeErr(batchErr.Err)
+		writeJson(w, code, rest.FileBatchFailedInfo{Error: safe.Error(), FailedOperation: batchErr.Index})
+		return
+	} else if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	writeJson(w, http.StatusOK, rest.FileBatchInfo{NewFolderIds: newFolderIds})
+}
+
+// CopyFiles godoc
+//
+//	@ID	CopyFiles
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Copy a list of files into a folder
+//	@Description	Dispatch a task to copy the files, and everything under them, into the given folder.
+//	@Description	Copies that would collide with an existing file are handled with the conflict policy, which gives them a unique name if not set.
+//	@Description	The conflicts, and how each was handled, are in the result of the task. With the fail policy, the conflicts are returned right away instead.
+//	@Tags			Files
+//	@Param			request	body		rest.CopyFilesParams	true	"Copy files request body"
+//	@Param			shareId	query		string					false	"Share Id"
+//	@Success		202		{object}	rest.DispatchInfo		"Task Dispatch Info"
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		409		{object}	rest.FileConflictsInfo	"Conflicting files, when the conflict policy is fail"
+//	@Failure		500
+//	@Failure		507
+//	@Router			/files/copy [post]
+func copyFiles(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	sh, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	params, err := readCtxBody[rest.CopyFilesParams](w, r)
+	if err != nil {
+		return
+	}
+
+	if len(params.Files) == 0 {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "No file ids provided"})
+		return
+	}
+
+	if params.NewParentId == "" {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "No parent id provided"})
+		return
+	}
+
+	newParent, err := pack.FileService.GetFileSafe(params.NewParentId, u, sh)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if !newParent.IsDir() {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Copy destination must be a folder"})
+		return
+	}
+
+	if pack.FileService.IsFileInTrash(newParent) {
+		writeJson(w, http.StatusForbidden, rest.WeblensErrorInfo{Error: "cannot copy files into the trash"})
+		return
+	}
+
+	policy, err := models.ParseConflictPolicy(string(params.ConflictPolicy), models.ConflictKeepBoth)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	var files []*fileTree.WeblensFileImpl
+	var copySize int64
+	for _, fileId := range params.Files {
+		f, err := pack.FileService.GetFileSafe(fileId, u, sh)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+
+		if f.IsDir() && (f == newParent || strings.HasPrefix(newParent.AbsPath(), f.AbsPath())) {
+			SafeErrorAndExit(werror.ErrCopyIntoSelf, w)
+			return
+		}
+
+		files = append(files, f)
+		copySize += f.Size()
+	}
+
+	// CopyFiles checks these too, but it runs in a task, so checking here lets us tell the client right away
+	err = pack.FileService.CheckQuota(newParent, copySize)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if policy == models.ConflictFail {
+		conflicts := models.FindConflicts(files, newParent)
+		if len(conflicts) != 0 && writeConflictsAndExit(conflicts, werror.WithStack(werror.ErrFileAlreadyExists), w) {
+			return
+		}
+	}
+
+	meta := models.CopyFilesMeta{
+		Caster:         pack.Caster,
+		FileService:    pack.FileService,
+		User:           u,
+		Files:          files,
+		Destination:    newParent,
+		ConflictPolicy: policy,
+	}
+	t, err := pack.TaskService.DispatchJob(models.CopyFilesTask, meta, nil)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	writeJson(w, http.StatusAccepted, rest.DispatchInfo{TaskId: t.TaskId()})
+}
+
+// ExtractArchive godoc
+//
+//	@ID	ExtractArchive
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Extract a zip or tar archive into a folder
+//	@Description	Dispatch a task to extract the zip, tar, tar.gz or tar.zst archive into the given folder.
+//	@Description	Archives with paths that point outside of the destination, or that would expand to far more than their own size, are refused.
+//	@Tags			Files
+//	@Param			fileId	path		string						true	"Archive File Id"
+//	@Param			request	body		rest.ExtractArchiveParams	true	"Extract archive request body"
+//	@Param			shareId	query		string						false	"Share Id"
+//	@Success		202		{object}	rest.DispatchInfo			"Task Dispatch Info"
+//	@Failure		400
+//	@Failure		403
+//	@Failure		404
+//	@Failure		500
+//	@Failure		507
+//	@Router			/files/{fileId}/extract [post]
+func extractArchive(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	sh, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	params, err := readCtxBody[rest.ExtractArchiveParams](w, r)
+	if err != nil {
+		return
+	}
+
+	archiveFile, err := pack.FileService.GetFileSafe(chi.URLParam(r, "fileId"), u, sh)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if _, ok := archive.DetectFormat(archiveFile.Filename()); archiveFile.IsDir() || !ok {
+		SafeErrorAndExit(werror.ErrNotArchive, w)
+		return
+	}
+
+	destination := archiveFile.GetParent()
+	if params.DestinationFolderId != "" {
+		destination, err = pack.FileService.GetFileSafe(params.DestinationFolderId, u, sh)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+	}
+
+	if !destination.IsDir() {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Extract destination must be a folder"})
+		return
+	}
+
+	if pack.FileService.IsFileInTrash(destination) {
+		writeJson(w, http.StatusForbidden, rest.WeblensErrorInfo{Error: "cannot extract files into the trash"})
+		return
+	}
+
+	if !pack.AccessService.CanUserAccessFile(u, destination, sh) {
+		SafeErrorAndExit(werror.ErrNoFileAccess, w)
+		return
+	}
+
+	policy, err := models.ParseConflictPolicy(string(params.ConflictPolicy), models.ConflictKeepBoth)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	// Entries are either files or folders merged with those already there, so only these make sense here
+	if policy != models.ConflictOverwrite && policy != models.ConflictSkip && policy != models.ConflictKeepBoth {
+		SafeErrorAndExit(werror.ErrBadConflictPolicy.WithArg(policy), w)
+		return
+	}
+
+	meta := models.ExtractArchiveMeta{
+		FileService:    pack.FileService,
+		MediaService:   pack.MediaService,
+		TaskService:    pack.TaskService,
+		TaskSubber:     pack.ClientService,
+		Caster:         pack.Caster,
+		User:           u,
+		Archive:        archiveFile,
+		Destination:    destination,
+		ConflictPolicy: policy,
+	}
+	t, err := pack.TaskService.DispatchJob(models.ExtractArchiveTask, meta, nil)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	writeJson(w, http.StatusAccepted, rest.DispatchInfo{TaskId: t.TaskId()})
+}
+
+// GetArchiveEntries godoc
+//
+//	@ID	GetArchiveEntries
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Get the files and folders inside of a zip or tar archive
+//	@Description	Lists the entries of the archive without extracting it.
+//	@Tags			Files
+//	@Produce		json
+//	@Param			fileId	path		string				true	"Archive File Id"
+//	@Param			shareId	query		string				false	"Share Id"
+//	@Success		200		{object}	rest.ArchiveInfo	"Archive entries"
+//	@Failure		400
+//	@Failure		404
+//	@Failure		422
+//	@Failure		500
+//	@Router			/files/{fileId}/archive [get]
+func getArchiveEntries(w http.ResponseWriter, r *http.Request) {
+	archiveFile, format, err := getArchiveFile(w, r)
+	if err != nil {
+		return
+	}
+
+	entries, err := archive.List(archiveFile.AbsPath(), format)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	writeJson(w, http.StatusOK, rest.NewArchiveInfo(format, entries))
+}
+
+// GetArchiveEntry godoc
+//
+//	@ID	GetArchiveEntry
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Download one file from inside of a zip or tar archive
+//	@Description	Streams the file at the given path in the archive, without extracting the rest of the archive.
+//	@Description	If a quality is given, and the file is an image, a webp preview of the image is sent instead.
+//	@Tags			Files
+//	@Produce		octet-stream
+//	@Param			fileId	path		string	true	"Archive File Id"
+//	@Param			path	query		string	true	"Path of the file in the archive"
+//	@Param			quality	query		string	false	"Quality of the image preview"	Enums(thumbnail, fullres)
+//	@Param			shareId	query		string	false	"Share Id"
+//	@Success		200		{string}	binary	"File content"
+//	@Failure		400
+//	@Failure		404
+//	@Failure		413
+//	@Failure		422
+//	@Failure		500
+//	@Router			/files/{fileId}/archive/entry [get]
+func getArchiveEntry(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	archiveFile, format, err := getArchiveFile(w, r)
+	if err != nil {
+		return
+	}
+
+	content, entry, err := archive.Open(archiveFile.AbsPath(), format, r.URL.Query().Get("path"))
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	defer content.Close()
+
+	mType := pack.MediaService.GetMediaTypes().ParseExtension(filepath.Ext(entry.Path))
+
+	quality := models.MediaQuality(r.URL.Query().Get("quality"))
+	if quality != "" {
+		writeArchivePreview(w, pack, content, entry, mType, quality)
+		return
+	}
+
+	contentType := "application/octet-stream"
+	if mType.IsSupported() {
+		contentType = mType.Mime
+	}
+
+	// What is in an archive can be anything, so the browser must not be allowed to run it as a page of our own
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(entry.Path)}))
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, content)
+	if err != nil {
+		pack.Log.ErrTrace(werror.WithStack(err))
+	}
+}
+
+// maxArchivePreviewSize is the largest image in an archive we will make a preview of, as the whole image
+// has to be read into memory to do it
+const maxArchivePreviewSize = 256 * 1024 * 1024
+
+func writeArchivePreview(
+	w http.ResponseWriter, pack *models.ServicePack, content io.Reader, entry archive.Entry, mType models.MediaType,
+	quality models.MediaQuality,
+) {
+	if quality != models.LowRes && quality != models.HighRes {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "quality must be thumbnail or fullres"})
+		return
+	}
+
+	if !mType.IsDisplayable() || mType.Video {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "archive entry is not an image"})
+		return
+	}
+
+	if entry.Size > maxArchivePreviewSize {
+		writeJson(w, http.StatusRequestEntityTooLarge, rest.WeblensErrorInfo{Error: "archive entry is too large to preview"})
+		return
+	}
+
+	image, err := io.ReadAll(content)
+	if SafeErrorAndExit(werror.WithStack(err), w) {
+		return
+	}
+
+	bs, err := pack.MediaService.RenderPreview(image, quality)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	ret := rest.FolderInfoResponse{Children: childInfos, Self: selfInfo}
-	writeJson(w, http.StatusOK, ret)
+	// The archive may change, and the preview is not cached on our side, so the client only keeps it for a bit
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Header().Set("Content-Type", "image/webp")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(bs)
+	if err != nil {
+		pack.Log.ErrTrace(werror.WithStack(err))
+	}
 }
 
-// RestoreFiles godoc
-//
-//	@ID			RestoreFiles
-//
-//	@Security	SessionAuth
-//
-//	@Summary	Restore files from some time in the past
-//	@Tags		Files
-//	@Accept		json
-//	@Produce	json
-//	@Param		request	body		rest.RestoreFilesBody	true	"Restore files request body"
-//	@Success	200		{object}	rest.RestoreFilesInfo	"Restore files info"
-//	@Failure	400
-//	@Failure	404
-//	@Failure	500
-//	@Router		/files/restore [post]
-func restoreFiles(w http.ResponseWriter, r *http.Request) {
+// getArchiveFile gets the file in the fileId url param, and makes sure it is an archive we can read.
+// If err is not nil, the error has already been written to w.
+func getArchiveFile(w http.ResponseWriter, r *http.Request) (*fileTree.WeblensFileImpl, archive.Format, error) {
 	pack := getServices(r)
 	u, err := getUserFromCtx(r, true)
 	if SafeErrorAndExit(err, w) {
-		return
+		return nil, "", err
 	}
-
-	body, err := readCtxBody[rest.RestoreFilesBody](w, r)
+	sh, err := getShareFromCtx[*models.FileShare](w, r)
 	if err != nil {
-		return
+		return nil, "", err
 	}
 
-	if body.Timestamp == 0 {
-		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "Missing body parameter 'timestamp'"})
-		return
+	archiveFile, err := pack.FileService.GetFileSafe(chi.URLParam(r, "fileId"), u, sh)
+	if SafeErrorAndExit(err, w) {
+		return nil, "", err
 	}
-	restoreTime := time.UnixMilli(body.Timestamp)
 
-	lt := pack.FileService.GetJournalByTree("USERS").Get(body.NewParentId)
-	if lt == nil {
-		writeJson(w, http.StatusNotFound, rest.WeblensErrorInfo{Error: "Could not find new parent"})
-		return
+	format, ok := archive.DetectFormat(archiveFile.Filename())
+	if archiveFile.IsDir() || !ok {
+		err = werror.WithStack(werror.ErrNotArchive)
+		SafeErrorAndExit(err, w)
+		return nil, "", err
 	}
 
-	var newParent *fileTree.WeblensFileImpl
-	if lt.GetLatestAction().GetActionType() == fileTree.FileDelete {
-		newParent, err = pack.FileService.GetFileSafe(u.HomeId, u, nil)
+	return archiveFile, format, nil
+}
 
-		// this should never error, but you never know
-		if SafeErrorAndExit(err, w) {
-			return
-		}
-	} else {
-		newParent, err = pack.FileService.GetFileSafe(body.NewParentId, u, nil)
-		if SafeErrorAndExit(err, w) {
-			return
-		}
-	}
+// DedupFiles godoc
+//
+//	@ID	DedupFiles
+//
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
+//
+//	@Summary		Link together duplicate files
+//	@Description	Dispatch a task to replace duplicate files with links to a single copy of their content.
+//	@Description	The task result reports how many files were linked, and how many bytes were reclaimed.
+//	@Tags			Files
+//	@Success		202	{object}	rest.DispatchInfo	"Task Dispatch Info"
+//	@Failure		401
+//	@Failure		500
+//	@Router			/files/dedup [post]
+func dedupFiles(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
 
-	err = pack.FileService.RestoreFiles(body.FileIds, newParent, restoreTime, pack.Caster)
+	meta := models.DedupFilesMeta{
+		FileService: pack.FileService,
+		TreeName:    "USERS",
+	}
+	t, err := pack.TaskService.DispatchJob(models.DedupFilesTask, meta, nil)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	res := rest.RestoreFilesInfo{NewParentId: newParent.ID()}
-
-	writeJson(w, http.StatusOK, res)
+	writeJson(w, http.StatusAccepted, rest.DispatchInfo{TaskId: t.TaskId()})
 }
 
-// UpdateFile godoc
+// ScrubFiles godoc
 //
-//	@ID	UpdateFile
+//	@ID	ScrubFiles
 //
-//	@Security
-//	@Security	SessionAuth
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
 //
-//	@Summary	Update a File
-//	@Tags		Files
-//	@Accept		json
-//	@Param		fileId	path	string					true	"File Id"
-//	@Param		shareId	query	string					false	"Share Id"
-//	@Param		request	body	rest.UpdateFileParams	true	"Update file request body"
-//	@Success	200
-//	@Failure	403
-//	@Failure	404
-//	@Failure	500
-//	@Router		/files/{fileId} [patch]
-func updateFile(w http.ResponseWriter, r *http.Request) {
+//	@Summary		Check files for corruption
+//	@Description	Dispatch a task to rehash every file in a tree and compare it to its stored content id.
+//	@Description	Files that no longer match, or cannot be read, are saved in a scrub report.
+//	@Tags			Files
+//	@Param			treeName	query		string				false	"Name of the tree to scrub, defaults to the users tree"
+//	@Success		202			{object}	rest.DispatchInfo	"Task Dispatch Info"
+//	@Failure		401
+//	@Failure		404			{object}	rest.WeblensErrorInfo
+//	@Failure		500
+//	@Router			/files/scrub [post]
+func scrubFiles(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
-	u, err := getUserFromCtx(r, true)
-	if SafeErrorAndExit(err, w) {
-		return
+
+	treeName := r.URL.Query().Get("treeName")
+	if treeName == "" {
+		treeName = "USERS"
 	}
 
-	fileId := chi.URLParam(r, "fileId")
-	updateInfo, err := readCtxBody[rest.UpdateFileParams](w, r)
-	if err != nil {
+	if pack.FileService.GetFileTreeByName(treeName) == nil {
+		SafeErrorAndExit(werror.ErrNoFileTree.WithArg(treeName), w)
 		return
 	}
 
-	share, err := getShareFromCtx[*models.FileShare](w, r)
+	meta := models.ScrubFilesMeta{
+		FileService:  pack.FileService,
+		ScrubService: pack.ScrubService,
+		Caster:       pack.Caster,
+		TreeName:     treeName,
+	}
+	t, err := pack.TaskService.DispatchJob(models.ScrubFilesTask, meta, nil)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	file, err := pack.FileService.GetFileSafe(fileId, u, share)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
+	writeJson(w, http.StatusAccepted, rest.DispatchInfo{TaskId: t.TaskId()})
+}
 
-	if pack.FileService.IsFileInTrash(file) {
-		writeJson(w, http.StatusForbidden, rest.WeblensErrorInfo{Error: "cannot rename file in trash"})
-		return
+// GetLatestScrubReport godoc
+//
+//	@ID	GetLatestScrubReport
+//
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
+//
+//	@Summary	Get the report of the most recent scrub of a tree
+//	@Tags		Files
+//	@Produce	json
+//	@Param		treeName	query		string				false	"Name of the scrubbed tree, defaults to the users tree"
+//	@Success	200			{object}	models.ScrubReport	"Scrub Report"
+//	@Failure	401
+//	@Failure	404			{object}	rest.WeblensErrorInfo
+//	@Failure	500
+//	@Router		/files/scrub [get]
+func getLatestScrubReport(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+
+	treeName := r.URL.Query().Get("treeName")
+	if treeName == "" {
+		treeName = "USERS"
 	}
 
-	err = pack.FileService.RenameFile(file, updateInfo.NewName, pack.Caster)
+	report, err := pack.ScrubService.GetLatest(treeName)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeJson(w, http.StatusOK, report)
 }
 
-// MoveFiles godoc
+// GetScrubReport godoc
 //
-//	@ID	MoveFiles
+//	@ID	GetScrubReport
 //
-//	@Security
-//	@Security	SessionAuth
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
 //
-//	@Summary	Move a list of files to a new parent folder
+//	@Summary	Get a scrub report
 //	@Tags		Files
-//	@Param		request	body	rest.MoveFilesParams	true	"Move files request body"
-//	@Param		shareId	query	string					false	"Share Id"
-//	@Success	200
-//	@Failure	404
+//	@Produce	json
+//	@Param		reportId	path		string				true	"Report Id"
+//	@Success	200			{object}	models.ScrubReport	"Scrub Report"
+//	@Failure	401
+//	@Failure	404			{object}	rest.WeblensErrorInfo
 //	@Failure	500
-//	@Router		/files [patch]
-func moveFiles(w http.ResponseWriter, r *http.Request) {
+//	@Router		/files/scrub/{reportId} [get]
+func getScrubReport(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
-	u, err := getUserFromCtx(r, true)
+
+	report, err := pack.ScrubService.Get(models.ScrubReportId(chi.URLParam(r, "reportId")))
 	if SafeErrorAndExit(err, w) {
 		return
 	}
-	sh, err := getShareFromCtx[*models.FileShare](w, r)
-	if err != nil {
-		return
-	}
 
-	filesData, err := readCtxBody[rest.MoveFilesParams](w, r)
-	if err != nil {
-		return
-	}
+	writeJson(w, http.StatusOK, report)
+}
 
-	newParent, err := pack.FileService.GetFileSafe(filesData.NewParentId, u, sh)
-	if SafeErrorAndExit(err, w) {
+// RefetchScrubbedFiles godoc
+//
+//	@ID	RefetchScrubbedFiles
+//
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
+//
+//	@Summary		Copy the files a scrub found to be bad from the core again
+//	@Description	Only available on a backup server. Each corrupted or unreadable file in the report
+//	@Description	is replaced with the copy the core has of it.
+//	@Tags			Files
+//	@Param			reportId	path		string				true	"Report Id"
+//	@Success		202			{object}	rest.DispatchInfo	"Task Dispatch Info"
+//	@Failure		400			{object}	rest.WeblensErrorInfo
+//	@Failure		401
+//	@Failure		404			{object}	rest.WeblensErrorInfo
+//	@Failure		500
+//	@Router			/files/scrub/{reportId}/refetch [post]
+func refetchScrubbedFiles(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+
+	if pack.InstanceService.GetLocal().GetRole() != models.BackupServerRole {
+		SafeErrorAndExit(werror.ErrServerNotBackup, w)
 		return
 	}
 
-	if len(filesData.Files) == 0 {
-		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "No file ids provided"})
+	report, err := pack.ScrubService.Get(models.ScrubReportId(chi.URLParam(r, "reportId")))
+	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	if filesData.NewParentId == "" {
-		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "No parent id provided"})
+	// Backup trees are named after the id of the core they are a backup of
+	core := pack.InstanceService.GetByInstanceId(report.TreeName)
+	if core == nil {
+		SafeErrorAndExit(werror.WithStack(werror.ErrNoInstance), w)
 		return
 	}
 
-	var files []*fileTree.WeblensFileImpl
-	parentId := ""
-	for _, fileId := range filesData.Files {
-		f, err := pack.FileService.GetFileSafe(fileId, u, sh)
-		if SafeErrorAndExit(err, w) {
-			return
-		}
-		if parentId == "" {
-			parentId = f.GetParentId()
-		} else if parentId != f.GetParentId() {
-			writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "All files must have the same parent"})
-			return
-		}
-
-		files = append(files, f)
+	meta := models.RefetchScrubbedMeta{
+		FileService: pack.FileService,
+		TaskService: pack.TaskService,
+		Caster:      pack.Caster,
+		Core:        core,
+		Report:      report,
 	}
-
-	err = pack.FileService.MoveFiles(files, newParent, "USERS", pack.Caster)
+	t, err := pack.TaskService.DispatchJob(models.RefetchScrubbedTask, meta, nil)
 	if SafeErrorAndExit(err, w) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeJson(w, http.StatusAccepted, rest.DispatchInfo{TaskId: t.TaskId()})
 }
 
 // UnTrashFiles godoc
@@ -1196,6 +2757,35 @@ func deleteFiles(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// EmptyTrash godoc
+//
+//	@ID			EmptyTrash
+//
+//	@Security	SessionAuth
+//
+//	@Summary	Permanently delete everything in the trash of the logged in user
+//	@Tags		Files
+//	@Produce	json
+//	@Success	200	{object}	rest.EmptyTrashInfo	"Number of files deleted"
+//	@Failure	401
+//	@Failure	404
+//	@Failure	500
+//	@Router		/files/trash [delete]
+func emptyTrash(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	deleted, err := pack.FileService.EmptyTrash(u, time.Now(), pack.Caster)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	writeJson(w, http.StatusOK, rest.EmptyTrashInfo{FilesDeleted: deleted})
+}
+
 // StartUpload godoc
 //
 //	@ID	StartUpload
@@ -1211,6 +2801,7 @@ func deleteFiles(w http.ResponseWriter, r *http.Request) {
 //	@Failure	401
 //	@Failure	404
 //	@Failure	500
+//	@Failure	507
 //	@Router		/upload [post]
 func newUploadTask(w http.ResponseWriter, r *http.Request) {
 	pack := getServices(r)
@@ -1242,6 +2833,12 @@ func newUploadTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only checked here, the size of each batch of files is reserved as it is added to the upload
+	err = pack.FileService.CheckQuota(rootFolder, upInfo.TotalUploadSize)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
 	uploadEvent := pack.FileService.GetJournalByTree("USERS").NewEvent()
 
 	meta := models.UploadFilesMeta{
@@ -1277,11 +2874,13 @@ func newUploadTask(w http.ResponseWriter, r *http.Request) {
 //	@Tags		Files
 //	@Param		uploadId	path		string				true	"Upload Id"
 //	@Param		shareId		query		string				false	"Share Id"
-//	@Param		request		body		rest.NewFilesParams	true	"New file params"
-//	@Success	200			{object}	rest.NewFilesInfo	"FileIds"
+//	@Param		request		body		rest.NewFilesParams		true	"New file params"
+//	@Success	200			{object}	rest.NewFilesInfo		"FileIds"
 //	@Failure	401
 //	@Failure	404
+//	@Failure	409			{object}	rest.FileConflictsInfo	"Conflicting files, when the conflict policy is fail"
 //	@Failure	500
+//	@Failure	507
 //	@Router		/upload/{uploadId} [post]
 func newFileUpload(w http.ResponseWriter, r *http.Request) {
 	uploadTaskId := chi.URLParam(r, "uploadId")
@@ -1313,18 +2912,105 @@ func newFileUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var ids []fileTree.FileId
+	policy, err := models.ParseConflictPolicy(string(params.ConflictPolicy), models.ConflictFail)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	// Nothing is created if any of the files conflict and the upload should fail because of it
+	var conflicts []models.FileConflict
+	if policy == models.ConflictFail {
+		for _, newFInfo := range params.NewFiles {
+			parent, err := pack.FileService.GetFileSafe(newFInfo.ParentFolderId, u, share)
+			if SafeErrorAndExit(err, w) {
+				return
+			}
+
+			if existing, _ := parent.GetChild(newFInfo.NewFileName); existing != nil {
+				conflicts = append(
+					conflicts, models.FileConflict{
+						ExistingId: existing.ID(), Filename: newFInfo.NewFileName, Resolution: policy,
+					},
+				)
+			}
+		}
+
+		if writeConflictsAndExit(conflicts, werror.WithStack(werror.ErrFileAlreadyExists), w) {
+			return
+		}
+	}
+
+	// Files in an upload are created empty and filled in as chunks arrive, so the home folder size
+	// does not count them yet. The declared sizes are reserved before creating any of them, and held
+	// until the upload task is done, so other batches and uploads cannot use the same quota.
+	var declaredSize int64
 	for _, newFInfo := range params.NewFiles {
-		parent, err := pack.FileService.GetFileSafe(newFInfo.ParentFolderId, u, share)
+		declaredSize += newFInfo.FileSize
+	}
+	if len(params.NewFiles) != 0 {
+		parent, err := pack.FileService.GetFileSafe(params.NewFiles[0].ParentFolderId, u, share)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+
+		err = pack.FileService.ReserveQuota(parent, uTask.TaskId(), declaredSize)
 		if SafeErrorAndExit(err, w) {
 			return
 		}
 
-		child, _ := parent.GetChild(newFInfo.NewFileName)
-		if child != nil {
-			writeJson(w, http.StatusConflict, rest.WeblensErrorInfo{Error: "File with the same name already exists in folder"})
+		// The task gives back its reservation when it finishes, which it may have just done
+		if completed, _ := uTask.Status(); completed {
+			pack.FileService.ReleaseQuota(uTask.TaskId())
+			writeError(w, http.StatusNotFound, werror.ErrUploadAlreadyComplete)
 			return
 		}
+	}
+
+	ids := []fileTree.FileId{}
+	linkedIds := []fileTree.FileId{}
+	var linked []models.FileChunk
+	for _, newFInfo := range params.NewFiles {
+		parent, err := pack.FileService.GetFileSafe(newFInfo.ParentFolderId, u, share)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+
+		newFileName := newFInfo.NewFileName
+		var replaces *fileTree.WeblensFileImpl
+
+		if existing, _ := parent.GetChild(newFileName); existing != nil {
+			conflict := models.FileConflict{ExistingId: existing.ID(), Filename: newFileName, Resolution: policy}
+
+			switch {
+			case policy == models.ConflictSkip:
+				conflicts = append(conflicts, conflict)
+				ids = append(ids, "")
+				continue
+			case newFInfo.IsDir && existing.IsDir() && (policy == models.ConflictMerge || policy == models.ConflictOverwrite):
+				// Uploading a folder over a folder puts what is uploaded into the folder that is already there
+				conflict.Resolution = models.ConflictMerge
+				conflicts = append(conflicts, conflict)
+				ids = append(ids, existing.ID())
+				continue
+			case policy == models.ConflictOverwrite && !newFInfo.IsDir && !existing.IsDir():
+				// The upload is written next to the existing file, and replaces its content once it is finished,
+				// so the existing content can still be restored
+				conflicts = append(conflicts, conflict)
+				replaces = existing
+				newFileName = parent.UniqueChildName(".upload-" + existing.ID())
+			case policy == models.ConflictOverwrite:
+				conflicts = append(conflicts, conflict)
+				err = pack.FileService.DeleteFiles([]*fileTree.WeblensFileImpl{existing}, "USERS", pack.Caster)
+				if SafeErrorAndExit(err, w) {
+					return
+				}
+			default:
+				newFileName = parent.UniqueChildName(newFileName)
+				conflict.Resolution = models.ConflictKeepBoth
+				conflict.NewName = newFileName
+				conflicts = append(conflicts, conflict)
+			}
+		}
 
 		uTask.ClearTimeout()
 
@@ -1333,20 +3019,33 @@ func newFileUpload(w http.ResponseWriter, r *http.Request) {
 				uploadMeta := meta.(models.UploadFilesMeta)
 				var newF *fileTree.WeblensFileImpl
 				if newFInfo.IsDir {
-					newF, err = pack.FileService.CreateFolder(parent, newFInfo.NewFileName, uploadMeta.UploadEvent, pack.Caster)
+					newF, err = pack.FileService.CreateFolder(parent, newFileName, uploadMeta.UploadEvent, pack.Caster)
 					if err != nil {
 						return err
 					}
 				} else {
 					// We must not pass the event in here, as it will attempt to generate the contentId for the
 					// file before the file has content.
-					newF, err = pack.FileService.CreateFile(parent, newFInfo.NewFileName, nil, pack.Caster)
+					newF, err = pack.FileService.CreateFile(parent, newFileName, nil, pack.Caster)
 					if err != nil {
 						return err
 					}
 
-					uploadMeta.ChunkStream <- models.FileChunk{
-						NewFile: newF, ContentRange: "0-0/" + strconv.FormatInt(newFInfo.FileSize, 10),
+					chunk := models.FileChunk{
+						NewFile: newF, Replaces: replaces, ContentId: newFInfo.ContentId,
+						ContentRange: "0-0/" + strconv.FormatInt(newFInfo.FileSize, 10),
+					}
+
+					// Files made from content already on the server are handed to the upload after all the others,
+					// so it cannot finish before every file in this request has been added to it
+					if newFInfo.ContentId != "" {
+						chunk.LinkFrom = findUploadContent(pack, u, newFInfo.ContentId, newFInfo.FileSize)
+					}
+					if chunk.LinkFrom != nil {
+						linked = append(linked, chunk)
+						linkedIds = append(linkedIds, newF.ID())
+					} else {
+						uploadMeta.ChunkStream <- chunk
 					}
 				}
 
@@ -1361,10 +3060,41 @@ func newFileUpload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	newInfo := rest.NewFilesInfo{FileIds: ids}
+	if len(linked) != 0 {
+		linked[len(linked)-1].LastNewFile = true
+		err = uTask.Manipulate(
+			func(meta task.TaskMetadata) error {
+				for _, chunk := range linked {
+					meta.(models.UploadFilesMeta).ChunkStream <- chunk
+				}
+				return nil
+			},
+		)
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+	}
+
+	newInfo := rest.NewFilesInfo{FileIds: ids, Conflicts: conflicts, LinkedFileIds: linkedIds}
 	writeJson(w, http.StatusCreated, newInfo)
 }
 
+// findUploadContent finds a file with the content a user is uploading, so it does not have to be sent again.
+// Content ids are not secret, so the user must already be able to see the file, or anyone could get a copy of
+// any file on the server just by knowing its content id.
+func findUploadContent(pack *models.ServicePack, u *models.User, contentId models.ContentId, size int64) *fileTree.WeblensFileImpl {
+	if size == 0 || u.IsPublic() {
+		return nil
+	}
+
+	f, err := pack.FileService.GetFileByContentId(contentId)
+	if err != nil || f.IsDir() || f.Size() != size || !pack.AccessService.CanUserAccessFile(u, f, nil) {
+		return nil
+	}
+
+	return f
+}
+
 // UploadFileChunk godoc
 //
 //	@ID	UploadFileChunk
@@ -1452,8 +3182,8 @@ func handleUploadChunk(w http.ResponseWriter, r *http.Request) {
 //
 //	@Summary	Get the result of an upload task. This will block until the upload is complete
 //	@Tags		Files
-//	@Param		uploadId	path	string	true	"Upload Id"
-//	@Success	200
+//	@Param		uploadId	path		string					true	"Upload Id"
+//	@Success	200			{object}	rest.UploadResultInfo	"Upload Result"
 //	@Failure	401
 //	@Failure	404
 //	@Failure	500
@@ -1474,7 +3204,12 @@ func getUploadResult(w http.ResponseWriter, r *http.Request) {
 	}
 
 	t.Wait()
-	w.WriteHeader(http.StatusOK)
+
+	rejected, _ := t.GetResult("rejectedFiles").([]fileTree.FileId)
+	if rejected == nil {
+		rejected = []fileTree.FileId{}
+	}
+	writeJson(w, http.StatusOK, rest.UploadResultInfo{RejectedFileIds: rejected})
 }
 
 // Helper Function
@@ -1521,18 +3256,21 @@ func formatRespondFolderInfo(dir *fileTree.WeblensFileImpl, w http.ResponseWrite
 	var parentsInfo []rest.FileInfo
 	parent := dir.GetParent()
 
-	owner, err := pack.FileService.GetFileOwner(parent)
-	if SafeErrorAndExit(err, w) {
-		return
-	}
-
-	for parent.ID() != "ROOT" && pack.AccessService.CanUserAccessFile(u, parent, share) && !owner.IsSystemUser() {
-		parentInfo, err := rest.WeblensFileToFileInfo(parent, pack, false)
+	// The top folder of a library is the root of its tree, so it has no parents to show
+	if parent != nil {
+		owner, err := pack.FileService.GetFileOwner(parent)
 		if SafeErrorAndExit(err, w) {
 			return
 		}
-		parentsInfo = append(parentsInfo, parentInfo)
-		parent = parent.GetParent()
+
+		for parent.ID() != "ROOT" && pack.AccessService.CanUserAccessFile(u, parent, share) && !owner.IsSystemUser() {
+			parentInfo, err := rest.WeblensFileToFileInfo(parent, pack, false)
+			if SafeErrorAndExit(err, w) {
+				return
+			}
+			parentsInfo = append(parentsInfo, parentInfo)
+			parent = parent.GetParent()
+		}
 	}
 
 	children := dir.GetChildren()
@@ -1,11 +1,13 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal"
@@ -252,17 +254,16 @@ func streamVideo(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		pack.Log.Trace.Println("Serving chunk", chunkName)
-		wrote, err := io.Copy(w, chunkFile)
-		if SafeErrorAndExit(err, w) {
-			return
-		}
+		defer chunkFile.Close()
 
-		err = chunkFile.Close()
-		pack.Log.Trace.Println("Chunk [%s] wrote [%d] bytes", chunkName, wrote)
-		if SafeErrorAndExit(err, w) {
+		stat, err := chunkFile.Stat()
+		if SafeErrorAndExit(werror.WithStack(err), w) {
 			return
 		}
+
+		pack.Log.Trace.Println("Serving chunk", chunkName)
+		// Without a name, the content type is sniffed from the chunk rather than guessed from its extension
+		http.ServeContent(w, r, "", stat.ModTime(), chunkFile)
 		return
 	}
 
@@ -533,12 +534,8 @@ func getProcessedMedia(q models.MediaQuality, format string, w http.ResponseWrit
 	w.Header().Set("Cache-Control", "max-age=3600")
 	w.Header().Set("Content-Type", "image/webp")
 
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(bs)
-
-	if err != nil {
-		pack.Log.ErrTrace(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	// The media id is the content id of the original, and the cache images are made from only that, so together
+	// with the quality and page it identifies these exact bytes
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s-%d"`, m.ID(), q, pageNum))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(bs))
 }
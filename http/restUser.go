@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ethanrous/weblens/internal/log"
@@ -348,6 +349,104 @@ func setUserAdmin(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// SetUserQuota godoc
+//
+//	@ID			SetUserQuota
+//
+//	@Security	SessionAuth[admin]
+//	@Security	ApiKeyAuth[admin]
+//
+//	@Summary	Update the storage quota of a user
+//	@Tags		Users
+//	@Produce	json
+//
+//	@Param		username	path	string	true	"Username of user to update"
+//	@Param		quota		query	integer	true	"Most bytes the user may store, 0 for no limit"
+//	@Success	200
+//	@Failure	400	{object}	rest.WeblensErrorInfo
+//	@Failure	403
+//	@Failure	404
+//	@Router		/users/{username}/quota [patch]
+func setUserQuota(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	admin, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	if !admin.IsAdmin() {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	username := chi.URLParam(r, "username")
+	u := pack.UserService.Get(username)
+	if u == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	quota, err := strconv.ParseInt(r.URL.Query().Get("quota"), 10, 64)
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "quota query parameter is required and must be a number of bytes"})
+		return
+	}
+
+	err = pack.UserService.SetUserQuota(u, quota)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// SetUserTrashRetention godoc
+//
+//	@ID			SetUserTrashRetention
+//
+//	@Security	SessionAuth
+//	@Security	ApiKeyAuth
+//
+//	@Summary	Update how long files stay in the trash of a user before they are deleted
+//	@Tags		Users
+//	@Produce	json
+//
+//	@Param		username		path	string	true	"Username of user to update"
+//	@Param		retentionDays	query	integer	true	"Days to keep trashed files, 0 to use the server default, -1 to keep them forever"
+//	@Success	200
+//	@Failure	400	{object}	rest.WeblensErrorInfo
+//	@Failure	401
+//	@Failure	404
+//	@Router		/users/{username}/trash [patch]
+func setUserTrashRetention(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	requester, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	username := chi.URLParam(r, "username")
+	u := pack.UserService.Get(username)
+
+	// Users may only change their own retention, unless they are an admin
+	if u == nil || (u.GetUsername() != requester.GetUsername() && !requester.IsAdmin()) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	days, err := strconv.Atoi(r.URL.Query().Get("retentionDays"))
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, rest.WeblensErrorInfo{Error: "retentionDays query parameter is required and must be a number of days"})
+		return
+	}
+
+	err = pack.UserService.SetTrashRetention(u, days)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 // ActivateUser godoc
 //
 //	@ID			ActivateUser
@@ -146,22 +146,51 @@ func (s *Server) UseApi() *chi.Mux {
 		r.Get("/{fileId}/stats", getFileStats)
 		r.Get("/{fileId}/download", downloadFile)
 		r.Get("/{fileId}/history", getFolderHistory)
+		r.Get("/{fileId}/history/diff", getFolderHistoryDiff)
+		r.Get("/{fileId}/versions", getFileVersions)
+		r.Get("/{fileId}/archive", getArchiveEntries)
+		r.Get("/{fileId}/archive/entry", getArchiveEntry)
 		r.Get("/search", searchByFilename)
 		r.Get("/autocomplete", autocompletePath)
 		r.Get("/shared", getSharedFiles)
+		r.Get("/starred", getStarredFiles)
+		r.Get("/recent", getRecentFiles)
+		r.Get("/external", getExternalDirs)
+		r.Get("/external/{alias}", getExternalFolderInfo)
 
 		r.Post("/restore", restoreFiles)
+		r.Post("/copy", copyFiles)
+		r.Post("/batch", runFileBatch)
+		r.Post("/{fileId}/versions/{contentId}/restore", restoreFileVersion)
+		r.Post("/{fileId}/extract", extractArchive)
 
 		r.Patch("/{fileId}", updateFile)
+		r.Patch("/{fileId}/metadata", updateFileMetadata)
+		r.Put("/{fileId}/star", starFile)
+		r.Delete("/{fileId}/star", unstarFile)
 		r.Patch("/", moveFiles)
 		// r.Patch("/trash", trashFiles)
 		r.Patch("/untrash", unTrashFiles)
 		r.Delete("/", deleteFiles)
+		r.Delete("/trash", emptyTrash)
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequireAdmin)
+			r.Post("/dedup", dedupFiles)
+			r.Post("/scrub", scrubFiles)
+			r.Get("/scrub", getLatestScrubReport)
+			r.Get("/scrub/{reportId}", getScrubReport)
+			r.Post("/scrub/{reportId}/refetch", refetchScrubbedFiles)
+			r.Post("/external", addExternalDir)
+			r.Patch("/external/{alias}/accessors", setExternalDirAccessors)
+			r.Delete("/external/{alias}", removeExternalDir)
+		})
 	})
 
 	// Folder
 	r.Route("/folder", func(r chi.Router) {
 		r.Post("/", createFolder)
+		r.Post("/scan", scanDir)
 		r.Patch("/{folderId}/cover", setFolderCover)
 
 		r.Group(func(r chi.Router) {
@@ -177,6 +206,13 @@ func (s *Server) UseApi() *chi.Mux {
 
 	// Upload
 	r.Route("/upload", func(r chi.Router) {
+		r.Route("/tus", func(r chi.Router) {
+			r.Post("/", createTusUpload)
+			r.Head("/{tusId}", getTusUploadOffset)
+			r.Patch("/{tusId}", writeTusUpload)
+			r.Delete("/{tusId}", deleteTusUpload)
+		})
+
 		r.Get("/{uploadId}", getUploadResult)
 		r.Post("/", newUploadTask)
 		r.Post("/{uploadId}", newFileUpload)
@@ -185,6 +221,7 @@ func (s *Server) UseApi() *chi.Mux {
 
 	// Takeout
 	r.Post("/takeout", createTakeout)
+	r.Get("/takeout/{takeoutId}/stream", streamTakeoutZip)
 
 	// Users
 	r.Route("/users", func(r chi.Router) {
@@ -202,6 +239,8 @@ func (s *Server) UseApi() *chi.Mux {
 		r.Post("/logout", logoutUser)
 		r.Patch("/{username}/password", updateUserPassword)
 		r.Patch("/{username}/admin", setUserAdmin)
+		r.Patch("/{username}/quota", setUserQuota)
+		r.Patch("/{username}/trash", setUserTrashRetention)
 		r.Delete("/{username}", deleteUser)
 	})
 
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal"
+	"github.com/ethanrous/weblens/internal/archive"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/jobs"
+	"github.com/ethanrous/weblens/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// newStreamTakeout saves what a streamed takeout is of, and gives the id of its url
+func newStreamTakeout(
+	pack *models.ServicePack, files []*fileTree.WeblensFileImpl, u *models.User, share *models.FileShare,
+	timestamp time.Time, format archive.Format,
+) (takeoutId models.StreamTakeoutId, filename string) {
+	ids := internal.Map(files, func(f *fileTree.WeblensFileImpl) fileTree.FileId { return f.ID() })
+
+	if len(files) == 1 {
+		filename = files[0].Filename() + format.Extension()
+	} else {
+		sortedIds := slices.Sorted(slices.Values(ids))
+		filename = internal.GlobbyHash(8, strings.Join(sortedIds, "")) + format.Extension()
+	}
+
+	var shareId models.ShareId
+	if share != nil {
+		shareId = share.ID()
+	}
+
+	takeoutId = pack.StreamTakeoutService.Add(
+		models.StreamTakeout{
+			FileIds:   ids,
+			Username:  u.GetUsername(),
+			ShareId:   shareId,
+			Timestamp: timestamp,
+			Format:    format,
+			Filename:  filename,
+			ExpiresAt: time.Now().Add(models.StreamTakeoutExpiry),
+		},
+	)
+
+	return takeoutId, filename
+}
+
+// StreamTakeout godoc
+//
+//	@ID	StreamTakeout
+//
+//	@Security
+//	@Security		SessionAuth
+//
+//	@Summary		Download a streamed takeout
+//	@Description	Writes a zip, or tarball, of the files of a takeout created with stream set, as the files are read.
+//	@Description	The zip is not saved anywhere, so the download cannot be resumed, and the size is not known ahead of time.
+//	@Tags			Files
+//	@Produce		application/zip, application/x-tar, application/gzip, application/zstd
+//	@Param			takeoutId	path		string	true	"Takeout Id"
+//	@Success		200			{string}	binary	"Zip content"
+//	@Failure		403
+//	@Failure		404
+//	@Router			/takeout/{takeoutId}/stream [get]
+func streamTakeoutZip(w http.ResponseWriter, r *http.Request) {
+	pack := getServices(r)
+	u, err := getUserFromCtx(r, true)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	// Only the user that made the takeout can download it, and a takeout of someone else looks the same as
+	// one that does not exist
+	takeout, ok := pack.StreamTakeoutService.Get(chi.URLParam(r, "takeoutId"))
+	if !ok || takeout.Username != u.GetUsername() {
+		SafeErrorAndExit(werror.ErrNoTakeout, w)
+		return
+	}
+
+	var share *models.FileShare
+	if takeout.ShareId != "" {
+		share, ok = pack.ShareService.Get(takeout.ShareId).(*models.FileShare)
+		if !ok || share == nil {
+			SafeErrorAndExit(werror.ErrNoShare, w)
+			return
+		}
+	}
+
+	// Access may have changed since the takeout was made, so it is checked again
+	files := make([]*fileTree.WeblensFileImpl, 0, len(takeout.FileIds))
+	for _, fileId := range takeout.FileIds {
+		var f *fileTree.WeblensFileImpl
+		if takeout.Timestamp.IsZero() {
+			f, err = pack.FileService.GetFileSafe(fileId, u, share)
+		} else {
+			f, err = getPastFileSafe(pack, fileId, takeout.Timestamp, u, share)
+		}
+		if SafeErrorAndExit(err, w) {
+			return
+		}
+
+		files = append(files, f)
+	}
+
+	meta := models.ZipMeta{
+		FileService: pack.FileService,
+		Share:       share,
+		Requester:   u,
+		Files:       files,
+		Timestamp:   takeout.Timestamp,
+		Format:      takeout.Format,
+	}
+
+	w.Header().Set("Content-Type", takeout.Format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": takeout.Filename}))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	if takeout.Format == archive.Zip {
+		err = jobs.StreamZip(w, meta)
+	} else {
+		err = jobs.StreamTarball(w, meta)
+	}
+	if err != nil {
+		pack.Log.ErrTrace(err)
+
+		// Part of the zip has already been sent, so the only way to tell the client it failed is to cut the
+		// connection, instead of letting it end with what looks like a complete zip
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,500 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/task"
+	"github.com/go-chi/chi/v5"
+)
+
+// tusFileIdHeader tells the client the id of the file a finished upload became
+const tusFileIdHeader = "Weblens-File-Id"
+
+// tusTaskIdHeader tells the client the id of the upload task that hashes, scans and journals a finished upload,
+// which can be subscribed to, to find out when it is done
+const tusTaskIdHeader = "Weblens-Task-Id"
+
+// finishingTusUploads stops a client that retries its last request from moving the same upload into the tree twice
+var finishingTusUploads sync.Map
+
+// tusOptions answers the OPTIONS request a tus client makes to learn what the server supports. It is called
+// from the CORS middleware, which answers every OPTIONS request before it gets to the router.
+func tusOptions(w http.ResponseWriter) {
+	w.Header().Set("Tus-Version", models.TusVersion)
+	w.Header().Set("Tus-Extension", strings.Join(models.TusExtensions, ","))
+	w.Header().Set("Tus-Checksum-Algorithm", strings.Join(models.TusChecksumAlgorithms, ","))
+}
+
+// checkTusResumable sets the headers every tus response must have, and makes sure the client speaks the same
+// version of the protocol as we do
+func checkTusResumable(w http.ResponseWriter, r *http.Request) (ok bool) {
+	w.Header().Set("Tus-Resumable", models.TusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Header.Get("Tus-Resumable") != models.TusVersion {
+		w.Header().Set("Tus-Version", models.TusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+func getTusUser(r *http.Request) (*models.User, error) {
+	u, err := getUserFromCtx(r, true)
+	if errors.Is(err, werror.ErrNoPublicUser) {
+		return getServices(r).UserService.GetPublicUser(), nil
+	}
+	return u, err
+}
+
+// getTusUpload gets the upload in the url. Uploads are only visible to the user who created them.
+func getTusUpload(w http.ResponseWriter, r *http.Request) (*models.User, models.TusUpload, bool) {
+	pack := getServices(r)
+	u, err := getTusUser(r)
+	if SafeErrorAndExit(err, w) {
+		return nil, models.TusUpload{}, false
+	}
+
+	upload, err := pack.TusUploadService.Get(chi.URLParam(r, "tusId"))
+	if err == nil && upload.Username != u.GetUsername() {
+		err = werror.WithStack(werror.ErrNoUpload)
+	}
+	if SafeErrorAndExit(err, w) {
+		return nil, models.TusUpload{}, false
+	}
+
+	return u, upload, true
+}
+
+func setTusOffset(w http.ResponseWriter, upload models.TusUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// writeTusChunk writes the body of a request with upload data to the upload, and moves it into the tree if
+// that was the last of it
+func writeTusChunk(w http.ResponseWriter, r *http.Request, u *models.User, upload models.TusUpload, offset int64) (ok bool) {
+	pack := getServices(r)
+
+	var checksum *models.TusChecksum
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		var err error
+		checksum, err = models.ParseTusChecksum(header)
+		if SafeErrorAndExit(err, w) {
+			return false
+		}
+	}
+
+	upload, err := pack.TusUploadService.WriteChunk(upload.Id, offset, r.Body, checksum)
+	if SafeErrorAndExit(err, w) {
+		return false
+	}
+
+	setTusOffset(w, upload)
+
+	if upload.IsComplete() {
+		fileId, taskId, err := finishTusUpload(pack, u, upload)
+		if SafeErrorAndExit(err, w) {
+			return false
+		}
+		w.Header().Set(tusFileIdHeader, fileId)
+		if taskId != "" {
+			w.Header().Set(tusTaskIdHeader, taskId)
+		}
+	}
+
+	return true
+}
+
+// CreateTusUpload godoc
+//
+//	@ID	CreateTusUpload
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Begin a resumable upload of a single file, using the tus protocol
+//	@Description	Upload-Metadata must have filename and parentId, and can have conflictPolicy, which is keepBoth if not given.
+//	@Tags			Files
+//	@Param			Tus-Resumable	header	string	true	"Tus protocol version"
+//	@Param			Upload-Length	header	integer	true	"Size of the file in bytes"
+//	@Param			Upload-Metadata	header	string	true	"Tus upload metadata"
+//	@Param			shareId			query	string	false	"Share Id"
+//	@Success		201
+//	@Failure		400
+//	@Failure		404
+//	@Failure		409
+//	@Failure		412
+//	@Failure		500
+//	@Failure		507
+//	@Router			/upload/tus [post]
+func createTusUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	pack := getServices(r)
+	u, err := getTusUser(r)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	share, err := getShareFromCtx[*models.FileShare](w, r)
+	if err != nil {
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		writeError(w, http.StatusBadRequest, werror.Errorf("Upload-Defer-Length is not supported"))
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		writeError(w, http.StatusBadRequest, werror.Errorf("Upload-Length must be a number of bytes"))
+		return
+	}
+
+	metadata, err := models.ParseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	filename := metadata["filename"]
+	if filename == "" || strings.ContainsAny(filename, "/\\") {
+		writeError(w, http.StatusBadRequest, werror.Errorf("Upload-Metadata must have a filename"))
+		return
+	}
+
+	policy, err := models.ParseConflictPolicy(metadata["conflictPolicy"], models.ConflictKeepBoth)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	parent, err := pack.FileService.GetFileSafe(metadata["parentId"], u, share)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	if !parent.IsDir() || !pack.AccessService.CanUserAccessFile(u, parent, share) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = pack.FileService.CheckQuota(parent, length)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	// Find out about a conflict now, rather than after the whole file has been sent
+	if existing, _ := parent.GetChild(filename); existing != nil && policy == models.ConflictFail {
+		conflicts := []models.FileConflict{{ExistingId: existing.ID(), Filename: filename, Resolution: policy}}
+		writeConflictsAndExit(conflicts, werror.WithStack(werror.ErrFileAlreadyExists), w)
+		return
+	}
+
+	upload := models.TusUpload{
+		Username:       u.GetUsername(),
+		ParentId:       parent.ID(),
+		Filename:       filename,
+		ConflictPolicy: policy,
+		Length:         length,
+		Metadata:       r.Header.Get("Upload-Metadata"),
+	}
+	if share != nil {
+		upload.ShareId = share.ID()
+	}
+
+	upload, err = pack.TusUploadService.Create(upload)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+
+	// The whole length is held against the quota until the upload is finished, so many uploads that are
+	// only partly sent cannot together go over it
+	err = pack.FileService.ReserveQuota(parent, upload.Id, length)
+	if err != nil {
+		if deleteErr := pack.TusUploadService.Delete(upload.Id); deleteErr != nil {
+			log.ErrTrace(deleteErr)
+		}
+		SafeErrorAndExit(err, w)
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.Id)
+	setTusOffset(w, upload)
+
+	// With the creation-with-upload extension, the first chunk can come along with the request that creates the upload.
+	// An empty file is complete as soon as it is created.
+	if r.Header.Get("Content-Type") == "application/offset+octet-stream" || length == 0 {
+		if !writeTusChunk(w, r, u, upload, 0) {
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetTusUploadOffset godoc
+//
+//	@ID	GetTusUploadOffset
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary	Get how much of a resumable upload the server has
+//	@Tags		Files
+//	@Param		tusId			path	string	true	"Tus Upload Id"
+//	@Param		Tus-Resumable	header	string	true	"Tus protocol version"
+//	@Success	200
+//	@Failure	404
+//	@Failure	412
+//	@Router		/upload/tus/{tusId} [head]
+func getTusUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	_, upload, ok := getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	setTusOffset(w, upload)
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// WriteTusUpload godoc
+//
+//	@ID	WriteTusUpload
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary		Add data to a resumable upload
+//	@Description	Once all of the file has been sent, it is moved into place, and the id of the new file is in the Weblens-File-Id header.
+//	@Description	The file is hashed and scanned after the response is sent, by the upload task in the Weblens-Task-Id header.
+//	@Tags			Files
+//	@Param			tusId			path	string	true	"Tus Upload Id"
+//	@Param			Tus-Resumable	header	string	true	"Tus protocol version"
+//	@Param			Upload-Offset	header	integer	true	"Where in the file the data goes"
+//	@Param			Upload-Checksum	header	string	false	"Checksum of the data"
+//	@Success		204
+//	@Failure		400
+//	@Failure		404
+//	@Failure		409
+//	@Failure		412
+//	@Failure		413
+//	@Failure		415
+//	@Failure		460
+//	@Failure		500
+//	@Router			/upload/tus/{tusId} [patch]
+func writeTusUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeError(w, http.StatusUnsupportedMediaType, werror.Errorf("Content-Type must be application/offset+octet-stream"))
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, werror.Errorf("Upload-Offset must be a number of bytes"))
+		return
+	}
+
+	u, upload, ok := getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if !writeTusChunk(w, r, u, upload, offset) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteTusUpload godoc
+//
+//	@ID	DeleteTusUpload
+//
+//	@Security
+//	@Security	SessionAuth
+//
+//	@Summary	Stop a resumable upload, and throw away what has been sent of it
+//	@Tags		Files
+//	@Param		tusId			path	string	true	"Tus Upload Id"
+//	@Param		Tus-Resumable	header	string	true	"Tus protocol version"
+//	@Success	204
+//	@Failure	404
+//	@Failure	412
+//	@Router		/upload/tus/{tusId} [delete]
+func deleteTusUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	_, upload, ok := getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	pack := getServices(r)
+	err := pack.TusUploadService.Delete(upload.Id)
+	if SafeErrorAndExit(err, w) {
+		return
+	}
+	pack.FileService.ReleaseQuota(upload.Id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves a complete upload into the users tree. The staged file is renamed into place, and then
+// given to an upload task, the same as any other upload, so it is hashed, scanned and journaled the same way.
+// The task does that after the request is answered, so the client is not left waiting on a large file to be read.
+// It returns the id of the file the upload became, or an empty id if the upload was skipped because of a conflict,
+// along with the id of the upload task.
+func finishTusUpload(pack *models.ServicePack, u *models.User, upload models.TusUpload) (fileTree.FileId, task.Id, error) {
+	if _, finishing := finishingTusUploads.LoadOrStore(upload.Id, true); finishing {
+		return "", "", werror.WithStack(werror.ErrUploadAlreadyComplete)
+	}
+	defer finishingTusUploads.Delete(upload.Id)
+
+	var share *models.FileShare
+	if upload.ShareId != "" {
+		share, _ = pack.ShareService.Get(upload.ShareId).(*models.FileShare)
+		if share == nil {
+			return "", "", werror.WithStack(werror.ErrNoShare)
+		}
+	}
+
+	parent, err := pack.FileService.GetFileSafe(upload.ParentId, u, share)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Conflicts are resolved the same way as they are for any other upload of a single file
+	filename := upload.Filename
+	var replaces *fileTree.WeblensFileImpl
+	if existing, _ := parent.GetChild(filename); existing != nil {
+		switch {
+		case upload.ConflictPolicy == models.ConflictSkip:
+			pack.FileService.ReleaseQuota(upload.Id)
+			return "", "", pack.TusUploadService.Delete(upload.Id)
+		case upload.ConflictPolicy == models.ConflictFail:
+			return "", "", werror.WithStack(werror.ErrFileAlreadyExists)
+		case upload.ConflictPolicy == models.ConflictOverwrite && !existing.IsDir():
+			replaces = existing
+			filename = parent.UniqueChildName(".upload-" + existing.ID())
+		case upload.ConflictPolicy == models.ConflictOverwrite:
+			err = pack.FileService.DeleteFiles([]*fileTree.WeblensFileImpl{existing}, "USERS", pack.Caster)
+			if err != nil {
+				return "", "", err
+			}
+		default:
+			filename = parent.UniqueChildName(filename)
+		}
+	}
+
+	newF, err := pack.FileService.CreateFile(parent, filename, nil, pack.Caster)
+	if err != nil {
+		return "", "", err
+	}
+
+	chunks := []models.FileChunk{{
+		NewFile: newF, Replaces: replaces, Staged: true, LastNewFile: true,
+		ContentRange: "0-0/" + strconv.FormatInt(upload.Length, 10),
+	}}
+	if upload.Length == 0 {
+		// An empty file has nothing to hash, so it is finished the same way as any other empty upload
+		chunks = []models.FileChunk{
+			{NewFile: newF, Replaces: replaces, ContentRange: "0-0/0"},
+			{FileId: newF.ID(), ContentRange: "0-0/0"},
+		}
+		err = pack.TusUploadService.Delete(upload.Id)
+	} else {
+		err = pack.TusUploadService.MoveTo(upload.Id, newF.AbsPath())
+		if err == nil {
+			_, err = newF.LoadStat()
+		}
+	}
+	if err != nil {
+		removeErr := removeTusFile(pack, newF)
+		if removeErr != nil {
+			log.ErrTrace(removeErr)
+		}
+		return "", "", err
+	}
+
+	meta := models.UploadFilesMeta{
+		ChunkStream:  make(chan models.FileChunk, len(chunks)),
+		RootFolderId: parent.ID(),
+		// The whole file is given to the task at once, and a task cannot have a chunk size of zero
+		ChunkSize:    max(upload.Length, 1),
+		FileService:  pack.FileService,
+		MediaService: pack.MediaService,
+		TaskService:  pack.TaskService,
+		TaskSubber:   pack.ClientService,
+		User:         u,
+		Caster:       pack.Caster,
+		UploadEvent:  pack.FileService.GetJournalByTree("USERS").NewEvent(),
+		Share:        share,
+
+		// The task gives back the quota held for the upload once the file is sized into the tree
+		QuotaReservation: upload.Id,
+	}
+
+	// The task is given its chunks before it is started, and the channel has room for all of them, so nothing
+	// has to wait on the task to read them
+	for _, chunk := range chunks {
+		meta.ChunkStream <- chunk
+	}
+
+	t, err := pack.TaskService.DispatchJob(models.UploadFilesTask, meta, nil)
+	if err != nil {
+		removeErr := removeTusFile(pack, newF)
+		if removeErr != nil {
+			log.ErrTrace(removeErr)
+		}
+		return "", "", err
+	}
+
+	if replaces != nil {
+		return replaces.ID(), t.TaskId(), nil
+	}
+	return newF.ID(), t.TaskId(), nil
+}
+
+// removeTusFile takes back a file made for a tus upload that could not be finished. It was never logged to the
+// journal, so it can be removed without a trace.
+func removeTusFile(pack *models.ServicePack, f *fileTree.WeblensFileImpl) error {
+	_, err := pack.FileService.GetFileTreeByName("USERS").Remove(f.ID())
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(f.AbsPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
@@ -214,6 +214,7 @@ func wsWebClientSwitchboard(msgBuf []byte, c *models.WsClient, pack *models.Serv
 				MediaService: pack.MediaService,
 				TaskService:  pack.TaskService,
 				TaskSubber:   pack.ClientService,
+				ContentIndex: pack.ContentIndexService,
 			}
 
 			var taskName string
@@ -0,0 +1,378 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/klauspost/compress/zstd"
+)
+
+type Format string
+
+const (
+	Zip    Format = "zip"
+	Tar    Format = "tar"
+	TarGz  Format = "tar.gz"
+	TarZst Format = "tar.zst"
+)
+
+// extensions are checked in order, so the longer ones must come before any they end with
+var extensions = []struct {
+	ext    string
+	format Format
+}{
+	{".tar.gz", TarGz},
+	{".tgz", TarGz},
+	{".tar.zst", TarZst},
+	{".tzst", TarZst},
+	{".tar", Tar},
+	{".zip", Zip},
+}
+
+// DetectFormat works out the format of an archive from its file name
+func DetectFormat(filename string) (Format, bool) {
+	lower := strings.ToLower(filename)
+	for _, e := range extensions {
+		if strings.HasSuffix(lower, e.ext) {
+			return e.format, true
+		}
+	}
+
+	return "", false
+}
+
+// ParseFormat checks that format is one we can read and write, as given by a client
+func ParseFormat(format string) (Format, bool) {
+	switch f := Format(format); f {
+	case Zip, Tar, TarGz, TarZst:
+		return f, true
+	default:
+		return "", false
+	}
+}
+
+// Extension is the file extension an archive of this format is given
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// ContentType is the mime type an archive of this format is sent with
+func (f Format) ContentType() string {
+	switch f {
+	case Zip:
+		return "application/zip"
+	case Tar:
+		return "application/x-tar"
+	case TarGz:
+		return "application/gzip"
+	case TarZst:
+		return "application/zstd"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Entry is a file or folder in an archive
+type Entry struct {
+	// Path is where the entry is in the archive, with slashes, and without a leading slash
+	Path string
+
+	Size int64
+
+	// CompressedSize is how much space the entry takes in the archive. Entries in a tar are not compressed
+	// on their own, so this is the same as Size for a tar, even if the whole tar is compressed.
+	CompressedSize int64
+
+	ModTime time.Time
+	IsDir   bool
+}
+
+// CleanPath turns the name of an entry into a path that cannot point outside of where the archive is
+// extracted. An empty path means the entry is the root of the archive itself, and has nothing to extract.
+func CleanPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) || (len(name) >= 2 && name[1] == ':') {
+		return "", werror.WithStack(werror.ErrUnsafeArchivePath.WithArg(name))
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", nil
+	} else if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", werror.WithStack(werror.ErrUnsafeArchivePath.WithArg(name))
+	}
+
+	return cleaned, nil
+}
+
+// Walk calls fn with each file and folder in the archive at archivePath, in the order they are stored.
+// For files, content reads what is in the entry, and does not need to be read to the end. Anything that
+// is not a file or folder, such as a link, is skipped. An entry with a path that would end up outside
+// of the archive stops the walk with werror.ErrUnsafeArchivePath.
+func Walk(archivePath string, format Format, fn func(entry Entry, content io.Reader) error) error {
+	switch format {
+	case Zip:
+		return walkZip(archivePath, fn)
+	case Tar, TarGz, TarZst:
+		return walkTar(archivePath, format, fn)
+	default:
+		return werror.WithStack(werror.ErrNotArchive)
+	}
+}
+
+// List gives every file and folder in the archive at archivePath, without reading what is in them.
+// A tar that is compressed as a whole still has to be decompressed to find its entries.
+func List(archivePath string, format Format) ([]Entry, error) {
+	var entries []Entry
+	err := Walk(
+		archivePath, format, func(entry Entry, _ io.Reader) error {
+			entries = append(entries, entry)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Open finds the file at entryPath in the archive at archivePath, and gives a reader of what is in it.
+// The reader must be closed when done with. An entry that does not exist, or is a folder, gives
+// werror.ErrNoArchiveEntry.
+func Open(archivePath string, format Format, entryPath string) (io.ReadCloser, Entry, error) {
+	entryPath, err := CleanPath(entryPath)
+	if err != nil {
+		return nil, Entry{}, err
+	} else if entryPath == "" {
+		return nil, Entry{}, werror.WithStack(werror.ErrNoArchiveEntry)
+	}
+
+	switch format {
+	case Zip:
+		return openZipEntry(archivePath, entryPath)
+	case Tar, TarGz, TarZst:
+		return openTarEntry(archivePath, format, entryPath)
+	default:
+		return nil, Entry{}, werror.WithStack(werror.ErrNotArchive)
+	}
+}
+
+// entryReader closes the archive the entry is in when the entry is closed
+type entryReader struct {
+	io.Reader
+	close func()
+}
+
+func (er entryReader) Close() error {
+	er.close()
+	return nil
+}
+
+func openZipEntry(archivePath, entryPath string) (io.ReadCloser, Entry, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, Entry{}, werror.WithStack(werror.ErrBadArchive.WithArg(err))
+	}
+
+	for _, f := range r.File {
+		entry, ok, err := zipEntry(f)
+		if err != nil {
+			// An unsafe path elsewhere in the archive does not stop us from reading the entry asked for
+			continue
+		} else if !ok || entry.IsDir || entry.Path != entryPath {
+			continue
+		}
+
+		content, err := f.Open()
+		if err != nil {
+			_ = r.Close()
+			return nil, Entry{}, werror.WithStack(werror.ErrBadArchive.WithArg(err))
+		}
+
+		return entryReader{Reader: content, close: func() { _ = content.Close(); _ = r.Close() }}, entry, nil
+	}
+
+	_ = r.Close()
+	return nil, Entry{}, werror.WithStack(werror.ErrNoArchiveEntry.WithArg(entryPath))
+}
+
+func openTarEntry(archivePath string, format Format, entryPath string) (io.ReadCloser, Entry, error) {
+	tr, closeTar, err := openTar(archivePath, format)
+	if err != nil {
+		return nil, Entry{}, err
+	}
+
+	for {
+		entry, ok, err := nextTarEntry(tr)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil && !errors.Is(err, werror.ErrUnsafeArchivePath) {
+			closeTar()
+			return nil, Entry{}, err
+		} else if !ok || entry.IsDir || entry.Path != entryPath {
+			continue
+		}
+
+		return entryReader{Reader: tr, close: closeTar}, entry, nil
+	}
+
+	closeTar()
+	return nil, Entry{}, werror.WithStack(werror.ErrNoArchiveEntry.WithArg(entryPath))
+}
+
+func walkZip(archivePath string, fn func(entry Entry, content io.Reader) error) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return werror.WithStack(werror.ErrBadArchive.WithArg(err))
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		entry, ok, err := zipEntry(f)
+		if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		if entry.IsDir {
+			err = fn(entry, nil)
+		} else {
+			err = walkZipFile(f, entry, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkZipFile(f *zip.File, entry Entry, fn func(entry Entry, content io.Reader) error) error {
+	content, err := f.Open()
+	if err != nil {
+		return werror.WithStack(werror.ErrBadArchive.WithArg(err))
+	}
+	defer content.Close()
+
+	return fn(entry, content)
+}
+
+func zipEntry(f *zip.File) (Entry, bool, error) {
+	entryPath, err := CleanPath(f.Name)
+	if err != nil || entryPath == "" {
+		return Entry{}, false, err
+	}
+
+	mode := f.Mode()
+	isDir := mode.IsDir() || strings.HasSuffix(f.Name, "/")
+	if !isDir && !mode.IsRegular() {
+		return Entry{}, false, nil
+	}
+
+	entry := Entry{Path: entryPath, ModTime: f.Modified, IsDir: isDir}
+	if !isDir {
+		entry.Size = int64(f.UncompressedSize64)
+		entry.CompressedSize = int64(f.CompressedSize64)
+	}
+
+	return entry, true, nil
+}
+
+func walkTar(archivePath string, format Format, fn func(entry Entry, content io.Reader) error) error {
+	tr, closeTar, err := openTar(archivePath, format)
+	if err != nil {
+		return err
+	}
+	defer closeTar()
+
+	for {
+		entry, ok, err := nextTarEntry(tr)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		if entry.IsDir {
+			err = fn(entry, nil)
+		} else {
+			err = fn(entry, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// openTar opens the tar at archivePath, decompressing it if needed. closeTar must be called when done with tr.
+func openTar(archivePath string, format Format) (tr *tar.Reader, closeTar func(), err error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, werror.WithStack(err)
+	}
+
+	var stream io.Reader = f
+	closeTar = func() { _ = f.Close() }
+
+	switch format {
+	case TarGz:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			closeTar()
+			return nil, nil, werror.WithStack(werror.ErrBadArchive.WithArg(err))
+		}
+		stream = gz
+		closeTar = func() { _ = gz.Close(); _ = f.Close() }
+	case TarZst:
+		zst, err := zstd.NewReader(f)
+		if err != nil {
+			closeTar()
+			return nil, nil, werror.WithStack(werror.ErrBadArchive.WithArg(err))
+		}
+		stream = zst
+		closeTar = func() { zst.Close(); _ = f.Close() }
+	}
+
+	return tar.NewReader(stream), closeTar, nil
+}
+
+// nextTarEntry moves tr to the next header. Entries that are not files or folders, or are the root of
+// the archive, give false. The end of the archive gives io.EOF.
+func nextTarEntry(tr *tar.Reader) (Entry, bool, error) {
+	hdr, err := tr.Next()
+	if errors.Is(err, io.EOF) {
+		return Entry{}, false, err
+	} else if err != nil {
+		return Entry{}, false, werror.WithStack(werror.ErrBadArchive.WithArg(err))
+	}
+
+	entryPath, err := CleanPath(hdr.Name)
+	if err != nil || entryPath == "" {
+		return Entry{}, false, err
+	}
+
+	entry := Entry{Path: entryPath, ModTime: hdr.ModTime}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		entry.IsDir = true
+	case tar.TypeReg:
+		entry.Size = hdr.Size
+		entry.CompressedSize = hdr.Size
+	default:
+		return Entry{}, false, nil
+	}
+
+	return entry, true, nil
+}
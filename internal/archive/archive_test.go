@@ -0,0 +1,234 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/ethanrous/weblens/internal/archive"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanPath(t *testing.T) {
+	t.Parallel()
+
+	safe := map[string]string{
+		"photos/cat.jpg":        "photos/cat.jpg",
+		"./photos/../cat.jpg":   "cat.jpg",
+		"photos\\dog.jpg":       "photos/dog.jpg",
+		"photos/":               "photos",
+		"./":                    "",
+		"photos/./a/../b/c.txt": "photos/b/c.txt",
+	}
+	for name, expected := range safe {
+		cleaned, err := CleanPath(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, cleaned, name)
+	}
+
+	unsafe := []string{"../evil.sh", "photos/../../evil.sh", "/etc/passwd", "..\\evil.exe", "C:\\evil.exe", "a\x00b"}
+	for _, name := range unsafe {
+		_, err := CleanPath(name)
+		assert.ErrorIs(t, err, werror.ErrUnsafeArchivePath, name)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	t.Parallel()
+
+	formats := map[string]Format{
+		"a.zip":     Zip,
+		"a.ZIP":     Zip,
+		"a.tar":     Tar,
+		"a.tar.gz":  TarGz,
+		"a.tgz":     TarGz,
+		"a.tar.zst": TarZst,
+		"a.tzst":    TarZst,
+	}
+	for name, expected := range formats {
+		format, ok := DetectFormat(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expected, format, name)
+	}
+
+	_, ok := DetectFormat("a.gz")
+	assert.False(t, ok)
+	_, ok = DetectFormat("a.jpg")
+	assert.False(t, ok)
+
+	format, ok := ParseFormat("tar.zst")
+	assert.True(t, ok)
+	assert.Equal(t, TarZst, format)
+	assert.Equal(t, ".tar.zst", format.Extension())
+
+	_, ok = ParseFormat("rar")
+	assert.False(t, ok)
+}
+
+var testFiles = map[string]string{
+	"notes.txt":         "hello world",
+	"photos/cat.txt":    "meow",
+	"photos/deep/a.txt": "",
+}
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	archives := map[Format]string{
+		Zip:    writeTestZip(t, filepath.Join(dir, "test.zip")),
+		Tar:    writeTestTar(t, filepath.Join(dir, "test.tar"), Tar),
+		TarGz:  writeTestTar(t, filepath.Join(dir, "test.tar.gz"), TarGz),
+		TarZst: writeTestTar(t, filepath.Join(dir, "test.tar.zst"), TarZst),
+	}
+
+	for format, archivePath := range archives {
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			found := map[string]string{}
+			var dirs []string
+			err := Walk(
+				archivePath, format, func(entry Entry, content io.Reader) error {
+					if entry.IsDir {
+						dirs = append(dirs, entry.Path)
+						return nil
+					}
+
+					data, err := io.ReadAll(content)
+					require.NoError(t, err)
+					assert.Equal(t, int64(len(data)), entry.Size)
+					found[entry.Path] = string(data)
+
+					return nil
+				},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, testFiles, found)
+			assert.Equal(t, []string{"photos"}, dirs)
+		})
+	}
+}
+
+func TestListAndOpen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	archives := map[Format]string{
+		Zip:    writeTestZip(t, filepath.Join(dir, "test.zip")),
+		TarZst: writeTestTar(t, filepath.Join(dir, "test.tar.zst"), TarZst),
+	}
+
+	for format, archivePath := range archives {
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			entries, err := List(archivePath, format)
+			require.NoError(t, err)
+			assert.Len(t, entries, len(testFiles)+1)
+
+			content, entry, err := Open(archivePath, format, "./photos/cat.txt")
+			require.NoError(t, err)
+			data, err := io.ReadAll(content)
+			require.NoError(t, err)
+			require.NoError(t, content.Close())
+			assert.Equal(t, "photos/cat.txt", entry.Path)
+			assert.Equal(t, testFiles["photos/cat.txt"], string(data))
+
+			_, _, err = Open(archivePath, format, "photos")
+			assert.ErrorIs(t, err, werror.ErrNoArchiveEntry)
+
+			_, _, err = Open(archivePath, format, "missing.txt")
+			assert.ErrorIs(t, err, werror.ErrNoArchiveEntry)
+
+			_, _, err = Open(archivePath, format, "../notes.txt")
+			assert.ErrorIs(t, err, werror.ErrUnsafeArchivePath)
+		})
+	}
+}
+
+func TestWalkUnsafePath(t *testing.T) {
+	t.Parallel()
+
+	archivePath := filepath.Join(t.TempDir(), "evil.zip")
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+
+	zw := zip.NewWriter(f)
+	_, err = zw.Create("../../evil.sh")
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	err = Walk(
+		archivePath, Zip, func(Entry, io.Reader) error {
+			t.Fatal("unsafe entry was not refused")
+			return nil
+		},
+	)
+	assert.ErrorIs(t, err, werror.ErrUnsafeArchivePath)
+}
+
+func writeTestZip(t *testing.T, archivePath string) string {
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	_, err = zw.Create("photos/")
+	require.NoError(t, err)
+
+	for _, name := range []string{"notes.txt", "photos/cat.txt", "photos/deep/a.txt"} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(testFiles[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	return archivePath
+}
+
+func writeTestTar(t *testing.T, archivePath string, format Format) string {
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var w io.WriteCloser = f
+	switch format {
+	case TarGz:
+		w = gzip.NewWriter(f)
+	case TarZst:
+		w, err = zstd.NewWriter(f)
+		require.NoError(t, err)
+	}
+
+	tw := tar.NewWriter(w)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "photos/", Typeflag: tar.TypeDir, Mode: 0755}))
+
+	// Links are skipped, and must not be followed out of the archive
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "photos/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+
+	for _, name := range []string{"notes.txt", "photos/cat.txt", "photos/deep/a.txt"} {
+		content := testFiles[name]
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err = tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	if w != io.WriteCloser(f) {
+		require.NoError(t, w.Close())
+	}
+
+	return archivePath
+}
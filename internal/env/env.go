@@ -37,6 +37,21 @@ type Config struct {
 	// Optional
 	WorkerCount int  `json:"workerCount"`
 	DetachUi    bool `json:"detachUi"`
+
+	// File history retention. Zero values keep history forever.
+	HistoryRetentionDays       int  `json:"historyRetentionDays"`
+	HistoryMaxActions          int  `json:"historyMaxActions"`
+	HistoryCollapseSizeChanges bool `json:"historyCollapseSizeChanges"`
+
+	// Link duplicate files together on disk once a day
+	DedupFiles bool `json:"dedupFiles"`
+
+	// How many days files stay in the trash before they are deleted. Users may override this,
+	// and zero keeps files in the trash until they are deleted by hand.
+	TrashRetentionDays int `json:"trashRetentionDays"`
+
+	// How many days apart to check every file for corruption. Zero only scrubs when asked to.
+	ScrubIntervalDays int `json:"scrubIntervalDays"`
 }
 
 func GetConfig(configName string, withOverrides bool) (Config, error) {
@@ -68,6 +83,12 @@ func GetConfig(configName string, withOverrides bool) (Config, error) {
 		cnf.LogLevel = GetLogLevel(string(cnf.LogLevel))
 		cnf.MongodbName = GetMongoDBName(cnf)
 		cnf.MongodbUri = GetMongoURI()
+		cnf.HistoryRetentionDays = GetHistoryRetentionDays(cnf)
+		cnf.HistoryMaxActions = GetHistoryMaxActions(cnf)
+		cnf.HistoryCollapseSizeChanges = GetHistoryCollapseSizeChanges(cnf)
+		cnf.DedupFiles = GetDedupFiles(cnf)
+		cnf.TrashRetentionDays = GetTrashRetentionDays(cnf)
+		cnf.ScrubIntervalDays = GetScrubIntervalDays(cnf)
 	}
 
 	return cnf, nil
@@ -98,6 +119,82 @@ func GetWorkerCount(cnf Config) int {
 	return runtime.NumCPU() - 2
 }
 
+// GetHistoryRetentionDays is how many days to keep the history of deleted files
+func GetHistoryRetentionDays(cnf Config) int {
+	retentionDays := os.Getenv("HISTORY_RETENTION_DAYS")
+	if retentionDays != "" {
+		days, err := strconv.Atoi(retentionDays)
+		if err == nil {
+			return days
+		}
+		log.Error.Println(err)
+	}
+
+	return cnf.HistoryRetentionDays
+}
+
+// GetHistoryMaxActions is the most history actions to keep for any one file
+func GetHistoryMaxActions(cnf Config) int {
+	maxActions := os.Getenv("HISTORY_MAX_ACTIONS")
+	if maxActions != "" {
+		count, err := strconv.Atoi(maxActions)
+		if err == nil {
+			return count
+		}
+		log.Error.Println(err)
+	}
+
+	return cnf.HistoryMaxActions
+}
+
+// GetHistoryCollapseSizeChanges is if runs of size changes in the history of a file should be collapsed into one
+func GetHistoryCollapseSizeChanges(cnf Config) bool {
+	collapse := os.Getenv("HISTORY_COLLAPSE_SIZE_CHANGES")
+	if collapse != "" {
+		return collapse == "true"
+	}
+
+	return cnf.HistoryCollapseSizeChanges
+}
+
+// GetDedupFiles is if duplicate files should be periodically replaced with links to a single copy
+func GetDedupFiles(cnf Config) bool {
+	dedup := os.Getenv("DEDUP_FILES")
+	if dedup != "" {
+		return dedup == "true"
+	}
+
+	return cnf.DedupFiles
+}
+
+// GetTrashRetentionDays is how many days files stay in the trash, unless the user has set their own
+func GetTrashRetentionDays(cnf Config) int {
+	retentionDays := os.Getenv("TRASH_RETENTION_DAYS")
+	if retentionDays != "" {
+		days, err := strconv.Atoi(retentionDays)
+		if err == nil {
+			return days
+		}
+		log.Error.Println(err)
+	}
+
+	return cnf.TrashRetentionDays
+}
+
+// GetScrubIntervalDays is how many days apart the files on the server are checked for corruption
+func GetScrubIntervalDays(cnf Config) int {
+	intervalDays := os.Getenv("SCRUB_INTERVAL_DAYS")
+	if intervalDays != "" {
+		days, err := strconv.Atoi(intervalDays)
+		if err == nil {
+			return days
+		}
+		log.Error.Println(err)
+	}
+
+	return cnf.ScrubIntervalDays
+}
+
 var appRoot string
 
 func GetAppRootDir() string {
@@ -87,6 +87,8 @@ func Startup(cnf env.Config, pack *models.ServicePack) {
 	setupFileService(cnf.DataRoot, cnf.CachesRoot, pack)
 	sw.Lap("Init file service")
 
+	pack.ScrubService = service.NewScrubService(db.Collection(string(database.ScrubReportsCollectionKey)))
+
 	// Add basic routes to the router
 	if localRole != models.InitServerRole {
 		// If server is CORE, add core routes and discover user directories
@@ -94,6 +96,24 @@ func Startup(cnf env.Config, pack *models.ServicePack) {
 			// srv.UseInterserverRoutes()
 
 			sw.Lap("Find or create user directories")
+
+			retention := fileTree.RetentionPolicy{
+				DeletedLifetimeTTL:    time.Duration(cnf.HistoryRetentionDays) * time.Hour * 24,
+				MaxActionsPerLifetime: cnf.HistoryMaxActions,
+				CollapseSizeChanges:   cnf.HistoryCollapseSizeChanges,
+			}
+			go jobs.CompactJournalD(time.Hour*24, retention, pack)
+
+			if cnf.DedupFiles {
+				go jobs.DedupFilesD(time.Hour*24, pack)
+			}
+
+			// Users can set their own trash retention, so this runs even if the server has none
+			go jobs.ExpireTrashD(time.Hour, cnf.TrashRetentionDays, pack)
+
+			if cnf.ScrubIntervalDays > 0 {
+				go jobs.ScrubFilesD(time.Hour*24*time.Duration(cnf.ScrubIntervalDays), pack)
+			}
 		} else if localRole == models.BackupServerRole {
 			/* If server is backup server, connect to core server and launch backup daemon */
 			pack.AddStartupTask("core_connect", "Waiting for Core connection")
@@ -124,6 +144,10 @@ func Startup(cnf env.Config, pack *models.ServicePack) {
 
 			go jobs.BackupD(time.Hour, pack)
 
+			if cnf.ScrubIntervalDays > 0 {
+				go jobs.ScrubFilesD(time.Hour*24*time.Duration(cnf.ScrubIntervalDays), pack)
+			}
+
 		}
 
 		setupMediaService(pack, db)
@@ -202,8 +226,17 @@ func setupTaskService(workerCount int, pack *models.ServicePack, logger log.Bund
 		workerPool.RegisterJob(models.BackupTask, jobs.DoBackup)
 		workerPool.RegisterJob(models.CopyFileFromCoreTask, jobs.CopyFileFromCore)
 		workerPool.RegisterJob(models.RestoreCoreTask, jobs.RestoreCore)
+		workerPool.RegisterJob(models.ScrubFilesTask, jobs.ScrubFiles, task.TaskOptions{Unique: true})
+		workerPool.RegisterJob(models.RefetchScrubbedTask, jobs.RefetchScrubbed, task.TaskOptions{Unique: true})
 	} else if pack.InstanceService.GetLocal().Role == models.CoreServerRole {
 		workerPool.RegisterJob(models.HashFileTask, jobs.HashFile)
+		workerPool.RegisterJob(models.CompactJournalTask, jobs.CompactJournal, task.TaskOptions{Unique: true})
+		workerPool.RegisterJob(models.CopyFilesTask, jobs.CopyFiles, task.TaskOptions{Unique: true})
+		workerPool.RegisterJob(models.DedupFilesTask, jobs.DedupFiles, task.TaskOptions{Unique: true})
+		workerPool.RegisterJob(models.ExpireTrashTask, jobs.ExpireTrash, task.TaskOptions{Unique: true})
+		workerPool.RegisterJob(models.ExtractArchiveTask, jobs.ExtractArchive)
+		workerPool.RegisterJob(models.ScrubFilesTask, jobs.ScrubFiles, task.TaskOptions{Unique: true})
+		workerPool.RegisterJob(models.IndexContentTask, jobs.IndexContent)
 	}
 
 	pack.TaskService = workerPool
@@ -349,6 +382,98 @@ func setupFileService(dataRootPath, cachesRootPath string, pack *models.ServiceP
 
 		pack.FileService.GetJournalByTree("USERS").LogEvent(event)
 		sw.Lap("Verify User Directories")
+
+		// Keep the users tree in sync with changes made to the data root outside of weblens
+		mediaJournal.SetWatchHandler(models.NewFileWatchHandler(pack))
+		go mediaJournal.FileWatcher()
+
+		/* Content Index */
+		contentIndex, err := service.NewContentIndexService(pack.Db.Collection(string(database.ContentIndexCollectionKey)))
+		if err != nil {
+			panic(err)
+		}
+		pack.ContentIndexService = contentIndex
+		mediaJournal.AddEventHandler(models.NewContentIndexEventHandler(pack))
+		sw.Lap("Init content index")
+
+		/* File Metadata */
+		fileMetadata, err := service.NewFileMetadataService(pack.Db.Collection(string(database.FileMetadataCollectionKey)))
+		if err != nil {
+			panic(err)
+		}
+		pack.FileMetadataService = fileMetadata
+		mediaJournal.AddEventHandler(models.NewFileMetadataEventHandler(pack))
+		sw.Lap("Init file metadata")
+
+		/* Stars and Downloads */
+		fileActivity, err := service.NewFileActivityService(pack.Db.Collection(string(database.FileActivityCollectionKey)))
+		if err != nil {
+			panic(err)
+		}
+		pack.FileActivityService = fileActivity
+		mediaJournal.AddEventHandler(models.NewFileActivityEventHandler(pack))
+		sw.Lap("Init file activity")
+
+		/* Resumable Uploads */
+		tusUploads, err := service.NewTusUploadService(
+			pack.Db.Collection(string(database.TusUploadsCollectionKey)), filepath.Join(cachesRootPath, "uploads"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		pack.TusUploadService = tusUploads
+		tusUploads.SetExpireHandler(func(uploadId models.TusUploadId) { fileService.ReleaseQuota(uploadId) })
+
+		// Quota reservations are only kept in memory, so uploads that were started before a restart reserve theirs again
+		pendingUploads, err := tusUploads.GetAll()
+		if err != nil {
+			panic(err)
+		}
+		for _, upload := range pendingUploads {
+			parent, err := fileService.GetFileByTree(upload.ParentId, service.UsersTreeKey)
+			if err != nil {
+				continue
+			}
+			err = fileService.ReserveQuota(parent, upload.Id, upload.Length)
+			if err != nil {
+				log.ErrTrace(err)
+			}
+		}
+		sw.Lap("Init resumable uploads")
+
+		/* Streamed Takeouts */
+		pack.StreamTakeoutService = service.NewStreamTakeoutService()
+
+		/* Libraries */
+		// Each library keeps its history under its own server id, so it is never
+		// mixed up with the users tree, or loaded by a backup of this server.
+		libraryJournal := func(lib *models.Library) (*fileTree.JournalImpl, error) {
+			journal, err := fileTree.NewJournal(
+				pack.Db.Collection(string(database.FileHistoryCollectionKey)),
+				pack.InstanceService.GetLocal().ServerId()+"-"+lib.Alias, false, hasherFactory, pack.Log,
+			)
+			if err != nil {
+				return nil, err
+			}
+			journal.SetWatchHandler(models.NewFileWatchHandler(pack))
+			journal.AddEventHandler(models.NewContentIndexEventHandler(pack))
+			journal.AddEventHandler(models.NewFileMetadataEventHandler(pack))
+			journal.AddEventHandler(models.NewFileActivityEventHandler(pack))
+
+			return journal, nil
+		}
+
+		libraryService, err := service.NewLibraryService(
+			fileService, libraryJournal, pack.Db.Collection(string(database.LibrariesCollectionKey)),
+			dataRootPath, cachesRootPath,
+		)
+		if err != nil {
+			panic(err)
+		}
+		pack.LibraryService = libraryService
+		fileService.SetLibraryService(libraryService)
+		pack.AccessService.(*service.AccessServiceImpl).SetLibraryService(libraryService)
+		sw.Lap("Attach libraries")
 	}
 	sw.Stop()
 	sw.PrintResults(false)
@@ -55,11 +55,67 @@ var ErrFileAlreadyExists = ClientSafeErr{
 	statusCode: http.StatusConflict,
 }
 
+var ErrCopyIntoSelf = ClientSafeErr{
+	safeErr:    errors.New("cannot copy a folder into itself"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrMoveIntoSelf = ClientSafeErr{
+	safeErr:    errors.New("cannot move a folder into itself"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrBadFileOperation = ClientSafeErr{
+	safeErr:    errors.New("bad file operation"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrBadFileMetadata = ClientSafeErr{
+	safeErr:    errors.New("bad file metadata"),
+	statusCode: http.StatusBadRequest,
+}
+
 var ErrUploadAlreadyComplete = ClientSafeErr{
 	safeErr:    errors.New("upload does not exist or is already complete"),
 	statusCode: http.StatusNotFound,
 }
 
+var ErrBadUploadRequest = ClientSafeErr{
+	safeErr:    errors.New("bad upload request"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrUploadContentMismatch = ClientSafeErr{
+	safeErr:    errors.New("uploaded content does not match its content id"),
+	statusCode: http.StatusUnprocessableEntity,
+}
+
+var ErrNoUpload = ClientSafeErr{
+	safeErr:    errors.New("upload not found"),
+	statusCode: http.StatusNotFound,
+}
+
+var ErrUploadOffsetMismatch = ClientSafeErr{
+	safeErr:    errors.New("upload offset does not match what the server has"),
+	statusCode: http.StatusConflict,
+}
+
+var ErrUploadTooLarge = ClientSafeErr{
+	safeErr:    errors.New("upload is larger than its declared length"),
+	statusCode: http.StatusRequestEntityTooLarge,
+}
+
+var ErrBadChecksumAlgorithm = ClientSafeErr{
+	safeErr:    errors.New("checksum algorithm is not supported"),
+	statusCode: http.StatusBadRequest,
+}
+
+// ErrChecksumMismatch uses 460, which is what the tus checksum extension expects
+var ErrChecksumMismatch = ClientSafeErr{
+	safeErr:    errors.New("checksum of the uploaded data does not match"),
+	statusCode: 460,
+}
+
 var ErrNilFile = errors.New("file is required but is nil")
 var ErrFilenameRequired = errors.New("filename is required but is empty")
 var ErrEmptyMove = errors.New("refusing to perform move with same filename and same parent")
@@ -83,3 +139,80 @@ var ErrNoFileTree = ClientSafeErr{
 }
 
 var ErrJournalServerMismatch = errors.New("journal serverId does not match the lifetime serverId")
+
+var ErrNoFileVersion = ClientSafeErr{
+	realError:  errors.New("file version does not exist in lifetime or restore tree"),
+	safeErr:    errors.New("file version does not exist or its content is no longer available"),
+	statusCode: http.StatusNotFound,
+}
+
+var ErrVersionIsCurrent = ClientSafeErr{
+	safeErr:    errors.New("file version is already the current version"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrReadOnly = ClientSafeErr{
+	safeErr:    errors.New("file is read-only"),
+	statusCode: http.StatusForbidden,
+}
+
+var ErrNoScrubReport = ClientSafeErr{
+	safeErr:    errors.New("scrub report not found"),
+	statusCode: http.StatusNotFound,
+}
+
+var ErrBadSearchQuery = ClientSafeErr{
+	safeErr:    errors.New("could not understand search query"),
+	statusCode: http.StatusBadRequest,
+}
+
+// ErrBadSearchTerm is ErrBadSearchQuery, but tells the client which part of the query was the problem
+func ErrBadSearchTerm(term string) ClientSafeErr {
+	return ClientSafeErr{
+		realError:  ErrBadSearchQuery,
+		safeErr:    fmt.Errorf("could not understand [%s] in search query", term),
+		arg:        term,
+		statusCode: http.StatusBadRequest,
+	}
+}
+
+var ErrBadConflictPolicy = ClientSafeErr{
+	safeErr:    errors.New("conflict policy must be one of fail, overwrite, keepBoth, skip or merge"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrNotArchive = ClientSafeErr{
+	safeErr:    errors.New("file is not a zip or tar archive"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrBadArchive = ClientSafeErr{
+	realError:  errors.New("failed to read archive"),
+	safeErr:    errors.New("archive is damaged or could not be read"),
+	statusCode: http.StatusUnprocessableEntity,
+}
+
+var ErrUnsafeArchivePath = ClientSafeErr{
+	safeErr:    errors.New("archive has an entry with a path outside of the archive"),
+	statusCode: http.StatusUnprocessableEntity,
+}
+
+var ErrArchiveTooLarge = ClientSafeErr{
+	safeErr:    errors.New("archive has more in it than can be extracted"),
+	statusCode: http.StatusUnprocessableEntity,
+}
+
+var ErrNoArchiveEntry = ClientSafeErr{
+	safeErr:    errors.New("archive has no file at the given path"),
+	statusCode: http.StatusNotFound,
+}
+
+var ErrNoTakeout = ClientSafeErr{
+	safeErr:    errors.New("takeout does not exist or has expired"),
+	statusCode: http.StatusNotFound,
+}
+
+var ErrBadArchiveFormat = ClientSafeErr{
+	safeErr:    errors.New("archive format must be one of zip, tar, tar.gz or tar.zst"),
+	statusCode: http.StatusBadRequest,
+}
@@ -19,6 +19,12 @@ var ErrBadTimestamp = ClientSafeErr{
 	statusCode: 400,
 }
 
+var ErrBadTimeRange = ClientSafeErr{
+	realError:  errors.New("the start of the time range must be before the end"),
+	safeErr:    nil,
+	statusCode: 400,
+}
+
 var ErrNoJournal = ClientSafeErr{
 	realError:  errors.New("could not load journal"),
 	safeErr:    nil,
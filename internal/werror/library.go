@@ -0,0 +1,26 @@
+package werror
+
+import (
+	"errors"
+	"net/http"
+)
+
+var ErrNoLibrary = ClientSafeErr{
+	safeErr:    errors.New("library not found"),
+	statusCode: http.StatusNotFound,
+}
+
+var ErrLibraryAlreadyExists = ClientSafeErr{
+	safeErr:    errors.New("a library or file tree with that alias already exists"),
+	statusCode: http.StatusConflict,
+}
+
+var ErrBadLibraryAlias = ClientSafeErr{
+	safeErr:    errors.New("library alias must be non-empty and cannot contain ':' or '/'"),
+	statusCode: http.StatusBadRequest,
+}
+
+var ErrBadLibraryPath = ClientSafeErr{
+	safeErr:    errors.New("library path must be an absolute path to an existing directory outside of the weblens data"),
+	statusCode: http.StatusBadRequest,
+}
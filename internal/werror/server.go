@@ -18,3 +18,8 @@ var ErrNoCoreAddress = errors.New("core server cannot be added with no address")
 var ErrNoLocal = errors.New("could not get local server")
 
 var ErrNoInstance = errors.New("instance not found")
+
+var ErrServerNotBackup = ClientSafeErr{
+	safeErr:    errors.New("this operation can only be performed on a backup server"),
+	statusCode: 400,
+}
@@ -25,3 +25,18 @@ var ErrNoUser = ClientSafeErr{
 }
 
 var ErrCtxMissingUser = ClientSafeErr{realError: errors.New("user not found in context"), statusCode: 500}
+
+var ErrBadQuota = ClientSafeErr{
+	safeErr:    errors.New("quota must not be negative"),
+	statusCode: 400,
+}
+
+var ErrQuotaExceeded = ClientSafeErr{
+	safeErr:    errors.New("not enough storage quota remaining"),
+	statusCode: 507,
+}
+
+var ErrBadTrashRetention = ClientSafeErr{
+	safeErr:    errors.New("trash retention must be a number of days, 0 to use the server setting, or -1 to never expire"),
+	statusCode: 400,
+}
@@ -248,8 +248,9 @@ func DoBackup(t *task.Task) {
 					t.ReqNoErr(err)
 				}
 
-				err = meta.FileService.MoveFiles(
-					[]*fileTree.WeblensFileImpl{existingFile}, newParent, meta.Core.ServerId(), meta.Caster,
+				_, err = meta.FileService.MoveFiles(
+					[]*fileTree.WeblensFileImpl{existingFile}, newParent, meta.Core.ServerId(), models.ConflictKeepBoth,
+					meta.Caster,
 				)
 				t.ReqNoErr(err)
 			}
@@ -358,6 +359,10 @@ func CopyFileFromCore(t *task.Task) {
 	}
 	defer writeFile.Close()
 
+	// A file being refetched after a scrub already has (bad) content, which must not be left at the end
+	err = writeFile.Truncate(0)
+	t.ReqNoErr(err)
+
 	res, err := proxy.NewCoreRequest(meta.Core, "GET", "/files/"+meta.CoreFileId+"/download").Call()
 	t.ReqNoErr(err)
 
@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/task"
+)
+
+// CopyFiles copies the files, and everything under them, into the destination folder,
+// updating any client subscribed to the task as it goes.
+func CopyFiles(t *task.Task) {
+	meta := t.GetMeta().(models.CopyFilesMeta)
+
+	var totalFiles int
+	var bytesTotal int64
+	for _, file := range meta.Files {
+		_ = file.RecursiveMap(
+			func(f *fileTree.WeblensFileImpl) error {
+				totalFiles++
+				if !f.IsDir() {
+					bytesTotal += f.Size()
+				}
+				return nil
+			},
+		)
+	}
+
+	meta.Caster.PushTaskUpdate(t, models.TaskCreatedEvent, task.TaskResult{"totalFiles": totalFiles})
+
+	const updateInterval = 500 * time.Millisecond
+
+	var completedFiles int
+	var bytesSoFar int64
+	lastUpdate := time.Now()
+	onCopy := func(_, copied *fileTree.WeblensFileImpl) {
+		completedFiles++
+		if !copied.IsDir() {
+			bytesSoFar += copied.Size()
+		}
+
+		if time.Since(lastUpdate) < updateInterval {
+			return
+		}
+		lastUpdate = time.Now()
+
+		meta.Caster.PushTaskUpdate(
+			t, models.CopyFilesProgressEvent, task.TaskResult{
+				"completedFiles": completedFiles, "totalFiles": totalFiles,
+				"bytesSoFar": bytesSoFar,
+				"bytesTotal": bytesTotal,
+			},
+		)
+	}
+
+	copies, conflicts, err := meta.FileService.CopyFiles(meta.Files, meta.Destination, meta.ConflictPolicy, meta.Caster, onCopy)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	newIds := make([]fileTree.FileId, 0, len(copies))
+	for _, copied := range copies {
+		newIds = append(newIds, copied.ID())
+	}
+
+	t.SetResult(
+		task.TaskResult{
+			"fileIds": newIds, "conflicts": conflicts, "completedFiles": completedFiles, "totalFiles": totalFiles,
+		},
+	)
+	meta.Caster.PushTaskUpdate(t, models.CopyFilesCompleteEvent, t.GetResults())
+	t.Success()
+}
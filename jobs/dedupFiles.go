@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service"
+	"github.com/ethanrous/weblens/task"
+)
+
+// DedupFilesD links together duplicate files in the users tree once every interval
+func DedupFilesD(interval time.Duration, pack *models.ServicePack) {
+	for {
+		now := time.Now()
+		sleepFor := now.Truncate(interval).Add(interval).Sub(now)
+		log.Debug.Println("DedupFilesD going to sleep for", sleepFor)
+		time.Sleep(sleepFor)
+
+		meta := models.DedupFilesMeta{
+			FileService: pack.FileService,
+			TreeName:    service.UsersTreeKey,
+		}
+		_, err := pack.TaskService.DispatchJob(models.DedupFilesTask, meta, nil)
+		if err != nil {
+			log.ErrTrace(err)
+		}
+	}
+}
+
+// DedupFiles replaces duplicate files in the tree with links to a single copy of their content,
+// and reports how much space was reclaimed.
+func DedupFiles(t *task.Task) {
+	meta := t.GetMeta().(models.DedupFilesMeta)
+
+	tree := meta.FileService.GetFileTreeByName(meta.TreeName)
+	if tree == nil {
+		t.ReqNoErr(werror.ErrNoFileTree.WithArg(meta.TreeName))
+	}
+
+	start := time.Now()
+	result, err := fileTree.Dedup(tree.GetRoot())
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	log.Info.Printf(
+		"Dedup of %s linked %d files (%d reflinks, %d hardlinks, %d failed), reclaiming %d bytes in %s",
+		meta.TreeName, result.FilesLinked, result.Reflinked, result.Hardlinked, result.FilesFailed,
+		result.BytesReclaimed, time.Since(start),
+	)
+
+	t.SetResult(
+		task.TaskResult{
+			"filesLinked":    result.FilesLinked,
+			"reflinked":      result.Reflinked,
+			"hardlinked":     result.Hardlinked,
+			"filesFailed":    result.FilesFailed,
+			"bytesReclaimed": result.BytesReclaimed,
+		},
+	)
+	t.Success()
+}
@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/task"
+)
+
+// ExpireTrashD deletes files that have been in the trash for longer than allowed, once every interval
+func ExpireTrashD(interval time.Duration, retentionDays int, pack *models.ServicePack) {
+	for {
+		now := time.Now()
+		sleepFor := now.Truncate(interval).Add(interval).Sub(now)
+		log.Debug.Println("ExpireTrashD going to sleep for", sleepFor)
+		time.Sleep(sleepFor)
+
+		meta := models.ExpireTrashMeta{
+			FileService:   pack.FileService,
+			UserService:   pack.UserService,
+			Caster:        pack.Caster,
+			RetentionDays: retentionDays,
+		}
+		_, err := pack.TaskService.DispatchJob(models.ExpireTrashTask, meta, nil)
+		if err != nil {
+			log.ErrTrace(err)
+		}
+	}
+}
+
+// ExpireTrash deletes the files in each users trash that were trashed longer ago than the users trash retention
+func ExpireTrash(t *task.Task) {
+	meta := t.GetMeta().(models.ExpireTrashMeta)
+
+	users, err := meta.UserService.GetAll()
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	now := time.Now()
+	var deleted int
+	var failed int
+	for u := range users {
+		if u.IsSystemUser() || u.TrashId == "" {
+			continue
+		}
+
+		retention := u.GetTrashRetention(meta.RetentionDays)
+		if retention == 0 {
+			continue
+		}
+
+		count, err := meta.FileService.EmptyTrash(u, now.Add(-retention), meta.Caster)
+		if err != nil {
+			// One users trash failing should not keep the others from being cleaned up
+			log.ErrTrace(err)
+			failed++
+			continue
+		}
+
+		if count != 0 {
+			log.Debug.Printf("Expired %d files from the trash of %s", count, u.GetUsername())
+		}
+		deleted += count
+	}
+
+	t.SetResult(task.TaskResult{"filesDeleted": deleted, "usersFailed": failed})
+	t.Success()
+}
@@ -0,0 +1,290 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/archive"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service"
+	"github.com/ethanrous/weblens/task"
+)
+
+// Limits on what an archive can hold, so one made to expand into far more than it looks like, a zip
+// bomb, cannot fill the disk.
+const (
+	maxExtractEntries = 100_000
+
+	// maxExtractRatio is how many times larger than the archive its content can be. Real archives are rarely
+	// more than 10 times smaller than what is in them, but a zip bomb can be millions of times smaller.
+	maxExtractRatio = 100
+
+	// minExtractLimit lets small archives of very compressible files through, even if they are over the ratio
+	minExtractLimit = 256 * 1024 * 1024
+)
+
+// ExtractArchive writes the files in a zip or tar archive into the destination folder. The whole archive is
+// read through once before anything is written, so an archive with a bad path, or too much in it, is refused
+// without leaving anything behind. New files and folders are logged under one journal event. Files that are
+// overwritten keep their old content as a version, the same as any other overwrite.
+func ExtractArchive(t *task.Task) {
+	meta := t.GetMeta().(models.ExtractArchiveMeta)
+
+	format, ok := archive.DetectFormat(meta.Archive.Filename())
+	if !ok {
+		t.ReqNoErr(werror.WithStack(werror.ErrNotArchive))
+	}
+
+	totalFiles, bytesTotal, err := checkArchive(meta.Archive, format)
+	t.ReqNoErr(err)
+
+	err = meta.FileService.CheckQuota(meta.Destination, bytesTotal)
+	t.ReqNoErr(err)
+
+	meta.Caster.PushTaskUpdate(t, models.TaskCreatedEvent, task.TaskResult{"totalFiles": totalFiles, "bytesTotal": bytesTotal})
+
+	event := meta.FileService.GetJournalByTree(service.UsersTreeKey).NewEvent()
+	t.SetErrorCleanup(
+		func(*task.Task) {
+			if event.Logged.Load() {
+				return
+			}
+			// Whatever was extracted before the failure is still in the tree, so it must be in the journal too
+			meta.FileService.GetJournalByTree(service.UsersTreeKey).LogEvent(event)
+		},
+	)
+
+	ex := &archiveExtractor{
+		meta:    meta,
+		event:   event,
+		folders: map[string]*fileTree.WeblensFileImpl{"": meta.Destination},
+	}
+
+	const updateInterval = 500 * time.Millisecond
+	lastUpdate := time.Now()
+
+	err = archive.Walk(
+		meta.Archive.AbsPath(), format, func(entry archive.Entry, content io.Reader) error {
+			t.ExitIfSignaled()
+
+			err := ex.extract(entry, content)
+			if err != nil {
+				return err
+			}
+
+			if time.Since(lastUpdate) >= updateInterval {
+				lastUpdate = time.Now()
+				meta.Caster.PushTaskUpdate(
+					t, models.ExtractArchiveProgressEvent, task.TaskResult{
+						"completedFiles": ex.completedFiles, "totalFiles": totalFiles,
+						"bytesSoFar": ex.bytesWritten, "bytesTotal": bytesTotal,
+					},
+				)
+			}
+
+			return nil
+		},
+	)
+	t.ReqNoErr(err)
+
+	err = meta.FileService.ResizeUp(meta.Destination, event, meta.Caster)
+	if err != nil {
+		log.ErrTrace(err)
+	}
+
+	meta.FileService.GetJournalByTree(service.UsersTreeKey).LogEvent(event)
+	event.Wait()
+
+	scanMeta := models.ScanMeta{
+		File:         meta.Destination,
+		FileService:  meta.FileService,
+		TaskService:  meta.TaskService,
+		MediaService: meta.MediaService,
+		TaskSubber:   meta.TaskSubber,
+		Caster:       meta.Caster,
+	}
+	_, err = meta.TaskService.DispatchJob(models.ScanDirectoryTask, scanMeta, nil)
+	if err != nil {
+		log.ErrTrace(err)
+	}
+
+	t.SetResult(
+		task.TaskResult{
+			"completedFiles": ex.completedFiles, "totalFiles": totalFiles, "skippedFiles": ex.skippedFiles,
+			"bytesSoFar": ex.bytesWritten, "bytesTotal": bytesTotal,
+		},
+	)
+	meta.Caster.PushTaskUpdate(t, models.ExtractArchiveCompleteEvent, t.GetResults())
+	t.Success()
+}
+
+// checkArchive reads through the archive without extracting anything, to make sure every path in it is safe,
+// and that it does not have more in it than we are willing to extract. The sizes come from the archive itself,
+// but can be trusted, as reading an entry stops with an error if it has more in it than its size says.
+func checkArchive(archiveFile *fileTree.WeblensFileImpl, format archive.Format) (totalFiles int, bytesTotal int64, err error) {
+	limit := max(archiveFile.Size()*maxExtractRatio, minExtractLimit)
+
+	err = archive.Walk(
+		archiveFile.AbsPath(), format, func(entry archive.Entry, _ io.Reader) error {
+			totalFiles++
+			bytesTotal += entry.Size
+
+			if totalFiles > maxExtractEntries {
+				return werror.WithStack(werror.ErrArchiveTooLarge.WithArg("too many entries"))
+			} else if bytesTotal > limit {
+				return werror.WithStack(werror.ErrArchiveTooLarge.WithArg("too many bytes"))
+			}
+
+			return nil
+		},
+	)
+
+	return totalFiles, bytesTotal, err
+}
+
+type archiveExtractor struct {
+	meta  models.ExtractArchiveMeta
+	event *fileTree.FileEvent
+
+	// folders are the folders entries go into, by their path in the archive. A nil folder
+	// means the folder was skipped, and so is everything in it.
+	folders map[string]*fileTree.WeblensFileImpl
+
+	completedFiles int
+	skippedFiles   int
+	bytesWritten   int64
+}
+
+func (ex *archiveExtractor) extract(entry archive.Entry, content io.Reader) error {
+	parent, err := ex.getFolder(path.Dir(entry.Path))
+	if err != nil {
+		return err
+	} else if parent == nil {
+		ex.skippedFiles++
+		return nil
+	}
+
+	if entry.IsDir {
+		_, err = ex.getFolder(entry.Path)
+		ex.completedFiles++
+		return err
+	}
+
+	name := path.Base(entry.Path)
+	existing, _ := parent.GetChild(name)
+	if existing != nil {
+		switch {
+		case ex.meta.ConflictPolicy == models.ConflictSkip:
+			ex.skippedFiles++
+			return nil
+		case ex.meta.ConflictPolicy == models.ConflictOverwrite && !existing.IsDir():
+			err = ex.meta.FileService.ReplaceFileContent(existing, content, ex.meta.Caster)
+			if err != nil {
+				return err
+			}
+			ex.bytesWritten += entry.Size
+			ex.completedFiles++
+			return nil
+		default:
+			name = parent.UniqueChildName(name)
+		}
+	}
+
+	// The event is not given here, or it would try to hash the file before it has any content
+	newFile, err := ex.meta.FileService.CreateFile(parent, name, nil, ex.meta.Caster)
+	if err != nil {
+		return err
+	}
+
+	err = ex.writeFile(newFile, entry, content)
+	if err != nil {
+		return err
+	}
+
+	ex.meta.Caster.PushFileCreate(newFile)
+	if ex.event.NewCreateAction(newFile) == nil {
+		return werror.Errorf("failed to create new file action on extract for [%s]", newFile.AbsPath())
+	}
+
+	ex.bytesWritten += entry.Size
+	ex.completedFiles++
+
+	return nil
+}
+
+func (ex *archiveExtractor) writeFile(f *fileTree.WeblensFileImpl, entry archive.Entry, content io.Reader) error {
+	w, err := f.Writeable()
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(w, hash), content)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	f.SetSize(written)
+	f.SetContentId(service.ContentIdFromHash(hash))
+
+	if !entry.ModTime.IsZero() {
+		err = os.Chtimes(f.AbsPath(), entry.ModTime, entry.ModTime)
+		if err != nil {
+			log.ErrTrace(werror.WithStack(err))
+		}
+	}
+
+	return nil
+}
+
+// getFolder finds the folder at dirPath in the archive, creating it, and the folders above it, if they do not
+// exist yet. Folders in the archive are merged into folders already in the destination with the same name.
+func (ex *archiveExtractor) getFolder(dirPath string) (*fileTree.WeblensFileImpl, error) {
+	if dirPath == "." {
+		dirPath = ""
+	}
+	if folder, ok := ex.folders[dirPath]; ok {
+		return folder, nil
+	}
+
+	parentPath, name := "", dirPath
+	if i := strings.LastIndex(dirPath, "/"); i != -1 {
+		parentPath, name = dirPath[:i], dirPath[i+1:]
+	}
+
+	parent, err := ex.getFolder(parentPath)
+	if err != nil || parent == nil {
+		return nil, err
+	}
+
+	existing, _ := parent.GetChild(name)
+	switch {
+	case existing != nil && existing.IsDir() && !ex.meta.FileService.IsFileInTrash(existing):
+		ex.folders[dirPath] = existing
+		return existing, nil
+	case existing != nil && ex.meta.ConflictPolicy == models.ConflictSkip:
+		ex.folders[dirPath] = nil
+		return nil, nil
+	case existing != nil:
+		// A file cannot be overwritten by a folder, and nothing is extracted into the trash, so the folder is given another name
+		name = parent.UniqueChildName(name)
+	}
+
+	folder, err := ex.meta.FileService.CreateFolder(parent, name, ex.event, ex.meta.Caster)
+	if err != nil {
+		return nil, err
+	}
+	ex.folders[dirPath] = folder
+
+	return folder, nil
+}
@@ -81,6 +81,18 @@ func ScanDirectory(t *task.Task) {
 				return nil
 			}
 
+			if meta.ContentIndex != nil && meta.ContentIndex.IsIndexable(mf) && !meta.ContentIndex.IsIndexed(mf) {
+				indexMeta := models.IndexContentMeta{
+					FileService:  meta.FileService,
+					ContentIndex: meta.ContentIndex,
+					File:         mf,
+				}
+				_, err := meta.TaskService.DispatchJob(models.IndexContentTask, indexMeta, pool)
+				if err != nil {
+					return err
+				}
+			}
+
 			if !meta.MediaService.IsFileDisplayable(mf) {
 				log.Trace.Func(func(l log.Logger) { l.Printf("Skipping file %s, not displayable", mf.GetPortablePath()) })
 				return nil
@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/task"
+)
+
+// IndexContent reads the text of a file into the content index, so it can be found by what it says
+func IndexContent(t *task.Task) {
+	meta := t.GetMeta().(models.IndexContentMeta)
+
+	// The file may have been deleted while the task was queued
+	if !meta.File.Exists() {
+		err := meta.ContentIndex.Remove(meta.File.ID())
+		t.ReqNoErr(err)
+		t.Success()
+		return
+	}
+
+	// A file we cannot read the text of is only left out of content searches, which should not
+	// fail the directory scan that queued it
+	err := meta.ContentIndex.Index(meta.File)
+	if err != nil {
+		log.ErrTrace(err)
+		t.Success("Could not index file content")
+		return
+	}
+
+	t.Success()
+}
@@ -4,8 +4,11 @@ import (
 	"archive/zip"
 	"context"
 	"crypto/sha256"
+	"errors"
+	"io"
 	"maps"
 	"os"
+	"path"
 	"slices"
 	"strconv"
 	"strings"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal"
+	"github.com/ethanrous/weblens/internal/archive"
 	"github.com/ethanrous/weblens/internal/log"
 	"github.com/ethanrous/weblens/internal/werror"
 	"github.com/ethanrous/weblens/models"
@@ -28,6 +32,16 @@ func CreateZip(t *task.Task) {
 		t.ReqNoErr(werror.ErrEmptyZip)
 	}
 
+	if zipMeta.Format != "" && zipMeta.Format != archive.Zip {
+		createTarball(t, zipMeta)
+		return
+	}
+
+	if !zipMeta.Timestamp.IsZero() {
+		createPastZip(t, zipMeta)
+		return
+	}
+
 	filesInfoMap := map[string]os.FileInfo{}
 
 	internal.Map(
@@ -170,6 +184,233 @@ func CreateZip(t *task.Task) {
 	t.Success()
 }
 
+type zipEntry struct {
+	file    *fileTree.WeblensFileImpl
+	zipPath string
+}
+
+// createPastZip builds a zip of the files as they were at zipMeta.Timestamp. The files may have since been moved,
+// changed or deleted, so the content of each is found by its contentId, which pulls deleted content from the restore tree.
+func createPastZip(t *task.Task, zipMeta models.ZipMeta) {
+	entries, bytesTotal, fileCount, err := gatherPastZipEntries(zipMeta)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	zipName := takeoutKey(zipMeta) + ".zip"
+
+	takeoutDir, err := zipMeta.FileService.GetFileTreeByName(service.CachesTreeKey).GetRoot().GetChild("takeout")
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	// The past does not change, so a zip of the same files at the same time can be reused
+	if zipFile, err := takeoutDir.GetChild(zipName); err == nil {
+		t.SetResult(task.TaskResult{"takeoutId": zipFile.ID(), "filename": zipFile.Filename()})
+		zipMeta.Caster.PushTaskUpdate(t, models.ZipCompleteEvent, t.GetResults())
+		t.Success()
+		return
+	}
+
+	zipMeta.Caster.PushTaskUpdate(t, models.TaskCreatedEvent, task.TaskResult{"totalFiles": fileCount})
+
+	// The zip is written to a temp file, and only given its name once it is complete, so a zip that failed
+	// part way through is never found and handed out by a later takeout of the same files
+	fp, err := os.CreateTemp(takeoutDir.AbsPath(), "."+zipName+"-*.partial")
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+	t.SetErrorCleanup(
+		func(*task.Task) {
+			_ = fp.Close()
+			err := os.Remove(fp.Name())
+			if err != nil && !os.IsNotExist(err) {
+				log.ErrTrace(werror.WithStack(err))
+			}
+		},
+	)
+
+	zipWriter := zip.NewWriter(fp)
+
+	const updateInterval = 500 * time.Millisecond
+
+	var completedFiles int
+	var bytesSoFar int64
+	lastUpdate := time.Now()
+	lastBytes := int64(0)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{
+			Name:     entry.zipPath,
+			Method:   zip.Store,
+			Modified: entry.file.ModTime(),
+		}
+
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			t.ReqNoErr(err)
+		}
+
+		if entry.file.IsDir() {
+			continue
+		}
+
+		// Empty files have no content to copy
+		if entry.file.GetContentId() != "" {
+			written, err := copyPastContent(zipMeta.FileService, entry.file, writer)
+			if err != nil {
+				t.ReqNoErr(err)
+			}
+			bytesSoFar += written
+		}
+		completedFiles++
+
+		if since := time.Since(lastUpdate); since >= updateInterval {
+			zipMeta.Caster.PushTaskUpdate(
+				t, models.ZipProgressEvent, task.TaskResult{
+					"completedFiles": completedFiles, "totalFiles": fileCount,
+					"bytesSoFar": bytesSoFar,
+					"bytesTotal": bytesTotal,
+					"speedBytes": int(float64(bytesSoFar-lastBytes) / since.Seconds()),
+				},
+			)
+			lastUpdate = time.Now()
+			lastBytes = bytesSoFar
+		}
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	err = fp.Close()
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	zipFile, err := finishTakeout(zipMeta, fp.Name(), zipName)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	t.SetResult(task.TaskResult{"takeoutId": zipFile.ID(), "filename": zipFile.Filename()})
+	zipMeta.Caster.PushTaskUpdate(
+		t, models.ZipCompleteEvent, t.GetResults(),
+	) // Let any client subscribers know we are done
+	t.Success()
+}
+
+// finishTakeout gives the takeout written to tmpPath its name in the takeout cache, once it is complete
+func finishTakeout(zipMeta models.ZipMeta, tmpPath, name string) (*fileTree.WeblensFileImpl, error) {
+	takeoutFile, err := zipMeta.FileService.NewZip(name, zipMeta.Requester)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmpPath, takeoutFile.AbsPath())
+	if err != nil {
+		if rmErr := zipMeta.FileService.DeleteCacheFile(takeoutFile); rmErr != nil {
+			log.ErrTrace(rmErr)
+		}
+		return nil, werror.WithStack(err)
+	}
+
+	// Touch made the takeout empty, so it must be stat-ed again to pick up the size of the content renamed over it
+	takeoutFile.SetSize(-1)
+	_, err = takeoutFile.LoadStat()
+	if err != nil {
+		return nil, err
+	}
+
+	return takeoutFile, nil
+}
+
+// takeoutKey names a takeout of zipMeta.Files, as they were at zipMeta.Timestamp if it is set. The requester is part
+// of the key, as well as the ids of the files, so users with folders of the same name never find each other's takeouts.
+func takeoutKey(zipMeta models.ZipMeta) string {
+	ids := internal.Map(zipMeta.Files, func(f *fileTree.WeblensFileImpl) string { return f.ID() })
+	slices.Sort(ids)
+
+	var requester models.Username
+	if zipMeta.Requester != nil {
+		requester = zipMeta.Requester.GetUsername()
+	}
+
+	key := internal.GlobbyHash(8, requester, strings.Join(ids, ""))
+	if !zipMeta.Timestamp.IsZero() {
+		key += "-" + zipMeta.Timestamp.Format("2006-01-02T150405")
+	}
+	if len(zipMeta.Files) == 1 {
+		key = zipMeta.Files[0].Filename() + "-" + key
+	}
+
+	return key
+}
+
+// gatherPastZipEntries finds every file and folder under zipMeta.Files as they were at zipMeta.Timestamp,
+// along with where each goes in the zip
+func gatherPastZipEntries(zipMeta models.ZipMeta) (entries []zipEntry, bytesTotal int64, fileCount int, err error) {
+	journal := zipMeta.FileService.GetJournalByTree(service.UsersTreeKey)
+
+	var gatherEntries func(f *fileTree.WeblensFileImpl, zipPath string) error
+	gatherEntries = func(f *fileTree.WeblensFileImpl, zipPath string) error {
+		if !f.IsDir() {
+			entries = append(entries, zipEntry{file: f, zipPath: zipPath})
+			bytesTotal += max(f.Size(), 0)
+			fileCount++
+			return nil
+		}
+
+		entries = append(entries, zipEntry{file: f, zipPath: zipPath + "/"})
+
+		children, err := journal.GetPastFolderChildren(f, zipMeta.Timestamp)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			err = gatherEntries(child, path.Join(zipPath, child.Filename()))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, f := range zipMeta.Files {
+		err = gatherEntries(f, f.Filename())
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	return entries, bytesTotal, fileCount, nil
+}
+
+// copyPastContent writes the content a past file held into w. Any file with the same contentId holds the same
+// content, so the live file is used if one exists, and the copy in the restore tree otherwise.
+func copyPastContent(fileService models.FileService, pastFile *fileTree.WeblensFileImpl, w io.Writer) (int64, error) {
+	contentFile, err := fileService.GetFileByContentId(pastFile.GetContentId())
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := os.Open(contentFile.AbsPath())
+	if err != nil {
+		return 0, werror.WithStack(err)
+	}
+	defer content.Close()
+
+	written, err := io.Copy(w, content)
+	if err != nil {
+		return written, werror.WithStack(err)
+	}
+
+	return written, nil
+}
+
 func parseRangeHeader(contentRange string) (min, max, total int64, err error) {
 	rangeAndSize := strings.Split(contentRange, "/")
 	rangeParts := strings.Split(rangeAndSize[0], "-")
@@ -215,6 +456,15 @@ func HandleFileUploads(t *task.Task) {
 	var usingFiles []fileTree.FileId
 	var topLevels []*fileTree.WeblensFileImpl
 
+	// Files that did not match the content id the client gave for them, which the client is told to upload again
+	rejected := []fileTree.FileId{}
+	reject := func(f *fileTree.WeblensFileImpl) {
+		err := rejectUpload(meta, f)
+		t.ReqNoErr(err)
+		rejected = append(rejected, f.ID())
+		topLevels = slices.DeleteFunc(topLevels, func(tl *fileTree.WeblensFileImpl) bool { return tl == f })
+	}
+
 	fileEvent := meta.UploadEvent
 
 	timeout := false
@@ -229,6 +479,14 @@ func HandleFileUploads(t *task.Task) {
 
 	// Cleanup routine. This must be run even if the upload fails
 	t.SetCleanup(func(t *task.Task) {
+		// Once the files are sized into the tree, the home folder counts what was written, so the quota held for
+		// the upload is given back. This is deferred so it is given back even if sizing the files fails.
+		defer func() {
+			meta.FileService.ReleaseQuota(t.TaskId())
+			if meta.QuotaReservation != "" {
+				meta.FileService.ReleaseQuota(meta.QuotaReservation)
+			}
+		}()
 
 		log.Debug.Func(func(l log.Logger) {
 			l.Printf("Upload fileMap has %d remaining and chunk stream has %d remaining", len(fileMap), len(meta.ChunkStream))
@@ -331,8 +589,23 @@ WriterLoop:
 					topLevels = append(topLevels, tmpFile)
 				}
 
+				if chunk.LinkFrom != nil || chunk.Staged {
+					err = linkUpload(meta, chunk, fileEvent)
+					if errors.Is(err, werror.ErrUploadContentMismatch) {
+						reject(chunk.NewFile)
+					} else {
+						t.ReqNoErr(err)
+					}
+
+					if chunk.LastNewFile && len(fileMap) == 0 && len(meta.ChunkStream) == 0 {
+						break WriterLoop
+					}
+					continue WriterLoop
+				}
+
 				fileMap[chunk.NewFile.ID()] = &models.FileUploadProgress{
-					File: chunk.NewFile, BytesWritten: 0, FileSizeTotal: total, Hash: sha256.New(),
+					File: chunk.NewFile, Replaces: chunk.Replaces, ContentId: chunk.ContentId, BytesWritten: 0,
+					FileSizeTotal: total, Hash: sha256.New(),
 				}
 
 				internal.InsertFunc(
@@ -376,7 +649,15 @@ WriterLoop:
 			}
 
 			// When file is finished writing
-			if chnk.BytesWritten >= chnk.FileSizeTotal {
+			if chnk.BytesWritten >= chnk.FileSizeTotal && chnk.ContentId != "" && service.ContentIdFromHash(chnk.Hash) != chnk.ContentId {
+				log.Debug.Printf("Upload of [%s] does not match the content id it was given", chnk.File.GetPortablePath())
+				reject(chnk.File)
+				delete(fileMap, chunk.FileId)
+			} else if chnk.BytesWritten >= chnk.FileSizeTotal && chnk.Replaces != nil {
+				err = replaceWithUpload(meta, chnk)
+				t.ReqNoErr(err)
+				delete(fileMap, chunk.FileId)
+			} else if chnk.BytesWritten >= chnk.FileSizeTotal {
 
 				// Hash file content to get content ID. Must do this before attaching the file,
 				// or the journal worker will beat us to it, which could break if importing
@@ -422,9 +703,90 @@ WriterLoop:
 	}
 
 	log.Debug.Printf("Finished writing upload files for %s", rootFile.GetPortablePath())
+	t.SetResult(task.TaskResult{"rejectedFiles": rejected})
 	t.Success()
 }
 
+// replaceWithUpload makes the content of a finished upload the new content of the file it was uploaded to
+// overwrite, and removes the uploaded file
+func replaceWithUpload(meta models.UploadFilesMeta, upload *models.FileUploadProgress) error {
+	content, err := upload.File.Readable()
+	if err != nil {
+		return err
+	}
+
+	err = meta.FileService.ReplaceFileContent(upload.Replaces, content, meta.Caster)
+	if closer, ok := content.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	// The uploaded file was never logged to the journal, so it can be removed without a trace
+	_, err = meta.FileService.GetFileTreeByName("USERS").Remove(upload.File.ID())
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(upload.File.AbsPath())
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+// linkUpload gives a new file in an upload the content of a file that is already on the server, or, if the chunk
+// is staged, finishes a file whose content is already in place. The content is hashed again once it is in place,
+// as the content id we found it by may not be up to date.
+func linkUpload(meta models.UploadFilesMeta, chunk models.FileChunk, fileEvent *fileTree.FileEvent) error {
+	if chunk.LinkFrom != nil {
+		method, err := chunk.NewFile.LinkFrom(chunk.LinkFrom)
+		if err != nil {
+			return err
+		}
+		log.Trace.Printf("Upload of [%s] was made from [%s] with a %s", chunk.NewFile.GetPortablePath(), chunk.LinkFrom.GetPortablePath(), method)
+	}
+
+	contentId, err := service.HashFileContent(chunk.NewFile)
+	if err != nil {
+		return err
+	} else if chunk.ContentId != "" && contentId != chunk.ContentId {
+		return werror.WithStack(werror.ErrUploadContentMismatch.WithArg(chunk.NewFile.GetPortablePath()))
+	}
+
+	if chunk.Replaces != nil {
+		return replaceWithUpload(meta, &models.FileUploadProgress{File: chunk.NewFile, Replaces: chunk.Replaces})
+	}
+
+	chunk.NewFile.SetContentId(contentId)
+	meta.Caster.PushFileCreate(chunk.NewFile)
+
+	if fileEvent.NewCreateAction(chunk.NewFile) == nil {
+		return werror.Errorf("failed to create new file action on upload for [%s]", chunk.NewFile.AbsPath())
+	}
+
+	return nil
+}
+
+// rejectUpload removes a file from an upload. It was never logged to the journal, so it can be removed without a trace.
+func rejectUpload(meta models.UploadFilesMeta, f *fileTree.WeblensFileImpl) error {
+	_, err := meta.FileService.GetFileTreeByName("USERS").Remove(f.ID())
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(f.AbsPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return werror.WithStack(err)
+	}
+
+	meta.Caster.PushFileDelete(f)
+
+	return nil
+}
+
 type extSize struct {
 	Name  string `json:"name"`
 	Value int64  `json:"value"`
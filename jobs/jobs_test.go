@@ -0,0 +1,124 @@
+package jobs_test
+
+import (
+	"archive/zip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/env"
+	"github.com/ethanrous/weblens/internal/tests"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service"
+	"github.com/ethanrous/weblens/service/mock"
+	"github.com/ethanrous/weblens/task"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateZip_PastTimestamp(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("skipping %s in short mode", t.Name())
+	}
+
+	t.Parallel()
+
+	pack, err := tests.NewWeblensTestInstance(t.Name(), env.Config{
+		Role: string(models.CoreServerRole),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pack.Server.Stop()
+
+	caster := &mock.MockCaster{}
+	owner := pack.UserService.Get("test-username")
+	home, err := pack.FileService.GetFileByTree(owner.HomeId, service.UsersTreeKey)
+	require.NoError(t, err)
+	journal := pack.FileService.GetJournalByTree(service.UsersTreeKey)
+
+	event := journal.NewEvent()
+	album, err := pack.FileService.CreateFolder(home, "album", event, caster)
+	require.NoError(t, err)
+
+	newFile := func(name, content string) *fileTree.WeblensFileImpl {
+		f, err := pack.FileService.CreateFile(album, name, event, caster)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		return f
+	}
+
+	newFile("kept.txt", "kept as it was")
+	deleted := newFile("deleted.txt", "deleted later")
+	changed := newFile("changed.txt", "before the overwrite")
+
+	journal.LogEvent(event)
+	event.Wait()
+
+	// Journal actions are timestamped to the millisecond, so keep the past time clear of both sides
+	time.Sleep(10 * time.Millisecond)
+	pastTime := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	// After the past time, one file is deleted and another overwritten, so their content at the
+	// past time only exists in the restore tree
+	err = pack.FileService.DeleteFiles([]*fileTree.WeblensFileImpl{deleted}, service.UsersTreeKey, caster)
+	require.NoError(t, err)
+	err = pack.FileService.ReplaceFileContent(changed, strings.NewReader("after the overwrite"), caster)
+	require.NoError(t, err)
+
+	_, err = album.GetChild("deleted.txt")
+	require.Error(t, err)
+
+	pastAlbum, err := journal.GetPastFile(album.ID(), pastTime)
+	require.NoError(t, err)
+
+	zipTask, err := pack.TaskService.DispatchJob(
+		models.CreateZipTask, models.ZipMeta{
+			Files:       []*fileTree.WeblensFileImpl{pastAlbum},
+			Requester:   owner,
+			Caster:      caster,
+			FileService: pack.FileService,
+			Timestamp:   pastTime,
+		}, nil,
+	)
+	require.NoError(t, err)
+
+	zipTask.Wait()
+	_, exitStatus := zipTask.Status()
+	require.Equal(t, task.TaskSuccess, exitStatus, zipTask.ReadError())
+
+	zipFile, err := pack.FileService.GetZip(zipTask.GetResult("takeoutId").(fileTree.FileId))
+	require.NoError(t, err)
+
+	zr, err := zip.OpenReader(zipFile.AbsPath())
+	require.NoError(t, err)
+	defer zr.Close()
+
+	found := map[string]string{}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			found[f.Name] = ""
+			continue
+		}
+
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		found[f.Name] = string(data)
+	}
+
+	assert.Equal(
+		t, map[string]string{
+			"album/":            "",
+			"album/kept.txt":    "kept as it was",
+			"album/deleted.txt": "deleted later",
+			"album/changed.txt": "before the overwrite",
+		}, found,
+	)
+}
@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service"
+	"github.com/ethanrous/weblens/task"
+)
+
+// CompactJournalD applies the retention policy to the users journal once every interval
+func CompactJournalD(interval time.Duration, policy fileTree.RetentionPolicy, pack *models.ServicePack) {
+	if policy.IsEmpty() {
+		return
+	}
+
+	for {
+		now := time.Now()
+		sleepFor := now.Truncate(interval).Add(interval).Sub(now)
+		log.Debug.Println("CompactJournalD going to sleep for", sleepFor)
+		time.Sleep(sleepFor)
+
+		meta := models.CompactJournalMeta{
+			FileService: pack.FileService,
+			Journal:     pack.FileService.GetJournalByTree(service.UsersTreeKey),
+			Policy:      policy,
+		}
+		_, err := pack.TaskService.DispatchJob(models.CompactJournalTask, meta, nil)
+		if err != nil {
+			log.ErrTrace(err)
+		}
+	}
+}
+
+// CompactJournal trims the file history down to what the retention policy allows,
+// then removes content from the restore tree that can no longer be restored.
+func CompactJournal(t *task.Task) {
+	meta := t.GetMeta().(models.CompactJournalMeta)
+
+	result, err := meta.Journal.Compact(meta.Policy)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	purged, freed, err := meta.FileService.PurgeRestoreFiles(result.OrphanedContentIds)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	t.SetResult(
+		task.TaskResult{
+			"lifetimesRemoved": result.LifetimesRemoved,
+			"actionsRemoved":   result.ActionsRemoved,
+			"contentsPurged":   purged,
+			"bytesFreed":       freed,
+		},
+	)
+	t.Success()
+}
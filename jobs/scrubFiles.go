@@ -0,0 +1,256 @@
+package jobs
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service"
+	"github.com/ethanrous/weblens/task"
+)
+
+// ScrubFilesD checks the files on the server for corruption once every interval. A core scrubs the users
+// tree, and a backup server scrubs the tree of each core it backs up.
+func ScrubFilesD(interval time.Duration, pack *models.ServicePack) {
+	for {
+		now := time.Now()
+		sleepFor := now.Truncate(interval).Add(interval).Sub(now)
+		log.Debug.Println("ScrubFilesD going to sleep for", sleepFor)
+		time.Sleep(sleepFor)
+
+		var treeNames []string
+		if pack.InstanceService.GetLocal().GetRole() == models.BackupServerRole {
+			for _, core := range pack.InstanceService.GetCores() {
+				treeNames = append(treeNames, core.ServerId())
+			}
+		} else {
+			treeNames = append(treeNames, service.UsersTreeKey)
+		}
+
+		for _, treeName := range treeNames {
+			meta := models.ScrubFilesMeta{
+				FileService:  pack.FileService,
+				ScrubService: pack.ScrubService,
+				Caster:       pack.Caster,
+				TreeName:     treeName,
+			}
+			_, err := pack.TaskService.DispatchJob(models.ScrubFilesTask, meta, nil)
+			if err != nil {
+				log.ErrTrace(err)
+			}
+		}
+	}
+}
+
+// ScrubFiles rehashes every file in the tree that has a content id, and saves a report of the
+// files that no longer match it, or could not be read.
+func ScrubFiles(t *task.Task) {
+	meta := t.GetMeta().(models.ScrubFilesMeta)
+
+	tree := meta.FileService.GetFileTreeByName(meta.TreeName)
+	if tree == nil {
+		t.ReqNoErr(werror.ErrNoFileTree.WithArg(meta.TreeName))
+	}
+
+	var files []*fileTree.WeblensFileImpl
+	var bytesTotal int64
+	err := tree.GetRoot().RecursiveMap(
+		func(f *fileTree.WeblensFileImpl) error {
+			// Empty files are never given a content id, so there is nothing to check them against
+			if f.IsDir() || f.Size() == 0 || f.GetContentId() == "" {
+				return nil
+			}
+			files = append(files, f)
+			bytesTotal += f.Size()
+			return nil
+		},
+	)
+	t.ReqNoErr(err)
+
+	report := models.NewScrubReport(meta.TreeName)
+
+	meta.Caster.PushTaskUpdate(
+		t, models.TaskCreatedEvent, task.TaskResult{
+			"treeName": meta.TreeName, "totalFiles": len(files), "bytesTotal": bytesTotal,
+		},
+	)
+
+	// Only the users tree is written to outside of weblens, by way of the file watcher. Files in a backup tree are
+	// written after the core hashed them, so their mod time says nothing about if their content id is stale.
+	var journal fileTree.Journal
+	if meta.TreeName == service.UsersTreeKey {
+		journal = tree.GetJournal()
+	}
+
+	const updateInterval = 500 * time.Millisecond
+	lastUpdate := time.Now()
+
+	for _, f := range files {
+		t.ExitIfSignaled()
+
+		if journal != nil && changedSinceHashed(f, journal) {
+			report.FilesChanged++
+			continue
+		}
+
+		scrubbed := scrubFile(f, tree)
+		if scrubbed != nil && scrubbed.Error != "" {
+			report.Unreadable = append(report.Unreadable, *scrubbed)
+		} else if scrubbed != nil {
+			report.Corrupted = append(report.Corrupted, *scrubbed)
+		}
+
+		report.FilesChecked++
+		report.BytesChecked += f.Size()
+
+		if time.Since(lastUpdate) < updateInterval {
+			continue
+		}
+		lastUpdate = time.Now()
+
+		meta.Caster.PushTaskUpdate(
+			t, models.ScrubProgressEvent, task.TaskResult{
+				"filesChecked": report.FilesChecked, "totalFiles": len(files),
+				"bytesChecked": report.BytesChecked, "bytesTotal": bytesTotal,
+				"corrupted": len(report.Corrupted), "unreadable": len(report.Unreadable),
+			},
+		)
+	}
+
+	report.Finished = time.Now()
+	err = meta.ScrubService.Add(report)
+	t.ReqNoErr(err)
+
+	if !report.IsClean() {
+		log.Warning.Printf(
+			"Scrub of %s found %d corrupted and %d unreadable files (report %s)",
+			meta.TreeName, len(report.Corrupted), len(report.Unreadable), report.Id,
+		)
+	}
+
+	t.SetResult(
+		task.TaskResult{
+			"reportId":     report.Id,
+			"treeName":     meta.TreeName,
+			"filesChecked": report.FilesChecked,
+			"bytesChecked": report.BytesChecked,
+			"filesChanged": report.FilesChanged,
+			"corrupted":    len(report.Corrupted),
+			"unreadable":   len(report.Unreadable),
+		},
+	)
+	meta.Caster.PushTaskUpdate(t, models.ScrubCompleteEvent, t.GetResults())
+	t.Success()
+}
+
+// scrubFile hashes the file and compares it to its content id. It returns nil if the file is fine, and
+// otherwise a record of the problem, which has an error if the file could not be read at all.
+func scrubFile(f *fileTree.WeblensFileImpl, tree fileTree.FileTree) *models.ScrubbedFile {
+	contentId, err := service.HashFileContent(f)
+	if err == nil && contentId == f.GetContentId() {
+		return nil
+	}
+
+	// The file may have been deleted since we started, which is not something wrong with it
+	if errors.Is(err, os.ErrNotExist) && tree.Get(f.ID()) == nil {
+		return nil
+	}
+
+	scrubbed := &models.ScrubbedFile{
+		FileId:         f.ID(),
+		Path:           f.GetPortablePath().ToPortable(),
+		ContentId:      f.GetContentId(),
+		FoundContentId: contentId,
+	}
+
+	if err != nil {
+		log.ErrTrace(err)
+		scrubbed.Error = err.Error()
+	}
+
+	return scrubbed
+}
+
+// changedSinceHashed is if the file has been written to since its content id was last set. The file watcher keeps
+// the size of a file up to date when it is edited outside of weblens, but does not rehash it, so without this
+// any such edit would look the same as the file having been corrupted.
+func changedSinceHashed(f *fileTree.WeblensFileImpl, journal fileTree.Journal) bool {
+	lt := journal.Get(f.ID())
+	if lt == nil {
+		return false
+	}
+
+	var hashedAt time.Time
+	for _, action := range lt.GetActions() {
+		switch action.GetActionType() {
+		case fileTree.FileCreate, fileTree.FileCopy, fileTree.FileRestore, fileTree.FileModify:
+			if action.GetTimestamp().After(hashedAt) {
+				hashedAt = action.GetTimestamp()
+			}
+		}
+	}
+
+	stat, err := os.Stat(f.AbsPath())
+	if err != nil {
+		// Let the scrub find out why the file cannot be read
+		return false
+	}
+
+	return stat.ModTime().After(hashedAt)
+}
+
+// RefetchScrubbed copies the files a scrub found to be corrupted or unreadable on a backup
+// server again from the core, over the top of the bad copy.
+func RefetchScrubbed(t *task.Task) {
+	meta := t.GetMeta().(models.RefetchScrubbedMeta)
+
+	pool := t.GetTaskPool().GetWorkerPool().NewTaskPool(true, t)
+	t.SetChildTaskPool(pool)
+
+	var skipped int
+	for _, scrubbed := range slices.Concat(meta.Report.Corrupted, meta.Report.Unreadable) {
+		// Files in a backup tree have the id of their lifetime on the core, so it doubles as the core file id
+		f, err := meta.FileService.GetFileByTree(scrubbed.FileId, meta.Core.ServerId())
+		if errors.Is(err, werror.ErrNoFile) {
+			skipped++
+			continue
+		}
+		t.ReqNoErr(err)
+
+		copyFileMeta := models.BackupCoreFileMeta{
+			FileService: meta.FileService,
+			File:        f,
+			Caster:      meta.Caster,
+			Core:        meta.Core,
+			CoreFileId:  f.ID(),
+			Filename:    f.Filename(),
+		}
+		// If the copy fails, the bad file is deleted, and the next backup will copy it from the core again
+		_, err = meta.TaskService.DispatchJob(models.CopyFileFromCoreTask, copyFileMeta, pool)
+		t.ReqNoErr(err)
+	}
+
+	pool.SignalAllQueued()
+	pool.Wait(true)
+
+	failed := len(pool.Errors())
+	t.SetResult(
+		task.TaskResult{
+			"reportId":       meta.Report.Id,
+			"filesRefetched": int(pool.Status().Total) - failed,
+			"filesFailed":    failed,
+			"filesSkipped":   skipped,
+		},
+	)
+
+	if failed != 0 {
+		t.Fail(werror.Errorf("%d of %d refetched files have failed", failed, pool.Status().Total))
+	}
+
+	t.Success()
+}
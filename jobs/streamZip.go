@@ -0,0 +1,153 @@
+package jobs
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/klauspost/compress/flate"
+)
+
+// compressedExtensions are files that are already compressed, so deflating them again would take a long time
+// and save almost nothing. These are stored in the zip as they are.
+var compressedExtensions = map[string]struct{}{
+	".jpg": {}, ".jpeg": {}, ".png": {}, ".gif": {}, ".webp": {}, ".heic": {}, ".heif": {}, ".avif": {},
+	".mp4": {}, ".mov": {}, ".m4v": {}, ".mkv": {}, ".webm": {}, ".avi": {},
+	".mp3": {}, ".m4a": {}, ".aac": {}, ".ogg": {}, ".opus": {}, ".flac": {},
+	".zip": {}, ".gz": {}, ".tgz": {}, ".zst": {}, ".tzst": {}, ".xz": {}, ".bz2": {}, ".7z": {}, ".rar": {},
+	".pdf": {}, ".docx": {}, ".xlsx": {}, ".pptx": {},
+}
+
+// StreamZip writes a zip of zipMeta.Files into w as it walks them, so nothing is staged on disk, and the client can
+// start downloading right away. Files that are already compressed are stored, and everything else is deflated.
+//
+// The size of the zip is not known until it is done, so unlike a cached takeout, a streamed one cannot be resumed.
+func StreamZip(w io.Writer, zipMeta models.ZipMeta) error {
+	if len(zipMeta.Files) == 0 {
+		return werror.WithStack(werror.ErrEmptyZip)
+	}
+
+	entries, _, _, err := gatherTakeoutEntries(zipMeta)
+	if err != nil {
+		return err
+	}
+
+	zipWriter := zip.NewWriter(w)
+	zipWriter.RegisterCompressor(
+		zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, flate.BestSpeed)
+		},
+	)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{
+			Name:     entry.zipPath,
+			Method:   zipMethod(entry.zipPath),
+			Modified: entry.file.ModTime(),
+		}
+
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return werror.WithStack(err)
+		}
+
+		if entry.file.IsDir() {
+			continue
+		}
+
+		if !zipMeta.Timestamp.IsZero() {
+			// Empty files have no content to copy
+			if entry.file.GetContentId() != "" {
+				_, err = copyPastContent(zipMeta.FileService, entry.file, writer)
+			}
+		} else {
+			err = copyFileContent(entry.file, writer)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return werror.WithStack(zipWriter.Close())
+}
+
+// gatherTakeoutEntries finds every file and folder that goes in the takeout, whether it is of the files as they
+// are now, or as they were at zipMeta.Timestamp
+func gatherTakeoutEntries(zipMeta models.ZipMeta) (entries []zipEntry, bytesTotal int64, fileCount int, err error) {
+	if !zipMeta.Timestamp.IsZero() {
+		return gatherPastZipEntries(zipMeta)
+	}
+
+	entries, err = gatherZipEntries(zipMeta.Files)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	for _, entry := range entries {
+		if !entry.file.IsDir() {
+			bytesTotal += entry.file.Size()
+			fileCount++
+		}
+	}
+
+	return entries, bytesTotal, fileCount, nil
+}
+
+// gatherZipEntries finds every file and folder under files, along with where each goes in the zip
+func gatherZipEntries(files []*fileTree.WeblensFileImpl) ([]zipEntry, error) {
+	var entries []zipEntry
+	for _, root := range files {
+		rootParent := filepath.Dir(strings.TrimSuffix(root.AbsPath(), "/"))
+
+		err := root.RecursiveMap(
+			func(f *fileTree.WeblensFileImpl) error {
+				zipPath, err := filepath.Rel(rootParent, f.AbsPath())
+				if err != nil {
+					return werror.WithStack(err)
+				}
+
+				zipPath = filepath.ToSlash(zipPath)
+				if f.IsDir() {
+					zipPath += "/"
+				}
+				entries = append(entries, zipEntry{file: f, zipPath: zipPath})
+
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+func zipMethod(zipPath string) uint16 {
+	if strings.HasSuffix(zipPath, "/") {
+		return zip.Store
+	}
+
+	if _, ok := compressedExtensions[strings.ToLower(path.Ext(zipPath))]; ok {
+		return zip.Store
+	}
+
+	return zip.Deflate
+}
+
+func copyFileContent(f *fileTree.WeblensFileImpl, w io.Writer) error {
+	content, err := os.Open(f.AbsPath())
+	if err != nil {
+		return werror.WithStack(err)
+	}
+	defer content.Close()
+
+	_, err = io.Copy(w, content)
+	return werror.WithStack(err)
+}
@@ -0,0 +1,132 @@
+package jobs_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/archive"
+	. "github.com/ethanrous/weblens/jobs"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamZip(t *testing.T) {
+	t.Parallel()
+
+	rootPath := t.TempDir() + "/"
+	content := map[string]string{
+		"photos/cat.jpg":        "not really a jpg",
+		"photos/notes.txt":      "hello world",
+		"photos/deep/notes.txt": "deeper",
+	}
+	for name, data := range content {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(rootPath, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(rootPath, name), []byte(data), 0644))
+	}
+
+	tree, err := fileTree.NewFileTree(rootPath, "USERS", mock.NewHollowJournalService(), true)
+	require.NoError(t, err)
+
+	photos, err := tree.GetRoot().GetChild("photos")
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = StreamZip(buf, models.ZipMeta{Files: []*fileTree.WeblensFileImpl{photos}})
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	found := map[string]string{}
+	for _, f := range zr.File {
+		if f.Mode().IsDir() {
+			continue
+		}
+
+		// Files that are already compressed are stored, everything else is deflated
+		if filepath.Ext(f.Name) == ".jpg" {
+			assert.Equal(t, zip.Store, f.Method)
+		} else {
+			assert.Equal(t, zip.Deflate, f.Method)
+		}
+
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		found[f.Name] = string(data)
+	}
+	assert.Equal(t, content, found)
+
+	err = StreamZip(io.Discard, models.ZipMeta{})
+	assert.Error(t, err)
+}
+
+func TestStreamTarball(t *testing.T) {
+	t.Parallel()
+
+	rootPath := t.TempDir() + "/"
+	modTime := time.Date(2021, 6, 1, 12, 30, 15, 250_000_000, time.UTC)
+	content := map[string]string{
+		"ünïcödé/日本語.txt": "hello world",
+		"ünïcödé/a/b.txt": "deeper",
+	}
+	for name, data := range content {
+		filePath := filepath.Join(rootPath, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		require.NoError(t, os.WriteFile(filePath, []byte(data), 0644))
+		require.NoError(t, os.Chtimes(filePath, modTime, modTime))
+	}
+
+	tree, err := fileTree.NewFileTree(rootPath, "USERS", mock.NewHollowJournalService(), true)
+	require.NoError(t, err)
+
+	folder, err := tree.GetRoot().GetChild("ünïcödé")
+	require.NoError(t, err)
+
+	for _, format := range []archive.Format{archive.Tar, archive.TarGz, archive.TarZst} {
+		archivePath := filepath.Join(t.TempDir(), "takeout"+format.Extension())
+		fp, err := os.Create(archivePath)
+		require.NoError(t, err)
+
+		err = StreamTarball(fp, models.ZipMeta{Files: []*fileTree.WeblensFileImpl{folder}, Format: format})
+		require.NoError(t, err)
+		require.NoError(t, fp.Close())
+
+		found := map[string]string{}
+		err = archive.Walk(
+			archivePath, format, func(entry archive.Entry, r io.Reader) error {
+				if entry.IsDir {
+					return nil
+				}
+
+				data, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				found[entry.Path] = string(data)
+
+				if !entry.ModTime.Equal(modTime) {
+					return errors.New("mod time of " + entry.Path + " was not kept")
+				}
+
+				return nil
+			},
+		)
+		require.NoError(t, err, format)
+		assert.Equal(t, content, found, format)
+	}
+
+	err = StreamTarball(io.Discard, models.ZipMeta{Files: []*fileTree.WeblensFileImpl{folder}, Format: archive.Zip})
+	assert.Error(t, err)
+}
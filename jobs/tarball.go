@@ -0,0 +1,231 @@
+package jobs
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/archive"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service"
+	"github.com/ethanrous/weblens/task"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// createTarball builds a tar, tar.gz or tar.zst takeout in the takeout cache, the same as CreateZip does for zips
+func createTarball(t *task.Task, zipMeta models.ZipMeta) {
+	entries, bytesTotal, fileCount, err := gatherTakeoutEntries(zipMeta)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	tarName := takeoutKey(zipMeta) + zipMeta.Format.Extension()
+
+	takeoutDir, err := zipMeta.FileService.GetFileTreeByName(service.CachesTreeKey).GetRoot().GetChild("takeout")
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	if tarFile, err := takeoutDir.GetChild(tarName); err == nil {
+		t.SetResult(task.TaskResult{"takeoutId": tarFile.ID(), "filename": tarFile.Filename()})
+		zipMeta.Caster.PushTaskUpdate(t, models.ZipCompleteEvent, t.GetResults())
+		t.Success()
+		return
+	}
+
+	zipMeta.Caster.PushTaskUpdate(t, models.TaskCreatedEvent, task.TaskResult{"totalFiles": fileCount})
+
+	// Like a past zip, the tarball only gets its name once it is complete, so one that is still being written, or
+	// that failed part way through, is never found and handed out by a later takeout of the same files
+	fp, err := os.CreateTemp(takeoutDir.AbsPath(), "."+tarName+"-*.partial")
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+	t.SetErrorCleanup(
+		func(*task.Task) {
+			_ = fp.Close()
+			err := os.Remove(fp.Name())
+			if err != nil && !os.IsNotExist(err) {
+				log.ErrTrace(werror.WithStack(err))
+			}
+		},
+	)
+
+	const updateInterval = 500 * time.Millisecond
+
+	lastUpdate := time.Now()
+	lastBytes := int64(0)
+	onProgress := func(completedFiles int, bytesSoFar int64) {
+		since := time.Since(lastUpdate)
+		if since < updateInterval {
+			return
+		}
+
+		zipMeta.Caster.PushTaskUpdate(
+			t, models.ZipProgressEvent, task.TaskResult{
+				"completedFiles": completedFiles, "totalFiles": fileCount,
+				"bytesSoFar": bytesSoFar,
+				"bytesTotal": bytesTotal,
+				"speedBytes": int(float64(bytesSoFar-lastBytes) / since.Seconds()),
+			},
+		)
+		lastUpdate = time.Now()
+		lastBytes = bytesSoFar
+	}
+
+	err = writeTarball(fp, zipMeta, entries, onProgress)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	err = fp.Close()
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	tarFile, err := finishTakeout(zipMeta, fp.Name(), tarName)
+	if err != nil {
+		t.ReqNoErr(err)
+	}
+
+	t.SetResult(task.TaskResult{"takeoutId": tarFile.ID(), "filename": tarFile.Filename()})
+	zipMeta.Caster.PushTaskUpdate(
+		t, models.ZipCompleteEvent, t.GetResults(),
+	) // Let any client subscribers know we are done
+	t.Success()
+}
+
+// StreamTarball writes a tar, tar.gz or tar.zst of zipMeta.Files into w as it walks them, like StreamZip does for zips
+func StreamTarball(w io.Writer, zipMeta models.ZipMeta) error {
+	if len(zipMeta.Files) == 0 {
+		return werror.WithStack(werror.ErrEmptyZip)
+	}
+
+	entries, _, _, err := gatherTakeoutEntries(zipMeta)
+	if err != nil {
+		return err
+	}
+
+	return writeTarball(w, zipMeta, entries, nil)
+}
+
+// writeTarball writes the entries into w as a tar, compressing it if the format calls for it. Headers are written in
+// the PAX format, which keeps mod times to the nanosecond, and has no limits on the length or characters of names,
+// or on the size of files. onProgress, if given, is called after each file is written.
+func writeTarball(
+	w io.Writer, zipMeta models.ZipMeta, entries []zipEntry, onProgress func(completedFiles int, bytesSoFar int64),
+) error {
+	var compressor io.WriteCloser
+	switch zipMeta.Format {
+	case archive.Tar:
+	case archive.TarGz:
+		compressor = gzip.NewWriter(w)
+	case archive.TarZst:
+		zst, err := zstd.NewWriter(w)
+		if err != nil {
+			return werror.WithStack(err)
+		}
+		compressor = zst
+	default:
+		return werror.WithStack(werror.ErrBadArchiveFormat.WithArg(zipMeta.Format))
+	}
+
+	tarWriter := tar.NewWriter(w)
+	if compressor != nil {
+		tarWriter = tar.NewWriter(compressor)
+	}
+
+	var completedFiles int
+	var bytesSoFar int64
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.zipPath,
+			ModTime: entry.file.ModTime(),
+			Format:  tar.FormatPAX,
+		}
+
+		if entry.file.IsDir() {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+
+			err := tarWriter.WriteHeader(header)
+			if err != nil {
+				return werror.WithStack(err)
+			}
+			continue
+		}
+
+		written, err := writeTarFile(tarWriter, header, zipMeta, entry.file)
+		if err != nil {
+			return err
+		}
+
+		completedFiles++
+		bytesSoFar += written
+		if onProgress != nil {
+			onProgress(completedFiles, bytesSoFar)
+		}
+	}
+
+	err := tarWriter.Close()
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	if compressor != nil {
+		return werror.WithStack(compressor.Close())
+	}
+
+	return nil
+}
+
+// writeTarFile writes the header and content of f into the tar. A tar header has to have the size of the file
+// before its content, so the size is taken from the file that is about to be read, not from the tree.
+func writeTarFile(tarWriter *tar.Writer, header *tar.Header, zipMeta models.ZipMeta, f *fileTree.WeblensFileImpl) (int64, error) {
+	header.Typeflag = tar.TypeReg
+	header.Mode = 0644
+
+	contentPath := f.AbsPath()
+	if !zipMeta.Timestamp.IsZero() {
+		// Empty files have no content to copy
+		if f.GetContentId() == "" {
+			return 0, werror.WithStack(tarWriter.WriteHeader(header))
+		}
+
+		contentFile, err := zipMeta.FileService.GetFileByContentId(f.GetContentId())
+		if err != nil {
+			return 0, err
+		}
+		contentPath = contentFile.AbsPath()
+	}
+
+	content, err := os.Open(contentPath)
+	if err != nil {
+		return 0, werror.WithStack(err)
+	}
+	defer content.Close()
+
+	stat, err := content.Stat()
+	if err != nil {
+		return 0, werror.WithStack(err)
+	}
+	header.Size = stat.Size()
+
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return 0, werror.WithStack(err)
+	}
+
+	// If the file grows while it is being read, only what fits in the size given in the header is written
+	written, err := io.CopyN(tarWriter, content, header.Size)
+	if err != nil {
+		return written, werror.WithStack(err)
+	}
+
+	return written, nil
+}
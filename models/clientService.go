@@ -130,7 +130,11 @@ const (
 	CopyFileCompleteEvent        = "copyFileComplete"
 	CopyFileFailedEvent          = "copyFileFailed"
 	CopyFileStartedEvent         = "copyFileStarted"
+	CopyFilesCompleteEvent       = "copyFilesComplete"
+	CopyFilesProgressEvent       = "copyFilesProgress"
 	ErrorEvent                   = "error"
+	ExtractArchiveCompleteEvent  = "extractArchiveComplete"
+	ExtractArchiveProgressEvent  = "extractArchiveProgress"
 	FileCreatedEvent             = "fileCreated"
 	FileDeletedEvent             = "fileDeleted"
 	FileMovedEvent               = "fileMoved"
@@ -149,6 +153,8 @@ const (
 	RestoreProgressEvent         = "restoreProgress"
 	RestoreStartedEvent          = "restoreStarted"
 	ScanDirectoryProgressEvent   = "scanDirectoryProgress"
+	ScrubCompleteEvent           = "scrubComplete"
+	ScrubProgressEvent           = "scrubProgress"
 	ServerGoingDownEvent         = "goingDown"
 	ShareUpdatedEvent            = "shareUpdated"
 	StartupProgressEvent         = "startupProgress"
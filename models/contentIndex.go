@@ -0,0 +1,80 @@
+package models
+
+import (
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+)
+
+// ContentMatch is a file whose text matched a content search, along with the parts of the text that matched
+type ContentMatch struct {
+	FileId   fileTree.FileId
+	Score    int
+	Snippets []TextSnippet
+}
+
+// TextSnippet is a short excerpt of the text of a file. Highlights are the ranges of the snippet that
+// matched the search, counted in characters (not bytes) from the start of the snippet.
+type TextSnippet struct {
+	Text       string      `json:"text"`
+	Highlights []TextRange `json:"highlights"`
+} // @name TextSnippet
+
+type TextRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+} // @name TextRange
+
+type ContentIndexService interface {
+	// IsIndexable reports if we know how to read the text of the file
+	IsIndexable(f *fileTree.WeblensFileImpl) bool
+
+	// IsIndexed reports if the file is in the index, with the content it has now
+	IsIndexed(f *fileTree.WeblensFileImpl) bool
+
+	// Index reads the text of the file and adds it to the index, replacing what was there for the file before
+	Index(f *fileTree.WeblensFileImpl) error
+	Remove(fileIds ...fileTree.FileId) error
+
+	// Search finds the files that contain every word in the query, and are allowed by the filter,
+	// ordered by how often the words show up. At most limit matches are returned.
+	Search(query string, filter func(fileId fileTree.FileId) bool, limit int) ([]ContentMatch, error)
+}
+
+// NewContentIndexEventHandler keeps the content index up to date with the changes logged to a journal
+func NewContentIndexEventHandler(pack *ServicePack) fileTree.FileEventHandler {
+	return func(event *fileTree.FileEvent) {
+		if pack.ContentIndexService == nil || pack.TaskService == nil {
+			return
+		}
+
+		var removed []fileTree.FileId
+		for _, action := range event.GetActions() {
+			switch action.GetActionType() {
+			case fileTree.FileDelete:
+				removed = append(removed, action.GetLifetimeId())
+			case fileTree.FileCreate, fileTree.FileRestore, fileTree.FileCopy, fileTree.FileModify, fileTree.FileSizeChange:
+				f := action.GetFile()
+				if f == nil || f.IsDir() || !pack.ContentIndexService.IsIndexable(f) {
+					continue
+				}
+
+				meta := IndexContentMeta{
+					FileService:  pack.FileService,
+					ContentIndex: pack.ContentIndexService,
+					File:         f,
+				}
+				_, err := pack.TaskService.DispatchJob(IndexContentTask, meta, nil)
+				if err != nil {
+					log.ErrTrace(err)
+				}
+			}
+		}
+
+		if len(removed) != 0 {
+			err := pack.ContentIndexService.Remove(removed...)
+			if err != nil {
+				log.ErrTrace(err)
+			}
+		}
+	}
+}
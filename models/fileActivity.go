@@ -0,0 +1,126 @@
+package models
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+)
+
+type FileActivityKind string
+
+const (
+	FileStarred    FileActivityKind = "star"
+	FileDownloaded FileActivityKind = "download"
+)
+
+// FileActivity is something a user did with a file that the journal does not keep track of. There is at most
+// one of each kind for a user and file, holding the last time it happened.
+type FileActivity struct {
+	Username  Username         `bson:"username"`
+	FileId    fileTree.FileId  `bson:"fileId"`
+	Kind      FileActivityKind `bson:"kind"`
+	Timestamp time.Time        `bson:"timestamp"`
+}
+
+type FileActivityService interface {
+	Star(username Username, fileId fileTree.FileId) error
+	Unstar(username Username, fileId fileTree.FileId) error
+	RecordDownload(username Username, fileId fileTree.FileId) error
+
+	// GetActivity returns the activity of the kind for the user, newest first
+	GetActivity(username Username, kind FileActivityKind) ([]FileActivity, error)
+
+	// RemoveFiles forgets all activity on the files, for every user
+	RemoveFiles(fileIds ...fileTree.FileId) error
+}
+
+// RecentFileActions are the journal actions that count as a user touching a file
+var RecentFileActions = []fileTree.FileActionType{
+	fileTree.FileCreate, fileTree.FileMove, fileTree.FileModify, fileTree.FileRestore, fileTree.FileCopy,
+}
+
+// RecentFile is a file in the recent files feed, with the last thing that happened to it
+type RecentFile struct {
+	File      *fileTree.WeblensFileImpl
+	Action    string
+	Timestamp time.Time
+}
+
+// RecentFiles merges journal actions and downloads into one feed, newest first, with only the latest entry for
+// each file. Actions are by lifetime id, which is also the id of the file. getFile returns the file with the id,
+// or nil if it should not be in the feed, such as when it has been deleted or the user cannot access it.
+func RecentFiles(
+	actions []*fileTree.FileAction, downloads []FileActivity, getFile func(id fileTree.FileId) *fileTree.WeblensFileImpl,
+	limit int,
+) []RecentFile {
+	type entry struct {
+		id        fileTree.FileId
+		action    string
+		timestamp time.Time
+	}
+
+	latest := map[fileTree.FileId]entry{}
+	add := func(id fileTree.FileId, action string, at time.Time) {
+		if existing, ok := latest[id]; ok && !existing.timestamp.Before(at) {
+			return
+		}
+		latest[id] = entry{id: id, action: action, timestamp: at}
+	}
+
+	for _, action := range actions {
+		add(action.GetLifetimeId(), action.GetActionType(), action.GetTimestamp())
+	}
+	for _, download := range downloads {
+		add(download.FileId, string(FileDownloaded), download.Timestamp)
+	}
+
+	entries := slices.Collect(maps.Values(latest))
+	slices.SortFunc(
+		entries, func(a, b entry) int {
+			if c := b.timestamp.Compare(a.timestamp); c != 0 {
+				return c
+			}
+			return strings.Compare(a.id, b.id)
+		},
+	)
+
+	// Files are only looked up until the feed is full, as checking access to each one is not free
+	var recent []RecentFile
+	for _, e := range entries {
+		if limit > 0 && len(recent) == limit {
+			break
+		}
+		if f := getFile(e.id); f != nil {
+			recent = append(recent, RecentFile{File: f, Action: e.action, Timestamp: e.timestamp})
+		}
+	}
+
+	return recent
+}
+
+// NewFileActivityEventHandler forgets stars and downloads of files when they are deleted
+func NewFileActivityEventHandler(pack *ServicePack) fileTree.FileEventHandler {
+	return func(event *fileTree.FileEvent) {
+		if pack.FileActivityService == nil {
+			return
+		}
+
+		var removed []fileTree.FileId
+		for _, action := range event.GetActions() {
+			if action.GetActionType() == fileTree.FileDelete {
+				removed = append(removed, action.GetLifetimeId())
+			}
+		}
+
+		if len(removed) != 0 {
+			err := pack.FileActivityService.RemoveFiles(removed...)
+			if err != nil {
+				log.ErrTrace(err)
+			}
+		}
+	}
+}
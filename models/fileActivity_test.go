@@ -0,0 +1,54 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	. "github.com/ethanrous/weblens/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentFiles(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+	root := fileTree.NewWeblensFile("home", "alice", nil, true)
+	files := map[fileTree.FileId]*fileTree.WeblensFileImpl{
+		"notes":  fileTree.NewWeblensFile("notes", "notes.txt", root, false),
+		"photo":  fileTree.NewWeblensFile("photo", "photo.jpg", root, false),
+		"report": fileTree.NewWeblensFile("report", "report.pdf", root, false),
+		"shared": fileTree.NewWeblensFile("shared", "shared.mov", root, false),
+	}
+	getFile := func(id fileTree.FileId) *fileTree.WeblensFileImpl {
+		return files[id]
+	}
+
+	actions := []*fileTree.FileAction{
+		{LifeId: "notes", ActionType: fileTree.FileCreate, Timestamp: now.Add(-time.Hour)},
+		{LifeId: "photo", ActionType: fileTree.FileMove, Timestamp: now.Add(-2 * time.Hour)},
+		{LifeId: "report", ActionType: fileTree.FileModify, Timestamp: now.Add(-3 * time.Hour)},
+		{LifeId: "deleted", ActionType: fileTree.FileCreate, Timestamp: now},
+	}
+	downloads := []FileActivity{
+		{FileId: "report", Kind: FileDownloaded, Timestamp: now.Add(-time.Minute)},
+		{FileId: "notes", Kind: FileDownloaded, Timestamp: now.Add(-5 * time.Hour)},
+		{FileId: "shared", Kind: FileDownloaded, Timestamp: now.Add(-4 * time.Hour)},
+	}
+
+	recent := RecentFiles(actions, downloads, getFile, 0)
+
+	var ids []fileTree.FileId
+	var kinds []string
+	for _, rf := range recent {
+		ids = append(ids, rf.File.ID())
+		kinds = append(kinds, rf.Action)
+	}
+
+	// Each file shows up once, for whatever happened to it last, and files that are gone are left out
+	assert.Equal(t, []fileTree.FileId{"report", "notes", "photo", "shared"}, ids)
+	assert.Equal(t, []string{string(FileDownloaded), fileTree.FileCreate, fileTree.FileMove, string(FileDownloaded)}, kinds)
+	assert.Equal(t, now.Add(-time.Minute), recent[0].Timestamp)
+
+	assert.Len(t, RecentFiles(actions, downloads, getFile, 2), 2)
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/ethanrous/weblens/fileTree"
+)
+
+type FileOperationType string
+
+const (
+	// FileOpMove moves FileIds into ParentId
+	FileOpMove FileOperationType = "move"
+
+	// FileOpRename gives the one file in FileIds the name Name
+	FileOpRename FileOperationType = "rename"
+
+	// FileOpTrash moves FileIds to the trash of their owner
+	FileOpTrash FileOperationType = "trash"
+
+	// FileOpMkdir creates a folder called Name in ParentId
+	FileOpMkdir FileOperationType = "mkdir"
+)
+
+// FileOperation is one step of a batch of file changes. Where a parent is needed, ParentId can also be "$n",
+// the folder created by the mkdir operation at index n earlier in the same batch.
+type FileOperation struct {
+	Type     FileOperationType `json:"type"`
+	FileIds  []fileTree.FileId `json:"fileIds,omitempty"`
+	ParentId fileTree.FileId   `json:"parentId,omitempty"`
+	Name     string            `json:"name,omitempty"`
+} // @name FileOperation
+
+// BatchRef is how a later operation in a batch refers to the folder made by the mkdir operation at opIndex
+func BatchRef(opIndex int) fileTree.FileId {
+	return fmt.Sprintf("$%d", opIndex)
+}
+
+// FileBatchError is returned when an operation in a batch fails, after every change that came before it has been undone
+type FileBatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *FileBatchError) Error() string {
+	return fmt.Sprintf("file operation %d failed: %s", e.Index, e.Err)
+}
+
+func (e *FileBatchError) Unwrap() error {
+	return e.Err
+}
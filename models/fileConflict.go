@@ -0,0 +1,75 @@
+package models
+
+import (
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+)
+
+// ConflictPolicy is what to do when a file is moved, copied, restored or uploaded into a folder that already
+// has a file with the same name
+type ConflictPolicy string
+
+const (
+	// ConflictFail changes nothing if any of the files have a conflict
+	ConflictFail ConflictPolicy = "fail"
+
+	// ConflictOverwrite deletes the existing file to make room for the new one. The deleted
+	// file can still be restored from the history.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+
+	// ConflictKeepBoth gives the new file a unique name, like "photo.jpg (1)"
+	ConflictKeepBoth ConflictPolicy = "keepBoth"
+
+	// ConflictSkip leaves the existing file alone, and does nothing with the new one
+	ConflictSkip ConflictPolicy = "skip"
+
+	// ConflictMerge combines a folder with the existing folder of the same name. Files inside of it that
+	// conflict, and conflicts that are not between two folders, are handled as ConflictKeepBoth.
+	ConflictMerge ConflictPolicy = "merge"
+)
+
+// ParseConflictPolicy checks that policy is one we know, and gives defaultPolicy if it is empty
+func ParseConflictPolicy(policy string, defaultPolicy ConflictPolicy) (ConflictPolicy, error) {
+	switch ConflictPolicy(policy) {
+	case "":
+		return defaultPolicy, nil
+	case ConflictFail, ConflictOverwrite, ConflictKeepBoth, ConflictSkip, ConflictMerge:
+		return ConflictPolicy(policy), nil
+	}
+
+	return "", werror.WithStack(werror.ErrBadConflictPolicy.WithArg(policy))
+}
+
+// FileConflict is a file that had the same name as one already in the folder it was going into,
+// and what was done about it
+type FileConflict struct {
+	// FileId is the file that was being moved, copied, restored or uploaded. It is empty for uploads that
+	// were never created.
+	FileId     fileTree.FileId `json:"fileId"`
+	ExistingId fileTree.FileId `json:"existingId"`
+	Filename   string          `json:"filename"`
+	Resolution ConflictPolicy  `json:"resolution"`
+
+	// NewName is the name the file was given instead, if it was kept alongside the existing one
+	NewName string `json:"newName,omitempty"`
+} // @name FileConflict
+
+// FindConflicts finds the files that have the same name as a file already in destFolder, other than themselves
+func FindConflicts(files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl) []FileConflict {
+	var conflicts []FileConflict
+	for _, file := range files {
+		existing, _ := destFolder.GetChild(file.Filename())
+		if existing != nil && existing != file {
+			conflicts = append(
+				conflicts, FileConflict{
+					FileId:     file.ID(),
+					ExistingId: existing.ID(),
+					Filename:   file.Filename(),
+					Resolution: ConflictFail,
+				},
+			)
+		}
+	}
+
+	return conflicts
+}
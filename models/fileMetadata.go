@@ -0,0 +1,68 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+)
+
+// FileMetadata is what users have attached to a file or folder. It is kept by the file id, which is the id of
+// the lifetime of the file in the journal, so it stays with the file when it is moved, renamed or trashed.
+type FileMetadata struct {
+	FileId     fileTree.FileId   `bson:"_id" json:"-"`
+	Tags       []string          `bson:"tags,omitempty" json:"tags"`
+	Properties map[string]string `bson:"properties,omitempty" json:"properties"`
+}
+
+// FileMetadataUpdate is a change to the metadata of a file. Tags are matched without case, so adding
+// a tag the file already has in another case does nothing, and removing it removes it in any case.
+type FileMetadataUpdate struct {
+	AddTags          []string
+	RemoveTags       []string
+	SetProperties    map[string]string
+	RemoveProperties []string
+}
+
+type FileMetadataService interface {
+	// Get returns the metadata of the file, which is empty if nothing has been attached to it
+	Get(fileId fileTree.FileId) FileMetadata
+
+	// Update applies the changes to the metadata of the file, and returns what it is after. Removals
+	// are applied before additions.
+	Update(fileId fileTree.FileId, update FileMetadataUpdate) (FileMetadata, error)
+	Remove(fileIds ...fileTree.FileId) error
+}
+
+// HasTag reports if the metadata has the tag, ignoring case
+func (m FileMetadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewFileMetadataEventHandler forgets the metadata of files when they are deleted
+func NewFileMetadataEventHandler(pack *ServicePack) fileTree.FileEventHandler {
+	return func(event *fileTree.FileEvent) {
+		if pack.FileMetadataService == nil {
+			return
+		}
+
+		var removed []fileTree.FileId
+		for _, action := range event.GetActions() {
+			if action.GetActionType() == fileTree.FileDelete {
+				removed = append(removed, action.GetLifetimeId())
+			}
+		}
+
+		if len(removed) != 0 {
+			err := pack.FileMetadataService.Remove(removed...)
+			if err != nil {
+				log.ErrTrace(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,545 @@
+package models
+
+import (
+	"cmp"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/lithammer/fuzzysearch/fuzzy"
+)
+
+// FileQuery is a parsed file search, like `report ext:pdf,docx size:>2M modified:2024 in:/Projects -is:dir`.
+//
+// Each term is either a word, which is matched against filenames, or a `key:value` filter. Any filter, or a
+// word, can be negated with a leading "-", and values with spaces can be wrapped in double quotes. Filters:
+//
+//	ext:mov,mp4          the file extension is one of these
+//	name:"some text"     the filename contains the text
+//	size:>2G             the size compares to this, in bytes or with a K, M, G or T suffix. Also ranges, like 1M..5M
+//	modified:2024        the file was last modified in this year, month (2024-03) or day (2024-03-15).
+//	                     These can be compared (>2024-03, <=2024) or be ranges (2024-01..2024-06), and can also be
+//	                     relative to now, so 30d is in the last 30 days, and >1y is more than a year ago.
+//	created:2024         the same as modified, but for when the file was first created, from its history
+//	owner:alice          the file is owned by this user
+//	is:dir               the file is a dir (or folder), a file, or media (or image, or video)
+//	type:video           the media type of the file is image, video, raw, or has this mime type or name
+//	tag:trips,2024       the file has one of these tags
+//	prop:camera=X100     the file has this property, with this value if one is given
+//	in:/Projects         only look in this folder, relative to the users home
+type FileQuery struct {
+	// Words are the parts of the query that are not filters, and are fuzzy matched against filenames
+	Words []string
+
+	// In is the folder to search in, as it was given, or empty to search the default folder
+	In string
+
+	filters []fileQueryFilter
+}
+
+// FileQueryContext is how a query finds out about a file beyond what the file itself knows
+type FileQueryContext struct {
+	Owner     func(f *fileTree.WeblensFileImpl) Username
+	MediaType func(f *fileTree.WeblensFileImpl) MediaType
+	Created   func(f *fileTree.WeblensFileImpl) time.Time
+	Metadata  func(f *fileTree.WeblensFileImpl) FileMetadata
+}
+
+type fileQueryFilter struct {
+	negate bool
+	match  func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool
+}
+
+type FileSortBy string
+
+const (
+	FileSortRelevance FileSortBy = ""
+	FileSortName      FileSortBy = "name"
+	FileSortSize      FileSortBy = "size"
+	FileSortModified  FileSortBy = "modified"
+	FileSortCreated   FileSortBy = "created"
+)
+
+var sizeUnits = map[string]float64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10, "kb": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40,
+}
+
+// ParseFileQuery parses a query in the grammar described on FileQuery. Relative dates are relative to now.
+func ParseFileQuery(query string, now time.Time) (*FileQuery, error) {
+	terms, err := splitQueryTerms(query)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &FileQuery{}
+	for _, term := range terms {
+		negate := false
+		body := term
+		if len(body) > 1 && body[0] == '-' {
+			negate = true
+			body = body[1:]
+		}
+
+		key, value, isFilter := strings.Cut(body, ":")
+		if !isFilter || strings.HasPrefix(body, `"`) {
+			word := strings.Trim(body, `"`)
+			if negate {
+				q.filters = append(q.filters, fileQueryFilter{negate: true, match: nameContains(word)})
+			} else {
+				q.Words = append(q.Words, word)
+			}
+			continue
+		}
+
+		value = strings.Trim(value, `"`)
+		if value == "" {
+			return nil, werror.WithStack(werror.ErrBadSearchTerm(term))
+		}
+
+		var match func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool
+		switch strings.ToLower(key) {
+		case "in":
+			if negate || q.In != "" {
+				return nil, werror.WithStack(werror.ErrBadSearchTerm(term))
+			}
+			q.In = value
+			continue
+		case "ext":
+			match = extensionIn(strings.Split(strings.ToLower(value), ","))
+		case "name":
+			match = nameContains(value)
+		case "size":
+			match, err = sizeMatcher(value)
+		case "modified":
+			match, err = timeMatcher(value, now, func(f *fileTree.WeblensFileImpl, _ FileQueryContext) time.Time {
+				return f.ModTime()
+			})
+		case "created":
+			match, err = timeMatcher(value, now, func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) time.Time {
+				return ctx.Created(f)
+			})
+		case "owner":
+			match = func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+				return ctx.Owner(f) == value
+			}
+		case "is":
+			match, err = isMatcher(strings.ToLower(value))
+		case "type":
+			match = typeMatcher(strings.ToLower(value))
+		case "tag":
+			match = tagMatcher(strings.Split(value, ","))
+		case "prop":
+			match = propertyMatcher(value)
+		default:
+			err = werror.ErrBadSearchTerm(term)
+		}
+		if err != nil {
+			return nil, werror.WithStack(werror.ErrBadSearchTerm(term))
+		}
+
+		q.filters = append(q.filters, fileQueryFilter{negate: negate, match: match})
+	}
+
+	return q, nil
+}
+
+// Matches reports if the file passes every filter in the query. Words are not checked here, as they are ranked
+// against all the filenames at once in SearchFiles.
+func (q *FileQuery) Matches(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+	for _, filter := range q.filters {
+		if filter.match(f, ctx) == filter.negate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SearchCandidates finds the files under base that a search for words could match. The name index of the tree only
+// finds names that contain each of the words, so when it finds nothing, every file under base is returned instead,
+// letting SearchFiles still find fuzzy matches, like "hldy" for "holiday.mov". Searches with no words have nothing
+// to narrow the files down by, so those always get every file under base.
+func SearchCandidates(tree fileTree.FileTree, base *fileTree.WeblensFileImpl, words []string) []*fileTree.WeblensFileImpl {
+	if tree != nil && len(words) != 0 {
+		if candidates := tree.SearchFilenames(strings.Join(words, " "), base); len(candidates) != 0 {
+			return candidates
+		}
+	}
+
+	var candidates []*fileTree.WeblensFileImpl
+	_ = base.RecursiveMap(
+		func(f *fileTree.WeblensFileImpl) error {
+			if f != base {
+				candidates = append(candidates, f)
+			}
+			return nil
+		},
+	)
+
+	return candidates
+}
+
+// SearchFiles returns the candidates that match the query, sorted by sortBy. Sorting by relevance
+// puts the best filename matches first, or sorts by name if the query has no words.
+func SearchFiles(
+	candidates []*fileTree.WeblensFileImpl, q *FileQuery, ctx FileQueryContext, sortBy FileSortBy, descending bool,
+) ([]*fileTree.WeblensFileImpl, error) {
+	matched := slices.DeleteFunc(
+		slices.Clone(candidates), func(f *fileTree.WeblensFileImpl) bool {
+			return !q.Matches(f, ctx)
+		},
+	)
+
+	if len(q.Words) != 0 {
+		// Each word is matched on its own, so they do not have to be next to each other in the name. The
+		// distance is how many characters of the name were not part of the match, added up over the words.
+		type rankedFile struct {
+			file     *fileTree.WeblensFileImpl
+			distance int
+		}
+		ranked := make([]rankedFile, 0, len(matched))
+		for _, f := range matched {
+			distance := 0
+			for _, word := range q.Words {
+				wordDistance := fuzzy.RankMatchFold(word, f.Filename())
+				if wordDistance == -1 {
+					distance = -1
+					break
+				}
+				distance += wordDistance
+			}
+			if distance != -1 {
+				ranked = append(ranked, rankedFile{file: f, distance: distance})
+			}
+		}
+
+		slices.SortStableFunc(
+			ranked, func(a, b rankedFile) int {
+				return a.distance - b.distance
+			},
+		)
+
+		matched = matched[:0]
+		for _, r := range ranked {
+			matched = append(matched, r.file)
+		}
+	} else if sortBy == FileSortRelevance {
+		sortBy = FileSortName
+	}
+
+	var compare func(a, b *fileTree.WeblensFileImpl) int
+	switch sortBy {
+	case FileSortRelevance:
+		if descending {
+			slices.Reverse(matched)
+		}
+		return matched, nil
+	case FileSortName:
+		compare = func(a, b *fileTree.WeblensFileImpl) int {
+			return strings.Compare(strings.ToLower(a.Filename()), strings.ToLower(b.Filename()))
+		}
+	case FileSortSize:
+		compare = func(a, b *fileTree.WeblensFileImpl) int {
+			return cmp.Compare(a.Size(), b.Size())
+		}
+	case FileSortModified:
+		compare = func(a, b *fileTree.WeblensFileImpl) int {
+			return a.ModTime().Compare(b.ModTime())
+		}
+	case FileSortCreated:
+		compare = func(a, b *fileTree.WeblensFileImpl) int {
+			return ctx.Created(a).Compare(ctx.Created(b))
+		}
+	default:
+		return nil, werror.WithStack(werror.ErrBadSearchTerm("sort=" + string(sortBy)))
+	}
+
+	slices.SortStableFunc(
+		matched, func(a, b *fileTree.WeblensFileImpl) int {
+			if descending {
+				return compare(b, a)
+			}
+			return compare(a, b)
+		},
+	)
+
+	return matched, nil
+}
+
+// splitQueryTerms splits the query on whitespace, keeping quoted text together
+func splitQueryTerms(query string) ([]string, error) {
+	var terms []string
+	var term strings.Builder
+	inQuotes := false
+	for _, r := range query {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if unicode.IsSpace(r) && !inQuotes {
+			if term.Len() != 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+			continue
+		}
+		term.WriteRune(r)
+	}
+
+	if inQuotes {
+		return nil, werror.WithStack(werror.ErrBadSearchTerm(term.String()))
+	} else if term.Len() != 0 {
+		terms = append(terms, term.String())
+	}
+
+	return terms, nil
+}
+
+func nameContains(text string) func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool {
+	text = strings.ToLower(text)
+	return func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool {
+		return strings.Contains(strings.ToLower(f.Filename()), text)
+	}
+}
+
+func extensionIn(exts []string) func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool {
+	for i, ext := range exts {
+		exts[i] = strings.TrimPrefix(ext, ".")
+	}
+
+	return func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool {
+		if f.IsDir() {
+			return false
+		}
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(f.Filename())), ".")
+		return slices.Contains(exts, ext)
+	}
+}
+
+func tagMatcher(tags []string) func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+	return func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+		metadata := ctx.Metadata(f)
+		return slices.ContainsFunc(tags, metadata.HasTag)
+	}
+}
+
+// propertyMatcher matches files that have the property in value, which is either just a key, or key=value
+func propertyMatcher(value string) func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+	key, want, hasValue := strings.Cut(value, "=")
+	return func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+		got, ok := ctx.Metadata(f).Properties[key]
+		return ok && (!hasValue || strings.EqualFold(got, want))
+	}
+}
+
+func isMatcher(value string) (func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool, error) {
+	switch value {
+	case "dir", "folder":
+		return func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool { return f.IsDir() }, nil
+	case "file":
+		return func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool { return !f.IsDir() }, nil
+	case "media", "image", "video":
+		return typeMatcher(value), nil
+	}
+
+	return nil, werror.ErrBadSearchQuery
+}
+
+func typeMatcher(value string) func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+	return func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+		if f.IsDir() {
+			return false
+		}
+
+		mt := ctx.MediaType(f)
+		switch value {
+		case "media":
+			return mt.IsDisplayable()
+		case "image":
+			return mt.IsDisplayable() && !mt.Video
+		case "video":
+			return mt.Video
+		case "raw":
+			return mt.Raw
+		}
+
+		return mt.IsSupported() && (strings.ToLower(mt.Mime) == value || strings.ToLower(mt.FriendlyName()) == value)
+	}
+}
+
+// compareOp splits a leading comparison operator off of a filter value
+func compareOp(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+
+	return "", value
+}
+
+func sizeMatcher(value string) (func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool, error) {
+	low, high, err := parseRange(value, func(v string) (float64, float64, error) {
+		size, err := parseSize(v)
+		return size, size, err
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(f *fileTree.WeblensFileImpl, _ FileQueryContext) bool {
+		size := float64(f.Size())
+		return size >= low && size <= high
+	}, nil
+}
+
+func parseSize(value string) (float64, error) {
+	value = strings.ToLower(value)
+	numEnd := strings.IndexFunc(value, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if numEnd == -1 {
+		numEnd = len(value)
+	}
+
+	num, err := strconv.ParseFloat(value[:numEnd], 64)
+	if err != nil {
+		return 0, werror.ErrBadSearchQuery
+	}
+
+	unit, ok := sizeUnits[value[numEnd:]]
+	if !ok {
+		return 0, werror.ErrBadSearchQuery
+	}
+
+	return math.Round(num * unit), nil
+}
+
+// parseRange turns a value like ">2G", "1M..5M" or "2024" into an inclusive range. parse gives the first
+// and last values of what a single value covers, like the first and last instants of a year. Values that
+// cannot be compared exactly, like sizes, count as [v, v].
+func parseRange(
+	value string, parse func(v string) (float64, float64, error), exact bool,
+) (float64, float64, error) {
+	if from, to, ok := strings.Cut(value, ".."); ok {
+		low, _, err := parse(from)
+		if err != nil {
+			return 0, 0, err
+		}
+		_, high, err := parse(to)
+		if err != nil {
+			return 0, 0, err
+		}
+		return low, high, nil
+	}
+
+	op, value := compareOp(value)
+	first, last, err := parse(value)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch op {
+	case ">":
+		if exact {
+			return math.Nextafter(last, math.Inf(1)), math.Inf(1), nil
+		}
+		return last, math.Inf(1), nil
+	case ">=":
+		return first, math.Inf(1), nil
+	case "<":
+		if exact {
+			return math.Inf(-1), math.Nextafter(first, math.Inf(-1)), nil
+		}
+		return math.Inf(-1), first, nil
+	case "<=":
+		return math.Inf(-1), last, nil
+	}
+
+	return first, last, nil
+}
+
+func timeMatcher(
+	value string, now time.Time, getTime func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) time.Time,
+) (func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool, error) {
+	// A relative time is an age, so "more than 30 days old" is before the cutoff, and a bare 30d means
+	// "in the last 30 days"
+	if op, age := compareOp(value); age != "" && strings.ContainsAny(age[len(age)-1:], "dwmy") && isNumber(age[:len(age)-1]) {
+		cutoff, err := relativeTime(age, now)
+		if err != nil {
+			return nil, err
+		}
+
+		olderThan := op == ">" || op == ">="
+		return func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+			return getTime(f, ctx).Before(cutoff) == olderThan
+		}, nil
+	}
+
+	low, high, err := parseRange(value, func(v string) (float64, float64, error) {
+		start, end, err := parsePeriod(v, now.Location())
+		return float64(start.UnixNano()), float64(end.UnixNano()), err
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// The end of a period is the start of the next one, so it is not part of the period itself
+	return func(f *fileTree.WeblensFileImpl, ctx FileQueryContext) bool {
+		t := float64(getTime(f, ctx).UnixNano())
+		return t >= low && t < high
+	}, nil
+}
+
+// parsePeriod parses a year, month or day into the time it starts, and the time the next one starts
+func parsePeriod(value string, loc *time.Location) (time.Time, time.Time, error) {
+	for _, layout := range []struct {
+		format string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006", 1, 0, 0},
+		{"2006-01", 0, 1, 0},
+		{"2006-01-02", 0, 0, 1},
+	} {
+		start, err := time.ParseInLocation(layout.format, value, loc)
+		if err == nil {
+			return start, start.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+
+	return time.Time{}, time.Time{}, werror.ErrBadSearchQuery
+}
+
+func relativeTime(age string, now time.Time) (time.Time, error) {
+	n, err := strconv.Atoi(age[:len(age)-1])
+	if err != nil {
+		return time.Time{}, werror.ErrBadSearchQuery
+	}
+
+	switch age[len(age)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+
+	return time.Time{}, werror.ErrBadSearchQuery
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
@@ -0,0 +1,151 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+	. "github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileQuery(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+
+	root := fileTree.NewWeblensFile("root", "USERS", nil, true)
+	projects := fileTree.NewWeblensFile("projects", "Projects", root, true)
+	report := fileTree.NewWeblensFile("report", "Quarterly Report.pdf", projects, false)
+	report.SetSize(3 << 20)
+	movie := fileTree.NewWeblensFile("movie", "holiday.MOV", projects, false)
+	movie.SetSize(4 << 30)
+	notes := fileTree.NewWeblensFile("notes", "report notes.txt", projects, false)
+	notes.SetSize(512)
+	files := []*fileTree.WeblensFileImpl{projects, report, movie, notes}
+
+	created := map[fileTree.FileId]time.Time{
+		"projects": time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"report":   time.Date(2024, time.March, 31, 23, 59, 0, 0, time.UTC),
+		"movie":    time.Date(2024, time.June, 10, 0, 0, 0, 0, time.UTC),
+		"notes":    time.Date(2023, time.December, 25, 0, 0, 0, 0, time.UTC),
+	}
+	ctx := FileQueryContext{
+		Owner: func(f *fileTree.WeblensFileImpl) Username {
+			if f.ID() == "notes" {
+				return "alice"
+			}
+			return "bob"
+		},
+		MediaType: func(f *fileTree.WeblensFileImpl) MediaType {
+			if f.ID() == "movie" {
+				return MediaType{Mime: "video/quicktime", Name: "Quicktime", Displayable: true, Video: true}
+			}
+			return MediaType{Mime: "generic"}
+		},
+		Created: func(f *fileTree.WeblensFileImpl) time.Time {
+			return created[f.ID()]
+		},
+		Metadata: func(f *fileTree.WeblensFileImpl) FileMetadata {
+			switch f.ID() {
+			case "report":
+				return FileMetadata{Tags: []string{"Work", "2024"}, Properties: map[string]string{"status": "Final"}}
+			case "movie":
+				return FileMetadata{Tags: []string{"trips"}, Properties: map[string]string{"camera": "X100V"}}
+			}
+			return FileMetadata{}
+		},
+	}
+
+	search := func(query string, sortBy FileSortBy, descending bool) []fileTree.FileId {
+		q, err := ParseFileQuery(query, now)
+		require.NoError(t, err, query)
+
+		found, err := SearchFiles(files, q, ctx, sortBy, descending)
+		require.NoError(t, err, query)
+
+		ids := []fileTree.FileId{}
+		for _, f := range found {
+			ids = append(ids, f.ID())
+		}
+		return ids
+	}
+
+	assert.Equal(t, []fileTree.FileId{"notes", "report"}, search("report", "", false))
+	assert.Equal(t, []fileTree.FileId{"notes"}, search("report -pdf", "", false))
+	assert.Equal(t, []fileTree.FileId{"movie", "report"}, search("ext:mov,pdf", FileSortName, false))
+	assert.Equal(t, []fileTree.FileId{"movie"}, search("size:>2G", "", false))
+	assert.Equal(t, []fileTree.FileId{"report"}, search("size:1M..5M", "", false))
+	assert.Equal(t, []fileTree.FileId{"notes"}, search("size:<=512 is:file", "", false))
+	assert.Equal(t, []fileTree.FileId{"report", "movie"}, search("created:2024", FileSortCreated, false))
+	assert.Equal(t, []fileTree.FileId{"report"}, search("created:2024-03", "", false))
+	assert.Equal(t, []fileTree.FileId{"projects", "notes"}, search("created:<2024", FileSortCreated, false))
+	assert.Equal(t, []fileTree.FileId{"notes", "report"}, search("created:2023-12..2024-03", FileSortCreated, false))
+	assert.Equal(t, []fileTree.FileId{"movie"}, search("created:30d", "", false))
+	assert.Equal(t, []fileTree.FileId{"projects"}, search("created:>1y is:dir", "", false))
+	assert.Equal(t, []fileTree.FileId{"notes"}, search("owner:alice", "", false))
+	assert.Equal(t, []fileTree.FileId{"movie"}, search("is:video", "", false))
+	assert.Equal(t, []fileTree.FileId{"notes", "report"}, search("-is:dir -type:video", FileSortSize, false))
+	assert.Equal(t, []fileTree.FileId{"movie", "report", "notes"}, search("is:file", FileSortSize, true))
+	assert.Equal(t, []fileTree.FileId{"report"}, search(`name:"quarterly report"`, "", false))
+	assert.Equal(t, []fileTree.FileId{"movie", "report"}, search("tag:work,TRIPS", FileSortName, false))
+	assert.Equal(t, []fileTree.FileId{"projects", "notes", "report"}, search("-tag:trips", FileSortSize, false))
+	assert.Equal(t, []fileTree.FileId{"movie"}, search("prop:camera", "", false))
+	assert.Equal(t, []fileTree.FileId{"report"}, search("prop:status=final", "", false))
+	assert.Empty(t, search("prop:status=draft", "", false))
+
+	q, err := ParseFileQuery(`"holiday plans" in:/Projects`, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"holiday plans"}, q.Words)
+	assert.Equal(t, "/Projects", q.In)
+
+	for _, bad := range []string{"color:red", "size:big", "modified:yesterday", "is:cool", `name:"unclosed`, "ext:"} {
+		_, err = ParseFileQuery(bad, now)
+		assert.ErrorIs(t, err, werror.ErrBadSearchQuery, bad)
+	}
+
+	q, err = ParseFileQuery("report", now)
+	require.NoError(t, err)
+	_, err = SearchFiles(files, q, ctx, "color", false)
+	assert.ErrorIs(t, err, werror.ErrBadSearchQuery)
+}
+
+func TestSearchCandidates(t *testing.T) {
+	t.Parallel()
+
+	tree, err := fileTree.NewFileTree(t.TempDir()+"/", "USERS", mock.NewHollowJournalService(), false)
+	require.NoError(t, err)
+
+	root := tree.GetRoot()
+	movie, err := tree.Touch(root, "holiday.mov", nil)
+	require.NoError(t, err)
+	_, err = tree.Touch(root, "notes.txt", nil)
+	require.NoError(t, err)
+
+	search := func(query string) []fileTree.FileId {
+		q, err := ParseFileQuery(query, time.Now())
+		require.NoError(t, err, query)
+
+		found, err := SearchFiles(SearchCandidates(tree, root, q.Words), q, FileQueryContext{}, FileSortRelevance, false)
+		require.NoError(t, err, query)
+
+		ids := []fileTree.FileId{}
+		for _, f := range found {
+			ids = append(ids, f.ID())
+		}
+		return ids
+	}
+
+	// Found by the name index
+	assert.Len(t, SearchCandidates(tree, root, []string{"holi"}), 1)
+	assert.Equal(t, []fileTree.FileId{movie.ID()}, search("holi"))
+
+	// Not in the name index, but still a fuzzy match
+	assert.Empty(t, tree.SearchFilenames("hldy", root))
+	assert.Equal(t, []fileTree.FileId{movie.ID()}, search("hldy"))
+
+	assert.Empty(t, search("xyz"))
+}
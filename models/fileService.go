@@ -1,6 +1,7 @@
 package models
 
 import (
+	"io"
 	"time"
 
 	"github.com/ethanrous/weblens/fileTree"
@@ -11,6 +12,7 @@ type FileService interface {
 	Size(treeAlias string) int64
 
 	AddTree(tree fileTree.FileTree)
+	RemoveTree(treeName string)
 	GetFileTreeByName(treeName string) fileTree.FileTree
 
 	GetFileByTree(id fileTree.FileId, treeAlias string) (*fileTree.WeblensFileImpl, error)
@@ -32,16 +34,65 @@ type FileService interface {
 	NewBackupFile(lt *fileTree.Lifetime) (*fileTree.WeblensFileImpl, error)
 
 	GetFileOwner(file *fileTree.WeblensFileImpl) (*User, error)
+
+	// CheckQuota returns werror.ErrQuotaExceeded if adding addBytes under destination would put
+	// the owner of destination over their storage quota.
+	CheckQuota(destination *fileTree.WeblensFileImpl, addBytes int64) error
+
+	// ReserveQuota checks the quota the same way CheckQuota does, and then holds addBytes of it under reservationId
+	// until ReleaseQuota is called. Uploads declare their size before any of it is written, and the home folder
+	// does not count it until then, so the declared bytes are reserved so other uploads cannot use them too.
+	// Reserving under the same id again adds to what it holds.
+	ReserveQuota(destination *fileTree.WeblensFileImpl, reservationId string, addBytes int64) error
+
+	// ReleaseQuota gives back everything held under reservationId
+	ReleaseQuota(reservationId string)
 	IsFileInTrash(file *fileTree.WeblensFileImpl) bool
 
-	MoveFiles(files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, treeName string, caster FileCaster) error
+	// MoveFiles moves the files into destFolder. Files with the same name as one already there are handled
+	// with the conflict policy, and each conflict and how it was handled is returned. With ConflictFail,
+	// nothing is moved if there are any conflicts, and werror.ErrFileAlreadyExists is returned with them.
+	MoveFiles(
+		files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, treeName string, policy ConflictPolicy,
+		caster FileCaster,
+	) ([]FileConflict, error)
+
+	// CopyFiles copies each of the files, and everything under them, into destFolder, handling conflicts the same
+	// way as MoveFiles. A file copied into the folder it is already in is always kept alongside itself. onCopy,
+	// if not nil, is called after each file is copied.
+	CopyFiles(
+		files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, policy ConflictPolicy,
+		caster FileCaster, onCopy func(source, copied *fileTree.WeblensFileImpl),
+	) ([]*fileTree.WeblensFileImpl, []FileConflict, error)
 	RenameFile(file *fileTree.WeblensFileImpl, newName string, caster FileCaster) error
 	MoveFilesToTrash(file []*fileTree.WeblensFileImpl, mover *User, share *FileShare, caster FileCaster) error
 	ReturnFilesFromTrash(files []*fileTree.WeblensFileImpl, caster FileCaster) error
+	// RunBatch applies each of the operations in order. If any of them fails, the ones before it are undone and
+	// a *FileBatchError is returned. Otherwise the ids of the folders made by each mkdir operation are returned,
+	// at the same index as the operation, with "" for every other kind.
+	RunBatch(ops []FileOperation, user *User, share *FileShare, caster FileCaster) ([]fileTree.FileId, error)
 	DeleteFiles(files []*fileTree.WeblensFileImpl, treeName string, caster FileCaster) error
-	RestoreFiles(ids []fileTree.FileId, newParent *fileTree.WeblensFileImpl, restoreTime time.Time, caster FileCaster) error
+
+	// EmptyTrash deletes the files in the trash of user that were moved there before trashedBefore,
+	// and returns how many were deleted.
+	EmptyTrash(user *User, trashedBefore time.Time, caster FileCaster) (int, error)
+	// RestoreFiles restores the files as they were at restoreTime into newParent, handling conflicts the same way as MoveFiles
+	RestoreFiles(
+		ids []fileTree.FileId, newParent *fileTree.WeblensFileImpl, restoreTime time.Time, policy ConflictPolicy,
+		caster FileCaster,
+	) ([]FileConflict, error)
 	RestoreHistory(lifetimes []*fileTree.Lifetime) error
 
+	// ReplaceFileContent swaps the content of file for newContent, keeping the old content in the restore tree.
+	ReplaceFileContent(file *fileTree.WeblensFileImpl, newContent io.Reader, caster FileCaster) error
+	GetFileVersions(file *fileTree.WeblensFileImpl) ([]fileTree.ContentVersion, error)
+	RestoreFileVersion(file *fileTree.WeblensFileImpl, contentId ContentId, caster FileCaster) error
+
+	// PurgeRestoreFiles removes the content with the given ids from the restore tree, and returns
+	// how many of them were removed, and how many bytes were freed. Content that is not in the
+	// restore tree is skipped.
+	PurgeRestoreFiles(contentIds []ContentId) (int, int64, error)
+
 	GetMediaCacheByFilename(filename string) (*fileTree.WeblensFileImpl, error)
 	NewCacheFile(media *Media, quality MediaQuality, pageNum int) (*fileTree.WeblensFileImpl, error)
 	DeleteCacheFile(file fileTree.WeblensFile) error
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+)
+
+var _ fileTree.FileWatchHandler = (*FileWatchHandler)(nil)
+
+// FileWatchHandler brings the rest of the services up to date with changes
+// the file watcher finds on disk that were not made through weblens.
+type FileWatchHandler struct {
+	pack *ServicePack
+}
+
+func NewFileWatchHandler(pack *ServicePack) *FileWatchHandler {
+	return &FileWatchHandler{pack: pack}
+}
+
+func (h *FileWatchHandler) FilesCreated(files []*fileTree.WeblensFileImpl) {
+	caster := h.pack.GetCaster()
+	for _, f := range files {
+		caster.PushFileCreate(f)
+	}
+
+	// The media service is not set up until after the file service
+	if h.pack.MediaService == nil || h.pack.TaskService == nil {
+		return
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !h.pack.MediaService.IsFileDisplayable(f) {
+			continue
+		}
+
+		meta := ScanMeta{
+			File:         f,
+			FileService:  h.pack.FileService,
+			MediaService: h.pack.MediaService,
+			Caster:       caster,
+		}
+		_, err := h.pack.TaskService.DispatchJob(ScanFileTask, meta, nil)
+		if err != nil {
+			log.ErrTrace(err)
+		}
+	}
+}
+
+func (h *FileWatchHandler) FileMoved(preMoveFile, postMoveFile *fileTree.WeblensFileImpl) {
+	h.pack.GetCaster().PushFileMove(preMoveFile, postMoveFile)
+}
+
+func (h *FileWatchHandler) FilesDeleted(files []*fileTree.WeblensFileImpl) {
+	h.pack.GetCaster().PushFilesDelete(files)
+
+	if h.pack.MediaService == nil {
+		return
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		m := h.pack.MediaService.Get(f.GetContentId())
+		if m == nil {
+			continue
+		}
+
+		err := h.pack.MediaService.RemoveFileFromMedia(m, f.ID())
+		if err != nil {
+			log.ErrTrace(err)
+		}
+	}
+}
+
+func (h *FileWatchHandler) FilesResized(files []*fileTree.WeblensFileImpl) {
+	caster := h.pack.GetCaster()
+	for _, f := range files {
+		var m *Media
+		if h.pack.MediaService != nil && !f.IsDir() {
+			m = h.pack.MediaService.Get(f.GetContentId())
+		}
+		caster.PushFileUpdate(f, m)
+	}
+}
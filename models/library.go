@@ -0,0 +1,53 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Library is a directory on the host, outside of the weblens data root, that is attached as its own read-only
+// file tree. The alias is used as the root name of the tree, so files in the library have portable paths like
+// "ALIAS:path/to/file".
+type Library struct {
+	Alias     string     `bson:"_id"`
+	Path      string     `bson:"path"`
+	Owner     Username   `bson:"owner"`
+	Accessors []Username `bson:"accessors"`
+	Created   time.Time  `bson:"created"`
+}
+
+func NewLibrary(alias, path string, owner *User) *Library {
+	return &Library{
+		Alias:     alias,
+		Path:      path,
+		Owner:     owner.GetUsername(),
+		Accessors: []Username{},
+		Created:   time.Now(),
+	}
+}
+
+func (l *Library) GetOwner() Username       { return l.Owner }
+func (l *Library) GetAccessors() []Username { return l.Accessors }
+
+// CanUserAccess reports if the user may browse the library. Admins can see every library.
+func (l *Library) CanUserAccess(u *User) bool {
+	if u == nil || u.IsPublic() {
+		return false
+	}
+
+	return u.IsAdmin() || u.GetUsername() == l.Owner || slices.Contains(l.Accessors, u.GetUsername())
+}
+
+type LibraryService interface {
+	Size() int
+
+	Get(alias string) *Library
+	GetAll() []*Library
+	GetLibrariesForUser(u *User) []*Library
+
+	// Add validates the library, attaches its file tree to the file service, and saves it
+	Add(lib *Library) error
+	Del(alias string) error
+
+	SetAccessors(lib *Library, accessors []Username) error
+}
@@ -395,6 +395,7 @@ type MediaService interface {
 	GetProminentColors(media *Media) (prom []string, err error)
 
 	FetchCacheImg(m *Media, quality MediaQuality, pageNum int) ([]byte, error)
+	RenderPreview(image []byte, quality MediaQuality) ([]byte, error)
 	StreamVideo(m *Media, u *User, share *FileShare) (*VideoStreamer, error)
 	StreamCacheVideo(m *Media, startByte, endByte int) ([]byte, error)
 
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/archive"
 	"github.com/ethanrous/weblens/models"
 )
 
@@ -17,15 +18,57 @@ type UpdateFileParams struct {
 	NewParentId fileTree.FileId `json:"newParentId"`
 } // @name UpdateFileParams
 
+// UpdateFileMetadataParams changes the tags and properties on a file. Removals are done before additions.
+type UpdateFileMetadataParams struct {
+	AddTags          []string          `json:"addTags"`
+	RemoveTags       []string          `json:"removeTags"`
+	SetProperties    map[string]string `json:"setProperties"`
+	RemoveProperties []string          `json:"removeProperties"`
+} // @name UpdateFileMetadataParams
+
 type MoveFilesParams struct {
 	NewParentId fileTree.FileId   `json:"newParentId"`
 	Files       []fileTree.FileId `json:"fileIds"`
+
+	// What to do with files that have the same name as one in the new parent, keepBoth if not given
+	ConflictPolicy models.ConflictPolicy `json:"conflictPolicy"`
 } // @name MoveFilesParams
 
+type FileBatchParams struct {
+	Operations []models.FileOperation `json:"operations"`
+} // @name FileBatchParams
+
+type CopyFilesParams struct {
+	NewParentId fileTree.FileId   `json:"newParentId"`
+	Files       []fileTree.FileId `json:"fileIds"`
+
+	// What to do with files that have the same name as one in the new parent, keepBoth if not given
+	ConflictPolicy models.ConflictPolicy `json:"conflictPolicy"`
+} // @name CopyFilesParams
+
+type ExtractArchiveParams struct {
+	// The folder to extract into, the folder the archive is in if not given
+	DestinationFolderId fileTree.FileId `json:"destinationFolderId"`
+
+	// What to do with entries that have the same name as a file already in the destination, keepBoth if not given
+	ConflictPolicy models.ConflictPolicy `json:"conflictPolicy"`
+} // @name ExtractArchiveParams
+
 type FilesListParams struct {
 	FileIds []fileTree.FileId `json:"fileIds"`
 } // @name FilesListParams
 
+type TakeoutParams struct {
+	FileIds []fileTree.FileId `json:"fileIds"`
+	// Optional, build the zip from the files as they were at this time, in ms since epoch
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Optional, instead of building the zip first, give a url that writes the zip as it is downloaded.
+	// Streamed zips cannot be resumed.
+	Stream bool `json:"stream,omitempty"`
+	// Optional, one of zip, tar, tar.gz or tar.zst. Defaults to zip.
+	Format archive.Format `json:"format,omitempty"`
+} // @name TakeoutParams
+
 type MediaIdsParams struct {
 	MediaIds []models.ContentId `json:"mediaIds"`
 } // @name MediaIdsParams
@@ -48,15 +91,25 @@ type NewFileParams struct {
 	NewFileName    string          `json:"newFileName"`
 	FileSize       int64           `json:"fileSize"`
 	IsDir          bool            `json:"isDir"`
+
+	// ContentId is the content id of the file, if the client has hashed it. If the server already has
+	// content with this id, the file is made from that, and the client should not send its chunks.
+	ContentId models.ContentId `json:"contentId,omitempty"`
 } // @name NewFileParams
 
 type NewFilesParams struct {
 	NewFiles []NewFileParams `json:"newFiles"`
+
+	// What to do with files that have the same name as one already in their parent folder, fail if not given
+	ConflictPolicy models.ConflictPolicy `json:"conflictPolicy"`
 } // @name NewFilesParams
 
 type NewUploadParams struct {
 	RootFolderId fileTree.FileId `json:"rootFolderId"`
 	ChunkSize    int64           `json:"chunkSize"`
+
+	// The combined size of every file in the upload, checked against the storage quota of the folder owner
+	TotalUploadSize int64 `json:"totalUploadSize"`
 } // @name NewUploadParams
 
 type PasswordUpdateParams struct {
@@ -160,6 +213,9 @@ type RestoreFilesBody struct {
 	NewParentId fileTree.FileId   `json:"newParentId"`
 	FileIds     []fileTree.FileId `json:"fileIds"`
 	Timestamp   int64             `json:"timestamp"`
+
+	// What to do with files that have the same name as one in the new parent, keepBoth if not given
+	ConflictPolicy models.ConflictPolicy `json:"conflictPolicy"`
 } // @name RestoreFilesBody
 
 type RestoreCoreParams struct {
@@ -170,3 +226,8 @@ type RestoreCoreParams struct {
 type ApiKeyParams struct {
 	Name string `json:"name" validate:"required"`
 } // @name ApiKeyParams
+
+type NewLibraryParams struct {
+	Alias string `json:"alias" validate:"required"`
+	Path  string `json:"path" validate:"required"`
+} // @name NewLibraryParams
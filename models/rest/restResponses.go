@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/archive"
 	"github.com/ethanrous/weblens/internal/werror"
 	"github.com/ethanrous/weblens/models"
 )
@@ -53,6 +54,9 @@ type FileInfo struct {
 	Modifiable      bool             `json:"modifiable"`
 	PastFile        bool             `json:"pastFile"`
 	HasRestoreMedia bool             `json:"hasRestoreMedia"`
+
+	Tags       []string          `json:"tags"`
+	Properties map[string]string `json:"properties"`
 } // @name FileInfo
 
 func WeblensFileToFileInfo(f *fileTree.WeblensFileImpl, pack *models.ServicePack, isPastFile bool) (FileInfo, error) {
@@ -87,7 +91,7 @@ func WeblensFileToFileInfo(f *fileTree.WeblensFileImpl, pack *models.ServicePack
 		}
 	}
 
-	modifiable := !isPastFile && !pack.FileService.IsFileInTrash(f)
+	modifiable := !isPastFile && !f.IsReadOnly() && !pack.FileService.IsFileInTrash(f)
 
 	var hasRestoreMedia bool
 	if !isPastFile || f.IsDir() {
@@ -98,6 +102,11 @@ func WeblensFileToFileInfo(f *fileTree.WeblensFileImpl, pack *models.ServicePack
 		hasRestoreMedia = err == nil
 	}
 
+	metadata := models.FileMetadata{Tags: []string{}, Properties: map[string]string{}}
+	if pack.FileMetadataService != nil {
+		metadata = pack.FileMetadataService.Get(f.ID())
+	}
+
 	return FileInfo{
 		Id:              f.ID(),
 		PortablePath:    f.GetPortablePath().ToPortable(),
@@ -115,6 +124,9 @@ func WeblensFileToFileInfo(f *fileTree.WeblensFileImpl, pack *models.ServicePack
 
 		Owner:    ownerName,
 		Children: children,
+
+		Tags:       metadata.Tags,
+		Properties: metadata.Properties,
 	}, nil
 }
 
@@ -152,12 +164,19 @@ type TakeoutInfo struct {
 	TaskId    string `json:"taskId"`
 	Filename  string `json:"filename"`
 	Single    bool   `json:"single"`
+
+	// Only set for streamed takeouts. Fetching it downloads the zip.
+	StreamUrl string `json:"streamUrl,omitempty"`
 } // @name TakeoutInfo
 
 type DispatchInfo struct {
 	TaskId string `json:"taskId"`
 } // @name DispatchInfo
 
+type EmptyTrashInfo struct {
+	FilesDeleted int `json:"filesDeleted"`
+} // @name EmptyTrashInfo
+
 type ServerInfo struct {
 	Id   models.InstanceId `json:"id"`
 	Name string            `json:"name"`
@@ -213,14 +232,16 @@ func ServerInfoToInstance(si ServerInfo) *models.Instance {
 }
 
 type UserInfo struct {
-	Username  models.Username `json:"username"`
-	HomeId    string          `json:"homeId"`
-	TrashId   string          `json:"trashId"`
-	Token     string          `json:"token" omitEmpty:"true"`
-	HomeSize  int64           `json:"homeSize"`
-	TrashSize int64           `json:"trashSize"`
-	Admin     bool            `json:"admin"`
-	Owner     bool            `json:"owner"`
+	Username           models.Username `json:"username"`
+	HomeId             string          `json:"homeId"`
+	TrashId            string          `json:"trashId"`
+	Token              string          `json:"token" omitEmpty:"true"`
+	HomeSize           int64           `json:"homeSize"`
+	TrashSize          int64           `json:"trashSize"`
+	Quota              int64           `json:"quota"`
+	TrashRetentionDays int             `json:"trashRetentionDays"`
+	Admin              bool            `json:"admin"`
+	Owner              bool            `json:"owner"`
 } // @name UserInfo
 
 type UserInfoArchive struct {
@@ -234,11 +255,13 @@ func UserToUserInfo(u *models.User) UserInfo {
 		return UserInfo{}
 	}
 	info := UserInfo{
-		Username: u.GetUsername(),
-		Admin:    u.IsAdmin(),
-		Owner:    u.IsOwner(),
-		HomeId:   u.HomeId,
-		TrashId:  u.TrashId,
+		Username:           u.GetUsername(),
+		Admin:              u.IsAdmin(),
+		Owner:              u.IsOwner(),
+		HomeId:             u.HomeId,
+		TrashId:            u.TrashId,
+		Quota:              u.GetQuota(),
+		TrashRetentionDays: u.TrashRetentionDays,
 	}
 
 	return info
@@ -257,19 +280,23 @@ func UserToUserInfoArchive(u *models.User) UserInfoArchive {
 	info.Owner = u.IsOwner()
 	info.HomeId = u.HomeId
 	info.TrashId = u.TrashId
+	info.Quota = u.GetQuota()
+	info.TrashRetentionDays = u.TrashRetentionDays
 
 	return info
 }
 
 func UserInfoArchiveToUser(uInfo UserInfoArchive) *models.User {
 	u := &models.User{
-		Username:      uInfo.Username,
-		Password:      uInfo.Password,
-		Activated:     uInfo.Activated,
-		Admin:         uInfo.Admin,
-		IsServerOwner: uInfo.Owner,
-		HomeId:        uInfo.HomeId,
-		TrashId:       uInfo.TrashId,
+		Username:           uInfo.Username,
+		Password:           uInfo.Password,
+		Activated:          uInfo.Activated,
+		Admin:              uInfo.Admin,
+		IsServerOwner:      uInfo.Owner,
+		HomeId:             uInfo.HomeId,
+		TrashId:            uInfo.TrashId,
+		Quota:              uInfo.Quota,
+		TrashRetentionDays: uInfo.TrashRetentionDays,
 	}
 
 	return u
@@ -314,6 +341,8 @@ type FileActionInfo struct {
 	ServerId        string `json:"serverId" validate:"required"`
 	Timestamp       int64  `json:"timestamp" validate:"required"`
 	Size            int64  `json:"size" validate:"required"`
+	ContentId       string `json:"contentId,omitempty"`
+	OldContentId    string `json:"oldContentId,omitempty"`
 } // @name FileActionInfo
 
 func FileActionToFileActionInfo(fa *fileTree.FileAction) FileActionInfo {
@@ -327,9 +356,61 @@ func FileActionToFileActionInfo(fa *fileTree.FileAction) FileActionInfo {
 		Size:            fa.Size,
 		ParentId:        fa.ParentId,
 		ServerId:        fa.ServerId,
+		ContentId:       fa.ContentId,
+		OldContentId:    fa.OldContentId,
 	}
 }
 
+type FileDiffInfo struct {
+	FileId   string `json:"fileId" validate:"required"`
+	DiffType string `json:"diffType" validate:"required"`
+	FromPath string `json:"fromPath,omitempty"`
+	ToPath   string `json:"toPath,omitempty"`
+	FromSize int64  `json:"fromSize"`
+	ToSize   int64  `json:"toSize"`
+	IsDir    bool   `json:"isDir" validate:"required"`
+} // @name FileDiffInfo
+
+func FileDiffToFileDiffInfo(d fileTree.FileDiff) FileDiffInfo {
+	return FileDiffInfo{
+		FileId:   d.FileId,
+		DiffType: string(d.DiffType),
+		FromPath: d.FromPath,
+		ToPath:   d.ToPath,
+		FromSize: d.FromSize,
+		ToSize:   d.ToSize,
+		IsDir:    d.IsDir,
+	}
+}
+
+type FileVersionInfo struct {
+	ContentId string `json:"contentId" validate:"required"`
+	Size      int64  `json:"size" validate:"required"`
+	// Time the file started holding this content, in ms since epoch
+	Begin int64 `json:"begin" validate:"required"`
+	// Time the content was replaced, in ms since epoch. 0 if this is the current version
+	End       int64 `json:"end" validate:"required"`
+	IsCurrent bool  `json:"isCurrent" validate:"required"`
+	// If the content of the version is still stored, and the version can be restored
+	Available bool `json:"available" validate:"required"`
+} // @name FileVersionInfo
+
+func ContentVersionToFileVersionInfo(v fileTree.ContentVersion, available bool) FileVersionInfo {
+	info := FileVersionInfo{
+		ContentId: v.ContentId,
+		Size:      v.Size,
+		Begin:     v.Begin.UnixMilli(),
+		IsCurrent: v.End.IsZero(),
+		Available: available,
+	}
+
+	if !v.End.IsZero() {
+		info.End = v.End.UnixMilli()
+	}
+
+	return info
+}
+
 type MediaInfo struct {
 	MediaId string `json:"-" example:"5f9b3b3b7b4f3b0001b3b3b7"`
 
@@ -423,6 +504,33 @@ func ShareToShareInfo(s *models.FileShare) ShareInfo {
 	}
 }
 
+type LibraryInfo struct {
+	Alias     string   `json:"alias"`
+	Path      string   `json:"path"`
+	Owner     string   `json:"owner"`
+	Accessors []string `json:"accessors"`
+	Created   int64    `json:"created"`
+	Attached  bool     `json:"attached"`
+} // @name LibraryInfo
+
+// LibraryToLibraryInfo converts the library, attached should be false if the library is saved, but its directory
+// could not be loaded.
+func LibraryToLibraryInfo(l *models.Library, attached bool) LibraryInfo {
+	return LibraryInfo{
+		Alias:     l.Alias,
+		Path:      l.Path,
+		Owner:     l.GetOwner(),
+		Accessors: l.GetAccessors(),
+		Created:   l.Created.UnixMilli(),
+		Attached:  attached,
+	}
+}
+
+type ContentSearchResult struct {
+	File     FileInfo             `json:"file"`
+	Snippets []models.TextSnippet `json:"snippets"`
+} // @name ContentSearchResult
+
 type NewUploadInfo struct {
 	UploadId string `json:"uploadId"`
 } // @name NewUploadInfo
@@ -432,9 +540,21 @@ type NewFileInfo struct {
 } // @name NewFileInfo
 
 type NewFilesInfo struct {
-	FileIds []string `json:"fileIds"`
+	// FileIds has an id for each of the new files, in the order they were given. Skipped files have an empty id,
+	// and folders merged into an existing folder have the id of that folder.
+	FileIds   []string              `json:"fileIds"`
+	Conflicts []models.FileConflict `json:"conflicts"`
+
+	// LinkedFileIds are the new files that were made from content already on the server. Their chunks should not be sent.
+	LinkedFileIds []string `json:"linkedFileIds"`
 } // @name NewFilesInfo
 
+type UploadResultInfo struct {
+	// RejectedFileIds are the files in the upload whose content did not match the content id given for them.
+	// They have been removed, and should be uploaded again.
+	RejectedFileIds []string `json:"rejectedFileIds"`
+} // @name UploadResultInfo
+
 type AlbumInfo struct {
 	Id             string   `json:"id"`
 	Name           string   `json:"name"`
@@ -460,5 +580,67 @@ func AlbumToAlbumInfo(a *models.Album) AlbumInfo {
 }
 
 type RestoreFilesInfo struct {
-	NewParentId string `json:"newParentId"`
+	NewParentId string                `json:"newParentId"`
+	Conflicts   []models.FileConflict `json:"conflicts"`
 } //	@name	RestoreFilesInfo
+
+// FileConflictsInfo lists the files that had the same name as one already in the folder they were going to.
+// With the fail conflict policy, it is sent with a 409 and the error, and nothing was changed.
+type FileConflictsInfo struct {
+	Error     string                `json:"error,omitempty"`
+	Conflicts []models.FileConflict `json:"conflicts"`
+} // @name FileConflictsInfo
+
+// FileBatchInfo has, for each operation in the batch, the id of the folder it made, or "" if it was not a mkdir
+type FileBatchInfo struct {
+	NewFolderIds []fileTree.FileId `json:"newFolderIds"`
+} // @name FileBatchInfo
+
+// FileBatchFailedInfo is sent when an operation in a batch fails. Everything the batch had done before it was undone.
+type FileBatchFailedInfo struct {
+	Error           string `json:"error"`
+	FailedOperation int    `json:"failedOperation"`
+} // @name FileBatchFailedInfo
+
+type FileMetadataInfo struct {
+	Tags       []string          `json:"tags"`
+	Properties map[string]string `json:"properties"`
+} // @name FileMetadataInfo
+
+// RecentFileInfo is a file in the recent files feed. Action is the type of the journal action that last touched
+// the file, or "download" if it was last downloaded.
+type RecentFileInfo struct {
+	File      FileInfo `json:"file"`
+	Action    string   `json:"action"`
+	Timestamp int64    `json:"timestamp"`
+} // @name RecentFileInfo
+
+type ArchiveEntryInfo struct {
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressedSize"`
+	ModTime        int64  `json:"modifyTimestamp"`
+	IsDir          bool   `json:"isDir"`
+} // @name ArchiveEntryInfo
+
+type ArchiveInfo struct {
+	Format  string             `json:"format"`
+	Entries []ArchiveEntryInfo `json:"entries"`
+} // @name ArchiveInfo
+
+func NewArchiveInfo(format archive.Format, entries []archive.Entry) ArchiveInfo {
+	info := ArchiveInfo{Format: string(format), Entries: make([]ArchiveEntryInfo, 0, len(entries))}
+	for _, entry := range entries {
+		info.Entries = append(
+			info.Entries, ArchiveEntryInfo{
+				Path:           entry.Path,
+				Size:           entry.Size,
+				CompressedSize: entry.CompressedSize,
+				ModTime:        entry.ModTime.UnixMilli(),
+				IsDir:          entry.IsDir,
+			},
+		)
+	}
+
+	return info
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ScrubReportId string
+
+// ScrubReport is the outcome of rehashing every file in a tree and comparing it to the content id we
+// stored for it. Corrupted files no longer hash to their content id, and unreadable files could not be
+// hashed at all. Changed files have been edited outside of weblens since they were last hashed, so they are
+// not expected to match, and are counted without being checked.
+type ScrubReport struct {
+	Id           ScrubReportId  `bson:"_id" json:"id"`
+	TreeName     string         `bson:"treeName" json:"treeName"`
+	Started      time.Time      `bson:"started" json:"started"`
+	Finished     time.Time      `bson:"finished" json:"finished"`
+	FilesChecked int            `bson:"filesChecked" json:"filesChecked"`
+	BytesChecked int64          `bson:"bytesChecked" json:"bytesChecked"`
+	FilesChanged int            `bson:"filesChanged" json:"filesChanged"`
+	Corrupted    []ScrubbedFile `bson:"corrupted" json:"corrupted"`
+	Unreadable   []ScrubbedFile `bson:"unreadable" json:"unreadable"`
+} // @name ScrubReport
+
+type ScrubbedFile struct {
+	FileId    fileTree.FileId `bson:"fileId" json:"fileId"`
+	Path      string          `bson:"path" json:"path"`
+	ContentId ContentId       `bson:"contentId" json:"contentId"`
+
+	// FoundContentId is what the file hashes to now, and is empty if the file could not be read
+	FoundContentId ContentId `bson:"foundContentId" json:"foundContentId"`
+	Error          string    `bson:"error,omitempty" json:"error,omitempty"`
+} // @name ScrubbedFile
+
+func NewScrubReport(treeName string) *ScrubReport {
+	return &ScrubReport{
+		Id:         ScrubReportId(primitive.NewObjectID().Hex()),
+		TreeName:   treeName,
+		Started:    time.Now(),
+		Corrupted:  []ScrubbedFile{},
+		Unreadable: []ScrubbedFile{},
+	}
+}
+
+// IsClean reports if every file that was checked still matches its content id
+func (r *ScrubReport) IsClean() bool {
+	return len(r.Corrupted) == 0 && len(r.Unreadable) == 0
+}
+
+type ScrubService interface {
+	Add(report *ScrubReport) error
+	Get(id ScrubReportId) (*ScrubReport, error)
+
+	// GetLatest returns the most recent report for the tree, or ErrNoScrubReport if it has never been scrubbed
+	GetLatest(treeName string) (*ScrubReport, error)
+}
@@ -15,17 +15,24 @@ import (
 )
 
 type ServicePack struct {
-	Log             log.Bundle
-	FileService     FileService
-	MediaService    MediaService
-	AccessService   AccessService
-	UserService     UserService
-	ShareService    ShareService
-	InstanceService InstanceService
-	AlbumService    AlbumService
-	TaskService     task.TaskService
-	ClientService   ClientManager
-	Caster          Broadcaster
+	Log                  log.Bundle
+	FileService          FileService
+	MediaService         MediaService
+	AccessService        AccessService
+	UserService          UserService
+	ShareService         ShareService
+	LibraryService       LibraryService
+	ScrubService         ScrubService
+	ContentIndexService  ContentIndexService
+	FileMetadataService  FileMetadataService
+	FileActivityService  FileActivityService
+	TusUploadService     TusUploadService
+	StreamTakeoutService StreamTakeoutService
+	InstanceService      InstanceService
+	AlbumService         AlbumService
+	TaskService          task.TaskService
+	ClientService        ClientManager
+	Caster               Broadcaster
 
 	Server      Server
 	StartupChan chan bool
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/archive"
+)
+
+type StreamTakeoutId = string
+
+// StreamTakeoutExpiry is how long the url of a streamed takeout can be fetched after it is created
+const StreamTakeoutExpiry = time.Hour
+
+// StreamTakeout is what a streamed takeout will zip once its url is fetched. Only the ids are kept, so the
+// files, and access to them, are looked up again when the zip is written.
+type StreamTakeout struct {
+	FileIds   []fileTree.FileId
+	Username  Username
+	ShareId   ShareId
+	Timestamp time.Time
+	Format    archive.Format
+	Filename  string
+	ExpiresAt time.Time
+}
+
+type StreamTakeoutService interface {
+	// Add saves a streamed takeout until it expires, and gives the id of its url. The id is all that is needed
+	// to download the takeout, so it is not something that can be guessed.
+	Add(takeout StreamTakeout) StreamTakeoutId
+
+	// Get returns the takeout with the id, if it exists and has not expired
+	Get(takeoutId StreamTakeoutId) (StreamTakeout, bool)
+}
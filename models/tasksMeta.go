@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"hash"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal"
+	"github.com/ethanrous/weblens/internal/archive"
 	"github.com/ethanrous/weblens/internal/log"
 	"github.com/ethanrous/weblens/internal/werror"
 	"github.com/ethanrous/weblens/task"
@@ -26,6 +28,14 @@ const (
 	HashFileTask         = "hash_file"
 	CopyFileFromCoreTask = "copy_file_from_core"
 	RestoreCoreTask      = "restore_core"
+	CompactJournalTask   = "compact_journal"
+	CopyFilesTask        = "copy_files"
+	DedupFilesTask       = "dedup_files"
+	ExpireTrashTask      = "expire_trash"
+	ScrubFilesTask       = "scrub_files"
+	RefetchScrubbedTask  = "refetch_scrubbed_files"
+	IndexContentTask     = "index_file_content"
+	ExtractArchiveTask   = "extract_archive"
 )
 
 type TaskSubscriber interface {
@@ -47,6 +57,9 @@ type ScanMeta struct {
 	File         *fileTree.WeblensFileImpl
 	PartialMedia *Media
 
+	// ContentIndex is optional, and if set, directory scans will also index the text of the files they find
+	ContentIndex ContentIndexService
+
 	FileBytes []byte
 }
 
@@ -99,6 +112,12 @@ type ZipMeta struct {
 	Requester   *User
 
 	Files []*fileTree.WeblensFileImpl
+
+	// If set, Files are past files, and the zip is built from the file history as it was at Timestamp
+	Timestamp time.Time
+
+	// The kind of archive to build, a zip if not set
+	Format archive.Format
 }
 
 func (m ZipMeta) MetaString() string {
@@ -117,7 +136,17 @@ func (m ZipMeta) MetaString() string {
 		shareBit = string(m.Share.ShareId) + m.Share.LastUpdated().String()
 	}
 
-	data := CreateZipTask + string(idsString) + string(m.Requester.GetUsername()) + shareBit
+	var timeBit string
+	if !m.Timestamp.IsZero() {
+		timeBit = strconv.FormatInt(m.Timestamp.UnixMilli(), 10)
+	}
+
+	format := m.Format
+	if format == "" {
+		format = archive.Zip
+	}
+
+	data := CreateZipTask + string(idsString) + string(m.Requester.GetUsername()) + shareBit + timeBit + string(format)
 	return data
 }
 
@@ -144,6 +173,8 @@ func (m ZipMeta) Verify() error {
 		return werror.ErrBadJobMetadata(m.JobName(), "fileService")
 	} else if m.Caster == nil {
 		return werror.ErrBadJobMetadata(m.JobName(), "caster")
+	} else if _, ok := archive.ParseFormat(string(m.Format)); m.Format != "" && !ok {
+		return werror.ErrBadJobMetadata(m.JobName(), "format")
 	}
 
 	if m.Share == nil {
@@ -190,11 +221,227 @@ func (m MoveMeta) Verify() error {
 	return nil
 }
 
+type CopyFilesMeta struct {
+	Caster      FileCaster
+	FileService FileService
+
+	User        *User
+	Files       []*fileTree.WeblensFileImpl
+	Destination *fileTree.WeblensFileImpl
+
+	// ConflictPolicy is what to do with files that have the same name as one already in the destination
+	ConflictPolicy ConflictPolicy
+}
+
+func (m CopyFilesMeta) MetaString() string {
+	ids := internal.Map(
+		m.Files, func(f *fileTree.WeblensFileImpl) fileTree.FileId {
+			return f.ID()
+		},
+	)
+	slices.Sort(ids)
+
+	data := map[string]any{
+		"JobName": CopyFilesTask,
+		"FileIds": ids,
+		"DestId":  m.Destination.ID(),
+		"User":    m.User.GetUsername(),
+		"Policy":  m.ConflictPolicy,
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m CopyFilesMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{
+		"destinationId": m.Destination.ID(),
+		"totalFiles":    len(m.Files),
+	}
+}
+
+func (m CopyFilesMeta) JobName() string {
+	return CopyFilesTask
+}
+
+func (m CopyFilesMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.Caster == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Caster")
+	} else if m.User == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "User")
+	} else if len(m.Files) == 0 {
+		return werror.ErrBadJobMetadata(m.JobName(), "Files")
+	} else if m.Destination == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Destination")
+	}
+
+	return nil
+}
+
+type ExtractArchiveMeta struct {
+	FileService  FileService
+	MediaService MediaService
+	TaskService  task.TaskService
+	TaskSubber   TaskSubscriber
+	Caster       FileCaster
+
+	User        *User
+	Archive     *fileTree.WeblensFileImpl
+	Destination *fileTree.WeblensFileImpl
+
+	// ConflictPolicy is what to do with entries that have the same name as a file already in the destination,
+	// which is one of overwrite, skip or keepBoth. Folders are always merged into folders that are already there.
+	ConflictPolicy ConflictPolicy
+}
+
+func (m ExtractArchiveMeta) MetaString() string {
+	data := map[string]any{
+		"JobName":        ExtractArchiveTask,
+		"ArchiveId":      m.Archive.ID(),
+		"DestId":         m.Destination.ID(),
+		"User":           m.User.GetUsername(),
+		"ConflictPolicy": m.ConflictPolicy,
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m ExtractArchiveMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{
+		"archiveId":     m.Archive.ID(),
+		"filename":      m.Archive.Filename(),
+		"destinationId": m.Destination.ID(),
+	}
+}
+
+func (m ExtractArchiveMeta) JobName() string {
+	return ExtractArchiveTask
+}
+
+func (m ExtractArchiveMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.MediaService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "MediaService")
+	} else if m.TaskService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "TaskService")
+	} else if m.Caster == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Caster")
+	} else if m.User == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "User")
+	} else if m.Archive == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Archive")
+	} else if m.Destination == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Destination")
+	}
+
+	return nil
+}
+
+type DedupFilesMeta struct {
+	FileService FileService
+	TreeName    string
+}
+
+func (m DedupFilesMeta) MetaString() string {
+	data := map[string]any{
+		"JobName":  DedupFilesTask,
+		"TreeName": m.TreeName,
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m DedupFilesMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{}
+}
+
+func (m DedupFilesMeta) JobName() string {
+	return DedupFilesTask
+}
+
+func (m DedupFilesMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.TreeName == "" {
+		return werror.ErrBadJobMetadata(m.JobName(), "TreeName")
+	}
+
+	return nil
+}
+
+type ExpireTrashMeta struct {
+	FileService FileService
+	UserService UserService
+	Caster      FileCaster
+
+	// The server default for how long files stay in the trash, users may have their own
+	RetentionDays int
+}
+
+func (m ExpireTrashMeta) MetaString() string {
+	data := map[string]any{
+		"JobName":       ExpireTrashTask,
+		"RetentionDays": m.RetentionDays,
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m ExpireTrashMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{}
+}
+
+func (m ExpireTrashMeta) JobName() string {
+	return ExpireTrashTask
+}
+
+func (m ExpireTrashMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.UserService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "UserService")
+	} else if m.Caster == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Caster")
+	}
+
+	return nil
+}
+
 type FileChunk struct {
 	NewFile      *fileTree.WeblensFileImpl
 	FileId       fileTree.FileId
 	ContentRange string
 
+	// Replaces is the existing file that NewFile is uploaded to overwrite. When the upload is finished,
+	// the content of NewFile becomes the new content of Replaces, and NewFile is removed.
+	Replaces *fileTree.WeblensFileImpl
+
+	// ContentId is the content id the client says NewFile has. If it is given, the file is rejected
+	// once it has been written if its content does not match.
+	ContentId ContentId
+
+	// LinkFrom is a file already on the server with the content NewFile is being uploaded with.
+	// NewFile is given that content, instead of waiting for the client to send it.
+	LinkFrom *fileTree.WeblensFileImpl
+
+	// Staged is set when the content of NewFile was already put in place before it was given to the upload,
+	// as it is for a finished tus upload, so it only has to be hashed.
+	Staged bool
+
+	// LastNewFile is set on the last new file added to the upload by a request, so the upload knows it
+	// can finish if every file in it is already done, as happens when they are all linked.
+	LastNewFile bool
+
 	Chunk []byte
 }
 
@@ -212,6 +459,10 @@ type UploadFilesMeta struct {
 	Share        *FileShare
 	RootFolderId fileTree.FileId
 	ChunkSize    int64
+
+	// QuotaReservation is the quota the uploaded files were reserved under before the task was made, if any. It is
+	// released, along with anything reserved under the id of the task, once the files are sized into the tree.
+	QuotaReservation string
 }
 
 func (m UploadFilesMeta) MetaString() string {
@@ -280,6 +531,8 @@ func (m FsStatMeta) Verify() error {
 type FileUploadProgress struct {
 	Hash          hash.Hash
 	File          *fileTree.WeblensFileImpl
+	Replaces      *fileTree.WeblensFileImpl
+	ContentId     ContentId
 	BytesWritten  int64
 	FileSizeTotal int64
 }
@@ -512,3 +765,160 @@ func NewBackupTaskStages() *TaskStages {
 		},
 	}
 }
+
+type CompactJournalMeta struct {
+	FileService FileService
+	Journal     fileTree.Journal
+	Policy      fileTree.RetentionPolicy
+}
+
+func (m CompactJournalMeta) MetaString() string {
+	data := map[string]any{
+		"JobName": CompactJournalTask,
+		"policy":  m.Policy,
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m CompactJournalMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{}
+}
+
+func (m CompactJournalMeta) JobName() string {
+	return CompactJournalTask
+}
+
+func (m CompactJournalMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.Journal == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Journal")
+	}
+
+	return nil
+}
+
+type ScrubFilesMeta struct {
+	FileService  FileService
+	ScrubService ScrubService
+	Caster       FileCaster
+	TreeName     string
+}
+
+func (m ScrubFilesMeta) MetaString() string {
+	data := map[string]any{
+		"JobName":  ScrubFilesTask,
+		"TreeName": m.TreeName,
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m ScrubFilesMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{}
+}
+
+func (m ScrubFilesMeta) JobName() string {
+	return ScrubFilesTask
+}
+
+func (m ScrubFilesMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.ScrubService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "ScrubService")
+	} else if m.Caster == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Caster")
+	} else if m.TreeName == "" {
+		return werror.ErrBadJobMetadata(m.JobName(), "TreeName")
+	}
+
+	return nil
+}
+
+// RefetchScrubbedMeta is for copying the files a scrub found to be corrupted on a backup server
+// from the core they were backed up from.
+type RefetchScrubbedMeta struct {
+	FileService FileService
+	TaskService TaskDispatcher
+	Caster      Broadcaster
+	Core        *Instance
+	Report      *ScrubReport
+}
+
+func (m RefetchScrubbedMeta) MetaString() string {
+	data := map[string]any{
+		"JobName":  RefetchScrubbedTask,
+		"ReportId": m.Report.Id,
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m RefetchScrubbedMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{}
+}
+
+func (m RefetchScrubbedMeta) JobName() string {
+	return RefetchScrubbedTask
+}
+
+func (m RefetchScrubbedMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.TaskService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "TaskService")
+	} else if m.Caster == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Caster")
+	} else if m.Core == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Core")
+	} else if m.Report == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "Report")
+	}
+
+	return nil
+}
+
+type IndexContentMeta struct {
+	FileService  FileService
+	ContentIndex ContentIndexService
+	File         *fileTree.WeblensFileImpl
+}
+
+func (m IndexContentMeta) MetaString() string {
+	data := map[string]any{
+		"JobName": IndexContentTask,
+		"FileId":  m.File.ID(),
+	}
+	bs, err := json.Marshal(data)
+	log.ErrTrace(err)
+
+	return string(bs)
+}
+
+func (m IndexContentMeta) FormatToResult() task.TaskResult {
+	return task.TaskResult{}
+}
+
+func (m IndexContentMeta) JobName() string {
+	return IndexContentTask
+}
+
+func (m IndexContentMeta) Verify() error {
+	if m.FileService == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "FileService")
+	} else if m.ContentIndex == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "ContentIndex")
+	} else if m.File == nil {
+		return werror.ErrBadJobMetadata(m.JobName(), "File")
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+)
+
+type TusUploadId = string
+
+// TusVersion is the version of the tus resumable upload protocol the server speaks
+const TusVersion = "1.0.0"
+
+// TusExtensions are the tus extensions the server supports
+var TusExtensions = []string{"creation", "creation-with-upload", "termination", "checksum", "expiration"}
+
+// TusChecksumAlgorithms are the algorithms a client can send an Upload-Checksum with
+var TusChecksumAlgorithms = []string{"md5", "sha1", "sha256"}
+
+// TusUploadExpiry is how long an upload is kept after the last time data was written to it
+const TusUploadExpiry = time.Hour * 24
+
+// TusUpload is a single file being uploaded with the tus protocol. The data is written to a staging file as
+// it arrives, and the upload is kept in the database, so the client can pick up where it left off, even after
+// the server restarts. Once all of it has arrived, it is moved into the users tree like any other upload.
+type TusUpload struct {
+	Id             TusUploadId     `bson:"_id"`
+	Username       Username        `bson:"username"`
+	ShareId        ShareId         `bson:"shareId,omitempty"`
+	ParentId       fileTree.FileId `bson:"parentId"`
+	Filename       string          `bson:"filename"`
+	ConflictPolicy ConflictPolicy  `bson:"conflictPolicy"`
+	Length         int64           `bson:"length"`
+	Offset         int64           `bson:"offset"`
+
+	// Metadata is the Upload-Metadata header as the client sent it, which is given back to it when it asks
+	Metadata string `bson:"metadata"`
+
+	CreatedAt time.Time `bson:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+func (u TusUpload) IsComplete() bool {
+	return u.Offset == u.Length
+}
+
+// TusChecksum is what a chunk of an upload is expected to hash to
+type TusChecksum struct {
+	Hash     hash.Hash
+	Expected []byte
+}
+
+type TusUploadService interface {
+	// Create saves a new upload, and gives it an id and somewhere to stage its data
+	Create(upload TusUpload) (TusUpload, error)
+	Get(uploadId TusUploadId) (TusUpload, error)
+
+	// WriteChunk appends data to the upload, which must be at offset. If checksum is given and the data
+	// does not match it, nothing is written and werror.ErrChecksumMismatch is returned.
+	WriteChunk(uploadId TusUploadId, offset int64, data io.Reader, checksum *TusChecksum) (TusUpload, error)
+
+	// Open reads what has been staged for the upload so far
+	Open(uploadId TusUploadId) (io.ReadCloser, error)
+
+	// MoveTo puts what has been staged for the upload at dst, and deletes the upload. The staged file is renamed
+	// if dst is on the same filesystem as it, and copied if not.
+	MoveTo(uploadId TusUploadId, dst string) error
+	Delete(uploadId TusUploadId) error
+
+	// DeleteExpired removes every upload that has not been written to before it expired
+	DeleteExpired() error
+}
+
+// ParseTusMetadata reads an Upload-Metadata header, which is a comma separated list of
+// keys, each followed by a space and its base64 encoded value, if it has one.
+func ParseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, werror.WithStack(werror.ErrBadUploadRequest.WithArg("empty key in Upload-Metadata"))
+		}
+		if _, ok := metadata[key]; ok {
+			return nil, werror.WithStack(werror.ErrBadUploadRequest.WithArg("duplicate key in Upload-Metadata"))
+		}
+
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, werror.WithStack(werror.ErrBadUploadRequest.WithArg("value of " + key + " in Upload-Metadata is not base64"))
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// ParseTusChecksum reads an Upload-Checksum header, which is the name of the
+// algorithm, followed by a space and the base64 encoded checksum.
+func ParseTusChecksum(header string) (*TusChecksum, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, werror.WithStack(werror.ErrBadUploadRequest.WithArg("Upload-Checksum must be an algorithm and a checksum"))
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, werror.WithStack(werror.ErrBadUploadRequest.WithArg("checksum in Upload-Checksum is not base64"))
+	}
+
+	var h hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil, werror.WithStack(werror.ErrBadChecksumAlgorithm.WithArg(algorithm))
+	}
+
+	return &TusChecksum{Hash: h, Expected: expected}, nil
+}
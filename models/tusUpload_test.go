@@ -0,0 +1,43 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/ethanrous/weblens/internal/werror"
+	. "github.com/ethanrous/weblens/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	t.Parallel()
+
+	metadata, err := ParseTusMetadata("filename bXkgcGhvdG8uanBn,parentId Zm9sZGVyMQ==, is_private")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"filename": "my photo.jpg", "parentId": "folder1", "is_private": ""}, metadata)
+
+	metadata, err = ParseTusMetadata("")
+	require.NoError(t, err)
+	assert.Empty(t, metadata)
+
+	_, err = ParseTusMetadata("filename not*base64")
+	assert.ErrorIs(t, err, werror.ErrBadUploadRequest)
+
+	_, err = ParseTusMetadata("filename YQ==,filename Yg==")
+	assert.ErrorIs(t, err, werror.ErrBadUploadRequest)
+}
+
+func TestParseTusChecksum(t *testing.T) {
+	t.Parallel()
+
+	checksum, err := ParseTusChecksum("sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=")
+	require.NoError(t, err)
+	_, _ = checksum.Hash.Write([]byte("hello world"))
+	assert.Equal(t, checksum.Expected, checksum.Hash.Sum(nil))
+
+	_, err = ParseTusChecksum("crc32 AAAAAA==")
+	assert.ErrorIs(t, err, werror.ErrBadChecksumAlgorithm)
+
+	_, err = ParseTusChecksum("sha256")
+	assert.ErrorIs(t, err, werror.ErrBadUploadRequest)
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"iter"
+	"time"
 
 	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal/werror"
@@ -28,6 +29,13 @@ type User struct {
 	Activated     bool               `bson:"activated"`
 	IsServerOwner bool               `bson:"owner"`
 	SystemUser    bool
+
+	// The most bytes the user may keep in their home folder, including the trash. 0 is no limit.
+	Quota int64 `bson:"quota"`
+
+	// How many days files stay in the trash before they are deleted. 0 uses the server setting,
+	// and -1 keeps files in the trash until they are deleted by hand.
+	TrashRetentionDays int `bson:"trashRetentionDays"`
 }
 
 func NewUser(username Username, password string, isAdmin, autoActivate bool) (*User, error) {
@@ -106,6 +114,24 @@ func (u *User) IsSystemUser() bool {
 	return u.SystemUser
 }
 
+func (u *User) GetQuota() int64 {
+	return u.Quota
+}
+
+// GetTrashRetention is how long files should stay in the trash of the user, given the server default in days.
+// A zero duration means files in the trash never expire.
+func (u *User) GetTrashRetention(serverDays int) time.Duration {
+	days := u.TrashRetentionDays
+	if days == 0 {
+		days = serverDays
+	}
+	if days <= 0 {
+		return 0
+	}
+
+	return time.Duration(days) * time.Hour * 24
+}
+
 func (u *User) CheckLogin(password string) bool {
 	if !u.Activated {
 		return false
@@ -138,6 +164,14 @@ func (u *User) UnmarshalJSON(data []byte) error {
 	u.TrashId = obj["trashId"].(string)
 	u.SystemUser = obj["isSystemUser"].(bool)
 
+	// Users archived before quotas existed will not have one
+	if quota, ok := obj["quota"].(float64); ok {
+		u.Quota = int64(quota)
+	}
+	if retention, ok := obj["trashRetentionDays"].(float64); ok {
+		u.TrashRetentionDays = int(retention)
+	}
+
 	return nil
 }
 
@@ -153,6 +187,8 @@ type UserService interface {
 	GetPublicUser() *User
 	SearchByUsername(searchString string) (iter.Seq[*User], error)
 	SetUserAdmin(*User, bool) error
+	SetUserQuota(u *User, quota int64) error
+	SetTrashRetention(u *User, days int) error
 	ActivateUser(*User, bool) error
 	GetRootUser() *User
 	UpdateUserHome(u *User) error
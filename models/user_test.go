@@ -2,6 +2,7 @@ package models_test
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/ethanrous/weblens/models"
 	"github.com/stretchr/testify/assert"
@@ -30,3 +31,21 @@ func TestUserPassword(t *testing.T) {
 	rightPassCheck := u.CheckLogin(password)
 	assert.True(t, rightPassCheck)
 }
+
+func TestUserTrashRetention(t *testing.T) {
+	t.Parallel()
+
+	u, err := NewUser(Username(username), password, false, false)
+	require.NoError(t, err)
+
+	// With no retention of their own, the user follows the server
+	assert.Equal(t, time.Duration(0), u.GetTrashRetention(0))
+	assert.Equal(t, 30*24*time.Hour, u.GetTrashRetention(30))
+
+	u.TrashRetentionDays = 7
+	assert.Equal(t, 7*24*time.Hour, u.GetTrashRetention(30))
+
+	// Keep forever, even if the server would expire files
+	u.TrashRetentionDays = -1
+	assert.Equal(t, time.Duration(0), u.GetTrashRetention(30))
+}
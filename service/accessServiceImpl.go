@@ -23,10 +23,11 @@ import (
 var _ models.AccessService = (*AccessServiceImpl)(nil)
 
 type AccessServiceImpl struct {
-	userService models.UserService
-	apiKeyMap   map[models.WeblensApiKey]models.ApiKey
-	collection  *mongo.Collection
-	keyMapMu    sync.RWMutex
+	userService    models.UserService
+	libraryService models.LibraryService
+	apiKeyMap      map[models.WeblensApiKey]models.ApiKey
+	collection     *mongo.Collection
+	keyMapMu       sync.RWMutex
 }
 
 type WlClaims struct {
@@ -79,6 +80,12 @@ func (accSrv *AccessServiceImpl) CanUserAccessFile(
 		return true
 	}
 
+	if accSrv.libraryService != nil {
+		if lib := accSrv.libraryService.Get(file.GetPortablePath().RootName()); lib != nil && lib.CanUserAccess(user) {
+			return true
+		}
+	}
+
 	if share == nil || !share.Enabled || (!share.Public && !slices.Contains(share.Accessors, user.GetUsername())) {
 		return false
 	}
@@ -93,6 +100,10 @@ func (accSrv *AccessServiceImpl) CanUserAccessFile(
 	return false
 }
 
+func (accSrv *AccessServiceImpl) SetLibraryService(libraryService models.LibraryService) {
+	accSrv.libraryService = libraryService
+}
+
 func (accSrv *AccessServiceImpl) CanUserModifyShare(user *models.User, share models.Share) bool {
 	return user.GetUsername() == share.GetOwner()
 }
@@ -0,0 +1,435 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ models.ContentIndexService = (*ContentIndexServiceImpl)(nil)
+
+const (
+	// maxExtractedText is the most text we read out of any one file. Only the start of files larger than
+	// this can be searched.
+	maxExtractedText = 8 * 1024 * 1024
+
+	// maxStoredText is how much of the text we keep to build snippets from. Mongo documents are capped
+	// at 16MB, and a search has to read back the text of every file that it matches.
+	maxStoredText = 1024 * 1024
+
+	// maxStoredTermBytes is how much of the entry the terms of a file can take up, counting the bson each
+	// term is wrapped in as an array element, which is about bsonTermOverhead bytes
+	maxStoredTermBytes = 8 * 1024 * 1024
+	bsonTermOverhead   = 16
+
+	// maxContentCandidates is the most files, that pass the search filter, a search will read back
+	// from the database to rank
+	maxContentCandidates = 500
+
+	maxTermLength  = 64
+	maxSnippets    = 3
+	snippetPadding = 60
+
+	pdfExtractTimeout = time.Minute
+)
+
+var textFileExtensions = []string{
+	".txt", ".md", ".markdown", ".rst", ".org", ".tex", ".log", ".csv", ".tsv",
+	".json", ".yaml", ".yml", ".toml", ".ini", ".cfg", ".conf", ".env", ".xml", ".html", ".htm",
+	".css", ".scss", ".js", ".jsx", ".ts", ".tsx", ".vue", ".svelte", ".go", ".py", ".rb", ".rs",
+	".c", ".h", ".cc", ".cpp", ".hpp", ".cs", ".java", ".kt", ".swift", ".php", ".lua", ".pl", ".r",
+	".sh", ".bash", ".zsh", ".fish", ".sql",
+}
+
+type contentIndexEntry struct {
+	FileId    fileTree.FileId  `bson:"_id"`
+	ContentId models.ContentId `bson:"contentId"`
+	Terms     []string         `bson:"terms"`
+	Text      string           `bson:"text"`
+}
+
+type ContentIndexServiceImpl struct {
+	col *mongo.Collection
+
+	// pdfToTextPath is where the pdftotext binary from poppler is, or empty if it is not installed
+	pdfToTextPath string
+}
+
+func NewContentIndexService(col *mongo.Collection) (*ContentIndexServiceImpl, error) {
+	indexModel := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "terms", Value: 1}},
+		},
+	}
+	_, err := col.Indexes().CreateMany(context.Background(), indexModel)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	pdfToTextPath, err := exec.LookPath("pdftotext")
+	if err != nil {
+		log.Warning.Println("pdftotext is not installed, the text of PDF files will not be searchable")
+	}
+
+	return &ContentIndexServiceImpl{col: col, pdfToTextPath: pdfToTextPath}, nil
+}
+
+func (cs *ContentIndexServiceImpl) IsIndexable(f *fileTree.WeblensFileImpl) bool {
+	if f == nil || f.IsDir() {
+		return false
+	}
+
+	ext := strings.ToLower(filepath.Ext(f.Filename()))
+	if ext == ".pdf" {
+		return cs.pdfToTextPath != ""
+	}
+
+	return slices.Contains(textFileExtensions, ext)
+}
+
+func (cs *ContentIndexServiceImpl) IsIndexed(f *fileTree.WeblensFileImpl) bool {
+	count, err := cs.col.CountDocuments(
+		context.Background(), bson.M{"_id": f.ID(), "contentId": f.GetContentId()}, options.Count().SetLimit(1),
+	)
+	if err != nil {
+		log.ErrTrace(werror.WithStack(err))
+		return false
+	}
+
+	return count != 0
+}
+
+func (cs *ContentIndexServiceImpl) Index(f *fileTree.WeblensFileImpl) error {
+	if !cs.IsIndexable(f) {
+		return nil
+	}
+
+	text, err := cs.extractText(f)
+	if err != nil {
+		return err
+	}
+
+	terms := IndexTerms(text)
+	if len(terms) == 0 {
+		return cs.Remove(f.ID())
+	}
+
+	entry := contentIndexEntry{
+		FileId:    f.ID(),
+		ContentId: f.GetContentId(),
+		Terms:     terms,
+		Text:      truncateText(text, maxStoredText),
+	}
+
+	_, err = cs.col.ReplaceOne(
+		context.Background(), bson.M{"_id": entry.FileId}, entry, options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+func (cs *ContentIndexServiceImpl) Remove(fileIds ...fileTree.FileId) error {
+	if len(fileIds) == 0 {
+		return nil
+	}
+
+	_, err := cs.col.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": fileIds}})
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+func (cs *ContentIndexServiceImpl) Search(
+	query string, filter func(fileId fileTree.FileId) bool, limit int,
+) ([]models.ContentMatch, error) {
+	terms := TokenizeText(query)
+	if len(terms) == 0 {
+		return []models.ContentMatch{}, nil
+	}
+	slices.Sort(terms)
+	terms = slices.Compact(terms)
+
+	// The filter is applied to the ids as they are read, before the limit on candidates, so files the filter
+	// does not allow, like those of other users, cannot crowd out the ones it does
+	cursor, err := cs.col.Find(
+		context.Background(), bson.M{"terms": bson.M{"$all": terms}}, options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+	defer cursor.Close(context.Background())
+
+	var candidateIds []fileTree.FileId
+	for len(candidateIds) < maxContentCandidates && cursor.Next(context.Background()) {
+		var entry contentIndexEntry
+		err = cursor.Decode(&entry)
+		if err != nil {
+			return nil, werror.WithStack(err)
+		}
+
+		if filter == nil || filter(entry.FileId) {
+			candidateIds = append(candidateIds, entry.FileId)
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	if len(candidateIds) == 0 {
+		return []models.ContentMatch{}, nil
+	}
+
+	ret, err := cs.col.Find(
+		context.Background(), bson.M{"_id": bson.M{"$in": candidateIds}}, options.Find().SetProjection(bson.M{"text": 1}),
+	)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	var entries []contentIndexEntry
+	err = ret.All(context.Background(), &entries)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	matches := make([]models.ContentMatch, 0, len(entries))
+	for _, entry := range entries {
+		score, snippets := MakeSnippets(entry.Text, terms)
+		matches = append(matches, models.ContentMatch{FileId: entry.FileId, Score: score, Snippets: snippets})
+	}
+
+	slices.SortStableFunc(
+		matches, func(a, b models.ContentMatch) int {
+			return b.Score - a.Score
+		},
+	)
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, nil
+}
+
+// extractText reads the text out of the file. Text files that turn out not to be text are treated as empty.
+func (cs *ContentIndexServiceImpl) extractText(f *fileTree.WeblensFileImpl) (string, error) {
+	if strings.ToLower(filepath.Ext(f.Filename())) == ".pdf" {
+		ctx, cancel := context.WithTimeout(context.Background(), pdfExtractTimeout)
+		defer cancel()
+
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, cs.pdfToTextPath, "-q", "-enc", "UTF-8", f.AbsPath(), "-")
+		cmd.Stdout = &limitedWriter{w: &out, remaining: maxExtractedText}
+		err := cmd.Run()
+		if err != nil {
+			return "", werror.WithStack(err)
+		}
+
+		return strings.ToValidUTF8(out.String(), ""), nil
+	}
+
+	fp, err := f.Readable()
+	if err != nil {
+		return "", err
+	}
+	if closer, ok := fp.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	bs, err := io.ReadAll(io.LimitReader(fp, maxExtractedText))
+	if err != nil {
+		return "", werror.WithStack(err)
+	}
+
+	// The limit may have cut the last character in half, which should not make the whole file look like binary
+	if len(bs) == maxExtractedText {
+		for i := 1; i < utf8.UTFMax && !utf8.Valid(bs); i++ {
+			bs = bs[:len(bs)-1]
+		}
+	}
+
+	if bytes.IndexByte(bs, 0) != -1 || !utf8.Valid(bs) {
+		return "", nil
+	}
+
+	return string(bs), nil
+}
+
+// TokenizeText splits text into the lowercase words that are stored in, and searched for in, the content index
+func TokenizeText(text string) []string {
+	var terms []string
+	for _, span := range tokenSpans([]rune(text)) {
+		terms = append(terms, span.term)
+	}
+
+	return terms
+}
+
+// IndexTerms returns the sorted, unique terms of the text that a file is searched by. Only as many terms as fit in
+// maxStoredTermBytes are kept, taken in the order they first show up, so a file full of unique words, like a log of
+// ids or hashes, does not grow its entry past what mongo can store.
+func IndexTerms(text string) []string {
+	seen := map[string]struct{}{}
+	var terms []string
+	size := 0
+	for _, term := range TokenizeText(text) {
+		if _, ok := seen[term]; ok {
+			continue
+		}
+
+		size += len(term) + bsonTermOverhead
+		if size > maxStoredTermBytes {
+			break
+		}
+
+		seen[term] = struct{}{}
+		terms = append(terms, term)
+	}
+
+	slices.Sort(terms)
+
+	return terms
+}
+
+// MakeSnippets finds the places in the text where the terms show up, and returns how many times they do,
+// along with a few excerpts of the text around them.
+func MakeSnippets(text string, terms []string) (int, []models.TextSnippet) {
+	runes := []rune(text)
+
+	var hits []tokenSpan
+	for _, span := range tokenSpans(runes) {
+		if slices.Contains(terms, span.term) {
+			hits = append(hits, span)
+		}
+	}
+
+	snippets := []models.TextSnippet{}
+	windowEnd := -1
+	for i, hit := range hits {
+		if len(snippets) == maxSnippets {
+			break
+		} else if hit.start < windowEnd {
+			continue
+		}
+
+		start := max(0, hit.start-snippetPadding)
+		end := min(len(runes), hit.end+snippetPadding)
+		windowEnd = end
+
+		snippet := models.TextSnippet{
+			Text:       string(flattenWhitespace(runes[start:end])),
+			Highlights: []models.TextRange{},
+		}
+		for _, inWindow := range hits[i:] {
+			if inWindow.end > end {
+				break
+			}
+			snippet.Highlights = append(
+				snippet.Highlights, models.TextRange{Start: inWindow.start - start, End: inWindow.end - start},
+			)
+		}
+
+		snippets = append(snippets, snippet)
+	}
+
+	return len(hits), snippets
+}
+
+type tokenSpan struct {
+	term       string
+	start, end int
+}
+
+func tokenSpans(runes []rune) []tokenSpan {
+	var spans []tokenSpan
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			if start == -1 {
+				start = i
+			}
+			continue
+		} else if start == -1 {
+			continue
+		}
+
+		// Single characters and very long runs, like base64 blobs, are not useful to search for
+		if length := i - start; length > 1 && length <= maxTermLength {
+			spans = append(spans, tokenSpan{term: strings.ToLower(string(runes[start:i])), start: start, end: i})
+		}
+		start = -1
+	}
+
+	return spans
+}
+
+// flattenWhitespace replaces newlines and tabs with spaces, one for one, so highlight offsets are unchanged
+func flattenWhitespace(runes []rune) []rune {
+	flat := make([]rune, len(runes))
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			r = ' '
+		}
+		flat[i] = r
+	}
+
+	return flat
+}
+
+// truncateText cuts text down to at most maxBytes, without splitting a character in half
+func truncateText(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+
+	// Back up to the start of the character that straddles the limit
+	for maxBytes > 0 && !utf8.RuneStart(text[maxBytes]) {
+		maxBytes--
+	}
+
+	return text[:maxBytes]
+}
+
+// limitedWriter keeps the first bytes written to it, and quietly drops the rest
+type limitedWriter struct {
+	w         io.Writer
+	remaining int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if lw.remaining <= 0 {
+		return n, nil
+	}
+
+	if len(p) > lw.remaining {
+		p = p[:lw.remaining]
+	}
+	_, err := lw.w.Write(p)
+	if err != nil {
+		return 0, err
+	}
+	lw.remaining -= len(p)
+
+	return n, nil
+}
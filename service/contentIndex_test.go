@@ -0,0 +1,105 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTokenizeText(t *testing.T) {
+	t.Parallel()
+
+	terms := service.TokenizeText("The quick, brown fox's den-2024 (Über) a b")
+	assert.Equal(t, []string{"the", "quick", "brown", "fox", "den", "2024", "über"}, terms)
+
+	assert.Empty(t, service.TokenizeText("a . ! ?"))
+}
+
+func TestIndexTerms(t *testing.T) {
+	t.Parallel()
+
+	terms := service.IndexTerms("history of the file, and the history of the folder")
+	assert.Equal(t, []string{"and", "file", "folder", "history", "of", "the"}, terms)
+
+	// A log full of unique ids keeps only the terms that fit in a mongo document, from the start of the file
+	var sb strings.Builder
+	for i := range 400_000 {
+		sb.WriteString(fmt.Sprintf("%032x\n", i))
+	}
+	terms = service.IndexTerms(sb.String())
+	assert.Less(t, len(terms), 400_000)
+	assert.Contains(t, terms, fmt.Sprintf("%032x", 0))
+	assert.NotContains(t, terms, fmt.Sprintf("%032x", 399_999))
+
+	bs, err := bson.Marshal(bson.M{"terms": terms})
+	require.NoError(t, err)
+	assert.Less(t, len(bs), 15*1024*1024)
+}
+
+func TestMakeSnippets(t *testing.T) {
+	t.Parallel()
+
+	text := "Weblens keeps a history of every file.\nThe history can be used to restore a file."
+	score, snippets := service.MakeSnippets(text, []string{"history", "restore"})
+	assert.Equal(t, 3, score)
+	require.Len(t, snippets, 1)
+
+	snippet := snippets[0]
+	assert.NotContains(t, snippet.Text, "\n")
+
+	runes := []rune(snippet.Text)
+	var highlighted []string
+	for _, h := range snippet.Highlights {
+		highlighted = append(highlighted, string(runes[h.Start:h.End]))
+	}
+	assert.Equal(t, []string{"history", "history", "restore"}, highlighted)
+
+	score, snippets = service.MakeSnippets(text, []string{"missing"})
+	assert.Equal(t, 0, score)
+	assert.Empty(t, snippets)
+}
+
+func TestContentIndexServiceImpl_SearchFilter(t *testing.T) {
+	t.Parallel()
+
+	col := mondb.Collection(t.Name())
+	err := col.Drop(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	defer col.Drop(context.Background())
+
+	cs, err := service.NewContentIndexService(col)
+	require.NoError(t, err)
+
+	// Far more files that the filter does not allow than a search will rank, all inserted before the one it does
+	var docs []any
+	for i := range 1000 {
+		docs = append(docs, bson.M{"_id": "other-" + strconv.Itoa(i), "terms": []string{"report"}, "text": "report"})
+	}
+	_, err = col.InsertMany(context.Background(), docs)
+	require.NoError(t, err)
+	_, err = col.InsertOne(context.Background(), bson.M{"_id": "mine", "terms": []string{"report"}, "text": "my report"})
+	require.NoError(t, err)
+
+	matches, err := cs.Search(
+		"report", func(fileId fileTree.FileId) bool {
+			return fileId == "mine"
+		}, 10,
+	)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "mine", matches[0].FileId)
+
+	matches, err = cs.Search("missing", nil, 10)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
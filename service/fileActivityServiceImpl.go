@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ models.FileActivityService = (*FileActivityServiceImpl)(nil)
+
+// maxRecentDownloads is how many downloads are kept for each user. Older ones are dropped as new ones come in.
+const maxRecentDownloads = 200
+
+type FileActivityServiceImpl struct {
+	col *mongo.Collection
+}
+
+func NewFileActivityService(col *mongo.Collection) (*FileActivityServiceImpl, error) {
+	indexModel := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}, {Key: "kind", Value: 1}, {Key: "fileId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "fileId", Value: 1}},
+		},
+	}
+	_, err := col.Indexes().CreateMany(context.Background(), indexModel)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return &FileActivityServiceImpl{col: col}, nil
+}
+
+func (as *FileActivityServiceImpl) Star(username models.Username, fileId fileTree.FileId) error {
+	filter := bson.M{"username": username, "kind": models.FileStarred, "fileId": fileId}
+
+	// Starring a file again keeps when it was first starred
+	_, err := as.col.UpdateOne(
+		context.Background(), filter, bson.M{"$setOnInsert": bson.M{"timestamp": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+func (as *FileActivityServiceImpl) Unstar(username models.Username, fileId fileTree.FileId) error {
+	_, err := as.col.DeleteOne(
+		context.Background(), bson.M{"username": username, "kind": models.FileStarred, "fileId": fileId},
+	)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+func (as *FileActivityServiceImpl) RecordDownload(username models.Username, fileId fileTree.FileId) error {
+	filter := bson.M{"username": username, "kind": models.FileDownloaded, "fileId": fileId}
+	_, err := as.col.UpdateOne(
+		context.Background(), filter, bson.M{"$set": bson.M{"timestamp": time.Now()}}, options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	// Find where the oldest download we want to keep is, and drop anything older than that
+	opts := options.FindOne().SetSort(bson.M{"timestamp": -1}).SetSkip(maxRecentDownloads - 1)
+	var oldestKept models.FileActivity
+	err = as.col.FindOne(context.Background(), bson.M{"username": username, "kind": models.FileDownloaded}, opts).Decode(&oldestKept)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	} else if err != nil {
+		return werror.WithStack(err)
+	}
+
+	_, err = as.col.DeleteMany(
+		context.Background(), bson.M{
+			"username":  username,
+			"kind":      models.FileDownloaded,
+			"timestamp": bson.M{"$lt": oldestKept.Timestamp},
+		},
+	)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+func (as *FileActivityServiceImpl) GetActivity(username models.Username, kind models.FileActivityKind) (
+	[]models.FileActivity, error,
+) {
+	opts := options.Find().SetSort(bson.M{"timestamp": -1})
+	cur, err := as.col.Find(context.Background(), bson.M{"username": username, "kind": kind}, opts)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	var activity []models.FileActivity
+	err = cur.All(context.Background(), &activity)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return activity, nil
+}
+
+func (as *FileActivityServiceImpl) RemoveFiles(fileIds ...fileTree.FileId) error {
+	_, err := as.col.DeleteMany(context.Background(), bson.M{"fileId": bson.M{"$in": fileIds}})
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
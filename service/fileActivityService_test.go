@@ -0,0 +1,62 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/models"
+	. "github.com/ethanrous/weblens/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileActivityService(t *testing.T) {
+	t.Parallel()
+
+	col := mondb.Collection(t.Name())
+	err := col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	as, err := NewFileActivityService(col)
+	require.NoError(t, err)
+
+	// Times are only stored to the millisecond, so the sleeps keep the order the same every run
+	require.NoError(t, as.Star("alice", "file1"))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, as.Star("alice", "file2"))
+	require.NoError(t, as.Star("alice", "file1"))
+	require.NoError(t, as.Star("bob", "file1"))
+
+	stars, err := as.GetActivity("alice", models.FileStarred)
+	require.NoError(t, err)
+	require.Len(t, stars, 2)
+	assert.Equal(t, "file2", stars[0].FileId)
+
+	require.NoError(t, as.Unstar("alice", "file2"))
+	stars, err = as.GetActivity("alice", models.FileStarred)
+	require.NoError(t, err)
+	require.Len(t, stars, 1)
+
+	// Downloading a file again moves it back to the front
+	require.NoError(t, as.RecordDownload("alice", "file1"))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, as.RecordDownload("alice", "file2"))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, as.RecordDownload("alice", "file1"))
+	downloads, err := as.GetActivity("alice", models.FileDownloaded)
+	require.NoError(t, err)
+	require.Len(t, downloads, 2)
+	assert.Equal(t, "file1", downloads[0].FileId)
+
+	// Deleting a file forgets it for everyone
+	require.NoError(t, as.RemoveFiles("file1"))
+	stars, err = as.GetActivity("bob", models.FileStarred)
+	require.NoError(t, err)
+	assert.Empty(t, stars)
+	downloads, err = as.GetActivity("alice", models.FileDownloaded)
+	require.NoError(t, err)
+	require.Len(t, downloads, 1)
+	assert.Equal(t, "file2", downloads[0].FileId)
+}
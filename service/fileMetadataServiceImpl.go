@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ models.FileMetadataService = (*FileMetadataServiceImpl)(nil)
+
+const (
+	maxFileTags          = 64
+	maxTagLength         = 64
+	maxFileProperties    = 64
+	maxPropertyKeyLength = 64
+	maxPropertyValueSize = 1024
+)
+
+// FileMetadataServiceImpl keeps all the metadata in memory as well as in the database, so it can be added to
+// every file in a folder listing, and checked against every file in a search, without going to the database.
+type FileMetadataServiceImpl struct {
+	metadata map[fileTree.FileId]models.FileMetadata
+	col      *mongo.Collection
+
+	mu sync.RWMutex
+}
+
+func NewFileMetadataService(col *mongo.Collection) (*FileMetadataServiceImpl, error) {
+	cur, err := col.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	var all []models.FileMetadata
+	err = cur.All(context.Background(), &all)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	ms := &FileMetadataServiceImpl{
+		metadata: make(map[fileTree.FileId]models.FileMetadata, len(all)),
+		col:      col,
+	}
+	for _, m := range all {
+		ms.metadata[m.FileId] = m
+	}
+
+	return ms, nil
+}
+
+func (ms *FileMetadataServiceImpl) Get(fileId fileTree.FileId) models.FileMetadata {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.get(fileId)
+}
+
+func (ms *FileMetadataServiceImpl) get(fileId fileTree.FileId) models.FileMetadata {
+	m, ok := ms.metadata[fileId]
+	if !ok {
+		return models.FileMetadata{FileId: fileId, Tags: []string{}, Properties: map[string]string{}}
+	}
+
+	// Callers get their own copy, so they cannot change what is stored out from under the lock
+	m.Tags = append([]string{}, m.Tags...)
+	m.Properties = maps.Clone(m.Properties)
+	if m.Properties == nil {
+		m.Properties = map[string]string{}
+	}
+
+	return m
+}
+
+func (ms *FileMetadataServiceImpl) Update(fileId fileTree.FileId, update models.FileMetadataUpdate) (models.FileMetadata, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	m := ms.get(fileId)
+
+	for _, tag := range update.RemoveTags {
+		m.Tags = slices.DeleteFunc(m.Tags, func(t string) bool { return strings.EqualFold(t, strings.TrimSpace(tag)) })
+	}
+	for _, tag := range update.AddTags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || strings.ContainsAny(tag, ",\"") {
+			return models.FileMetadata{}, werror.WithStack(werror.ErrBadFileMetadata.WithArg(fmt.Sprintf("bad tag %q", tag)))
+		}
+		if !m.HasTag(tag) {
+			m.Tags = append(m.Tags, tag)
+		}
+	}
+	if len(m.Tags) > maxFileTags {
+		return models.FileMetadata{}, werror.WithStack(werror.ErrBadFileMetadata.WithArg(fmt.Sprintf("a file can have at most %d tags", maxFileTags)))
+	}
+
+	for _, key := range update.RemoveProperties {
+		delete(m.Properties, key)
+	}
+	for key, value := range update.SetProperties {
+		if key == "" || utf8.RuneCountInString(key) > maxPropertyKeyLength || strings.Contains(key, "=") {
+			return models.FileMetadata{}, werror.WithStack(werror.ErrBadFileMetadata.WithArg(fmt.Sprintf("bad property key %q", key)))
+		}
+		if len(value) > maxPropertyValueSize {
+			return models.FileMetadata{}, werror.WithStack(werror.ErrBadFileMetadata.WithArg(fmt.Sprintf("value of %s is too long", key)))
+		}
+		m.Properties[key] = value
+	}
+	if len(m.Properties) > maxFileProperties {
+		return models.FileMetadata{}, werror.WithStack(werror.ErrBadFileMetadata.WithArg(fmt.Sprintf("a file can have at most %d properties", maxFileProperties)))
+	}
+
+	if len(m.Tags) == 0 && len(m.Properties) == 0 {
+		_, err := ms.col.DeleteOne(context.Background(), bson.M{"_id": fileId})
+		if err != nil {
+			return models.FileMetadata{}, werror.WithStack(err)
+		}
+		delete(ms.metadata, fileId)
+
+		return m, nil
+	}
+
+	_, err := ms.col.ReplaceOne(context.Background(), bson.M{"_id": fileId}, m, options.Replace().SetUpsert(true))
+	if err != nil {
+		return models.FileMetadata{}, werror.WithStack(err)
+	}
+	ms.metadata[fileId] = m
+
+	return m, nil
+}
+
+func (ms *FileMetadataServiceImpl) Remove(fileIds ...fileTree.FileId) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var toDelete []fileTree.FileId
+	for _, fileId := range fileIds {
+		if _, ok := ms.metadata[fileId]; ok {
+			toDelete = append(toDelete, fileId)
+		}
+	}
+	if len(toDelete) == 0 {
+		return nil
+	}
+
+	_, err := ms.col.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": toDelete}})
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	for _, fileId := range toDelete {
+		delete(ms.metadata, fileId)
+	}
+
+	return nil
+}
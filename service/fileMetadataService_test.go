@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	. "github.com/ethanrous/weblens/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFileMetadataService(t *testing.T) {
+	t.Parallel()
+
+	col := mondb.Collection(t.Name())
+	err := col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	ms, err := NewFileMetadataService(col)
+	require.NoError(t, err)
+
+	empty := ms.Get("file1")
+	assert.Empty(t, empty.Tags)
+	assert.Empty(t, empty.Properties)
+
+	m, err := ms.Update(
+		"file1", models.FileMetadataUpdate{
+			AddTags:       []string{"Trips", " 2024 ", "trips"},
+			SetProperties: map[string]string{"camera": "X100V", "rating": "4"},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Trips", "2024"}, m.Tags)
+	assert.True(t, m.HasTag("TRIPS"))
+
+	m, err = ms.Update("file1", models.FileMetadataUpdate{RemoveTags: []string{"TRIPS"}, RemoveProperties: []string{"rating"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024"}, m.Tags)
+	assert.Equal(t, map[string]string{"camera": "X100V"}, m.Properties)
+
+	_, err = ms.Update("file1", models.FileMetadataUpdate{AddTags: []string{"a,b"}})
+	assert.ErrorIs(t, err, werror.ErrBadFileMetadata)
+	_, err = ms.Update("file1", models.FileMetadataUpdate{SetProperties: map[string]string{"": "empty key"}})
+	assert.ErrorIs(t, err, werror.ErrBadFileMetadata)
+
+	// A failed update changes nothing
+	assert.Equal(t, []string{"2024"}, ms.Get("file1").Tags)
+
+	// What is stored is read back when the service starts again
+	reloaded, err := NewFileMetadataService(col)
+	require.NoError(t, err)
+	assert.Equal(t, m.Tags, reloaded.Get("file1").Tags)
+	assert.Equal(t, m.Properties, reloaded.Get("file1").Properties)
+
+	err = reloaded.Remove("file1", "file2")
+	require.NoError(t, err)
+	assert.Empty(t, reloaded.Get("file1").Tags)
+
+	count, err := col.CountDocuments(context.Background(), bson.M{})
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -42,6 +43,7 @@ type FileServiceImpl struct {
 	accessService   models.AccessService
 	mediaService    models.MediaService
 	instanceService models.InstanceService
+	libraryService  models.LibraryService
 
 	trees map[string]fileTree.FileTree
 
@@ -53,6 +55,10 @@ type FileServiceImpl struct {
 
 	folderCoverCol *mongo.Collection
 
+	// quotaReservations are the bytes that uploads have declared but not yet written, by reservation id and
+	// then by the user whose quota they count against
+	quotaReservations map[string]map[models.Username]int64
+
 	log log.Bundle
 
 	treesLock sync.RWMutex
@@ -60,6 +66,8 @@ type FileServiceImpl struct {
 	contentIdLock sync.RWMutex
 
 	fileTaskLock sync.RWMutex
+
+	quotaLock sync.Mutex
 }
 
 type TrashEntry struct {
@@ -92,6 +100,8 @@ func NewFileService(
 		folderCoverCol:  folderCoverCol,
 		fileTaskLink:    make(map[fileTree.FileId][]*task.Task),
 		folderMedia:     make(map[fileTree.FileId]models.ContentId),
+
+		quotaReservations: make(map[string]map[models.Username]int64),
 	}
 
 	for _, tree := range trees {
@@ -130,7 +140,7 @@ func NewFileService(
 }
 
 func (fs *FileServiceImpl) Size(treeAlias string) int64 {
-	tree := fs.trees[treeAlias]
+	tree := fs.GetFileTreeByName(treeAlias)
 	if tree == nil {
 		return -1
 	}
@@ -146,6 +156,10 @@ func (fs *FileServiceImpl) SetMediaService(mediaService *MediaServiceImpl) {
 	fs.mediaService = mediaService
 }
 
+func (fs *FileServiceImpl) SetLibraryService(libraryService models.LibraryService) {
+	fs.libraryService = libraryService
+}
+
 func (fs *FileServiceImpl) GetFileByTree(id fileTree.FileId, treeAlias string) (*fileTree.WeblensFileImpl, error) {
 	return fs.getFileByIdAndRoot(id, treeAlias)
 }
@@ -181,7 +195,7 @@ func (fs *FileServiceImpl) GetFileByContentId(contentId models.ContentId) (*file
 }
 
 func (fs *FileServiceImpl) GetFiles(ids []fileTree.FileId) ([]*fileTree.WeblensFileImpl, []fileTree.FileId, error) {
-	usersTree := fs.trees[UsersTreeKey]
+	usersTree := fs.GetFileTreeByName(UsersTreeKey)
 	if usersTree == nil {
 		return nil, nil, werror.WithStack(werror.ErrNoFileTree.WithArg(UsersTreeKey))
 	}
@@ -201,7 +215,7 @@ func (fs *FileServiceImpl) GetFiles(ids []fileTree.FileId) ([]*fileTree.WeblensF
 				lostFiles = append(lostFiles, id)
 				continue
 			}
-			f, err := fs.trees[RestoreTreeKey].GetRoot().GetChild(contentId)
+			f, err := fs.GetFileTreeByName(RestoreTreeKey).GetRoot().GetChild(contentId)
 			if err != nil {
 				lostFiles = append(lostFiles, id)
 				continue
@@ -223,12 +237,15 @@ func (fs *FileServiceImpl) GetFileSafe(id fileTree.FileId, user *models.User, sh
 	*fileTree.WeblensFileImpl,
 	error,
 ) {
-	tree := fs.trees[UsersTreeKey]
+	tree := fs.GetFileTreeByName(UsersTreeKey)
 	if tree == nil {
 		return nil, werror.WithStack(werror.ErrNoFileTree)
 	}
 
 	f := tree.Get(id)
+	if f == nil {
+		f = fs.getLibraryFile(id)
+	}
 	if f == nil {
 		return nil, werror.WithStack(werror.ErrNoFile.WithArg(id))
 	}
@@ -245,11 +262,14 @@ func (fs *FileServiceImpl) GetFileSafe(id fileTree.FileId, user *models.User, sh
 }
 
 func (fs *FileServiceImpl) GetFileTreeByName(treeName string) fileTree.FileTree {
+	// Libraries can be added and removed while the server is running
+	fs.treesLock.RLock()
+	defer fs.treesLock.RUnlock()
 	return fs.trees[treeName]
 }
 
 func (fs *FileServiceImpl) GetMediaCacheByFilename(thumbFileName string) (*fileTree.WeblensFileImpl, error) {
-	thumbsDir, err := fs.trees[CachesTreeKey].GetRoot().GetChild(ThumbsDirName)
+	thumbsDir, err := fs.GetFileTreeByName(CachesTreeKey).GetRoot().GetChild(ThumbsDirName)
 	if err != nil {
 		return nil, err
 	}
@@ -270,11 +290,11 @@ func (fs *FileServiceImpl) NewCacheFile(
 		return nil, err
 	}
 
-	return fs.trees[CachesTreeKey].Touch(thumbsDir, filename, nil)
+	return fs.GetFileTreeByName(CachesTreeKey).Touch(thumbsDir, filename, nil)
 }
 
 func (fs *FileServiceImpl) DeleteCacheFile(f fileTree.WeblensFile) error {
-	_, err := fs.trees[CachesTreeKey].Remove(f.ID())
+	_, err := fs.GetFileTreeByName(CachesTreeKey).Remove(f.ID())
 	if err != nil {
 		return err
 	}
@@ -284,7 +304,11 @@ func (fs *FileServiceImpl) DeleteCacheFile(f fileTree.WeblensFile) error {
 func (fs *FileServiceImpl) CreateFile(parent *fileTree.WeblensFileImpl, filename string, event *fileTree.FileEvent, caster models.FileCaster) (
 	*fileTree.WeblensFileImpl, error,
 ) {
-	newF, err := fs.trees[UsersTreeKey].Touch(parent, filename, event)
+	if err := checkWritable(parent); err != nil {
+		return nil, err
+	}
+
+	newF, err := fs.GetFileTreeByName(UsersTreeKey).Touch(parent, filename, event)
 	if err != nil {
 		return nil, err
 	}
@@ -296,8 +320,11 @@ func (fs *FileServiceImpl) CreateFolder(parent *fileTree.WeblensFileImpl, folder
 	*fileTree.WeblensFileImpl,
 	error,
 ) {
+	if err := checkWritable(parent); err != nil {
+		return nil, err
+	}
 
-	newF, err := fs.trees[UsersTreeKey].MkDir(parent, folderName, event)
+	newF, err := fs.GetFileTreeByName(UsersTreeKey).MkDir(parent, folderName, event)
 	if err != nil {
 		return newF, err
 	}
@@ -308,13 +335,13 @@ func (fs *FileServiceImpl) CreateFolder(parent *fileTree.WeblensFileImpl, folder
 }
 
 func (fs *FileServiceImpl) CreateUserHome(user *models.User) error {
-	home, err := fs.trees[UsersTreeKey].MkDir(fs.trees[UsersTreeKey].GetRoot(), user.GetUsername(), nil)
+	home, err := fs.GetFileTreeByName(UsersTreeKey).MkDir(fs.GetFileTreeByName(UsersTreeKey).GetRoot(), user.GetUsername(), nil)
 	if err != nil && !errors.Is(err, werror.ErrDirAlreadyExists) {
 		return err
 	}
 	user.SetHomeFolder(home)
 
-	trash, err := fs.trees[UsersTreeKey].MkDir(home, UserTrashDirName, nil)
+	trash, err := fs.GetFileTreeByName(UsersTreeKey).MkDir(home, UserTrashDirName, nil)
 	if err != nil && !errors.Is(err, werror.ErrDirAlreadyExists) {
 		return err
 	}
@@ -326,6 +353,12 @@ func (fs *FileServiceImpl) CreateUserHome(user *models.User) error {
 func (fs *FileServiceImpl) GetFileOwner(file *fileTree.WeblensFileImpl) (*models.User, error) {
 	portable := file.GetPortablePath()
 	if portable.RootName() != UsersTreeKey {
+		// Libraries belong to the admin that attached them
+		if fs.libraryService != nil {
+			if lib := fs.libraryService.Get(portable.RootName()); lib != nil {
+				return fs.userService.Get(lib.GetOwner()), nil
+			}
+		}
 		return nil, werror.Errorf("trying to get owner of file not in MEDIA tree")
 	}
 
@@ -341,11 +374,112 @@ func (fs *FileServiceImpl) GetFileOwner(file *fileTree.WeblensFileImpl) (*models
 	return u, nil
 }
 
+func (fs *FileServiceImpl) CheckQuota(destination *fileTree.WeblensFileImpl, addBytes int64) error {
+	fs.quotaLock.Lock()
+	defer fs.quotaLock.Unlock()
+
+	_, err := fs.checkQuota(destination, addBytes)
+	return err
+}
+
+func (fs *FileServiceImpl) ReserveQuota(destination *fileTree.WeblensFileImpl, reservationId string, addBytes int64) error {
+	fs.quotaLock.Lock()
+	defer fs.quotaLock.Unlock()
+
+	owner, err := fs.checkQuota(destination, addBytes)
+	if err != nil || owner == nil || addBytes <= 0 {
+		return err
+	}
+
+	if fs.quotaReservations[reservationId] == nil {
+		fs.quotaReservations[reservationId] = map[models.Username]int64{}
+	}
+	fs.quotaReservations[reservationId][owner.GetUsername()] += addBytes
+
+	return nil
+}
+
+func (fs *FileServiceImpl) ReleaseQuota(reservationId string) {
+	fs.quotaLock.Lock()
+	defer fs.quotaLock.Unlock()
+
+	delete(fs.quotaReservations, reservationId)
+}
+
+// checkQuota returns the owner of destination, who has a quota, if they have room for addBytes more
+// on top of what they have used and reserved. The quota lock must be held.
+func (fs *FileServiceImpl) checkQuota(destination *fileTree.WeblensFileImpl, addBytes int64) (*models.User, error) {
+	// Nothing can be added to a library, no matter how much quota is left
+	if err := checkWritable(destination); err != nil {
+		return nil, err
+	}
+
+	if addBytes <= 0 {
+		return nil, nil
+	}
+
+	owner, err := fs.GetFileOwner(destination)
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil || owner.GetQuota() <= 0 {
+		return nil, nil
+	}
+
+	home := fs.GetFileTreeByName(UsersTreeKey).Get(owner.HomeId)
+	if home == nil {
+		return nil, werror.WithStack(werror.ErrNoFile.WithArg(owner.HomeId))
+	}
+
+	var reserved int64
+	for _, byOwner := range fs.quotaReservations {
+		reserved += byOwner[owner.GetUsername()]
+	}
+
+	// The home folder size is kept up to date by ResizeUp, and includes the trash
+	used := home.Size() + reserved
+	if used+addBytes > owner.GetQuota() {
+		return nil, werror.WithStack(
+			werror.ErrQuotaExceeded.WithArg(
+				fmt.Sprintf(
+					"%s needs %d bytes, but has used or reserved %d of %d", owner.GetUsername(), addBytes, used,
+					owner.GetQuota(),
+				),
+			),
+		)
+	}
+
+	return owner, nil
+}
+
+// checkTransferQuota checks the quota of the owner of destFolder for any of the files that are owned by someone else
+func (fs *FileServiceImpl) checkTransferQuota(files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl) error {
+	newOwner, err := fs.GetFileOwner(destFolder)
+	if err != nil {
+		return err
+	}
+
+	var transferSize int64
+	for _, file := range files {
+		owner, err := fs.GetFileOwner(file)
+		if err != nil {
+			return err
+		}
+		if owner != newOwner {
+			transferSize += file.Size()
+		}
+	}
+
+	return fs.CheckQuota(destFolder, transferSize)
+}
+
 func (fs *FileServiceImpl) MoveFilesToTrash(
 	files []*fileTree.WeblensFileImpl, user *models.User, share *models.FileShare, caster models.FileCaster,
 ) error {
 	if len(files) == 0 {
 		return nil
+	} else if err := checkWritable(files...); err != nil {
+		return err
 	}
 
 	owner, err := fs.GetFileOwner(files[0])
@@ -353,7 +487,7 @@ func (fs *FileServiceImpl) MoveFilesToTrash(
 		return err
 	}
 
-	tree := fs.trees[UsersTreeKey]
+	tree := fs.GetFileTreeByName(UsersTreeKey)
 	if tree == nil {
 		return werror.WithStack(werror.ErrNoFileTree)
 	}
@@ -377,7 +511,7 @@ func (fs *FileServiceImpl) MoveFilesToTrash(
 			return werror.WithStack(werror.ErrNoFileAccess)
 		}
 
-		newFilename := MakeUniqueChildName(trash, file.Filename())
+		newFilename := trash.UniqueChildName(file.Filename())
 		preMoveFile := file.Freeze()
 
 		_, err := tree.Move(file, trash, newFilename, false, event)
@@ -407,10 +541,14 @@ func (fs *FileServiceImpl) MoveFilesToTrash(
 func (fs *FileServiceImpl) ReturnFilesFromTrash(
 	trashFiles []*fileTree.WeblensFileImpl, c models.FileCaster,
 ) error {
+	if err := checkWritable(trashFiles...); err != nil {
+		return err
+	}
+
 	trash := trashFiles[0].GetParent()
 	trashPath := trash.GetPortablePath().ToPortable()
 
-	tree := fs.trees[UsersTreeKey]
+	tree := fs.GetFileTreeByName(UsersTreeKey)
 	if tree == nil {
 		return werror.WithStack(werror.ErrNoFileTree)
 	}
@@ -462,12 +600,16 @@ func (fs *FileServiceImpl) ReturnFilesFromTrash(
 func (fs *FileServiceImpl) DeleteFiles(
 	files []*fileTree.WeblensFileImpl, treeName string, caster models.FileCaster,
 ) error {
-	tree := fs.trees[treeName]
+	if err := checkWritable(files...); err != nil {
+		return err
+	}
+
+	tree := fs.GetFileTreeByName(treeName)
 	if tree == nil {
 		return werror.WithStack(werror.ErrNoFileTree)
 	}
 
-	restoreTree := fs.trees[RestoreTreeKey]
+	restoreTree := fs.GetFileTreeByName(RestoreTreeKey)
 	if restoreTree == nil {
 		return werror.WithStack(werror.ErrNoFileTree)
 	}
@@ -610,12 +752,57 @@ func (fs *FileServiceImpl) DeleteFiles(
 	return nil
 }
 
+func (fs *FileServiceImpl) EmptyTrash(user *models.User, trashedBefore time.Time, caster models.FileCaster) (int, error) {
+	tree := fs.GetFileTreeByName(UsersTreeKey)
+	if tree == nil {
+		return 0, werror.WithStack(werror.ErrNoFileTree.WithArg(UsersTreeKey))
+	}
+
+	trash := tree.Get(user.TrashId)
+	if trash == nil {
+		return 0, werror.WithStack(werror.ErrNoFile.WithArg(user.TrashId))
+	}
+
+	journal := tree.GetJournal()
+
+	var expired []*fileTree.WeblensFileImpl
+	for _, child := range trash.GetChildren() {
+		// The last move of a file in the trash is the move into the trash
+		trashedAt := child.ModTime()
+		if lt := journal.Get(child.ID()); lt != nil {
+			if move := lt.GetLatestMove(); move != nil {
+				trashedAt = move.GetTimestamp()
+			}
+		}
+
+		if trashedAt.Before(trashedBefore) {
+			expired = append(expired, child)
+		}
+	}
+
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	err := fs.DeleteFiles(expired, UsersTreeKey, caster)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(expired), nil
+}
+
 func (fs *FileServiceImpl) RestoreFiles(
-	ids []fileTree.FileId, newParent *fileTree.WeblensFileImpl, restoreTime time.Time, caster models.FileCaster,
-) error {
-	usersTree := fs.trees[UsersTreeKey]
+	ids []fileTree.FileId, newParent *fileTree.WeblensFileImpl, restoreTime time.Time, policy models.ConflictPolicy,
+	caster models.FileCaster,
+) ([]models.FileConflict, error) {
+	if err := checkWritable(newParent); err != nil {
+		return nil, err
+	}
+
+	usersTree := fs.GetFileTreeByName(UsersTreeKey)
 	if usersTree == nil {
-		return werror.WithStack(werror.ErrNoFileTree.WithArg(UsersTreeKey))
+		return nil, werror.WithStack(werror.ErrNoFileTree.WithArg(UsersTreeKey))
 	}
 
 	journal := usersTree.GetJournal()
@@ -629,14 +816,39 @@ func (fs *FileServiceImpl) RestoreFiles(
 	}
 
 	var restorePairs []restorePair
+	var restoreSize int64
+	var conflicts []models.FileConflict
 	for _, id := range ids {
 		lt := journal.Get(id)
 		if lt == nil {
-			return werror.Errorf("journal does not have file to restore")
+			return nil, werror.Errorf("journal does not have file to restore")
 		}
 		restorePairs = append(
-			restorePairs, restorePair{fileId: id, newParent: newParent, contentId: lt.ContentId},
+			restorePairs, restorePair{fileId: id, newParent: newParent, contentId: lt.ContentIdAt(restoreTime)},
 		)
+
+		pastFile, err := journal.GetPastFile(id, restoreTime)
+		if err != nil {
+			return nil, err
+		}
+		restoreSize += pastFile.Size()
+
+		if existing, _ := newParent.GetChild(pastFileName(pastFile)); existing != nil && policy == models.ConflictFail {
+			conflicts = append(
+				conflicts, models.FileConflict{
+					FileId: id, ExistingId: existing.ID(), Filename: existing.Filename(), Resolution: policy,
+				},
+			)
+		}
+	}
+
+	if len(conflicts) != 0 {
+		return conflicts, werror.WithStack(werror.ErrFileAlreadyExists)
+	}
+
+	err := fs.CheckQuota(newParent, restoreSize)
+	if err != nil {
+		return nil, err
 	}
 
 	for len(restorePairs) != 0 {
@@ -645,14 +857,14 @@ func (fs *FileServiceImpl) RestoreFiles(
 
 		pastFile, err := journal.GetPastFile(toRestore.fileId, restoreTime)
 		if err != nil {
-			return err
+			return conflicts, err
 		}
 
 		var childIds []fileTree.FileId
 		if pastFile.IsDir() {
 			children, err := journal.GetPastFolderChildren(pastFile, restoreTime)
 			if err != nil {
-				return err
+				return conflicts, err
 			}
 
 			childIds = internal.Map(
@@ -662,26 +874,60 @@ func (fs *FileServiceImpl) RestoreFiles(
 			)
 		}
 
-		path := pastFile.GetPortablePath().ToPortable()
-		// Paths of directory files will have an extra / on the end, so we need to remove it
-		if pastFile.IsDir() {
-			path = path[:len(path)-1]
-		}
+		oldName := pastFileName(pastFile)
+		newName := oldName
+
+		if existing, _ := toRestore.newParent.GetChild(oldName); existing != nil {
+			conflict := models.FileConflict{
+				FileId: toRestore.fileId, ExistingId: existing.ID(), Filename: oldName, Resolution: policy,
+			}
 
-		oldName := filepath.Base(path)
-		newName := MakeUniqueChildName(toRestore.newParent, oldName)
+			switch {
+			case policy == models.ConflictSkip:
+				conflicts = append(conflicts, conflict)
+				continue
+			case policy == models.ConflictMerge && pastFile.IsDir() && existing.IsDir():
+				// Restore what was in the folder into the one that is already there
+				conflicts = append(conflicts, conflict)
+				for _, childId := range childIds {
+					childLt := journal.Get(childId)
+					if childLt == nil {
+						return conflicts, werror.WithStack(werror.ErrNoFile)
+					}
+					restorePairs = append(
+						restorePairs,
+						restorePair{fileId: childId, newParent: existing, contentId: childLt.ContentIdAt(restoreTime)},
+					)
+				}
+				if toRestore.newParent == newParent {
+					topFiles = append(topFiles, existing)
+				}
+				continue
+			case policy == models.ConflictOverwrite:
+				conflicts = append(conflicts, conflict)
+				err = fs.DeleteFiles([]*fileTree.WeblensFileImpl{existing}, UsersTreeKey, caster)
+				if err != nil {
+					return conflicts, err
+				}
+			default:
+				newName = toRestore.newParent.UniqueChildName(oldName)
+				conflict.Resolution = models.ConflictKeepBoth
+				conflict.NewName = newName
+				conflicts = append(conflicts, conflict)
+			}
+		}
 
 		var restoredF *fileTree.WeblensFileImpl
 		if !pastFile.IsDir() {
 			var existingPath string
 
-			// File has been deleted, get the file from the restore tree
-			if liveF := usersTree.Get(toRestore.fileId); liveF == nil {
-				_, err = fs.trees[RestoreTreeKey].GetRoot().GetChild(toRestore.contentId)
+			// File has been deleted or its content has since been modified, get the file from the restore tree
+			if liveF := usersTree.Get(toRestore.fileId); liveF == nil || liveF.GetContentId() != toRestore.contentId {
+				_, err = fs.GetFileTreeByName(RestoreTreeKey).GetRoot().GetChild(toRestore.contentId)
 				if err != nil {
-					return err
+					return conflicts, err
 				}
-				existingPath = filepath.Join(fs.trees[RestoreTreeKey].GetRoot().AbsPath(), toRestore.contentId)
+				existingPath = filepath.Join(fs.GetFileTreeByName(RestoreTreeKey).GetRoot().AbsPath(), toRestore.contentId)
 			} else {
 				existingPath = liveF.AbsPath()
 			}
@@ -693,13 +939,13 @@ func (fs *FileServiceImpl) RestoreFiles(
 			restoredF.SetSize(pastFile.Size())
 			err = usersTree.Add(restoredF)
 			if err != nil {
-				return err
+				return conflicts, err
 			}
 
 			fs.log.Trace.Func(func(l log.Logger) { l.Printf("Restoring file [%s] to [%s]", existingPath, restoredF.AbsPath()) })
 			err = os.Link(existingPath, restoredF.AbsPath())
 			if err != nil {
-				return werror.WithStack(err)
+				return conflicts, werror.WithStack(err)
 			}
 
 			if toRestore.newParent == newParent {
@@ -712,22 +958,22 @@ func (fs *FileServiceImpl) RestoreFiles(
 			)
 			err = usersTree.Add(restoredF)
 			if err != nil {
-				return err
+				return conflicts, err
 			}
 
 			err = restoredF.CreateSelf()
 			if err != nil {
-				return err
+				return conflicts, err
 			}
 
 			for _, childId := range childIds {
 				childLt := journal.Get(childId)
 				if childLt == nil {
-					return werror.WithStack(werror.ErrNoFile)
+					return conflicts, werror.WithStack(werror.ErrNoFile)
 				}
 				restorePairs = append(
 					restorePairs,
-					restorePair{fileId: childId, newParent: restoredF, contentId: childLt.GetContentId()},
+					restorePair{fileId: childId, newParent: restoredF, contentId: childLt.ContentIdAt(restoreTime)},
 				)
 			}
 
@@ -742,23 +988,34 @@ func (fs *FileServiceImpl) RestoreFiles(
 	for _, f := range topFiles {
 		err := fs.ResizeDown(f, event, caster)
 		if err != nil {
-			return err
+			return conflicts, err
 		}
 		err = fs.ResizeUp(f, event, caster)
 		if err != nil {
-			return err
+			return conflicts, err
 		}
 	}
 
 	journal.LogEvent(event)
 	event.Wait()
 
-	return nil
+	return conflicts, nil
+}
+
+// pastFileName is the name a file had at the time it was read from the journal
+func pastFileName(pastFile *fileTree.WeblensFileImpl) string {
+	path := pastFile.GetPortablePath().ToPortable()
+	// Paths of directory files will have an extra / on the end, so we need to remove it
+	if pastFile.IsDir() {
+		path = path[:len(path)-1]
+	}
+
+	return filepath.Base(path)
 }
 
 func (fs *FileServiceImpl) RestoreHistory(lifetimes []*fileTree.Lifetime) error {
 
-	journal := fs.trees[UsersTreeKey].GetJournal()
+	journal := fs.GetFileTreeByName(UsersTreeKey).GetJournal()
 
 	err := journal.Add(lifetimes...)
 	if err != nil {
@@ -776,7 +1033,7 @@ func (fs *FileServiceImpl) RestoreHistory(lifetimes []*fileTree.Lifetime) error
 		if !portable.IsDir() {
 			continue
 		}
-		if fs.trees[UsersTreeKey].Get(lt.ID()) != nil {
+		if fs.GetFileTreeByName(UsersTreeKey).Get(lt.ID()) != nil {
 			continue
 		}
 
@@ -787,7 +1044,7 @@ func (fs *FileServiceImpl) RestoreHistory(lifetimes []*fileTree.Lifetime) error
 		}
 
 		newF := fileTree.NewWeblensFile(lt.ID(), portable.Filename(), parent, true)
-		err = fs.trees[UsersTreeKey].Add(newF)
+		err = fs.GetFileTreeByName(UsersTreeKey).Add(newF)
 		if err != nil {
 			return err
 		}
@@ -800,143 +1057,950 @@ func (fs *FileServiceImpl) RestoreHistory(lifetimes []*fileTree.Lifetime) error
 	return nil
 }
 
-func (fs *FileServiceImpl) NewZip(zipName string, owner *models.User) (*fileTree.WeblensFileImpl, error) {
-	cacheTree := fs.trees[CachesTreeKey]
-	if cacheTree == nil {
-		return nil, werror.ErrNoFileTree
+// ReplaceFileContent swaps the content of an existing file for newContent. The new content is staged
+// next to the restore tree and renamed over the file, so the old content is never written over and can
+// be kept in the restore tree, keyed by its contentId, to be restored later.
+func (fs *FileServiceImpl) ReplaceFileContent(
+	file *fileTree.WeblensFileImpl, newContent io.Reader, caster models.FileCaster,
+) error {
+	if file.IsDir() {
+		return werror.WithStack(werror.ErrDirNotAllowed)
+	} else if err := checkWritable(file); err != nil {
+		return err
 	}
 
-	cacheRoot := cacheTree.GetRoot()
+	stagingPath, err := fs.stageContent(file, newContent)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(stagingPath); err != nil && !os.IsNotExist(err) {
+			fs.log.ErrTrace(werror.WithStack(err))
+		}
+	}()
 
-	takeoutDir, err := cacheRoot.GetChild("takeout")
+	return fs.swapFileContent(file, stagingPath, caster)
+}
+
+func (fs *FileServiceImpl) GetFileVersions(file *fileTree.WeblensFileImpl) ([]fileTree.ContentVersion, error) {
+	if file.IsDir() {
+		return nil, werror.WithStack(werror.ErrDirNotAllowed)
+	}
+
+	lt := fs.GetFileTreeByName(UsersTreeKey).GetJournal().Get(file.ID())
+	if lt == nil {
+		return nil, werror.WithStack(werror.ErrNoLifetime.WithArg(file.ID()))
+	}
+
+	return lt.GetContentVersions(), nil
+}
+
+// RestoreFileVersion replaces the content of file with the content it held at an earlier version.
+// The content being replaced is kept as a version of its own, so the restore can be undone.
+func (fs *FileServiceImpl) RestoreFileVersion(
+	file *fileTree.WeblensFileImpl, contentId models.ContentId, caster models.FileCaster,
+) error {
+	if file.GetContentId() == contentId {
+		return werror.WithStack(werror.ErrVersionIsCurrent)
+	} else if err := checkWritable(file); err != nil {
+		return err
+	}
+
+	versions, err := fs.GetFileVersions(file)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	zipFile, err := cacheTree.Touch(takeoutDir, zipName, nil)
+	if !slices.ContainsFunc(
+		versions, func(v fileTree.ContentVersion) bool { return v.ContentId == contentId },
+	) {
+		return werror.WithStack(werror.ErrNoFileVersion.WithArg(contentId))
+	}
+
+	versionFile, err := fs.GetFileTreeByName(RestoreTreeKey).GetRoot().GetChild(contentId)
 	if err != nil {
-		return nil, err
+		return werror.WithStack(werror.ErrNoFileVersion.WithArg(contentId))
 	}
 
-	return zipFile, nil
-}
+	err = fs.CheckQuota(file.GetParent(), versionFile.Size()-file.Size())
+	if err != nil {
+		return err
+	}
 
-func (fs *FileServiceImpl) GetZip(id fileTree.FileId) (*fileTree.WeblensFileImpl, error) {
-	takeoutFile := fs.trees[CachesTreeKey].Get(id)
-	if takeoutFile == nil {
-		return nil, werror.ErrNoFile
+	versionContent, err := versionFile.Readable()
+	if err != nil {
+		return err
 	}
-	if takeoutFile.GetParent().Filename() != "takeout" {
-		return nil, werror.ErrNoFile
+	if closer, ok := versionContent.(io.Closer); ok {
+		defer closer.Close()
 	}
 
-	return takeoutFile, nil
+	// Copy the old content instead of linking it, writes to the file should never reach the stored version
+	return fs.ReplaceFileContent(file, versionContent, caster)
 }
 
-func (fs *FileServiceImpl) MoveFiles(
-	files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, treeName string, caster models.FileCaster,
-) error {
-	if len(files) == 0 {
-		return nil
+func (fs *FileServiceImpl) stageContent(file *fileTree.WeblensFileImpl, content io.Reader) (string, error) {
+	restoreTree := fs.GetFileTreeByName(RestoreTreeKey)
+	if restoreTree == nil {
+		return "", werror.WithStack(werror.ErrNoFileTree.WithArg(RestoreTreeKey))
 	}
 
-	tree := fs.trees[treeName]
+	// The restore tree is on the same filesystem as the users tree, so the staged file can be renamed into place
+	stagingPath := filepath.Join(restoreTree.GetRoot().AbsPath(), ".staging-"+file.ID())
+	stagingFile, err := os.Create(stagingPath)
+	if err != nil {
+		return "", werror.WithStack(err)
+	}
+	defer stagingFile.Close()
 
-	event := tree.GetJournal().NewEvent()
-	prevParent := files[0].GetParent()
+	_, err = io.Copy(stagingFile, content)
+	if err != nil {
+		return "", werror.WithStack(err)
+	}
 
-	moveUpdates := map[string][]*fileTree.WeblensFileImpl{}
+	return stagingPath, nil
+}
 
-	for _, file := range files {
-		preFile := file.Freeze()
-		newFilename := MakeUniqueChildName(destFolder, file.Filename())
+func (fs *FileServiceImpl) swapFileContent(
+	file *fileTree.WeblensFileImpl, newContentPath string, caster models.FileCaster,
+) error {
+	oldContentId, err := GenerateContentId(file)
+	if err != nil && !errors.Is(err, werror.ErrEmptyFile) {
+		return err
+	}
 
-		_, err := tree.Move(file, destFolder, newFilename, false, event)
+	if oldContentId != "" {
+		err = fs.retainContent(file, oldContentId)
 		if err != nil {
 			return err
 		}
-
-		key := preFile.GetParentId() + "->" + file.GetParentId()
-		if moveUpdates[key] == nil {
-			moveUpdates[key] = []*fileTree.WeblensFileImpl{file}
-		} else {
-			moveUpdates[key] = append(moveUpdates[key], file)
-		}
 	}
 
-	for key, moves := range moveUpdates {
-		keys := strings.Split(key, "->")
-		caster.PushFilesMove(keys[0], keys[1], moves)
+	err = os.Rename(newContentPath, file.AbsPath())
+	if err != nil {
+		return werror.WithStack(err)
 	}
 
-	err := fs.ResizeUp(destFolder, event, caster)
+	// Force the file to re-stat, LoadStat will skip files that already have a size
+	file.SetSize(-1)
+	file.SetContentId("")
+	_, err = file.LoadStat()
 	if err != nil {
 		return err
 	}
 
-	err = fs.ResizeUp(prevParent, event, caster)
-	if err != nil {
+	newContentId, err := GenerateContentId(file)
+	if err != nil && !errors.Is(err, werror.ErrEmptyFile) {
 		return err
 	}
 
-	tree.GetJournal().LogEvent(event)
+	if newContentId == oldContentId {
+		return nil
+	}
 
-	return nil
-}
+	journal := fs.GetFileTreeByName(UsersTreeKey).GetJournal()
+	event := journal.NewEvent()
+	event.NewModifyAction(file, oldContentId)
 
-func (fs *FileServiceImpl) RenameFile(file *fileTree.WeblensFileImpl, newName string, caster models.FileCaster) error {
-	preFile := file.Freeze()
-	_, err := fs.trees[UsersTreeKey].Move(file, file.GetParent(), newName, false, nil)
+	err = fs.ResizeUp(file, event, caster)
 	if err != nil {
 		return err
 	}
 
-	caster.PushFileMove(preFile, file)
+	journal.LogEvent(event)
+	event.Wait()
 
-	return nil
-}
+	if fs.contentIdCache != nil {
+		fs.contentIdLock.Lock()
+		fs.contentIdCache[newContentId] = file
+		fs.contentIdLock.Unlock()
+	}
 
-func (fs *FileServiceImpl) AddTree(tree fileTree.FileTree) {
-	fs.treesLock.Lock()
-	defer fs.treesLock.Unlock()
-	fs.trees[tree.GetRoot().GetPortablePath().RootName()] = tree
-}
+	if fs.mediaService != nil {
+		if m := fs.mediaService.Get(oldContentId); m != nil {
+			err = fs.mediaService.RemoveFileFromMedia(m, file.ID())
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-func (fs *FileServiceImpl) NewBackupFile(lt *fileTree.Lifetime) (*fileTree.WeblensFileImpl, error) {
-	filename := lt.GetLatestPath().Filename()
+	fs.log.Debug.Func(func(l log.Logger) {
+		l.Printf("Replaced content of [%s] (%s -> %s)", file.GetPortablePath(), oldContentId, newContentId)
+	})
 
-	tree := fs.trees[lt.ServerId]
-	if tree == nil {
-		return nil, werror.WithStack(werror.ErrNoFileTree.WithArg(lt.ServerId))
+	if caster != nil {
+		caster.PushFileUpdate(file, nil)
 	}
 
-	restoreTree := fs.trees[RestoreTreeKey]
+	return nil
+}
+
+// retainContent hard-links the current content of file into the restore tree, if that
+// content is not already there. The link keeps the content alive once the file is replaced.
+func (fs *FileServiceImpl) retainContent(file *fileTree.WeblensFileImpl, contentId models.ContentId) error {
+	restoreTree := fs.GetFileTreeByName(RestoreTreeKey)
 	if restoreTree == nil {
-		return nil, werror.WithStack(werror.ErrNoFileTree.WithArg(RestoreTreeKey))
+		return werror.WithStack(werror.ErrNoFileTree.WithArg(RestoreTreeKey))
 	}
 
-	if lt.GetIsDir() {
-		// If there is no path (i.e. the dir has been deleted), skip as there is
-		// no need to create a directory that no longer exists, just so long as it is
-		// included in the history, which is now is.
-		if lt.GetLatestPath().RootName() == "" {
-			fs.log.Trace.Func(func(l log.Logger) { l.Printf("Skipping dir that has no dest path") })
-			return nil, nil
-		}
+	if _, err := restoreTree.GetRoot().GetChild(contentId); err == nil {
+		return nil
+	}
 
-		// Find the directory's parent. This should already exist since we always create
-		// the backup file structure in order from parent to child.
-		latestAction := lt.GetLatestAction()
-		parent := tree.Get(latestAction.ParentId)
-		if parent == nil {
-			return nil, werror.WithStack(werror.ErrNoFile.WithArg(fmt.Sprintf("looking for parent of [%s]: %s", latestAction.DestinationPath, latestAction.ParentId)))
-		}
+	restoreFile := fileTree.NewWeblensFile(restoreTree.GenerateFileId(), contentId, restoreTree.GetRoot(), false)
+	restoreFile.SetContentId(contentId)
+	restoreFile.SetSize(file.Size())
 
-		// Create the directory object and add it to the tree
-		newDir := fileTree.NewWeblensFile(lt.ID(), filename, parent, true)
-		err := tree.Add(newDir)
-		if err != nil {
-			return nil, err
+	err := restoreTree.Add(restoreFile)
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(file.AbsPath(), restoreFile.AbsPath())
+	if err != nil {
+		if _, rmErr := restoreTree.Remove(restoreFile.ID()); rmErr != nil {
+			fs.log.ErrTrace(rmErr)
+		}
+		return werror.WithStack(err)
+	}
+
+	if fs.contentIdCache != nil {
+		fs.contentIdLock.Lock()
+		fs.contentIdCache[contentId] = restoreFile
+		fs.contentIdLock.Unlock()
+	}
+
+	return nil
+}
+
+func (fs *FileServiceImpl) PurgeRestoreFiles(contentIds []models.ContentId) (int, int64, error) {
+	restoreTree := fs.GetFileTreeByName(RestoreTreeKey)
+	if restoreTree == nil {
+		return 0, 0, werror.WithStack(werror.ErrNoFileTree.WithArg(RestoreTreeKey))
+	}
+
+	var freed int64
+	var purged int
+	for _, contentId := range contentIds {
+		restoreFile, err := restoreTree.GetRoot().GetChild(contentId)
+		if err != nil {
+			continue
+		}
+
+		size := restoreFile.Size()
+		err = restoreTree.Delete(restoreFile.ID(), restoreTree.GetJournal().NewEvent())
+		if err != nil {
+			return purged, freed, err
+		}
+		freed += size
+		purged++
+
+		if fs.contentIdCache != nil {
+			fs.contentIdLock.Lock()
+			if fs.contentIdCache[contentId] == restoreFile {
+				delete(fs.contentIdCache, contentId)
+			}
+			fs.contentIdLock.Unlock()
+		}
+	}
+
+	fs.log.Debug.Func(func(l log.Logger) {
+		l.Printf("Purged %d contents from the restore tree, freeing %s", purged, internal.ByteCountSI(freed))
+	})
+
+	return purged, freed, nil
+}
+
+func (fs *FileServiceImpl) NewZip(zipName string, owner *models.User) (*fileTree.WeblensFileImpl, error) {
+	cacheTree := fs.GetFileTreeByName(CachesTreeKey)
+	if cacheTree == nil {
+		return nil, werror.ErrNoFileTree
+	}
+
+	cacheRoot := cacheTree.GetRoot()
+
+	takeoutDir, err := cacheRoot.GetChild("takeout")
+	if err != nil {
+		return nil, err
+	}
+
+	zipFile, err := cacheTree.Touch(takeoutDir, zipName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return zipFile, nil
+}
+
+func (fs *FileServiceImpl) GetZip(id fileTree.FileId) (*fileTree.WeblensFileImpl, error) {
+	takeoutFile := fs.GetFileTreeByName(CachesTreeKey).Get(id)
+	if takeoutFile == nil {
+		return nil, werror.ErrNoFile
+	}
+	if takeoutFile.GetParent().Filename() != "takeout" {
+		return nil, werror.ErrNoFile
+	}
+
+	return takeoutFile, nil
+}
+
+func (fs *FileServiceImpl) MoveFiles(
+	files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, treeName string,
+	policy models.ConflictPolicy, caster models.FileCaster,
+) ([]models.FileConflict, error) {
+	if len(files) == 0 {
+		return nil, nil
+	} else if err := checkWritable(destFolder); err != nil {
+		return nil, err
+	} else if err := checkWritable(files...); err != nil {
+		return nil, err
+	}
+
+	if policy == models.ConflictFail {
+		conflicts := models.FindConflicts(files, destFolder)
+		if len(conflicts) != 0 {
+			return conflicts, werror.WithStack(werror.ErrFileAlreadyExists)
+		}
+	}
+
+	tree := fs.GetFileTreeByName(treeName)
+
+	// Files moving between users count against the quota of the new owner
+	if treeName == UsersTreeKey {
+		err := fs.checkTransferQuota(files, destFolder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	event := tree.GetJournal().NewEvent()
+	prevParent := files[0].GetParent()
+
+	mv := &conflictMove{
+		fs:          fs,
+		tree:        tree,
+		treeName:    treeName,
+		policy:      policy,
+		event:       event,
+		caster:      caster,
+		moveUpdates: map[string][]*fileTree.WeblensFileImpl{},
+	}
+
+	for _, file := range files {
+		err := mv.move(file, destFolder)
+		if err != nil {
+			return mv.conflicts, err
+		}
+	}
+
+	for key, moves := range mv.moveUpdates {
+		keys := strings.Split(key, "->")
+		caster.PushFilesMove(keys[0], keys[1], moves)
+	}
+	if len(mv.merged) != 0 {
+		caster.PushFilesDelete(mv.merged)
+	}
+
+	// Folders that were merged into have gained children that resizing up from destFolder does not see
+	err := fs.resizeMergeTargets(mv.mergedInto, event, caster)
+	if err != nil {
+		return mv.conflicts, err
+	}
+
+	err = fs.ResizeUp(destFolder, event, caster)
+	if err != nil {
+		return mv.conflicts, err
+	}
+
+	err = fs.ResizeUp(prevParent, event, caster)
+	if err != nil {
+		return mv.conflicts, err
+	}
+
+	tree.GetJournal().LogEvent(event)
+
+	return mv.conflicts, nil
+}
+
+// conflictMove is a move of files that handles the files already in the way with a conflict policy
+type conflictMove struct {
+	fs       *FileServiceImpl
+	tree     fileTree.FileTree
+	treeName string
+	policy   models.ConflictPolicy
+	event    *fileTree.FileEvent
+	caster   models.FileCaster
+
+	conflicts   []models.FileConflict
+	moveUpdates map[string][]*fileTree.WeblensFileImpl
+
+	// merged are the folders that were emptied into another folder of the same name, and then deleted
+	merged []*fileTree.WeblensFileImpl
+
+	// mergedInto are the folders that merged folders were emptied into, with the deepest first
+	mergedInto []*fileTree.WeblensFileImpl
+}
+
+func (mv *conflictMove) move(file, destFolder *fileTree.WeblensFileImpl) error {
+	newFilename := file.Filename()
+
+	existing, _ := destFolder.GetChild(newFilename)
+
+	// Nothing that holds the file being moved can be overwritten by it, that would delete the file itself
+	inExisting := existing != nil && strings.HasPrefix(file.AbsPath(), existing.AbsPath())
+
+	if existing != nil && existing != file {
+		conflict := models.FileConflict{
+			FileId:     file.ID(),
+			ExistingId: existing.ID(),
+			Filename:   newFilename,
+			Resolution: mv.policy,
+		}
+
+		switch {
+		case mv.policy == models.ConflictSkip:
+			mv.conflicts = append(mv.conflicts, conflict)
+			return nil
+		case mv.policy == models.ConflictMerge && file.IsDir() && existing.IsDir():
+			mv.conflicts = append(mv.conflicts, conflict)
+			for _, child := range file.GetChildren() {
+				err := mv.move(child, existing)
+				if err != nil {
+					return err
+				}
+			}
+
+			preDelete := file.Freeze()
+			err := mv.tree.Delete(file.ID(), mv.event)
+			if err != nil {
+				return err
+			}
+			mv.merged = append(mv.merged, preDelete)
+			mv.mergedInto = append(mv.mergedInto, existing)
+			return nil
+		case mv.policy == models.ConflictOverwrite && !inExisting:
+			mv.conflicts = append(mv.conflicts, conflict)
+			err := mv.fs.DeleteFiles([]*fileTree.WeblensFileImpl{existing}, mv.treeName, mv.caster)
+			if err != nil {
+				return err
+			}
+		default:
+			newFilename = destFolder.UniqueChildName(newFilename)
+			conflict.Resolution = models.ConflictKeepBoth
+			conflict.NewName = newFilename
+			mv.conflicts = append(mv.conflicts, conflict)
+		}
+	}
+
+	preFile := file.Freeze()
+
+	_, err := mv.tree.Move(file, destFolder, newFilename, false, mv.event)
+	if errors.Is(err, werror.ErrEmptyMove) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	key := preFile.GetParentId() + "->" + file.GetParentId()
+	mv.moveUpdates[key] = append(mv.moveUpdates[key], file)
+
+	return nil
+}
+
+// resizeMergeTargets sizes the folders that other folders were merged into. Each is resized up, which only looks at
+// the sizes of its direct children, so the deepest must come first.
+func (fs *FileServiceImpl) resizeMergeTargets(
+	mergedInto []*fileTree.WeblensFileImpl, event *fileTree.FileEvent, caster models.FileCaster,
+) error {
+	for _, target := range mergedInto {
+		err := fs.ResizeUp(target, event, caster)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *FileServiceImpl) CopyFiles(
+	files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, policy models.ConflictPolicy,
+	caster models.FileCaster, onCopy func(source, copied *fileTree.WeblensFileImpl),
+) ([]*fileTree.WeblensFileImpl, []models.FileConflict, error) {
+	if len(files) == 0 {
+		return nil, nil, nil
+	} else if err := checkWritable(destFolder); err != nil {
+		return nil, nil, err
+	}
+
+	if policy == models.ConflictFail {
+		conflicts := models.FindConflicts(files, destFolder)
+		if len(conflicts) != 0 {
+			return nil, conflicts, werror.WithStack(werror.ErrFileAlreadyExists)
+		}
+	}
+
+	tree := fs.GetFileTreeByName(UsersTreeKey)
+	if tree == nil {
+		return nil, nil, werror.WithStack(werror.ErrNoFileTree)
+	}
+
+	var copySize int64
+	for _, file := range files {
+		copySize += file.Size()
+	}
+	err := fs.CheckQuota(destFolder, copySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	event := tree.GetJournal().NewEvent()
+
+	// The copies share content with their sources, so they can share the media too
+	addToMedia := func(source, copied *fileTree.WeblensFileImpl) {
+		if !copied.IsDir() && fs.mediaService != nil {
+			if m := fs.mediaService.Get(source.GetContentId()); m != nil {
+				if err := fs.mediaService.AddFileToMedia(m, copied); err != nil {
+					fs.log.ErrTrace(err)
+				}
+			}
+		}
+
+		if onCopy != nil {
+			onCopy(source, copied)
+		}
+	}
+
+	cp := &conflictCopy{
+		fs:     fs,
+		tree:   tree,
+		policy: policy,
+		event:  event,
+		caster: caster,
+		onCopy: addToMedia,
+	}
+
+	var copyErr error
+	for _, file := range files {
+		copyErr = cp.copy(file, destFolder)
+		if copyErr != nil {
+			break
+		}
+	}
+
+	// Anything that was copied before an error still needs to be logged
+	err = fs.resizeMergeTargets(cp.mergedInto, event, caster)
+	if err != nil {
+		fs.log.ErrTrace(err)
+	}
+
+	err = fs.ResizeUp(destFolder, event, caster)
+	if err != nil {
+		fs.log.ErrTrace(err)
+	}
+
+	tree.GetJournal().LogEvent(event)
+	event.Wait()
+
+	if copyErr != nil {
+		return cp.copies, cp.conflicts, copyErr
+	}
+
+	return cp.copies, cp.conflicts, nil
+}
+
+// conflictCopy is a copy of files that handles the files already in the way with a conflict policy
+type conflictCopy struct {
+	fs     *FileServiceImpl
+	tree   fileTree.FileTree
+	policy models.ConflictPolicy
+	event  *fileTree.FileEvent
+	caster models.FileCaster
+	onCopy func(source, copied *fileTree.WeblensFileImpl)
+
+	copies    []*fileTree.WeblensFileImpl
+	conflicts []models.FileConflict
+
+	// mergedInto are the folders that copied folders were merged into, with the deepest first
+	mergedInto []*fileTree.WeblensFileImpl
+}
+
+func (cp *conflictCopy) copy(file, destFolder *fileTree.WeblensFileImpl) error {
+	newFilename := file.Filename()
+
+	existing, _ := destFolder.GetChild(newFilename)
+
+	// A file copied into the folder it is already in is always kept next to itself, and nothing that
+	// holds the file being copied can be overwritten by its copy
+	inExisting := existing != nil && (existing == file || strings.HasPrefix(file.AbsPath(), existing.AbsPath()))
+
+	if existing != nil {
+		conflict := models.FileConflict{
+			FileId:     file.ID(),
+			ExistingId: existing.ID(),
+			Filename:   newFilename,
+			Resolution: cp.policy,
+		}
+
+		switch {
+		case cp.policy == models.ConflictSkip && !inExisting:
+			cp.conflicts = append(cp.conflicts, conflict)
+			return nil
+		case cp.policy == models.ConflictMerge && file.IsDir() && existing.IsDir() && !inExisting:
+			cp.conflicts = append(cp.conflicts, conflict)
+			for _, child := range file.GetChildren() {
+				err := cp.copy(child, existing)
+				if err != nil {
+					return err
+				}
+			}
+			cp.mergedInto = append(cp.mergedInto, existing)
+			return nil
+		case cp.policy == models.ConflictOverwrite && !inExisting:
+			cp.conflicts = append(cp.conflicts, conflict)
+			err := cp.fs.DeleteFiles([]*fileTree.WeblensFileImpl{existing}, UsersTreeKey, cp.caster)
+			if err != nil {
+				return err
+			}
+		default:
+			newFilename = destFolder.UniqueChildName(newFilename)
+			conflict.Resolution = models.ConflictKeepBoth
+			conflict.NewName = newFilename
+			cp.conflicts = append(cp.conflicts, conflict)
+		}
+	}
+
+	copied, err := cp.tree.Copy(file, destFolder, newFilename, cp.event, cp.onCopy)
+	if copied != nil {
+		cp.copies = append(cp.copies, copied)
+		cp.caster.PushFileCreate(copied)
+	}
+
+	return err
+}
+
+func (fs *FileServiceImpl) RenameFile(file *fileTree.WeblensFileImpl, newName string, caster models.FileCaster) error {
+	if err := checkWritable(file); err != nil {
+		return err
+	}
+
+	preFile := file.Freeze()
+	_, err := fs.GetFileTreeByName(UsersTreeKey).Move(file, file.GetParent(), newName, false, nil)
+	if err != nil {
+		return err
+	}
+
+	caster.PushFileMove(preFile, file)
+
+	return nil
+}
+
+// RunBatch applies the operations in order under a single file event. If one of them fails, every change
+// made before it is undone, and the event is never logged, so the journal only ever sees a whole batch.
+func (fs *FileServiceImpl) RunBatch(
+	ops []models.FileOperation, user *models.User, share *models.FileShare, caster models.FileCaster,
+) ([]fileTree.FileId, error) {
+	tree := fs.GetFileTreeByName(UsersTreeKey)
+	if tree == nil {
+		return nil, werror.WithStack(werror.ErrNoFileTree)
+	}
+
+	b := &fileBatch{
+		fs:      fs,
+		tree:    tree,
+		user:    user,
+		share:   share,
+		event:   tree.GetJournal().NewEvent(),
+		created: make([]fileTree.FileId, len(ops)),
+	}
+
+	for i, op := range ops {
+		err := b.apply(i, op)
+		if err != nil {
+			b.rollback()
+			return nil, &models.FileBatchError{Index: i, Err: err}
+		}
+	}
+
+	for _, folder := range b.changedFolders {
+		err := fs.ResizeUp(folder, b.event, caster)
+		if err != nil {
+			log.ErrTrace(err)
+		}
+	}
+
+	tree.GetJournal().LogEvent(b.event)
+	b.event.Wait()
+
+	for _, push := range b.pushes {
+		push(caster)
+	}
+
+	return b.created, nil
+}
+
+// fileBatch is the state of one RunBatch call. Each change adds a way to reverse it to undo, and
+// clients are not told about anything until the whole batch has gone through.
+type fileBatch struct {
+	fs    *FileServiceImpl
+	tree  fileTree.FileTree
+	user  *models.User
+	share *models.FileShare
+	event *fileTree.FileEvent
+
+	created        []fileTree.FileId
+	undo           []func() error
+	pushes         []func(caster models.FileCaster)
+	changedFolders []*fileTree.WeblensFileImpl
+}
+
+func (b *fileBatch) apply(index int, op models.FileOperation) error {
+	switch op.Type {
+	case models.FileOpMove:
+		parent, err := b.getParent(index, op.ParentId)
+		if err != nil {
+			return err
+		}
+		files, err := b.getFiles(op.FileIds)
+		if err != nil {
+			return err
+		}
+		if err = b.fs.checkTransferQuota(files, parent); err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			if err = b.move(f, parent, f.Filename()); err != nil {
+				return err
+			}
+		}
+	case models.FileOpRename:
+		if len(op.FileIds) != 1 || op.Name == "" {
+			return werror.WithStack(werror.ErrBadFileOperation.WithArg("rename needs one file and a new name"))
+		}
+		files, err := b.getFiles(op.FileIds)
+		if err != nil {
+			return err
+		}
+
+		return b.move(files[0], files[0].GetParent(), op.Name)
+	case models.FileOpTrash:
+		files, err := b.getFiles(op.FileIds)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			if b.fs.IsFileInTrash(f) {
+				return werror.WithStack(werror.ErrBadFileOperation.WithArg(f.Filename() + " is already in the trash"))
+			}
+			owner, err := b.fs.GetFileOwner(f)
+			if err != nil {
+				return err
+			}
+			trash := b.tree.Get(owner.TrashId)
+			if trash == nil {
+				return werror.WithStack(errors.New("trash folder does not exist"))
+			}
+
+			if err = b.move(f, trash, trash.UniqueChildName(f.Filename())); err != nil {
+				return err
+			}
+		}
+	case models.FileOpMkdir:
+		if op.Name == "" {
+			return werror.WithStack(werror.ErrBadFileOperation.WithArg("mkdir needs a name"))
+		}
+		parent, err := b.getParent(index, op.ParentId)
+		if err != nil {
+			return err
+		}
+		if err = checkWritable(parent); err != nil {
+			return err
+		}
+
+		// MkDir hands back the folder that is already there along with the error, which is not ours to undo
+		dir, err := b.tree.MkDir(parent, op.Name, b.event)
+		if err != nil {
+			return err
+		}
+
+		b.created[index] = dir.ID()
+		b.undo = append(b.undo, func() error { return b.tree.Delete(dir.ID(), b.event) })
+		b.pushes = append(b.pushes, func(caster models.FileCaster) { caster.PushFileCreate(dir) })
+		b.changedFolders = append(b.changedFolders, parent)
+	default:
+		return werror.WithStack(werror.ErrBadFileOperation.WithArg(fmt.Sprintf("unknown operation type %q", op.Type)))
+	}
+
+	return nil
+}
+
+func (b *fileBatch) move(f, parent *fileTree.WeblensFileImpl, name string) error {
+	if err := checkWritable(f, parent); err != nil {
+		return err
+	}
+
+	oldParent := f.GetParent()
+	oldName := f.Filename()
+	if parent == oldParent && name == oldName {
+		return nil
+	}
+
+	if existing, _ := parent.GetChild(name); existing != nil && existing != f {
+		return werror.WithStack(werror.ErrFileAlreadyExists.WithArg(existing.GetPortablePath().ToPortable()))
+	}
+
+	preMoveFile := f.Freeze()
+	_, err := b.tree.Move(f, parent, name, false, b.event)
+	if err != nil {
+		return err
+	}
+
+	b.undo = append(
+		b.undo, func() error {
+			_, err := b.tree.Move(f, oldParent, oldName, false, b.event)
+			return err
+		},
+	)
+	b.pushes = append(b.pushes, func(caster models.FileCaster) { caster.PushFileMove(preMoveFile, f) })
+	b.changedFolders = append(b.changedFolders, oldParent, parent)
+
+	return nil
+}
+
+// rollback undoes the changes of the batch, newest first, so each one is reversed in the same state it was made in
+func (b *fileBatch) rollback() {
+	for i := len(b.undo) - 1; i >= 0; i-- {
+		if err := b.undo[i](); err != nil {
+			log.ErrTrace(err)
+		}
+	}
+}
+
+// getParent finds the folder with the id, which may instead be a reference ("$n") to a folder
+// made by an earlier operation in the batch
+func (b *fileBatch) getParent(index int, id fileTree.FileId) (*fileTree.WeblensFileImpl, error) {
+	if ref, ok := strings.CutPrefix(id, "$"); ok {
+		opIndex, err := strconv.Atoi(ref)
+		if err != nil || opIndex < 0 || opIndex >= index || b.created[opIndex] == "" {
+			return nil, werror.WithStack(werror.ErrBadFileOperation.WithArg(fmt.Sprintf("%s is not a folder made earlier in the batch", id)))
+		}
+		id = b.created[opIndex]
+	}
+
+	parent, err := b.fs.GetFileSafe(id, b.user, b.share)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsDir() {
+		return nil, werror.WithStack(werror.ErrBadFileOperation.WithArg(parent.Filename() + " is not a folder"))
+	}
+
+	return parent, nil
+}
+
+func (b *fileBatch) getFiles(ids []fileTree.FileId) ([]*fileTree.WeblensFileImpl, error) {
+	if len(ids) == 0 {
+		return nil, werror.WithStack(werror.ErrBadFileOperation.WithArg("no files given"))
+	}
+
+	files := make([]*fileTree.WeblensFileImpl, 0, len(ids))
+	for _, id := range ids {
+		f, err := b.fs.GetFileSafe(id, b.user, b.share)
+		if err != nil {
+			return nil, err
+		}
+
+		owner, err := b.fs.GetFileOwner(f)
+		if err != nil {
+			return nil, err
+		}
+		if owner == nil || f.ID() == owner.HomeId || f.ID() == owner.TrashId {
+			return nil, werror.WithStack(werror.ErrBadFileOperation.WithArg("home and trash folders cannot be moved"))
+		}
+
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+func (fs *FileServiceImpl) AddTree(tree fileTree.FileTree) {
+	fs.treesLock.Lock()
+	defer fs.treesLock.Unlock()
+	fs.trees[tree.GetRoot().GetPortablePath().RootName()] = tree
+}
+
+func (fs *FileServiceImpl) RemoveTree(treeName string) {
+	fs.treesLock.Lock()
+	defer fs.treesLock.Unlock()
+	delete(fs.trees, treeName)
+}
+
+// getLibraryFile looks for the file in each of the read-only library trees
+func (fs *FileServiceImpl) getLibraryFile(id fileTree.FileId) *fileTree.WeblensFileImpl {
+	fs.treesLock.RLock()
+	defer fs.treesLock.RUnlock()
+
+	for _, tree := range fs.trees {
+		if !tree.GetRoot().IsReadOnly() {
+			continue
+		}
+		if f := tree.Get(id); f != nil {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// checkWritable returns werror.ErrReadOnly if any of the files are in a read-only tree
+func checkWritable(files ...*fileTree.WeblensFileImpl) error {
+	for _, f := range files {
+		if f != nil && f.IsReadOnly() {
+			return werror.WithStack(werror.ErrReadOnly.WithArg(f.GetPortablePath().ToPortable()))
+		}
+	}
+
+	return nil
+}
+
+func (fs *FileServiceImpl) NewBackupFile(lt *fileTree.Lifetime) (*fileTree.WeblensFileImpl, error) {
+	filename := lt.GetLatestPath().Filename()
+
+	tree := fs.GetFileTreeByName(lt.ServerId)
+	if tree == nil {
+		return nil, werror.WithStack(werror.ErrNoFileTree.WithArg(lt.ServerId))
+	}
+
+	restoreTree := fs.GetFileTreeByName(RestoreTreeKey)
+	if restoreTree == nil {
+		return nil, werror.WithStack(werror.ErrNoFileTree.WithArg(RestoreTreeKey))
+	}
+
+	if lt.GetIsDir() {
+		// If there is no path (i.e. the dir has been deleted), skip as there is
+		// no need to create a directory that no longer exists, just so long as it is
+		// included in the history, which is now is.
+		if lt.GetLatestPath().RootName() == "" {
+			fs.log.Trace.Func(func(l log.Logger) { l.Printf("Skipping dir that has no dest path") })
+			return nil, nil
+		}
+
+		// Find the directory's parent. This should already exist since we always create
+		// the backup file structure in order from parent to child.
+		latestAction := lt.GetLatestAction()
+		parent := tree.Get(latestAction.ParentId)
+		if parent == nil {
+			return nil, werror.WithStack(werror.ErrNoFile.WithArg(fmt.Sprintf("looking for parent of [%s]: %s", latestAction.DestinationPath, latestAction.ParentId)))
+		}
+
+		// Create the directory object and add it to the tree
+		newDir := fileTree.NewWeblensFile(lt.ID(), filename, parent, true)
+		err := tree.Add(newDir)
+		if err != nil {
+			return nil, err
 		}
 
 		fs.log.Trace.Println("Creating backup dir", newDir.GetPortablePath())
@@ -1016,7 +2080,7 @@ func (fs *FileServiceImpl) NewBackupFile(lt *fileTree.Lifetime) (*fileTree.Weble
 }
 
 func (fs *FileServiceImpl) GetJournalByTree(treeName string) fileTree.Journal {
-	tree := fs.trees[treeName]
+	tree := fs.GetFileTreeByName(treeName)
 	if tree == nil {
 		fs.log.Error.Printf("No tree with name %s", treeName)
 		return nil
@@ -1025,7 +2089,7 @@ func (fs *FileServiceImpl) GetJournalByTree(treeName string) fileTree.Journal {
 }
 
 func (fs *FileServiceImpl) SetFolderCover(folderId fileTree.FileId, coverId models.ContentId) error {
-	tree := fs.trees[UsersTreeKey]
+	tree := fs.GetFileTreeByName(UsersTreeKey)
 	if tree == nil {
 		return werror.ErrNoFileTree
 	}
@@ -1095,7 +2159,7 @@ func (fs *FileServiceImpl) PathToFile(searchPath string) (*fileTree.WeblensFileI
 	searchPath = strings.TrimPrefix(searchPath, "USERS:")
 
 	pathParts := strings.Split(searchPath, "/")
-	workingFile := fs.trees[UsersTreeKey].GetRoot()
+	workingFile := fs.GetFileTreeByName(UsersTreeKey).GetRoot()
 	for _, pathPart := range pathParts {
 		if pathPart == "" {
 			continue
@@ -1150,7 +2214,7 @@ func (fs *FileServiceImpl) GetTasks(f *fileTree.WeblensFileImpl) []*task.Task {
 }
 
 func (fs *FileServiceImpl) ResizeUp(f *fileTree.WeblensFileImpl, event *fileTree.FileEvent, caster models.FileCaster) error {
-	tree := fs.trees[f.GetPortablePath().RootName()]
+	tree := fs.GetFileTreeByName(f.GetPortablePath().RootName())
 	if tree == nil {
 		return nil
 	}
@@ -1169,7 +2233,7 @@ func (fs *FileServiceImpl) ResizeUp(f *fileTree.WeblensFileImpl, event *fileTree
 }
 
 func (fs *FileServiceImpl) ResizeDown(f *fileTree.WeblensFileImpl, event *fileTree.FileEvent, caster models.FileCaster) error {
-	tree := fs.trees[f.GetPortablePath().RootName()]
+	tree := fs.GetFileTreeByName(f.GetPortablePath().RootName())
 	if tree == nil {
 		return nil
 	}
@@ -1194,7 +2258,7 @@ func (fs *FileServiceImpl) ResizeDown(f *fileTree.WeblensFileImpl, event *fileTr
 }
 
 func (fs *FileServiceImpl) GetThumbsDir() (*fileTree.WeblensFileImpl, error) {
-	cacheTree := fs.trees[CachesTreeKey]
+	cacheTree := fs.GetFileTreeByName(CachesTreeKey)
 	if cacheTree == nil {
 		return nil, werror.WithStack(werror.ErrNoFileTree.WithArg(CachesTreeKey))
 	}
@@ -1202,7 +2266,7 @@ func (fs *FileServiceImpl) GetThumbsDir() (*fileTree.WeblensFileImpl, error) {
 }
 
 func (fs *FileServiceImpl) getFileByIdAndRoot(id fileTree.FileId, rootAlias string) (*fileTree.WeblensFileImpl, error) {
-	tree := fs.trees[rootAlias]
+	tree := fs.GetFileTreeByName(rootAlias)
 	if tree == nil {
 		return nil, werror.Errorf("Trying to get file on non-existent tree [%s]", rootAlias)
 	}
@@ -1223,7 +2287,7 @@ func (fs *FileServiceImpl) loadContentIdCache() error {
 
 	fs.log.Trace.Println("Loading contentId cache")
 
-	_ = fs.trees[RestoreTreeKey].GetRoot().LeafMap(
+	_ = fs.GetFileTreeByName(RestoreTreeKey).GetRoot().LeafMap(
 		func(f *fileTree.WeblensFileImpl) error {
 			if f.IsDir() {
 				return nil
@@ -1233,7 +2297,7 @@ func (fs *FileServiceImpl) loadContentIdCache() error {
 		},
 	)
 
-	if usersTree := fs.trees[UsersTreeKey]; usersTree != nil {
+	if usersTree := fs.GetFileTreeByName(UsersTreeKey); usersTree != nil {
 		_ = usersTree.GetRoot().LeafMap(
 			func(f *fileTree.WeblensFileImpl) error {
 				if f.IsDir() {
@@ -1262,16 +2326,29 @@ func GenerateContentId(f *fileTree.WeblensFileImpl) (models.ContentId, error) {
 		return f.GetContentId(), nil
 	}
 
+	contentId, err := HashFileContent(f)
+	if err != nil {
+		return "", err
+	}
+
+	f.SetContentId(contentId)
+
+	return contentId, nil
+}
+
+// HashFileContent reads the whole file and returns the content id it hashes to. Unlike GenerateContentId,
+// this never trusts the content id already on the file, and does not update it.
+func HashFileContent(f *fileTree.WeblensFileImpl) (models.ContentId, error) {
+	if f.IsDir() {
+		return "", werror.Errorf("cannot hash directory")
+	}
+
 	fileSize := f.Size()
 
 	if fileSize == 0 {
 		return "", werror.WithStack(werror.ErrEmptyFile.WithArg(f.AbsPath()))
 	}
 
-	if f.GetContentId() != "" {
-		return f.GetContentId(), nil
-	}
-
 	// Read up to 1MB at a time
 	bufSize := math.Min(float64(fileSize), 1000*1000)
 	buf := make([]byte, int64(bufSize))
@@ -1295,29 +2372,9 @@ func GenerateContentId(f *fileTree.WeblensFileImpl) (models.ContentId, error) {
 		return "", err
 	}
 
-	contentId := base64.URLEncoding.EncodeToString(newHash.Sum(nil))[:20]
-	f.SetContentId(contentId)
-
-	return contentId, nil
+	return ContentIdFromHash(newHash), nil
 }
 
 func ContentIdFromHash(newHash hash.Hash) models.ContentId {
 	return base64.URLEncoding.EncodeToString(newHash.Sum(nil))[:20]
 }
-
-func MakeUniqueChildName(parent *fileTree.WeblensFileImpl, childName string) string {
-	dupeCount := 0
-	_, e := parent.GetChild(childName)
-	for e == nil {
-		dupeCount++
-		tmp := fmt.Sprintf("%s (%d)", childName, dupeCount)
-		_, e = parent.GetChild(tmp)
-	}
-
-	newFilename := childName
-	if dupeCount != 0 {
-		newFilename = fmt.Sprintf("%s (%d)", newFilename, dupeCount)
-	}
-
-	return newFilename
-}
@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal/env"
 	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
 	"github.com/ethanrous/weblens/models"
 	"github.com/ethanrous/weblens/service"
 	"github.com/ethanrous/weblens/service/mock"
@@ -226,7 +228,7 @@ func TestFileService_Restore_SingleFile(t *testing.T) {
 	require.NoError(t, err)
 
 	// Restore the file
-	err = pack.FileService.RestoreFiles([]string{testF.ID()}, userHome, beforeDelete, pack.Caster)
+	_, err = pack.FileService.RestoreFiles([]string{testF.ID()}, userHome, beforeDelete, models.ConflictKeepBoth, pack.Caster)
 	require.NoError(t, err)
 
 	// Check if the file is in the user's home
@@ -312,7 +314,7 @@ func TestFileService_Restore_Directory(t *testing.T) {
 	// require.Equal(t, fileCount+1, restoreTree.Size())
 
 	// Restore the file
-	err = pack.FileService.RestoreFiles([]string{dir.ID()}, userHome, beforeDelete, pack.Caster)
+	_, err = pack.FileService.RestoreFiles([]string{dir.ID()}, userHome, beforeDelete, models.ConflictKeepBoth, pack.Caster)
 	if !assert.NoError(t, err) {
 		log.ErrTrace(err)
 		t.FailNow()
@@ -407,3 +409,504 @@ func TestFileService_RestoreHistory(t *testing.T) {
 
 	assert.Equal(t, len(restoredUsersJournal.GetAllLifetimes()), len(lifetimes))
 }
+
+// TestFileService_CheckQuota tests that copies are refused once they would put the user over their quota
+func TestFileService_CheckQuota(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	pack, err := NewTestFileService(t.Name(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack.Caster = &mock.MockCaster{}
+
+	userName := "test-user"
+	testUser, err := models.NewUser(userName, "test-pass", false, true)
+	require.NoError(t, err)
+
+	usersTree := pack.FileService.GetFileTreeByName("USERS")
+	usersJournal := pack.FileService.GetJournalByTree("USERS")
+
+	event := usersJournal.NewEvent()
+	userHome, err := pack.FileService.CreateFolder(usersTree.GetRoot(), userName, event, pack.Caster)
+	require.NoError(t, err)
+	userTrash, err := pack.FileService.CreateFolder(userHome, ".user_trash", event, pack.Caster)
+	require.NoError(t, err)
+
+	testUser.SetHomeFolder(userHome)
+	testUser.SetTrashFolder(userTrash)
+	err = pack.UserService.Add(testUser)
+	require.NoError(t, err)
+
+	testF, err := pack.FileService.CreateFile(userHome, "test-file", event, pack.Caster)
+	require.NoError(t, err)
+	_, err = testF.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	err = pack.FileService.ResizeUp(userHome, event, pack.Caster)
+	require.NoError(t, err)
+
+	usersJournal.LogEvent(event)
+	event.Wait()
+
+	// No quota means no limit
+	assert.NoError(t, pack.FileService.CheckQuota(userHome, 1<<40))
+
+	err = pack.UserService.SetUserQuota(testUser, 15)
+	require.NoError(t, err)
+
+	assert.NoError(t, pack.FileService.CheckQuota(userHome, 5))
+	assert.ErrorIs(t, pack.FileService.CheckQuota(userHome, 6), werror.ErrQuotaExceeded)
+
+	_, _, err = pack.FileService.CopyFiles([]*fileTree.WeblensFileImpl{testF}, userHome, models.ConflictKeepBoth, pack.Caster, nil)
+	assert.ErrorIs(t, err, werror.ErrQuotaExceeded)
+
+	err = pack.UserService.SetUserQuota(testUser, 20)
+	require.NoError(t, err)
+
+	copies, _, err := pack.FileService.CopyFiles([]*fileTree.WeblensFileImpl{testF}, userHome, models.ConflictKeepBoth, pack.Caster, nil)
+	require.NoError(t, err)
+	assert.Len(t, copies, 1)
+
+	err = pack.UserService.SetUserQuota(testUser, -1)
+	assert.ErrorIs(t, err, werror.ErrBadQuota)
+}
+
+// TestFileService_ReserveQuota tests that bytes declared by uploads, but not yet written, count against the quota
+func TestFileService_ReserveQuota(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	pack, err := NewTestFileService(t.Name(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack.Caster = &mock.MockCaster{}
+
+	userName := "test-user"
+	testUser, err := models.NewUser(userName, "test-pass", false, true)
+	require.NoError(t, err)
+
+	usersTree := pack.FileService.GetFileTreeByName("USERS")
+	usersJournal := pack.FileService.GetJournalByTree("USERS")
+
+	event := usersJournal.NewEvent()
+	userHome, err := pack.FileService.CreateFolder(usersTree.GetRoot(), userName, event, pack.Caster)
+	require.NoError(t, err)
+	userTrash, err := pack.FileService.CreateFolder(userHome, ".user_trash", event, pack.Caster)
+	require.NoError(t, err)
+
+	testUser.SetHomeFolder(userHome)
+	testUser.SetTrashFolder(userTrash)
+	err = pack.UserService.Add(testUser)
+	require.NoError(t, err)
+
+	testF, err := pack.FileService.CreateFile(userHome, "test-file", event, pack.Caster)
+	require.NoError(t, err)
+	_, err = testF.Write([]byte("0123456789"))
+	require.NoError(t, err)
+
+	err = pack.FileService.ResizeUp(userHome, event, pack.Caster)
+	require.NoError(t, err)
+
+	usersJournal.LogEvent(event)
+	event.Wait()
+
+	// 90 bytes are left, and each batch declares 90% of that
+	err = pack.UserService.SetUserQuota(testUser, 100)
+	require.NoError(t, err)
+	const batchSize = 81
+
+	// The first batch of an upload fits, but its files are still empty, so the home folder does not count them
+	require.NoError(t, pack.FileService.ReserveQuota(userHome, "upload-1", batchSize))
+	assert.Equal(t, int64(10), userHome.Size())
+
+	// A second batch of the same upload, or a batch of another upload, cannot use the same quota again
+	assert.ErrorIs(t, pack.FileService.ReserveQuota(userHome, "upload-1", batchSize), werror.ErrQuotaExceeded)
+	assert.ErrorIs(t, pack.FileService.ReserveQuota(userHome, "upload-2", batchSize), werror.ErrQuotaExceeded)
+	assert.NoError(t, pack.FileService.CheckQuota(userHome, 9))
+	assert.ErrorIs(t, pack.FileService.CheckQuota(userHome, 10), werror.ErrQuotaExceeded)
+
+	// Once the first upload is done, what it held is given back
+	pack.FileService.ReleaseQuota("upload-1")
+	assert.NoError(t, pack.FileService.ReserveQuota(userHome, "upload-2", batchSize))
+}
+
+// TestFileService_MoveConflicts tests that each conflict policy does what it says to files moved onto existing ones
+func TestFileService_MoveConflicts(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	pack, err := NewTestFileService(t.Name(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack.Caster = &mock.MockCaster{}
+
+	usersTree := pack.FileService.GetFileTreeByName("USERS")
+	usersJournal := pack.FileService.GetJournalByTree("USERS")
+
+	event := usersJournal.NewEvent()
+	userHome, err := pack.FileService.CreateFolder(usersTree.GetRoot(), "test-user", event, pack.Caster)
+	require.NoError(t, err)
+	from, err := pack.FileService.CreateFolder(userHome, "from", event, pack.Caster)
+	require.NoError(t, err)
+	to, err := pack.FileService.CreateFolder(userHome, "to", event, pack.Caster)
+	require.NoError(t, err)
+
+	newFile := func(parent *fileTree.WeblensFileImpl, name, content string) *fileTree.WeblensFileImpl {
+		f, err := pack.FileService.CreateFile(parent, name, event, pack.Caster)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		return f
+	}
+
+	movingFile := newFile(from, "file.txt", "new")
+	existingFile := newFile(to, "file.txt", "old")
+
+	movingDir, err := pack.FileService.CreateFolder(from, "dir", event, pack.Caster)
+	require.NoError(t, err)
+	movingChild := newFile(movingDir, "a.txt", "a")
+	existingDir, err := pack.FileService.CreateFolder(to, "dir", event, pack.Caster)
+	require.NoError(t, err)
+	existingChild := newFile(existingDir, "b.txt", "b")
+
+	usersJournal.LogEvent(event)
+	event.Wait()
+
+	moving := []*fileTree.WeblensFileImpl{movingFile, movingDir}
+	move := func(policy models.ConflictPolicy) ([]models.FileConflict, error) {
+		return pack.FileService.MoveFiles(moving, to, "USERS", policy, pack.Caster)
+	}
+
+	// Fail moves nothing, and reports both conflicts
+	conflicts, err := move(models.ConflictFail)
+	assert.ErrorIs(t, err, werror.ErrFileAlreadyExists)
+	assert.Len(t, conflicts, 2)
+	assert.Equal(t, from.ID(), movingFile.GetParentId())
+
+	// Skip also moves nothing, but is not an error
+	conflicts, err = move(models.ConflictSkip)
+	require.NoError(t, err)
+	assert.Len(t, conflicts, 2)
+	assert.Equal(t, from.ID(), movingFile.GetParentId())
+
+	// Merge puts the contents of the folder into the existing one, and keeps both of the files
+	conflicts, err = move(models.ConflictMerge)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, models.ConflictKeepBoth, conflicts[0].Resolution)
+	assert.Equal(t, "file.txt (1)", movingFile.Filename())
+	assert.Equal(t, models.ConflictMerge, conflicts[1].Resolution)
+	assert.Equal(t, existingDir.ID(), movingChild.GetParentId())
+	assert.Equal(t, existingDir.ID(), existingChild.GetParentId())
+	assert.Nil(t, usersTree.Get(movingDir.ID()))
+
+	// The folder that was merged into is sized with what it gained
+	assert.Equal(t, movingChild.Size()+existingChild.Size(), existingDir.Size())
+
+	// Overwrite deletes what was there
+	_, err = pack.FileService.MoveFiles([]*fileTree.WeblensFileImpl{movingFile}, from, "USERS", models.ConflictFail, pack.Caster)
+	require.NoError(t, err)
+	err = pack.FileService.RenameFile(movingFile, "file.txt", pack.Caster)
+	require.NoError(t, err)
+
+	conflicts, err = pack.FileService.MoveFiles([]*fileTree.WeblensFileImpl{movingFile}, to, "USERS", models.ConflictOverwrite, pack.Caster)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, existingFile.ID(), conflicts[0].ExistingId)
+	assert.Nil(t, usersTree.Get(existingFile.ID()))
+
+	replaced, err := to.GetChild("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, movingFile.ID(), replaced.ID())
+
+	// A folder moved out of a folder of the same name does not overwrite the folder it came from, and is kept next to it
+	event = usersJournal.NewEvent()
+	nestedDir, err := pack.FileService.CreateFolder(existingDir, "dir", event, pack.Caster)
+	require.NoError(t, err)
+	usersJournal.LogEvent(event)
+	event.Wait()
+
+	conflicts, err = pack.FileService.MoveFiles([]*fileTree.WeblensFileImpl{nestedDir}, to, "USERS", models.ConflictOverwrite, pack.Caster)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, models.ConflictKeepBoth, conflicts[0].Resolution)
+	assert.Equal(t, "dir (1)", nestedDir.Filename())
+	assert.Equal(t, to.ID(), nestedDir.GetParentId())
+	assert.NotNil(t, usersTree.Get(existingDir.ID()))
+}
+
+func TestFileService_CopyConflicts(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	pack, err := NewTestFileService(t.Name(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack.Caster = &mock.MockCaster{}
+
+	usersTree := pack.FileService.GetFileTreeByName("USERS")
+	usersJournal := pack.FileService.GetJournalByTree("USERS")
+
+	event := usersJournal.NewEvent()
+	userHome, err := pack.FileService.CreateFolder(usersTree.GetRoot(), "test-user", event, pack.Caster)
+	require.NoError(t, err)
+	from, err := pack.FileService.CreateFolder(userHome, "from", event, pack.Caster)
+	require.NoError(t, err)
+	to, err := pack.FileService.CreateFolder(userHome, "to", event, pack.Caster)
+	require.NoError(t, err)
+
+	newFile := func(parent *fileTree.WeblensFileImpl, name, content string) *fileTree.WeblensFileImpl {
+		f, err := pack.FileService.CreateFile(parent, name, event, pack.Caster)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		return f
+	}
+
+	copyingFile := newFile(from, "file.txt", "new")
+	existingFile := newFile(to, "file.txt", "old")
+
+	copyingDir, err := pack.FileService.CreateFolder(from, "dir", event, pack.Caster)
+	require.NoError(t, err)
+	newFile(copyingDir, "a.txt", "a")
+	existingDir, err := pack.FileService.CreateFolder(to, "dir", event, pack.Caster)
+	require.NoError(t, err)
+	existingChild := newFile(existingDir, "b.txt", "b")
+
+	usersJournal.LogEvent(event)
+	event.Wait()
+
+	copying := []*fileTree.WeblensFileImpl{copyingFile, copyingDir}
+	copyFiles := func(policy models.ConflictPolicy) ([]*fileTree.WeblensFileImpl, []models.FileConflict, error) {
+		return pack.FileService.CopyFiles(copying, to, policy, pack.Caster, nil)
+	}
+
+	// Fail and skip copy nothing, and report both conflicts
+	copies, conflicts, err := copyFiles(models.ConflictFail)
+	assert.ErrorIs(t, err, werror.ErrFileAlreadyExists)
+	assert.Empty(t, copies)
+	assert.Len(t, conflicts, 2)
+
+	copies, conflicts, err = copyFiles(models.ConflictSkip)
+	require.NoError(t, err)
+	assert.Empty(t, copies)
+	assert.Len(t, conflicts, 2)
+	assert.Len(t, to.GetChildren(), 2)
+
+	// Merge copies the contents of the folder into the existing one, and keeps both of the files
+	copies, conflicts, err = copyFiles(models.ConflictMerge)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, models.ConflictKeepBoth, conflicts[0].Resolution)
+	assert.Equal(t, "file.txt (1)", conflicts[0].NewName)
+	assert.Equal(t, models.ConflictMerge, conflicts[1].Resolution)
+	assert.Len(t, copies, 2)
+
+	mergedChild, err := existingDir.GetChild("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, mergedChild.Size()+existingChild.Size(), existingDir.Size())
+
+	// Overwrite deletes what was there
+	copies, conflicts, err = pack.FileService.CopyFiles(
+		[]*fileTree.WeblensFileImpl{copyingFile}, to, models.ConflictOverwrite, pack.Caster, nil,
+	)
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, existingFile.ID(), conflicts[0].ExistingId)
+	assert.Nil(t, usersTree.Get(existingFile.ID()))
+
+	replaced, err := to.GetChild("file.txt")
+	require.NoError(t, err)
+	require.Len(t, copies, 1)
+	assert.Equal(t, copies[0].ID(), replaced.ID())
+
+	// A file copied into its own folder is kept next to itself, even when overwriting
+	copies, _, err = pack.FileService.CopyFiles(
+		[]*fileTree.WeblensFileImpl{copyingFile}, from, models.ConflictOverwrite, pack.Caster, nil,
+	)
+	require.NoError(t, err)
+	require.Len(t, copies, 1)
+	assert.Equal(t, "file.txt (1)", copies[0].Filename())
+	assert.NotNil(t, usersTree.Get(copyingFile.ID()))
+}
+
+func TestFileService_RunBatch(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	pack, err := NewTestFileService(t.Name(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack.Caster = &mock.MockCaster{}
+
+	testUser, err := models.NewUser("test-user", "test-pass", false, true)
+	require.NoError(t, err)
+	err = pack.FileService.CreateUserHome(testUser)
+	require.NoError(t, err)
+	err = pack.UserService.Add(testUser)
+	require.NoError(t, err)
+
+	userHome, err := pack.FileService.GetFileByTree(testUser.HomeId, "USERS")
+	require.NoError(t, err)
+
+	usersJournal := pack.FileService.GetJournalByTree("USERS")
+	event := usersJournal.NewEvent()
+	photos, err := pack.FileService.CreateFolder(userHome, "photos", event, pack.Caster)
+	require.NoError(t, err)
+	a, err := pack.FileService.CreateFile(photos, "a.jpg", event, pack.Caster)
+	require.NoError(t, err)
+	b, err := pack.FileService.CreateFile(photos, "b.jpg", event, pack.Caster)
+	require.NoError(t, err)
+	_, err = pack.FileService.CreateFile(userHome, "taken.jpg", event, pack.Caster)
+	require.NoError(t, err)
+	usersJournal.LogEvent(event)
+	event.Wait()
+
+	// The rename at index 3 collides, so the folder, the move and the trash before it are all undone
+	ops := []models.FileOperation{
+		{Type: models.FileOpMkdir, ParentId: userHome.ID(), Name: "2024"},
+		{Type: models.FileOpMove, FileIds: []fileTree.FileId{a.ID()}, ParentId: models.BatchRef(0)},
+		{Type: models.FileOpTrash, FileIds: []fileTree.FileId{b.ID()}},
+		{Type: models.FileOpRename, FileIds: []fileTree.FileId{photos.ID()}, Name: "taken.jpg"},
+	}
+	_, err = pack.FileService.RunBatch(ops, testUser, nil, pack.Caster)
+	var batchErr *models.FileBatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 3, batchErr.Index)
+	assert.ErrorIs(t, err, werror.ErrFileAlreadyExists)
+
+	assert.Equal(t, photos.ID(), a.GetParentId())
+	assert.Equal(t, photos.ID(), b.GetParentId())
+	assert.Equal(t, "b.jpg", b.Filename())
+	assert.FileExists(t, a.AbsPath())
+	assert.FileExists(t, b.AbsPath())
+	_, err = userHome.GetChild("2024")
+	assert.Error(t, err)
+	assert.NoDirExists(t, userHome.AbsPath()+"2024")
+
+	// Without the bad rename, the whole batch goes through as one event
+	ops[3].Name = "pictures"
+	newFolderIds, err := pack.FileService.RunBatch(ops, testUser, nil, pack.Caster)
+	require.NoError(t, err)
+	require.Len(t, newFolderIds, 4)
+
+	newFolder := pack.FileService.GetFileTreeByName("USERS").Get(newFolderIds[0])
+	require.NotNil(t, newFolder)
+	assert.Equal(t, newFolder.ID(), a.GetParentId())
+	assert.Equal(t, testUser.TrashId, b.GetParentId())
+	assert.Equal(t, "pictures", photos.Filename())
+	assert.Empty(t, newFolderIds[1])
+
+	// Folders cannot be moved inside of themselves
+	ops = []models.FileOperation{{Type: models.FileOpMove, FileIds: []fileTree.FileId{userHome.ID()}, ParentId: photos.ID()}}
+	_, err = pack.FileService.RunBatch(ops, testUser, nil, pack.Caster)
+	assert.ErrorIs(t, err, werror.ErrBadFileOperation)
+
+	ops = []models.FileOperation{{Type: models.FileOpMove, FileIds: []fileTree.FileId{photos.ID()}, ParentId: photos.ID()}}
+	_, err = pack.FileService.RunBatch(ops, testUser, nil, pack.Caster)
+	assert.ErrorIs(t, err, werror.ErrMoveIntoSelf)
+}
+
+func TestHashFileContent(t *testing.T) {
+	t.Parallel()
+
+	tree, err := NewTestFileTree()
+	require.NoError(t, err)
+
+	f, err := tree.Touch(tree.GetRoot(), "scrubbed.txt", nil)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("content that will rot"))
+	require.NoError(t, err)
+
+	contentId, err := service.GenerateContentId(f)
+	require.NoError(t, err)
+
+	hashed, err := service.HashFileContent(f)
+	require.NoError(t, err)
+	assert.Equal(t, contentId, hashed)
+
+	// Change the content behind the file's back, the same way bit rot would
+	require.NoError(t, os.WriteFile(f.AbsPath(), []byte("content that has rot!"), 0660))
+
+	hashed, err = service.HashFileContent(f)
+	require.NoError(t, err)
+	assert.NotEqual(t, contentId, hashed)
+
+	// The stored content id is left alone, so the mismatch can be found
+	assert.Equal(t, contentId, f.GetContentId())
+	cached, err := service.GenerateContentId(f)
+	require.NoError(t, err)
+	assert.Equal(t, contentId, cached)
+}
+
+func TestFileService_ReplaceFileContent(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	pack, err := NewTestFileService(t.Name(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack.Caster = &mock.MockCaster{}
+
+	usersTree := pack.FileService.GetFileTreeByName("USERS")
+	usersJournal := pack.FileService.GetJournalByTree("USERS")
+
+	event := usersJournal.NewEvent()
+	userHome, err := pack.FileService.CreateFolder(usersTree.GetRoot(), "test-user", event, pack.Caster)
+	require.NoError(t, err)
+	file, err := pack.FileService.CreateFile(userHome, "file.txt", event, pack.Caster)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("old content"))
+	require.NoError(t, err)
+
+	usersJournal.LogEvent(event)
+	event.Wait()
+
+	oldContentId, err := service.GenerateContentId(file)
+	require.NoError(t, err)
+
+	// Overwriting the file, as an upload with the overwrite policy does, keeps the old content as a version
+	err = pack.FileService.ReplaceFileContent(file, strings.NewReader("new content"), pack.Caster)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldContentId, file.GetContentId())
+
+	versions, err := pack.FileService.GetFileVersions(file)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, oldContentId, versions[0].ContentId)
+	assert.Equal(t, file.GetContentId(), versions[1].ContentId)
+
+	_, err = pack.FileService.GetFileTreeByName("RESTORE").GetRoot().GetChild(oldContentId)
+	require.NoError(t, err)
+
+	// Rolling back brings the old content back into the same file
+	err = pack.FileService.RestoreFileVersion(file, oldContentId, pack.Caster)
+	require.NoError(t, err)
+	assert.Equal(t, oldContentId, file.GetContentId())
+
+	data, err := file.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "old content", string(data))
+
+	err = pack.FileService.RestoreFileVersion(file, oldContentId, pack.Caster)
+	assert.ErrorIs(t, err, werror.ErrVersionIsCurrent)
+}
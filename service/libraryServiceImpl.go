@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var _ models.LibraryService = (*LibraryServiceImpl)(nil)
+
+type LibraryServiceImpl struct {
+	libraries map[string]*models.Library
+	journals  map[string]*fileTree.JournalImpl
+
+	fileService models.FileService
+
+	// newJournal creates the journal that keeps the history of a library. Each library
+	// has its own journal, so its lifetimes are never mixed up with those of the users tree.
+	newJournal func(lib *models.Library) (*fileTree.JournalImpl, error)
+
+	// reservedPaths are the directories weblens keeps its own data in, which libraries may not overlap
+	reservedPaths []string
+
+	col *mongo.Collection
+
+	libMu sync.RWMutex
+}
+
+func NewLibraryService(
+	fileService models.FileService, newJournal func(lib *models.Library) (*fileTree.JournalImpl, error),
+	col *mongo.Collection, reservedPaths ...string,
+) (*LibraryServiceImpl, error) {
+	ls := &LibraryServiceImpl{
+		libraries:     map[string]*models.Library{},
+		journals:      map[string]*fileTree.JournalImpl{},
+		fileService:   fileService,
+		newJournal:    newJournal,
+		reservedPaths: reservedPaths,
+		col:           col,
+	}
+
+	ret, err := ls.col.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	var target []*models.Library
+	err = ret.All(context.Background(), &target)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	for _, lib := range target {
+		ls.libraries[lib.Alias] = lib
+
+		// A drive that is not plugged in should not keep the server from starting. The library is kept,
+		// but has no file tree until the server is restarted with the directory available again.
+		err = ls.mount(lib)
+		if err != nil {
+			log.Error.Printf("Could not attach library [%s] at %s: %s", lib.Alias, lib.Path, err)
+		}
+	}
+
+	return ls, nil
+}
+
+func (ls *LibraryServiceImpl) Size() int {
+	ls.libMu.RLock()
+	defer ls.libMu.RUnlock()
+	return len(ls.libraries)
+}
+
+func (ls *LibraryServiceImpl) Get(alias string) *models.Library {
+	ls.libMu.RLock()
+	defer ls.libMu.RUnlock()
+	return ls.libraries[alias]
+}
+
+func (ls *LibraryServiceImpl) GetAll() []*models.Library {
+	ls.libMu.RLock()
+	defer ls.libMu.RUnlock()
+	return internal.MapToValues(ls.libraries)
+}
+
+func (ls *LibraryServiceImpl) GetLibrariesForUser(u *models.User) []*models.Library {
+	return internal.Filter(
+		ls.GetAll(), func(lib *models.Library) bool {
+			return lib.CanUserAccess(u)
+		},
+	)
+}
+
+func (ls *LibraryServiceImpl) Add(lib *models.Library) error {
+	if lib.Alias == "" || strings.ContainsAny(lib.Alias, ":/") {
+		return werror.WithStack(werror.ErrBadLibraryAlias.WithArg(lib.Alias))
+	}
+
+	lib.Path = filepath.Clean(lib.Path)
+	if !filepath.IsAbs(lib.Path) {
+		return werror.WithStack(werror.ErrBadLibraryPath.WithArg(lib.Path))
+	}
+
+	stat, err := os.Stat(lib.Path)
+	if err != nil || !stat.IsDir() {
+		return werror.WithStack(werror.ErrBadLibraryPath.WithArg(lib.Path))
+	}
+
+	for _, reserved := range ls.reservedPaths {
+		if pathsOverlap(lib.Path, reserved) {
+			return werror.WithStack(werror.ErrBadLibraryPath.WithArg(lib.Path))
+		}
+	}
+
+	ls.libMu.Lock()
+	defer ls.libMu.Unlock()
+
+	if _, ok := ls.libraries[lib.Alias]; ok || ls.fileService.GetFileTreeByName(lib.Alias) != nil {
+		return werror.WithStack(werror.ErrLibraryAlreadyExists.WithArg(lib.Alias))
+	}
+
+	for _, other := range ls.libraries {
+		if pathsOverlap(lib.Path, other.Path) {
+			return werror.WithStack(werror.ErrBadLibraryPath.WithArg(lib.Path))
+		}
+	}
+
+	err = ls.mount(lib)
+	if err != nil {
+		return err
+	}
+
+	_, err = ls.col.InsertOne(context.Background(), lib)
+	if err != nil {
+		ls.unmount(lib.Alias)
+		return werror.WithStack(err)
+	}
+
+	ls.libraries[lib.Alias] = lib
+
+	return nil
+}
+
+func (ls *LibraryServiceImpl) Del(alias string) error {
+	ls.libMu.Lock()
+	defer ls.libMu.Unlock()
+
+	if _, ok := ls.libraries[alias]; !ok {
+		return werror.WithStack(werror.ErrNoLibrary.WithArg(alias))
+	}
+
+	_, err := ls.col.DeleteOne(context.Background(), bson.M{"_id": alias})
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	ls.unmount(alias)
+	delete(ls.libraries, alias)
+
+	return nil
+}
+
+func (ls *LibraryServiceImpl) SetAccessors(lib *models.Library, accessors []models.Username) error {
+	if accessors == nil {
+		accessors = []models.Username{}
+	}
+
+	_, err := ls.col.UpdateOne(
+		context.Background(), bson.M{"_id": lib.Alias}, bson.M{"$set": bson.M{"accessors": accessors}},
+	)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	ls.libMu.Lock()
+	defer ls.libMu.Unlock()
+	lib.Accessors = accessors
+
+	return nil
+}
+
+// mount loads the library directory as a read-only file tree and adds it to the file service
+func (ls *LibraryServiceImpl) mount(lib *models.Library) error {
+	journal, err := ls.newJournal(lib)
+	if err != nil {
+		return err
+	}
+
+	tree, err := fileTree.NewReadOnlyFileTree(lib.Path, lib.Alias, journal)
+	if err != nil {
+		journal.Close()
+		return err
+	}
+
+	ls.fileService.AddTree(tree)
+	ls.journals[lib.Alias] = journal
+
+	// Files in a library are changed outside of weblens, so the watcher is the only way we hear about it
+	go journal.FileWatcher()
+
+	return nil
+}
+
+func (ls *LibraryServiceImpl) unmount(alias string) {
+	ls.fileService.RemoveTree(alias)
+
+	if journal, ok := ls.journals[alias]; ok {
+		journal.Close()
+		delete(ls.journals, alias)
+	}
+}
+
+// pathsOverlap reports if either path is the same as, or inside of, the other
+func pathsOverlap(a, b string) bool {
+	a = filepath.Clean(a)
+	b = filepath.Clean(b)
+
+	for _, rel := range []string{relPath(a, b), relPath(b, a)} {
+		if rel == "." || (rel != "" && rel != ".." && !strings.HasPrefix(rel, "../")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func relPath(base, target string) string {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return ""
+	}
+	return rel
+}
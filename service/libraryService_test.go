@@ -0,0 +1,117 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethanrous/weblens/fileTree"
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/ethanrous/weblens/service"
+	"github.com/ethanrous/weblens/service/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibraryService(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewLogPackage("", log.DEBUG)
+
+	pack, err := NewTestFileService(t.Name(), logger)
+	require.NoError(t, err)
+	pack.Caster = &mock.MockCaster{}
+
+	libraryCol := mondb.Collection(t.Name() + "libraries")
+	require.NoError(t, libraryCol.Drop(context.Background()))
+	defer libraryCol.Drop(context.Background())
+
+	journalCol := mondb.Collection(t.Name() + "libraryJournal")
+	require.NoError(t, journalCol.Drop(context.Background()))
+	defer journalCol.Drop(context.Background())
+
+	newJournal := func(lib *models.Library) (*fileTree.JournalImpl, error) {
+		hasherFactory := func() fileTree.Hasher {
+			hasher := mock.NewMockHasher()
+			hasher.SetShouldCount(true)
+			return hasher
+		}
+		return fileTree.NewJournal(journalCol, "TEST-SERVER-"+lib.Alias, false, hasherFactory, logger)
+	}
+
+	usersRoot := pack.FileService.GetFileTreeByName("USERS").GetRoot()
+	libraryService, err := service.NewLibraryService(pack.FileService, newJournal, libraryCol, usersRoot.AbsPath())
+	require.NoError(t, err)
+	pack.FileService.(*service.FileServiceImpl).SetLibraryService(libraryService)
+	pack.AccessService.(*service.AccessServiceImpl).SetLibraryService(libraryService)
+
+	admin, err := models.NewUser("library-admin", "test-pass", true, true)
+	require.NoError(t, err)
+	require.NoError(t, pack.UserService.Add(admin))
+
+	reader, err := models.NewUser("library-reader", "test-pass", false, true)
+	require.NoError(t, err)
+	require.NoError(t, pack.UserService.Add(reader))
+
+	libPath, err := os.MkdirTemp("", "weblens-test-library-*")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(libPath, "photo.jpg"), []byte("not really a photo"), 0644))
+
+	err = libraryService.Add(models.NewLibrary("BAD:ALIAS", libPath, admin))
+	assert.ErrorIs(t, err, werror.ErrBadLibraryAlias)
+
+	err = libraryService.Add(models.NewLibrary("RELATIVE", "relative/path", admin))
+	assert.ErrorIs(t, err, werror.ErrBadLibraryPath)
+
+	// Libraries cannot overlap with the data weblens manages itself
+	err = libraryService.Add(models.NewLibrary("INSIDE", filepath.Join(usersRoot.AbsPath(), ".."), admin))
+	assert.ErrorIs(t, err, werror.ErrBadLibraryPath)
+
+	err = libraryService.Add(models.NewLibrary("USERS", libPath, admin))
+	assert.ErrorIs(t, err, werror.ErrLibraryAlreadyExists)
+
+	lib := models.NewLibrary("PHOTOS", libPath, admin)
+	require.NoError(t, libraryService.Add(lib))
+
+	tree := pack.FileService.GetFileTreeByName("PHOTOS")
+	require.NotNil(t, tree)
+	assert.True(t, tree.GetRoot().IsReadOnly())
+
+	photo, err := tree.GetRoot().GetChild("photo.jpg")
+	require.NoError(t, err)
+
+	owner, err := pack.FileService.GetFileOwner(photo)
+	require.NoError(t, err)
+	assert.Equal(t, admin.GetUsername(), owner.GetUsername())
+
+	// Only admins and accessors can see the library
+	_, err = pack.FileService.GetFileSafe(photo.ID(), admin, nil)
+	assert.NoError(t, err)
+	_, err = pack.FileService.GetFileSafe(photo.ID(), reader, nil)
+	assert.ErrorIs(t, err, werror.ErrNoFileAccess)
+
+	require.NoError(t, libraryService.SetAccessors(lib, []models.Username{reader.GetUsername()}))
+	_, err = pack.FileService.GetFileSafe(photo.ID(), reader, nil)
+	assert.NoError(t, err)
+	assert.Len(t, libraryService.GetLibrariesForUser(reader), 1)
+
+	// Nothing in the library may be changed through weblens
+	_, err = pack.FileService.CreateFile(tree.GetRoot(), "new.txt", nil, pack.Caster)
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	err = pack.FileService.RenameFile(photo, "renamed.jpg", pack.Caster)
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	err = pack.FileService.DeleteFiles([]*fileTree.WeblensFileImpl{photo}, "PHOTOS", pack.Caster)
+	assert.ErrorIs(t, err, werror.ErrReadOnly)
+
+	_, err = os.Stat(filepath.Join(libPath, "photo.jpg"))
+	assert.NoError(t, err)
+
+	require.NoError(t, libraryService.Del("PHOTOS"))
+	assert.Nil(t, pack.FileService.GetFileTreeByName("PHOTOS"))
+	assert.Nil(t, libraryService.Get("PHOTOS"))
+}
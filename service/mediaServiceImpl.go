@@ -469,6 +469,67 @@ func (ms *MediaServiceImpl) GetProminentColors(media *models.Media) (prom []stri
 	return
 }
 
+// RenderPreview makes a webp of an image that is not in the media cache, such as one inside an archive.
+// Nothing is cached, so this should only be used for images that do not have a file of their own.
+func (ms *MediaServiceImpl) RenderPreview(image []byte, quality models.MediaQuality) ([]byte, error) {
+	var maxSize uint
+	switch quality {
+	case models.LowRes:
+		maxSize = ThumbSize
+	case models.HighRes:
+		maxSize = HighresSize
+	default:
+		return nil, werror.Errorf("Unknown media quality [%s]", quality)
+	}
+
+	mw := imagick.NewMagickWand()
+	defer mw.Destroy()
+
+	err := mw.ReadImageBlob(image)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	err = mw.AutoOrientImage()
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	// Only the first page of a multi-page image is shown
+	mw.SetIteratorIndex(0)
+	page := mw.GetImage()
+	defer page.Destroy()
+
+	preview := page.MergeImageLayers(imagick.IMAGE_LAYER_FLATTEN)
+	defer preview.Destroy()
+
+	err = preview.SetImageFormat("webp")
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	width := preview.GetImageWidth()
+	height := preview.GetImageHeight()
+	if width > maxSize || height > maxSize {
+		newWidth, newHeight := maxSize, maxSize*height/width
+		if height > width {
+			newWidth, newHeight = maxSize*width/height, maxSize
+		}
+
+		err = preview.ScaleImage(newWidth, newHeight)
+		if err != nil {
+			return nil, werror.WithStack(err)
+		}
+	}
+
+	blob, err := preview.GetImageBlob()
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return blob, nil
+}
+
 func (ms *MediaServiceImpl) StreamVideo(
 	m *models.Media, u *models.User, share *models.FileShare,
 ) (*models.VideoStreamer, error) {
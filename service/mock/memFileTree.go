@@ -1,6 +1,9 @@
 package mock
 
 import (
+	"slices"
+	"strings"
+
 	"github.com/ethanrous/weblens/fileTree"
 	"github.com/ethanrous/weblens/internal/werror"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -21,6 +24,26 @@ func (ft *MemFileTree) ReplaceId(oldId, newId fileTree.FileId) error {
 	panic("implement me")
 }
 
+func (ft *MemFileTree) SearchFilenames(query string, base *fileTree.WeblensFileImpl) []*fileTree.WeblensFileImpl {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil
+	}
+
+	var files []*fileTree.WeblensFileImpl
+	_ = base.RecursiveMap(
+		func(f *fileTree.WeblensFileImpl) error {
+			name := strings.ToLower(f.Filename())
+			if f != base && !slices.ContainsFunc(words, func(w string) bool { return !strings.Contains(name, w) }) {
+				files = append(files, f)
+			}
+			return nil
+		},
+	)
+
+	return files
+}
+
 func (ft *MemFileTree) AbsToPortable(absPath string) (fileTree.WeblensFilepath, error) {
 
 	panic("implement me")
@@ -117,6 +140,14 @@ func (ft *MemFileTree) MkDir(
 	return newDir, nil
 }
 
+func (ft *MemFileTree) Copy(
+	f, newParent *fileTree.WeblensFileImpl, newFilename string, event *fileTree.FileEvent,
+	onCopy func(source, copied *fileTree.WeblensFileImpl),
+) (*fileTree.WeblensFileImpl, error) {
+
+	panic("implement me")
+}
+
 func (ft *MemFileTree) SetRootAlias(alias string) error {
 	panic("implement me")
 }
@@ -36,6 +36,10 @@ func (mfs *MockFileService) AddTree(tree fileTree.FileTree) {
 	mfs.trees[tree.GetRoot().GetPortablePath().RootName()] = tree
 }
 
+func (mfs *MockFileService) RemoveTree(treeName string) {
+	delete(mfs.trees, treeName)
+}
+
 func (mfs *MockFileService) GetUsersRoot() *fileTree.WeblensFileImpl {
 
 	panic("implement me")
@@ -98,6 +102,20 @@ func (mfs *MockFileService) GetFileTreeByName(treeName string) fileTree.FileTree
 	return nil
 }
 
+func (mfs *MockFileService) EmptyTrash(user *models.User, trashedBefore time.Time, caster models.FileCaster) (int, error) {
+	panic("implement me")
+}
+
+func (mfs *MockFileService) CheckQuota(destination *fileTree.WeblensFileImpl, addBytes int64) error {
+	return nil
+}
+
+func (mfs *MockFileService) ReserveQuota(destination *fileTree.WeblensFileImpl, reservationId string, addBytes int64) error {
+	return nil
+}
+
+func (mfs *MockFileService) ReleaseQuota(reservationId string) {}
+
 func (mfs *MockFileService) GetFileOwner(file *fileTree.WeblensFileImpl) (*models.User, error) {
 	return &models.User{
 		Username: "MOCK_USER",
@@ -113,9 +131,10 @@ func (mfs *MockFileService) ImportFile(f *fileTree.WeblensFileImpl) error {
 }
 
 func (mfs *MockFileService) MoveFiles(
-	files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, treeName string, caster models.FileCaster,
-) error {
-	return nil
+	files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, treeName string,
+	policy models.ConflictPolicy, caster models.FileCaster,
+) ([]models.FileConflict, error) {
+	return nil, nil
 }
 
 func (mfs *MockFileService) RenameFile(file *fileTree.WeblensFileImpl, newName string, caster models.FileCaster) error {
@@ -132,13 +151,20 @@ func (mfs *MockFileService) ReturnFilesFromTrash(files []*fileTree.WeblensFileIm
 	return nil
 }
 
+func (mfs *MockFileService) RunBatch(
+	ops []models.FileOperation, user *models.User, share *models.FileShare, caster models.FileCaster,
+) ([]fileTree.FileId, error) {
+	panic("implement me")
+}
+
 func (mfs *MockFileService) DeleteFiles(files []*fileTree.WeblensFileImpl, treeName string, caster models.FileCaster) error {
 	return nil
 }
 
 func (mfs *MockFileService) RestoreFiles(
-	ids []fileTree.FileId, newParent *fileTree.WeblensFileImpl, restoreTime time.Time, caster models.FileCaster,
-) error {
+	ids []fileTree.FileId, newParent *fileTree.WeblensFileImpl, restoreTime time.Time, policy models.ConflictPolicy,
+	caster models.FileCaster,
+) ([]models.FileConflict, error) {
 
 	panic("implement me")
 }
@@ -148,6 +174,33 @@ func (mfs *MockFileService) RestoreHistory(lifetimes []*fileTree.Lifetime) error
 	panic("implement me")
 }
 
+func (mfs *MockFileService) ReplaceFileContent(
+	file *fileTree.WeblensFileImpl, newContent io.Reader, caster models.FileCaster,
+) error {
+	panic("implement me")
+}
+
+func (mfs *MockFileService) GetFileVersions(file *fileTree.WeblensFileImpl) ([]fileTree.ContentVersion, error) {
+	panic("implement me")
+}
+
+func (mfs *MockFileService) RestoreFileVersion(
+	file *fileTree.WeblensFileImpl, contentId models.ContentId, caster models.FileCaster,
+) error {
+	panic("implement me")
+}
+
+func (mfs *MockFileService) CopyFiles(
+	files []*fileTree.WeblensFileImpl, destFolder *fileTree.WeblensFileImpl, policy models.ConflictPolicy,
+	caster models.FileCaster, onCopy func(source, copied *fileTree.WeblensFileImpl),
+) ([]*fileTree.WeblensFileImpl, []models.FileConflict, error) {
+	panic("implement me")
+}
+
+func (mfs *MockFileService) PurgeRestoreFiles(contentIds []models.ContentId) (int, int64, error) {
+	panic("implement me")
+}
+
 func (mfs *MockFileService) ReadFile(file *fileTree.WeblensFileImpl) (io.ReadCloser, error) {
 	return nil, nil
 }
@@ -51,6 +51,12 @@ func (h *HollowJournalService) GetActionsByPath(filepath fileTree.WeblensFilepat
 	return nil, nil
 }
 
+func (h *HollowJournalService) GetRecentActions(
+	folder, exclude fileTree.WeblensFilepath, actionTypes []fileTree.FileActionType, limit int,
+) ([]*fileTree.FileAction, error) {
+	return nil, nil
+}
+
 func (h *HollowJournalService) GetPastFolderChildren(folder *fileTree.WeblensFileImpl, time time.Time) (
 	[]*fileTree.WeblensFileImpl, error,
 ) {
@@ -92,3 +98,13 @@ func (h *HollowJournalService) Clear() error {
 func (h *HollowJournalService) UpdateLifetime(lifetime *fileTree.Lifetime) error {
 	return nil
 }
+
+func (h *HollowJournalService) Compact(policy fileTree.RetentionPolicy) (fileTree.CompactResult, error) {
+	return fileTree.CompactResult{}, nil
+}
+
+func (h *HollowJournalService) GetFolderDiff(folderId fileTree.FileId, from, to time.Time, recursive bool) (
+	[]fileTree.FileDiff, error,
+) {
+	return nil, nil
+}
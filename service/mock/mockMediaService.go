@@ -65,6 +65,11 @@ func (ms *MockMediaService) GetProminentColors(media *models.Media) (prom []stri
 	panic("implement me")
 }
 
+func (ms *MockMediaService) RenderPreview(image []byte, quality models.MediaQuality) ([]byte, error) {
+
+	panic("implement me")
+}
+
 func (ms *MockMediaService) GetMediaTypes() models.MediaTypeService {
 
 	panic("implement me")
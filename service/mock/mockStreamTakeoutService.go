@@ -0,0 +1,17 @@
+package mock
+
+import (
+	"github.com/ethanrous/weblens/models"
+)
+
+var _ models.StreamTakeoutService = (*MockStreamTakeoutService)(nil)
+
+type MockStreamTakeoutService struct{}
+
+func (m *MockStreamTakeoutService) Add(takeout models.StreamTakeout) models.StreamTakeoutId {
+	return ""
+}
+
+func (m *MockStreamTakeoutService) Get(takeoutId models.StreamTakeoutId) (models.StreamTakeout, bool) {
+	return models.StreamTakeout{}, false
+}
@@ -75,6 +75,12 @@ func (pjs *ProxyJournalService) GetActionsByPath(filepath fileTree.WeblensFilepa
 	panic("implement me")
 }
 
+func (pjs *ProxyJournalService) GetRecentActions(
+	folder, exclude fileTree.WeblensFilepath, actionTypes []fileTree.FileActionType, limit int,
+) ([]*fileTree.FileAction, error) {
+	panic("implement me")
+}
+
 func (pjs *ProxyJournalService) GetPastFolderChildren(folder *fileTree.WeblensFileImpl, time time.Time) (
 	[]*fileTree.WeblensFileImpl, error,
 ) {
@@ -126,3 +132,13 @@ func (pjs *ProxyJournalService) Clear() error {
 func (pjs *ProxyJournalService) UpdateLifetime(lifetime *fileTree.Lifetime) error {
 	panic("implement me")
 }
+
+func (pjs *ProxyJournalService) Compact(policy fileTree.RetentionPolicy) (fileTree.CompactResult, error) {
+	panic("implement me")
+}
+
+func (pjs *ProxyJournalService) GetFolderDiff(folderId fileTree.FileId, from, to time.Time, recursive bool) (
+	[]fileTree.FileDiff, error,
+) {
+	panic("implement me")
+}
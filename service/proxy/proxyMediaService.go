@@ -58,6 +58,10 @@ func (pms *ProxyMediaService) GetProminentColors(media *models.Media) (prom []st
 	panic("implement me")
 }
 
+func (pms *ProxyMediaService) RenderPreview(image []byte, quality models.MediaQuality) ([]byte, error) {
+	panic("implement me")
+}
+
 func (pms *ProxyMediaService) GetMediaTypes() models.MediaTypeService {
 	panic("implement me")
 }
@@ -104,6 +104,16 @@ func (pus *ProxyUserService) SetUserAdmin(user *models.User, b bool) error {
 	panic("implement me")
 }
 
+func (pus *ProxyUserService) SetUserQuota(user *models.User, quota int64) error {
+
+	panic("implement me")
+}
+
+func (pus *ProxyUserService) SetTrashRetention(user *models.User, days int) error {
+
+	panic("implement me")
+}
+
 func (pus *ProxyUserService) ActivateUser(user *models.User, active bool) error {
 
 	panic("implement me")
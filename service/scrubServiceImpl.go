@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var _ models.ScrubService = (*ScrubServiceImpl)(nil)
+
+type ScrubServiceImpl struct {
+	col *mongo.Collection
+}
+
+func NewScrubService(col *mongo.Collection) *ScrubServiceImpl {
+	return &ScrubServiceImpl{col: col}
+}
+
+func (ss *ScrubServiceImpl) Add(report *models.ScrubReport) error {
+	_, err := ss.col.InsertOne(context.Background(), report)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return nil
+}
+
+func (ss *ScrubServiceImpl) Get(id models.ScrubReportId) (*models.ScrubReport, error) {
+	return ss.findOne(bson.M{"_id": id})
+}
+
+func (ss *ScrubServiceImpl) GetLatest(treeName string) (*models.ScrubReport, error) {
+	opts := options.FindOne().SetSort(bson.M{"started": -1})
+	return ss.findOne(bson.M{"treeName": treeName}, opts)
+}
+
+func (ss *ScrubServiceImpl) findOne(filter bson.M, opts ...*options.FindOneOptions) (*models.ScrubReport, error) {
+	report := &models.ScrubReport{}
+	err := ss.col.FindOne(context.Background(), filter, opts...).Decode(report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, werror.WithStack(werror.ErrNoScrubReport)
+	} else if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethanrous/weblens/models"
+	"github.com/google/uuid"
+)
+
+var _ models.StreamTakeoutService = (*StreamTakeoutServiceImpl)(nil)
+
+// StreamTakeoutServiceImpl keeps streamed takeouts in memory. They only live for an hour, so losing them
+// on a restart only means the client has to ask for a new one.
+type StreamTakeoutServiceImpl struct {
+	takeouts map[models.StreamTakeoutId]models.StreamTakeout
+	mu       sync.Mutex
+}
+
+func NewStreamTakeoutService() *StreamTakeoutServiceImpl {
+	return &StreamTakeoutServiceImpl{
+		takeouts: map[models.StreamTakeoutId]models.StreamTakeout{},
+	}
+}
+
+func (ts *StreamTakeoutServiceImpl) Add(takeout models.StreamTakeout) models.StreamTakeoutId {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.deleteExpired()
+
+	if takeout.ExpiresAt.IsZero() {
+		takeout.ExpiresAt = time.Now().Add(models.StreamTakeoutExpiry)
+	}
+
+	takeoutId := uuid.New().String()
+	ts.takeouts[takeoutId] = takeout
+
+	return takeoutId
+}
+
+func (ts *StreamTakeoutServiceImpl) Get(takeoutId models.StreamTakeoutId) (models.StreamTakeout, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	takeout, ok := ts.takeouts[takeoutId]
+	if !ok {
+		return models.StreamTakeout{}, false
+	}
+
+	if takeout.ExpiresAt.Before(time.Now()) {
+		delete(ts.takeouts, takeoutId)
+		return models.StreamTakeout{}, false
+	}
+
+	return takeout, true
+}
+
+// deleteExpired removes every takeout that can no longer be downloaded. The lock must be held by the caller.
+func (ts *StreamTakeoutServiceImpl) deleteExpired() {
+	now := time.Now()
+	for takeoutId, takeout := range ts.takeouts {
+		if takeout.ExpiresAt.Before(now) {
+			delete(ts.takeouts, takeoutId)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethanrous/weblens/internal/archive"
+	"github.com/ethanrous/weblens/models"
+	. "github.com/ethanrous/weblens/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamTakeoutService(t *testing.T) {
+	t.Parallel()
+
+	ts := NewStreamTakeoutService()
+
+	takeoutId := ts.Add(
+		models.StreamTakeout{
+			FileIds:  []string{"a", "b"},
+			Username: "alice",
+			Format:   archive.Zip,
+			Filename: "takeout.zip",
+		},
+	)
+	require.NotEmpty(t, takeoutId)
+
+	takeout, ok := ts.Get(takeoutId)
+	require.True(t, ok)
+	assert.Equal(t, "takeout.zip", takeout.Filename)
+	assert.True(t, takeout.ExpiresAt.After(time.Now()), "a takeout without an expiry should be given the default one")
+
+	_, ok = ts.Get("not-a-takeout")
+	assert.False(t, ok)
+
+	// A separate service does not see takeouts made by another
+	_, ok = NewStreamTakeoutService().Get(takeoutId)
+	assert.False(t, ok)
+
+	expiredId := ts.Add(models.StreamTakeout{Username: "alice", ExpiresAt: time.Now().Add(-time.Minute)})
+	_, ok = ts.Get(expiredId)
+	assert.False(t, ok)
+}
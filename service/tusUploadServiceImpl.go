@@ -0,0 +1,323 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethanrous/weblens/internal/log"
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var _ models.TusUploadService = (*TusUploadServiceImpl)(nil)
+
+// TusUploadServiceImpl keeps the data of each upload in a file in stagingDir, named by the upload id,
+// and how much of it has arrived in the database.
+type TusUploadServiceImpl struct {
+	col        *mongo.Collection
+	stagingDir string
+
+	// Only one request can write to an upload at a time, but different uploads can be written to at once
+	locks   map[models.TusUploadId]*sync.Mutex
+	locksMu sync.Mutex
+
+	// onExpire is called with the id of each upload that is removed because it expired
+	onExpire func(uploadId models.TusUploadId)
+}
+
+func NewTusUploadService(col *mongo.Collection, stagingDir string) (*TusUploadServiceImpl, error) {
+	err := os.MkdirAll(stagingDir, 0755)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	_, err = col.Indexes().CreateOne(context.Background(), mongo.IndexModel{Keys: bson.D{{Key: "expiresAt", Value: 1}}})
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	us := &TusUploadServiceImpl{
+		col:        col,
+		stagingDir: stagingDir,
+		locks:      map[models.TusUploadId]*sync.Mutex{},
+	}
+
+	err = us.DeleteExpired()
+	if err != nil {
+		return nil, err
+	}
+
+	return us, nil
+}
+
+// SetExpireHandler sets what is told about each upload that is removed because it expired, so anything held
+// for the upload, like the quota it reserved, can be given back
+func (us *TusUploadServiceImpl) SetExpireHandler(onExpire func(uploadId models.TusUploadId)) {
+	us.onExpire = onExpire
+}
+
+// GetAll returns every upload that has not yet been finished, deleted or expired
+func (us *TusUploadServiceImpl) GetAll() ([]models.TusUpload, error) {
+	cur, err := us.col.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	var uploads []models.TusUpload
+	err = cur.All(context.Background(), &uploads)
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return uploads, nil
+}
+
+func (us *TusUploadServiceImpl) Create(upload models.TusUpload) (models.TusUpload, error) {
+	// Clients that give up on an upload do not always tell us, so this is as good a time as any to clean up after them
+	err := us.DeleteExpired()
+	if err != nil {
+		log.ErrTrace(err)
+	}
+
+	// The id is all that is needed to find an upload, so it must not be something that can be guessed
+	upload.Id = uuid.New().String()
+	upload.Offset = 0
+	upload.CreatedAt = time.Now()
+	upload.ExpiresAt = upload.CreatedAt.Add(models.TusUploadExpiry)
+
+	f, err := os.Create(us.stagingPath(upload.Id))
+	if err != nil {
+		return models.TusUpload{}, werror.WithStack(err)
+	}
+	err = f.Close()
+	if err != nil {
+		return models.TusUpload{}, werror.WithStack(err)
+	}
+
+	_, err = us.col.InsertOne(context.Background(), upload)
+	if err != nil {
+		_ = os.Remove(us.stagingPath(upload.Id))
+		return models.TusUpload{}, werror.WithStack(err)
+	}
+
+	return upload, nil
+}
+
+func (us *TusUploadServiceImpl) Get(uploadId models.TusUploadId) (models.TusUpload, error) {
+	var upload models.TusUpload
+	err := us.col.FindOne(context.Background(), bson.M{"_id": uploadId}).Decode(&upload)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.TusUpload{}, werror.WithStack(werror.ErrNoUpload)
+	} else if err != nil {
+		return models.TusUpload{}, werror.WithStack(err)
+	}
+
+	if upload.ExpiresAt.Before(time.Now()) {
+		return models.TusUpload{}, werror.WithStack(werror.ErrNoUpload)
+	}
+
+	return upload, nil
+}
+
+func (us *TusUploadServiceImpl) WriteChunk(
+	uploadId models.TusUploadId, offset int64, data io.Reader, checksum *models.TusChecksum,
+) (models.TusUpload, error) {
+	lock := us.getLock(uploadId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := us.Get(uploadId)
+	if err != nil {
+		return models.TusUpload{}, err
+	}
+
+	if offset != upload.Offset {
+		return upload, werror.WithStack(werror.ErrUploadOffsetMismatch)
+	}
+
+	f, err := os.OpenFile(us.stagingPath(uploadId), os.O_WRONLY, 0)
+	if err != nil {
+		return upload, werror.WithStack(err)
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return upload, werror.WithStack(err)
+	}
+
+	var w io.Writer = f
+	if checksum != nil {
+		w = io.MultiWriter(f, checksum.Hash)
+	}
+
+	// Read one more byte than is left, so we can tell if the client sends too much
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(w, io.LimitReader(data, remaining+1))
+
+	var rejectErr error
+	switch {
+	case written > remaining:
+		rejectErr = werror.ErrUploadTooLarge
+	case checksum != nil && copyErr != nil:
+		// Part of a chunk cannot be checked against the checksum of all of it, so none of it is kept
+		rejectErr = copyErr
+	case checksum != nil && !bytes.Equal(checksum.Hash.Sum(nil), checksum.Expected):
+		rejectErr = werror.ErrChecksumMismatch
+	}
+
+	if rejectErr != nil {
+		err = f.Truncate(offset)
+		if err != nil {
+			return upload, werror.WithStack(err)
+		}
+		return upload, werror.WithStack(rejectErr)
+	}
+
+	// If the client went away part way through, what did arrive is kept, and the client can resume from there
+	if copyErr != nil {
+		log.Debug.Printf("Tus upload [%s] was interrupted after %d bytes: %s", uploadId, written, copyErr)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return upload, werror.WithStack(err)
+	}
+
+	upload.Offset += written
+	upload.ExpiresAt = time.Now().Add(models.TusUploadExpiry)
+	_, err = us.col.UpdateOne(
+		context.Background(), bson.M{"_id": uploadId},
+		bson.M{"$set": bson.M{"offset": upload.Offset, "expiresAt": upload.ExpiresAt}},
+	)
+	if err != nil {
+		return upload, werror.WithStack(err)
+	}
+
+	return upload, nil
+}
+
+func (us *TusUploadServiceImpl) Open(uploadId models.TusUploadId) (io.ReadCloser, error) {
+	f, err := os.Open(us.stagingPath(uploadId))
+	if err != nil {
+		return nil, werror.WithStack(err)
+	}
+
+	return f, nil
+}
+
+func (us *TusUploadServiceImpl) MoveTo(uploadId models.TusUploadId, dst string) error {
+	lock := us.getLock(uploadId)
+	lock.Lock()
+
+	err := os.Rename(us.stagingPath(uploadId), dst)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyStaged(us.stagingPath(uploadId), dst)
+	}
+	lock.Unlock()
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	return us.Delete(uploadId)
+}
+
+func (us *TusUploadServiceImpl) Delete(uploadId models.TusUploadId) error {
+	lock := us.getLock(uploadId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	_, err := us.col.DeleteOne(context.Background(), bson.M{"_id": uploadId})
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	err = os.Remove(us.stagingPath(uploadId))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return werror.WithStack(err)
+	}
+
+	us.locksMu.Lock()
+	delete(us.locks, uploadId)
+	us.locksMu.Unlock()
+
+	return nil
+}
+
+func (us *TusUploadServiceImpl) DeleteExpired() error {
+	cur, err := us.col.Find(context.Background(), bson.M{"expiresAt": bson.M{"$lt": time.Now()}})
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	var expired []models.TusUpload
+	err = cur.All(context.Background(), &expired)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	for _, upload := range expired {
+		log.Trace.Printf("Removing expired tus upload [%s] of %s", upload.Id, upload.Filename)
+		err = us.Delete(upload.Id)
+		if err != nil {
+			return err
+		}
+
+		if us.onExpire != nil {
+			us.onExpire(upload.Id)
+		}
+	}
+
+	return nil
+}
+
+func (us *TusUploadServiceImpl) getLock(uploadId models.TusUploadId) *sync.Mutex {
+	us.locksMu.Lock()
+	defer us.locksMu.Unlock()
+
+	lock, ok := us.locks[uploadId]
+	if !ok {
+		lock = &sync.Mutex{}
+		us.locks[uploadId] = lock
+	}
+
+	return lock
+}
+
+func (us *TusUploadServiceImpl) stagingPath(uploadId models.TusUploadId) string {
+	return filepath.Join(us.stagingDir, uploadId)
+}
+
+// copyStaged copies a staged upload to dst, for when the staging dir is on a different filesystem than dst
+func copyStaged(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,121 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethanrous/weblens/internal/werror"
+	"github.com/ethanrous/weblens/models"
+	. "github.com/ethanrous/weblens/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTusUploadService(t *testing.T) {
+	t.Parallel()
+
+	col := mondb.Collection(t.Name())
+	err := col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	stagingDir := t.TempDir()
+	us, err := NewTusUploadService(col, stagingDir)
+	require.NoError(t, err)
+
+	upload, err := us.Create(models.TusUpload{Username: "alice", ParentId: "folder", Filename: "notes.txt", Length: 11})
+	require.NoError(t, err)
+	assert.NotEmpty(t, upload.Id)
+	assert.Zero(t, upload.Offset)
+
+	upload, err = us.WriteChunk(upload.Id, 0, strings.NewReader("hello "), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), upload.Offset)
+	assert.False(t, upload.IsComplete())
+
+	_, err = us.WriteChunk(upload.Id, 0, strings.NewReader("hello "), nil)
+	assert.ErrorIs(t, err, werror.ErrUploadOffsetMismatch)
+
+	// A chunk that does not match its checksum is thrown away
+	badSum := &models.TusChecksum{Hash: sha1.New(), Expected: []byte("not the sum")}
+	_, err = us.WriteChunk(upload.Id, 6, strings.NewReader("world"), badSum)
+	assert.ErrorIs(t, err, werror.ErrChecksumMismatch)
+
+	_, err = us.WriteChunk(upload.Id, 6, strings.NewReader("world and more"), nil)
+	assert.ErrorIs(t, err, werror.ErrUploadTooLarge)
+
+	// The upload is picked up again by a new service, as it would be after a restart
+	us, err = NewTusUploadService(col, stagingDir)
+	require.NoError(t, err)
+
+	upload, err = us.Get(upload.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), upload.Offset)
+
+	sum := sha1.Sum([]byte("world"))
+	checksum, err := models.ParseTusChecksum("sha1 " + base64.StdEncoding.EncodeToString(sum[:]))
+	require.NoError(t, err)
+
+	upload, err = us.WriteChunk(upload.Id, 6, strings.NewReader("world"), checksum)
+	require.NoError(t, err)
+	assert.True(t, upload.IsComplete())
+
+	content, err := us.Open(upload.Id)
+	require.NoError(t, err)
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	require.NoError(t, content.Close())
+	assert.True(t, bytes.Equal([]byte("hello world"), data))
+
+	err = us.Delete(upload.Id)
+	require.NoError(t, err)
+
+	_, err = us.Get(upload.Id)
+	assert.ErrorIs(t, err, werror.ErrNoUpload)
+}
+
+func TestTusUploadService_MoveTo(t *testing.T) {
+	t.Parallel()
+
+	col := mondb.Collection(t.Name())
+	err := col.Drop(context.Background())
+	require.NoError(t, err)
+	defer col.Drop(context.Background())
+
+	stagingDir := t.TempDir()
+	us, err := NewTusUploadService(col, stagingDir)
+	require.NoError(t, err)
+
+	upload, err := us.Create(models.TusUpload{Username: "alice", ParentId: "folder", Filename: "notes.txt", Length: 11})
+	require.NoError(t, err)
+
+	upload, err = us.WriteChunk(upload.Id, 0, strings.NewReader("hello world"), nil)
+	require.NoError(t, err)
+	require.True(t, upload.IsComplete())
+
+	// The file it is moved to can already exist, as the file an upload becomes is made before it is moved
+	dst := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(dst, nil, 0666))
+
+	err = us.MoveTo(upload.Id, dst)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	// Moving the upload is the end of it
+	_, err = us.Get(upload.Id)
+	assert.ErrorIs(t, err, werror.ErrNoUpload)
+
+	staged, err := os.ReadDir(stagingDir)
+	require.NoError(t, err)
+	assert.Empty(t, staged)
+}
@@ -218,6 +218,40 @@ func (us *UserServiceImpl) SetUserAdmin(u *models.User, admin bool) error {
 	return nil
 }
 
+func (us *UserServiceImpl) SetUserQuota(u *models.User, quota int64) error {
+	if quota < 0 {
+		return werror.WithStack(werror.ErrBadQuota)
+	}
+
+	filter := bson.M{"username": u.GetUsername()}
+	update := bson.M{"$set": bson.M{"quota": quota}}
+	_, err := us.col.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	u.Quota = quota
+
+	return nil
+}
+
+func (us *UserServiceImpl) SetTrashRetention(u *models.User, days int) error {
+	if days < -1 {
+		return werror.WithStack(werror.ErrBadTrashRetention)
+	}
+
+	filter := bson.M{"username": u.GetUsername()}
+	update := bson.M{"$set": bson.M{"trashRetentionDays": days}}
+	_, err := us.col.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return werror.WithStack(err)
+	}
+
+	u.TrashRetentionDays = days
+
+	return nil
+}
+
 func (us *UserServiceImpl) UpdateUserPassword(
 	username models.Username, oldPassword, newPassword string,
 	allowEmptyOld bool,
@@ -86,7 +86,9 @@ func (w WebdavFs) Rename(ctx context.Context, oldName, newName string) error {
 		return err
 	}
 
-	err = w.WeblensFs.MoveFiles([]*fileTree.WeblensFileImpl{oldFile}, newParent, "USERS", w.Caster)
+	_, err = w.WeblensFs.MoveFiles(
+		[]*fileTree.WeblensFileImpl{oldFile}, newParent, "USERS", models.ConflictKeepBoth, w.Caster,
+	)
 	if err != nil {
 		return err
 	}